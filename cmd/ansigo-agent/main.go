@@ -0,0 +1,17 @@
+// ansigo-agent 是 ansigo 上传到目标主机的辅助程序：从标准输入逐行读取 JSON 请求，
+// 向标准输出逐行写入 JSON 响应（协议见 pkg/agent）
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/jimyag/ansigo/pkg/agent"
+)
+
+func main() {
+	if err := agent.Serve(os.Stdin, os.Stdout); err != nil {
+		fmt.Fprintf(os.Stderr, "ansigo-agent: %v\n", err)
+		os.Exit(1)
+	}
+}
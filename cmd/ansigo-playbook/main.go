@@ -4,16 +4,73 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
 
+	"github.com/jimyag/ansigo/pkg/connection"
 	"github.com/jimyag/ansigo/pkg/inventory"
 	"github.com/jimyag/ansigo/pkg/logger"
+	"github.com/jimyag/ansigo/pkg/module"
 	"github.com/jimyag/ansigo/pkg/playbook"
+	"github.com/jimyag/ansigo/pkg/worker"
+	"golang.org/x/term"
 )
 
+// tagsFlag 标签参数，可以重复指定，每次可以是逗号分隔的多个标签
+type tagsFlag playbook.Tags
+
+func (f *tagsFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *tagsFlag) Set(value string) error {
+	*f = append(*f, playbook.SplitTags(value)...)
+	return nil
+}
+
+// extraVarsFlag -e 参数，可以重复指定，后面的变量覆盖前面的变量
+type extraVarsFlag map[string]interface{}
+
+func (f extraVarsFlag) String() string {
+	return fmt.Sprint(map[string]interface{}(f))
+}
+
+func (f extraVarsFlag) Set(value string) error {
+	vars, err := playbook.ParseExtraVars(value)
+	if err != nil {
+		return err
+	}
+	for k, v := range vars {
+		f[k] = v
+	}
+	return nil
+}
+
 func main() {
 	// 定义命令行参数
 	inventoryPath := flag.String("i", "inventory.ini", "Path to inventory file")
 	verbose := flag.Bool("v", false, "Verbose mode")
+	hostKeyChecking := flag.String("host-key-checking", "", "Host key checking mode: strict, accept-new or off (default strict, or StrictHostKeyChecking from ssh args and ssh_config)")
+	knownHosts := flag.String("known-hosts", "", "Path to known_hosts file (default ~/.ssh/known_hosts)")
+	var forks int
+	flag.IntVar(&forks, "f", worker.DefaultForks, "Number of parallel processes to use")
+	flag.IntVar(&forks, "forks", worker.DefaultForks, "Number of parallel processes to use (same as -f)")
+	var tags, skipTags tagsFlag
+	flag.Var(&tags, "t", "Only run tasks tagged with these values (comma separated, repeatable)")
+	flag.Var(&tags, "tags", "Only run tasks tagged with these values (same as -t)")
+	flag.Var(&skipTags, "skip-tags", "Skip tasks tagged with these values (comma separated, repeatable)")
+	listTags := flag.Bool("list-tags", false, "List all available tags and exit")
+	check := flag.Bool("check", false, "Don't make any changes; instead, try to predict some of the changes that may occur")
+	extraVars := extraVarsFlag{}
+	flag.Var(extraVars, "e", "Set additional variables as key=value, @file.yml or JSON (repeatable)")
+	flag.Var(extraVars, "extra-vars", "Set additional variables (same as -e)")
+	var diff bool
+	flag.BoolVar(&diff, "D", false, "When changing (small) files and templates, show the differences in those files")
+	flag.BoolVar(&diff, "diff", false, "When changing (small) files and templates, show the differences in those files (same as -D)")
+	explainVar := flag.String("explain-var", "", "Show where the value of this variable comes from for every task and host")
+	var modulePath string
+	flag.StringVar(&modulePath, "M", "", "Colon separated paths to search for modules (default $ANSIBLE_LIBRARY)")
+	flag.StringVar(&modulePath, "module-path", "", "Colon separated paths to search for modules (same as -M)")
 	flag.Parse()
 
 	// 初始化日志系统
@@ -30,7 +87,7 @@ func main() {
 	// 获取 playbook 文件路径
 	args := flag.Args()
 	if len(args) == 0 {
-		fmt.Println("Usage: ansigo-playbook -i <inventory> <playbook.yml>")
+		fmt.Println("Usage: ansigo-playbook -i <inventory> [-f <forks>] [-e <vars>] [--tags <tags>] [--skip-tags <tags>] [--list-tags] [--check] [--diff] [--explain-var <name>] [-M <module path>] <playbook.yml>")
 		fmt.Println("Example: ansigo-playbook -i hosts.ini site.yml")
 		os.Exit(1)
 	}
@@ -52,6 +109,11 @@ func main() {
 		os.Exit(1)
 	}
 
+	// 解析 playbook 之前添加 library 目录，这样 library 中的模块可以在任务中使用
+	// playbook 同目录下的 library/ 优先，然后是 -M 或 ANSIBLE_LIBRARY 指定的目录
+	module.AddLibrary(filepath.Join(filepath.Dir(playbookPath), "library"))
+	module.AddLibrary(module.LibraryDirs(modulePath)...)
+
 	// 解析 playbook
 	pb, err := playbook.ParsePlaybook(playbookData)
 	if err != nil {
@@ -63,13 +125,50 @@ func main() {
 
 	// 创建 runner 并执行
 	runner := playbook.NewRunner(invMgr)
-	defer runner.Close() // 确保释放模板引擎资源
+	defer runner.Close() // 确保释放模板引擎和 SSH 连接
+	runner.SetForks(forks)
+	runner.SetTagFilter(playbook.TagFilter{
+		Only: playbook.Tags(tags),
+		Skip: playbook.Tags(skipTags),
+	})
+	runner.SetCheckMode(*check)
+	runner.SetDiffMode(diff)
+	runner.SetExtraVars(extraVars)
+	runner.SetExplainVar(*explainVar)
+	if term.IsTerminal(int(os.Stdin.Fd())) {
+		runner.SetVarPrompt(playbook.TerminalVarPrompt)
+	}
+
+	// 设置主机密钥检查和私钥口令输入
+	connMgr := runner.ConnectionManager()
+	if *hostKeyChecking != "" {
+		mode, err := connection.ParseHostKeyChecking(*hostKeyChecking)
+		if err != nil {
+			logger.Errorf("%v", err)
+			os.Exit(1)
+		}
+		connMgr.SetHostKeyChecking(mode)
+	}
+	connMgr.SetKnownHostsFile(*knownHosts)
+	connMgr.SetPassphrasePrompt(connection.TerminalPassphrasePrompt)
 
 	// 设置 playbook 路径（用于 role 查找）
 	runner.SetPlaybookPath(playbookPath)
 
+	// --list-tags 只列出标签，不执行任务
+	if *listTags {
+		fmt.Printf("\nplaybook: %s\n", playbookPath)
+		if err := runner.ListTags(pb, os.Stdout); err != nil {
+			logger.Errorf("Failed to list tags: %v", err)
+			runner.Close()
+			os.Exit(1)
+		}
+		return
+	}
+
 	if err := runner.Run(pb); err != nil {
 		logger.Errorf("Playbook execution failed: %v", err)
+		runner.Close() // os.Exit 不会执行 defer
 		os.Exit(2)
 	}
 }
@@ -6,8 +6,11 @@ import (
 	"os"
 	"strings"
 
+	"github.com/jimyag/ansigo/pkg/connection"
 	"github.com/jimyag/ansigo/pkg/inventory"
+	"github.com/jimyag/ansigo/pkg/module"
 	"github.com/jimyag/ansigo/pkg/runner"
+	"github.com/jimyag/ansigo/pkg/worker"
 )
 
 func main() {
@@ -15,13 +18,22 @@ func main() {
 	inventoryPath := flag.String("i", "inventory.ini", "Path to inventory file")
 	moduleName := flag.String("m", "ping", "Module name to execute")
 	moduleArgs := flag.String("a", "", "Module arguments")
+	hostKeyChecking := flag.String("host-key-checking", "", "Host key checking mode: strict, accept-new or off (default strict, or StrictHostKeyChecking from ssh args and ssh_config)")
+	knownHosts := flag.String("known-hosts", "", "Path to known_hosts file (default ~/.ssh/known_hosts)")
+	var forks int
+	flag.IntVar(&forks, "f", worker.DefaultForks, "Number of parallel processes to use")
+	flag.IntVar(&forks, "forks", worker.DefaultForks, "Number of parallel processes to use (same as -f)")
+	var modulePath string
+	flag.StringVar(&modulePath, "M", "", "Colon separated paths to search for modules (default $ANSIBLE_LIBRARY)")
+	flag.StringVar(&modulePath, "module-path", "", "Colon separated paths to search for modules (same as -M)")
 	flag.Parse()
 
 	// 获取主机模式
 	args := flag.Args()
 	if len(args) == 0 {
-		fmt.Println("Usage: ansigo -i <inventory> -m <module> -a <args> <pattern>")
+		fmt.Println("Usage: ansigo -i <inventory> [-f <forks>] [-M <module path>] -m <module> -a <args> <pattern>")
 		fmt.Println("Example: ansigo -i hosts.ini -m ping all")
+		fmt.Println("         ansigo -i hosts.ini -m ping 'webservers:&prod:!web01'")
 		os.Exit(1)
 	}
 	pattern := args[0]
@@ -33,12 +45,21 @@ func main() {
 		os.Exit(1)
 	}
 
+	// -m 可以是 library 目录中的模块
+	module.AddLibrary(module.LibraryDirs(modulePath)...)
+
 	// 解析模块参数
 	modArgs := parseModuleArgs(*moduleArgs)
 
 	// 创建 runner 并执行
 	adhocRunner := runner.NewAdhocRunner(invMgr)
+	adhocRunner.SetForks(forks)
+	if err := configureHostKeyChecking(adhocRunner.ConnectionManager(), *hostKeyChecking, *knownHosts); err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+		os.Exit(1)
+	}
 	results, err := adhocRunner.Run(pattern, *moduleName, modArgs)
+	adhocRunner.Close()
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
 		os.Exit(1)
@@ -62,6 +83,20 @@ func main() {
 	}
 }
 
+// configureHostKeyChecking 根据命令行参数设置主机密钥检查，并允许在终端输入私钥口令
+func configureHostKeyChecking(connMgr *connection.Manager, mode, knownHosts string) error {
+	if mode != "" {
+		parsed, err := connection.ParseHostKeyChecking(mode)
+		if err != nil {
+			return err
+		}
+		connMgr.SetHostKeyChecking(parsed)
+	}
+	connMgr.SetKnownHostsFile(knownHosts)
+	connMgr.SetPassphrasePrompt(connection.TerminalPassphrasePrompt)
+	return nil
+}
+
 // parseModuleArgs 解析模块参数字符串
 func parseModuleArgs(argsStr string) map[string]interface{} {
 	args := make(map[string]interface{})
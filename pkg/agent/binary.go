@@ -0,0 +1,206 @@
+package agent
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"sync"
+)
+
+// Package cmd/ansigo-agent 的导入路径，控制节点有 Go 工具链时用它交叉编译 agent
+const Package = "github.com/jimyag/ansigo/cmd/ansigo-agent"
+
+// goArchs ansible_architecture（uname -m 的输出）到 GOARCH 的映射
+var goArchs = map[string]string{
+	"x86_64":  "amd64",
+	"amd64":   "amd64",
+	"aarch64": "arm64",
+	"arm64":   "arm64",
+	"armv6l":  "arm",
+	"armv7l":  "arm",
+	"i386":    "386",
+	"i686":    "386",
+	"ppc64le": "ppc64le",
+	"s390x":   "s390x",
+	"riscv64": "riscv64",
+}
+
+// GoArch 把 ansible_architecture 转换为 GOARCH
+func GoArch(arch string) (string, error) {
+	goarch, ok := goArchs[strings.TrimSpace(arch)]
+	if !ok {
+		return "", fmt.Errorf("no agent for architecture %q", arch)
+	}
+	return goarch, nil
+}
+
+// BinaryName 返回 Linux 上 goarch 架构的 agent 可执行文件名
+func BinaryName(goarch string) string {
+	return "ansigo-agent-linux-" + goarch
+}
+
+var (
+	// buildMu 防止并发执行的任务同时编译同一个 agent
+	buildMu sync.Mutex
+	// buildErrs 编译失败的 agent，同一次运行中不再重复编译
+	buildErrs = make(map[string]error)
+)
+
+// Binary 返回 Linux 上 goarch 架构的 agent 在控制节点上的路径，按顺序查找：
+//  1. ANSIGO_AGENT_DIR 目录和 ansigo 可执行文件所在目录中预先编译的 ansigo-agent-linux-<goarch>
+//  2. 缓存目录（如 ~/.cache/ansigo/agent/v2-<ansigo 版本>）中已经编译的 agent
+//  3. 控制节点有 Go 工具链和 ansigo 源码时，交叉编译静态的 agent 到缓存目录
+//
+// 预先编译：GOOS=linux GOARCH=<goarch> CGO_ENABLED=0 go build -o ansigo-agent-linux-<goarch> ./cmd/ansigo-agent
+func Binary(goarch string) (string, error) {
+	name := BinaryName(goarch)
+
+	var dirs []string
+	if dir := os.Getenv("ANSIGO_AGENT_DIR"); dir != "" {
+		dirs = append(dirs, dir)
+	}
+	if exe, err := os.Executable(); err == nil {
+		dirs = append(dirs, filepath.Dir(exe))
+	}
+	cacheDir, cacheErr := cacheDir()
+	if cacheErr == nil {
+		dirs = append(dirs, cacheDir)
+	}
+	for _, dir := range dirs {
+		path := filepath.Join(dir, name)
+		if info, err := os.Stat(path); err == nil && info.Mode().IsRegular() {
+			return path, nil
+		}
+	}
+
+	if cacheErr != nil {
+		return "", fmt.Errorf("%s not found: %w", name, cacheErr)
+	}
+	return build(goarch, filepath.Join(cacheDir, name))
+}
+
+// cacheDir 返回编译的 agent 的缓存目录，目录名包含协议版本和 ansigo 的版本（buildKey）
+func cacheDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	key, err := buildKey()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "ansigo", "agent", "v"+Version+"-"+key), nil
+}
+
+// buildKey 标识编译 ansigo 时的源码：发布的模块版本或没有本地修改的 VCS 修订，
+// 否则（本地修改过的源码、go run 等）使用 ansigo 可执行文件的校验和。
+// 源码改变后缓存目录随之改变，不会继续使用旧源码编译的 agent
+var buildKey = sync.OnceValues(func() (string, error) {
+	if info, ok := debug.ReadBuildInfo(); ok {
+		if key := buildInfoKey(info); key != "" {
+			return key, nil
+		}
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return "", err
+	}
+	f, err := os.Open(exe)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return "sha256-" + hex.EncodeToString(h.Sum(nil))[:16], nil
+})
+
+// buildInfoKey 返回编译信息中的模块版本或 VCS 修订，无法确定源码时返回空字符串
+func buildInfoKey(info *debug.BuildInfo) string {
+	if v := info.Main.Version; v != "" && v != "(devel)" && !strings.Contains(v, "+dirty") {
+		return strings.ReplaceAll(v, "/", "_")
+	}
+	var revision string
+	for _, setting := range info.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			revision = setting.Value
+		case "vcs.modified":
+			if setting.Value == "true" {
+				return ""
+			}
+		}
+	}
+	if len(revision) > 12 {
+		revision = revision[:12]
+	}
+	return revision
+}
+
+// build 用 Go 工具链交叉编译 agent 到 dest，编译失败时记住错误
+func build(goarch, dest string) (string, error) {
+	buildMu.Lock()
+	defer buildMu.Unlock()
+
+	// 等待锁的时候其他任务可能已经编译好了或者编译失败了
+	if _, err := os.Stat(dest); err == nil {
+		return dest, nil
+	}
+	if err, ok := buildErrs[dest]; ok {
+		return "", err
+	}
+
+	path, err := buildTo(goarch, dest)
+	if err != nil {
+		buildErrs[dest] = err
+	}
+	return path, err
+}
+
+// buildTo 在 ansigo 的源码目录中执行 go build
+func buildTo(goarch, dest string) (string, error) {
+	goBin, err := exec.LookPath("go")
+	if err != nil {
+		return "", fmt.Errorf("%s not found and no Go toolchain to build it", filepath.Base(dest))
+	}
+	if err := os.MkdirAll(filepath.Dir(dest), 0o755); err != nil {
+		return "", err
+	}
+
+	tmp := fmt.Sprintf("%s.%d.tmp", dest, os.Getpid())
+	defer os.Remove(tmp)
+
+	cmd := exec.Command(goBin, "build", "-trimpath", "-ldflags=-s -w", "-o", tmp, Package)
+	cmd.Dir = sourceDir()
+	cmd.Env = append(os.Environ(), "GOOS=linux", "GOARCH="+goarch, "CGO_ENABLED=0")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return "", fmt.Errorf("failed to build %s: %v: %s", filepath.Base(dest), err, strings.TrimSpace(string(out)))
+	}
+	if err := os.Rename(tmp, dest); err != nil {
+		return "", err
+	}
+	return dest, nil
+}
+
+// sourceDir 返回编译 ansigo 时的源码目录（模块根目录），目录已经不存在或使用 -trimpath 编译时返回空字符串（当前目录）
+func sourceDir() string {
+	_, file, _, ok := runtime.Caller(0)
+	if !ok {
+		return ""
+	}
+	dir := filepath.Join(filepath.Dir(file), "..", "..")
+	if _, err := os.Stat(filepath.Join(dir, "go.mod")); err != nil {
+		return ""
+	}
+	return dir
+}
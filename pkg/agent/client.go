@@ -0,0 +1,135 @@
+package agent
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Client 向 agent 发送请求的客户端，请求按顺序处理，不能并发使用
+type Client struct {
+	enc *json.Encoder
+	dec *json.Decoder
+}
+
+// NewClient 创建通过 w 发送请求、从 r 读取响应的客户端，并与 agent 握手检查协议版本
+func NewClient(r io.Reader, w io.Writer) (*Client, error) {
+	c := &Client{enc: json.NewEncoder(w), dec: json.NewDecoder(r)}
+	resp, err := c.call(&Request{Op: OpHello})
+	if err != nil {
+		return nil, err
+	}
+	if resp.Version != Version {
+		return nil, fmt.Errorf("agent protocol version %q is not supported (want %q)", resp.Version, Version)
+	}
+	return c, nil
+}
+
+// call 发送请求并读取响应，agent 返回的错误作为 error 返回
+func (c *Client) call(req *Request) (*Response, error) {
+	if err := c.enc.Encode(req); err != nil {
+		return nil, fmt.Errorf("failed to send agent request: %w", err)
+	}
+	var resp Response
+	if err := c.dec.Decode(&resp); err != nil {
+		return nil, fmt.Errorf("agent did not respond to %s: %w", req.Op, err)
+	}
+	if resp.Error != "" {
+		return nil, errors.New(resp.Error)
+	}
+	return &resp, nil
+}
+
+// Stat 读取文件属性，文件不存在时返回 Exists 为 false 的 FileInfo
+func (c *Client) Stat(path string) (*FileInfo, error) {
+	resp, err := c.call(&Request{Op: OpStat, Path: path})
+	if err != nil {
+		return nil, err
+	}
+	if resp.Info == nil {
+		return &FileInfo{}, nil
+	}
+	return resp.Info, nil
+}
+
+// Read 读取文件内容
+func (c *Client) Read(path string) ([]byte, error) {
+	resp, err := c.call(&Request{Op: OpRead, Path: path})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Content, nil
+}
+
+// Checksum 返回文件的 SHA1 校验和，文件不存在时返回空字符串
+func (c *Client) Checksum(path string) (string, error) {
+	resp, err := c.call(&Request{Op: OpChecksum, Path: path})
+	if err != nil {
+		return "", err
+	}
+	return resp.Checksum, nil
+}
+
+// Write 原子地写入文件并设置属性，返回内容或属性是否发生变化
+func (c *Client) Write(path string, content []byte, attrs Attributes) (bool, error) {
+	resp, err := c.call(&Request{Op: OpWrite, Path: path, Content: content, Attributes: attrs})
+	if err != nil {
+		return false, err
+	}
+	return resp.Changed, nil
+}
+
+// SetAttributes 设置文件的权限、所有者和组，返回属性是否发生变化
+func (c *Client) SetAttributes(path string, attrs Attributes) (bool, error) {
+	resp, err := c.call(&Request{Op: OpAttrs, Path: path, Attributes: attrs})
+	if err != nil {
+		return false, err
+	}
+	return resp.Changed, nil
+}
+
+// SetAttributesRecursive 设置目录和其中所有文件的权限、所有者和组，返回是否有属性发生变化
+func (c *Client) SetAttributesRecursive(path string, attrs Attributes) (bool, error) {
+	resp, err := c.call(&Request{Op: OpAttrs, Path: path, Recurse: true, Attributes: attrs})
+	if err != nil {
+		return false, err
+	}
+	return resp.Changed, nil
+}
+
+// Mkdir 创建目录（包括上级目录）并设置属性，返回目录是否被创建或属性发生变化
+func (c *Client) Mkdir(path string, attrs Attributes) (bool, error) {
+	resp, err := c.call(&Request{Op: OpMkdir, Path: path, Attributes: attrs})
+	if err != nil {
+		return false, err
+	}
+	return resp.Changed, nil
+}
+
+// Touch 创建空文件或更新修改时间并设置属性，返回文件是否被创建或属性发生变化
+func (c *Client) Touch(path string, attrs Attributes) (bool, error) {
+	resp, err := c.call(&Request{Op: OpTouch, Path: path, Attributes: attrs})
+	if err != nil {
+		return false, err
+	}
+	return resp.Changed, nil
+}
+
+// Symlink 创建 path 指向 src 的符号链接，返回链接是否被创建或替换
+func (c *Client) Symlink(path, src string) (bool, error) {
+	resp, err := c.call(&Request{Op: OpSymlink, Path: path, Src: src})
+	if err != nil {
+		return false, err
+	}
+	return resp.Changed, nil
+}
+
+// Remove 递归删除文件或目录，返回是否删除了文件
+func (c *Client) Remove(path string) (bool, error) {
+	resp, err := c.call(&Request{Op: OpRemove, Path: path})
+	if err != nil {
+		return false, err
+	}
+	return resp.Changed, nil
+}
@@ -0,0 +1,105 @@
+// Package agent 实现 ansigo 的远程 agent：一个上传到目标主机的静态 Go 程序，
+// 在一个会话中通过标准输入输出处理 JSON 请求（每行一个请求和一个响应），
+// 代替模块中 test、stat、cat、chmod、chown 等多次 shell 调用。
+//
+// 这个包只依赖标准库，cmd/ansigo-agent 用它实现 agent，模块用 Client 发送请求
+package agent
+
+import (
+	"fmt"
+	"os"
+)
+
+// Version 协议版本，不兼容的修改需要增加版本号（已经上传的旧 agent 不会再被使用）
+const Version = "2"
+
+// 请求的操作
+const (
+	OpHello    = "hello"    // 握手，返回协议版本
+	OpStat     = "stat"     // 读取文件属性
+	OpRead     = "read"     // 读取文件内容
+	OpChecksum = "checksum" // 计算文件的 SHA1 校验和（与 Ansible 相同）
+	OpWrite    = "write"    // 原子地写入文件并设置属性，内容和属性都没有变化时不修改文件
+	OpAttrs    = "attrs"    // 设置文件的权限、所有者和组（Recurse 时包括目录中的所有文件）
+	OpMkdir    = "mkdir"    // 创建目录（包括不存在的上级目录）并设置属性
+	OpTouch    = "touch"    // 创建空文件或更新修改时间，并设置属性
+	OpSymlink  = "symlink"  // 创建指向 Src 的符号链接，已有的链接指向其他位置时原子地替换
+	OpRemove   = "remove"   // 递归删除文件或目录
+)
+
+// Request agent 请求
+type Request struct {
+	Op      string `json:"op"`
+	Path    string `json:"path,omitempty"`
+	Content []byte `json:"content,omitempty"` // write 写入的内容
+	Src     string `json:"src,omitempty"`     // symlink 链接的目标
+	Recurse bool   `json:"recurse,omitempty"` // attrs 递归设置目录中所有文件的属性
+	Attributes
+}
+
+// Attributes write 和 attrs 设置的文件属性，零值表示不修改
+// write 创建新文件时 Mode 为 0 使用 0644；替换已存在的文件时保持原有的权限、所有者和组
+type Attributes struct {
+	Mode  uint32 `json:"mode,omitempty"`  // 八进制权限，可以包含 setuid、setgid 和 sticky 位
+	Owner string `json:"owner,omitempty"` // 用户名或 UID
+	Group string `json:"group,omitempty"` // 组名或 GID
+}
+
+// Response agent 响应
+type Response struct {
+	Error    string    `json:"error,omitempty"`
+	Version  string    `json:"version,omitempty"`
+	Info     *FileInfo `json:"info,omitempty"`
+	Content  []byte    `json:"content,omitempty"`
+	Checksum string    `json:"checksum,omitempty"`
+	Changed  bool      `json:"changed,omitempty"`
+}
+
+// FileInfo stat 返回的文件属性（符号链接本身的属性，不跟随链接）
+type FileInfo struct {
+	Exists bool   `json:"exists"`
+	IsDir  bool   `json:"is_dir,omitempty"`
+	IsLink bool   `json:"is_link,omitempty"`
+	Mode   uint32 `json:"mode,omitempty"` // 八进制权限，与 stat -c %a 相同
+	Size   int64  `json:"size,omitempty"`
+	UID    int    `json:"uid"`
+	GID    int    `json:"gid"`
+	Owner  string `json:"owner,omitempty"`
+	Group  string `json:"group,omitempty"`
+	Target string `json:"target,omitempty"` // 符号链接指向的路径
+}
+
+// ModeString 返回八进制权限字符串，如 644、4755
+func (i *FileInfo) ModeString() string {
+	return fmt.Sprintf("%o", i.Mode)
+}
+
+// unixMode 把 os.FileMode 转换为八进制权限位
+func unixMode(mode os.FileMode) uint32 {
+	bits := uint32(mode.Perm())
+	if mode&os.ModeSetuid != 0 {
+		bits |= 0o4000
+	}
+	if mode&os.ModeSetgid != 0 {
+		bits |= 0o2000
+	}
+	if mode&os.ModeSticky != 0 {
+		bits |= 0o1000
+	}
+	return bits
+}
+
+// fileMode 把八进制权限位转换为 os.FileMode
+func fileMode(bits uint32) os.FileMode {
+	mode := os.FileMode(bits & 0o777)
+	if bits&0o4000 != 0 {
+		mode |= os.ModeSetuid
+	}
+	if bits&0o2000 != 0 {
+		mode |= os.ModeSetgid
+	}
+	if bits&0o1000 != 0 {
+		mode |= os.ModeSticky
+	}
+	return mode
+}
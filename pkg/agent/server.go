@@ -0,0 +1,373 @@
+package agent
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// Serve 从 r 逐个读取请求，把响应写入 w，直到 r 结束
+// 单个请求失败只在响应中返回错误，不会结束会话
+func Serve(r io.Reader, w io.Writer) error {
+	dec := json.NewDecoder(r)
+	enc := json.NewEncoder(w)
+	for {
+		var req Request
+		if err := dec.Decode(&req); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return fmt.Errorf("invalid request: %w", err)
+		}
+		if err := enc.Encode(handle(&req)); err != nil {
+			return err
+		}
+	}
+}
+
+// handle 处理一个请求
+func handle(req *Request) *Response {
+	resp := &Response{}
+	var err error
+	switch req.Op {
+	case OpHello:
+		resp.Version = Version
+	case OpStat:
+		resp.Info, err = stat(req.Path)
+	case OpRead:
+		resp.Content, err = os.ReadFile(req.Path)
+	case OpChecksum:
+		resp.Checksum, err = checksum(req.Path)
+	case OpWrite:
+		resp.Changed, err = write(req.Path, req.Content, req.Attributes)
+	case OpAttrs:
+		if req.Recurse {
+			resp.Changed, err = setAttributesRecursive(req.Path, req.Attributes)
+		} else {
+			resp.Changed, err = setAttributes(req.Path, req.Attributes)
+		}
+	case OpMkdir:
+		resp.Changed, err = mkdir(req.Path, req.Attributes)
+	case OpTouch:
+		resp.Changed, err = touch(req.Path, req.Attributes)
+	case OpSymlink:
+		resp.Changed, err = symlink(req.Path, req.Src)
+	case OpRemove:
+		resp.Changed, err = remove(req.Path)
+	default:
+		err = fmt.Errorf("unsupported operation: %s", req.Op)
+	}
+	if err != nil {
+		resp.Error = err.Error()
+	}
+	return resp
+}
+
+// stat 读取文件属性，文件不存在时 Exists 为 false
+func stat(path string) (*FileInfo, error) {
+	fi, err := os.Lstat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return &FileInfo{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	info := &FileInfo{
+		Exists: true,
+		IsDir:  fi.IsDir(),
+		IsLink: fi.Mode()&os.ModeSymlink != 0,
+		Mode:   unixMode(fi.Mode()),
+		Size:   fi.Size(),
+	}
+	if info.IsLink {
+		info.Target, _ = os.Readlink(path)
+	}
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+		info.UID = int(st.Uid)
+		info.GID = int(st.Gid)
+	}
+	if u, err := user.LookupId(strconv.Itoa(info.UID)); err == nil {
+		info.Owner = u.Username
+	}
+	if g, err := user.LookupGroupId(strconv.Itoa(info.GID)); err == nil {
+		info.Group = g.Name
+	}
+	return info, nil
+}
+
+// checksum 计算文件内容的 SHA1 校验和，文件不存在时返回空字符串
+func checksum(path string) (string, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha1.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// write 原子地写入文件：内容写入同目录的临时文件，设置权限和所有者后重命名为 path（符号链接指向的文件）
+// 内容相同时只设置属性；替换已存在的文件时，未指定的属性保持原样
+func write(path string, content []byte, attrs Attributes) (bool, error) {
+	// path 是符号链接时写入链接指向的文件
+	if target, err := filepath.EvalSymlinks(path); err == nil {
+		path = target
+	}
+	current, err := stat(path)
+	if err != nil {
+		return false, err
+	}
+	if current.IsDir {
+		return false, fmt.Errorf("%s is a directory", path)
+	}
+	if current.Exists {
+		existing, err := os.ReadFile(path)
+		if err != nil {
+			return false, err
+		}
+		if bytes.Equal(existing, content) {
+			return setAttributes(path, attrs)
+		}
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".ansigo-*")
+	if err != nil {
+		return false, err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return false, err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return false, err
+	}
+	if err := tmp.Close(); err != nil {
+		return false, err
+	}
+
+	mode := attrs.Mode
+	if mode == 0 {
+		mode = 0o644
+		if current.Exists {
+			mode = current.Mode
+		}
+	}
+	if err := os.Chmod(tmp.Name(), fileMode(mode)); err != nil {
+		return false, err
+	}
+
+	uid, gid, err := ownerIDs(attrs)
+	if err != nil {
+		return false, err
+	}
+	if current.Exists {
+		// 保持原有的所有者和组（以其他用户身份运行时可能无法修改，忽略错误）
+		if uid < 0 && gid < 0 {
+			_ = os.Chown(tmp.Name(), current.UID, current.GID)
+		} else if uid < 0 {
+			uid = current.UID
+		} else if gid < 0 {
+			gid = current.GID
+		}
+	}
+	if uid >= 0 || gid >= 0 {
+		if err := os.Chown(tmp.Name(), uid, gid); err != nil {
+			return false, err
+		}
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// setAttributes 设置文件的权限、所有者和组，返回属性是否发生变化
+func setAttributes(path string, attrs Attributes) (bool, error) {
+	current, err := stat(path)
+	if err != nil {
+		return false, err
+	}
+	if !current.Exists {
+		return false, fmt.Errorf("%s does not exist", path)
+	}
+
+	changed := false
+	if attrs.Mode != 0 && attrs.Mode != current.Mode {
+		if err := os.Chmod(path, fileMode(attrs.Mode)); err != nil {
+			return false, err
+		}
+		changed = true
+	}
+
+	uid, gid, err := ownerIDs(attrs)
+	if err != nil {
+		return false, err
+	}
+	if uid == current.UID {
+		uid = -1
+	}
+	if gid == current.GID {
+		gid = -1
+	}
+	if uid >= 0 || gid >= 0 {
+		if err := os.Lchown(path, uid, gid); err != nil {
+			return false, err
+		}
+		changed = true
+	}
+	return changed, nil
+}
+
+// setAttributesRecursive 设置 path 和其中所有文件的属性（不跟随符号链接），返回是否有属性发生变化
+func setAttributesRecursive(path string, attrs Attributes) (bool, error) {
+	changed := false
+	err := filepath.WalkDir(path, func(p string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if p != path && d.Type()&os.ModeSymlink != 0 {
+			return nil
+		}
+		c, err := setAttributes(p, attrs)
+		changed = changed || c
+		return err
+	})
+	return changed, err
+}
+
+// mkdir 创建目录（和 mkdir -p 一样创建不存在的上级目录）并设置属性
+// 目录已经存在时只设置属性，path 是其他类型的文件时返回错误
+func mkdir(path string, attrs Attributes) (bool, error) {
+	if target, err := filepath.EvalSymlinks(path); err == nil {
+		path = target
+	}
+	current, err := stat(path)
+	if err != nil {
+		return false, err
+	}
+	if current.Exists && !current.IsDir {
+		return false, fmt.Errorf("%s already exists and is not a directory", path)
+	}
+	if current.Exists {
+		return setAttributes(path, attrs)
+	}
+
+	if err := os.MkdirAll(path, 0o777); err != nil {
+		return false, err
+	}
+	if _, err := setAttributes(path, attrs); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// touch 文件不存在时创建空文件，存在时更新访问和修改时间，然后设置属性
+// 只有创建了文件或属性发生变化时才算修改
+func touch(path string, attrs Attributes) (bool, error) {
+	current, err := stat(path)
+	if err != nil {
+		return false, err
+	}
+	if current.Exists {
+		now := time.Now()
+		if err := os.Chtimes(path, now, now); err != nil {
+			return false, err
+		}
+	} else {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE, 0o666)
+		if err != nil {
+			return false, err
+		}
+		if err := f.Close(); err != nil {
+			return false, err
+		}
+	}
+
+	changed, err := setAttributes(path, attrs)
+	return changed || !current.Exists, err
+}
+
+// symlink 创建 path 指向 src 的符号链接
+// 链接已经指向 src 时不修改；path 是指向其他位置的链接时通过重命名原子地替换，是其他类型的文件时返回错误
+func symlink(path, src string) (bool, error) {
+	current, err := stat(path)
+	if err != nil {
+		return false, err
+	}
+	if current.Exists && !current.IsLink {
+		return false, fmt.Errorf("refusing to convert %s to a symlink", path)
+	}
+	if current.IsLink && current.Target == src {
+		return false, nil
+	}
+	if !current.Exists {
+		return true, os.Symlink(src, path)
+	}
+
+	tmp := filepath.Join(filepath.Dir(path), fmt.Sprintf(".%s.ansigo-%d", filepath.Base(path), time.Now().UnixNano()))
+	if err := os.Symlink(src, tmp); err != nil {
+		return false, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return false, err
+	}
+	return true, nil
+}
+
+// remove 递归删除文件或目录，path 不存在时不修改
+func remove(path string) (bool, error) {
+	if _, err := os.Lstat(path); errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err := os.RemoveAll(path); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// ownerIDs 把用户名和组名转换为 UID 和 GID，未指定时返回 -1
+func ownerIDs(attrs Attributes) (uid, gid int, err error) {
+	uid, gid = -1, -1
+	if attrs.Owner != "" {
+		if uid, err = strconv.Atoi(attrs.Owner); err != nil {
+			u, err := user.Lookup(attrs.Owner)
+			if err != nil {
+				return -1, -1, fmt.Errorf("unknown user %s", attrs.Owner)
+			}
+			uid, _ = strconv.Atoi(u.Uid)
+		}
+	}
+	if attrs.Group != "" {
+		if gid, err = strconv.Atoi(attrs.Group); err != nil {
+			g, err := user.LookupGroup(attrs.Group)
+			if err != nil {
+				return -1, -1, fmt.Errorf("unknown group %s", attrs.Group)
+			}
+			gid, _ = strconv.Atoi(g.Gid)
+		}
+	}
+	return uid, gid, nil
+}
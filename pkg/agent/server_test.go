@@ -0,0 +1,279 @@
+package agent
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// newTestClient 通过管道连接在当前进程中运行的 agent
+func newTestClient(t *testing.T) *Client {
+	t.Helper()
+
+	reqR, reqW := io.Pipe()
+	respR, respW := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		err := Serve(reqR, respW)
+		respW.Close()
+		done <- err
+	}()
+	t.Cleanup(func() {
+		reqW.Close()
+		if err := <-done; err != nil {
+			t.Errorf("Serve() error = %v", err)
+		}
+	})
+
+	client, err := NewClient(respR, reqW)
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	return client
+}
+
+func TestClientWrite(t *testing.T) {
+	client := newTestClient(t)
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.conf")
+
+	info, err := client.Stat(path)
+	if err != nil || info.Exists {
+		t.Fatalf("Stat() of missing file = %+v, %v", info, err)
+	}
+	if sum, err := client.Checksum(path); err != nil || sum != "" {
+		t.Errorf("Checksum() of missing file = %q, %v", sum, err)
+	}
+
+	changed, err := client.Write(path, []byte("port = 8080\n"), Attributes{Mode: 0o640})
+	if err != nil || !changed {
+		t.Fatalf("Write() = %v, %v, want changed", changed, err)
+	}
+	assertFile(t, path, "port = 8080\n", 0o640)
+
+	// 内容和属性都相同时不修改文件
+	changed, err = client.Write(path, []byte("port = 8080\n"), Attributes{Mode: 0o640})
+	if err != nil || changed {
+		t.Errorf("second Write() = %v, %v, want unchanged", changed, err)
+	}
+
+	// 没有指定 mode 时保持原有的权限
+	changed, err = client.Write(path, []byte("port = 9090\n"), Attributes{})
+	if err != nil || !changed {
+		t.Fatalf("Write() new content = %v, %v, want changed", changed, err)
+	}
+	assertFile(t, path, "port = 9090\n", 0o640)
+
+	content, err := client.Read(path)
+	if err != nil || string(content) != "port = 9090\n" {
+		t.Errorf("Read() = %q, %v", content, err)
+	}
+	// echo 'port = 9090' | sha1sum
+	if sum, err := client.Checksum(path); err != nil || sum != "64c6ed53eb2afeec5bab5f7ec0e954f3864bc57e" {
+		t.Errorf("Checksum() = %q, %v", sum, err)
+	}
+
+	info, err = client.Stat(path)
+	if err != nil || !info.Exists || info.IsDir || info.ModeString() != "640" || info.Size != 12 {
+		t.Errorf("Stat() = %+v, %v", info, err)
+	}
+	if info.UID != os.Getuid() {
+		t.Errorf("Stat() UID = %d, want %d", info.UID, os.Getuid())
+	}
+
+	changed, err = client.SetAttributes(path, Attributes{Mode: 0o4750})
+	if err != nil || !changed {
+		t.Errorf("SetAttributes() = %v, %v, want changed", changed, err)
+	}
+	if info, _ := client.Stat(path); info.ModeString() != "4750" {
+		t.Errorf("mode after SetAttributes() = %s, want 4750", info.ModeString())
+	}
+	changed, err = client.SetAttributes(path, Attributes{Mode: 0o4750, Owner: strconv.Itoa(os.Getuid())})
+	if err != nil || changed {
+		t.Errorf("SetAttributes() with same attributes = %v, %v, want unchanged", changed, err)
+	}
+
+	// 写入目录失败，错误返回给客户端，会话继续
+	if _, err := client.Write(dir, []byte("x"), Attributes{}); err == nil || !strings.Contains(err.Error(), "is a directory") {
+		t.Errorf("Write() to directory error = %v", err)
+	}
+	if _, err := client.Read(filepath.Join(dir, "missing")); err == nil {
+		t.Error("Read() of missing file succeeded, want error")
+	}
+	if _, err := client.call(&Request{Op: "exec"}); err == nil || !strings.Contains(err.Error(), "unsupported operation") {
+		t.Errorf("unsupported operation error = %v", err)
+	}
+	if _, err := client.Stat(path); err != nil {
+		t.Errorf("Stat() after errors = %v", err)
+	}
+}
+
+func TestClientWriteSymlink(t *testing.T) {
+	client := newTestClient(t)
+	dir := t.TempDir()
+	target := filepath.Join(dir, "target.conf")
+	link := filepath.Join(dir, "link.conf")
+	if err := os.WriteFile(target, []byte("old\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(target, link); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := client.Stat(link)
+	if err != nil || !info.IsLink {
+		t.Fatalf("Stat() of symlink = %+v, %v", info, err)
+	}
+
+	// 写入符号链接时替换链接指向的文件，链接保持不变
+	if _, err := client.Write(link, []byte("new\n"), Attributes{}); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	assertFile(t, target, "new\n", 0o600)
+	if fi, err := os.Lstat(link); err != nil || fi.Mode()&os.ModeSymlink == 0 {
+		t.Errorf("%s is no longer a symlink: %v", link, err)
+	}
+}
+
+func TestClientFileOperations(t *testing.T) {
+	client := newTestClient(t)
+	dir := t.TempDir()
+
+	// mkdir 创建上级目录，已经存在时只设置属性
+	sub := filepath.Join(dir, "a", "b")
+	if changed, err := client.Mkdir(sub, Attributes{Mode: 0o750}); err != nil || !changed {
+		t.Fatalf("Mkdir() = %v, %v, want changed", changed, err)
+	}
+	if info, _ := client.Stat(sub); !info.IsDir || info.ModeString() != "750" {
+		t.Errorf("Stat() after Mkdir() = %+v", info)
+	}
+	if changed, err := client.Mkdir(sub, Attributes{Mode: 0o750}); err != nil || changed {
+		t.Errorf("second Mkdir() = %v, %v, want unchanged", changed, err)
+	}
+
+	// touch 只有创建文件或属性变化时算修改
+	file := filepath.Join(sub, "app.conf")
+	if changed, err := client.Touch(file, Attributes{}); err != nil || !changed {
+		t.Fatalf("Touch() = %v, %v, want changed", changed, err)
+	}
+	if changed, err := client.Touch(file, Attributes{}); err != nil || changed {
+		t.Errorf("second Touch() = %v, %v, want unchanged", changed, err)
+	}
+	if changed, err := client.Touch(file, Attributes{Mode: 0o600}); err != nil || !changed {
+		t.Errorf("Touch() with mode = %v, %v, want changed", changed, err)
+	}
+	if _, err := client.Mkdir(file, Attributes{}); err == nil || !strings.Contains(err.Error(), "not a directory") {
+		t.Errorf("Mkdir() of a file error = %v", err)
+	}
+
+	// 递归设置目录中所有文件的属性
+	if changed, err := client.SetAttributesRecursive(filepath.Join(dir, "a"), Attributes{Mode: 0o700}); err != nil || !changed {
+		t.Errorf("SetAttributesRecursive() = %v, %v, want changed", changed, err)
+	}
+	for _, path := range []string{filepath.Join(dir, "a"), sub, file} {
+		if info, _ := client.Stat(path); info.ModeString() != "700" {
+			t.Errorf("%s mode = %s, want 700", path, info.ModeString())
+		}
+	}
+	if changed, err := client.SetAttributesRecursive(filepath.Join(dir, "a"), Attributes{Mode: 0o700}); err != nil || changed {
+		t.Errorf("second SetAttributesRecursive() = %v, %v, want unchanged", changed, err)
+	}
+
+	// symlink 已经指向目标时不修改，指向其他位置时替换，不会替换普通文件
+	link := filepath.Join(dir, "current")
+	if changed, err := client.Symlink(link, sub); err != nil || !changed {
+		t.Fatalf("Symlink() = %v, %v, want changed", changed, err)
+	}
+	if changed, err := client.Symlink(link, sub); err != nil || changed {
+		t.Errorf("second Symlink() = %v, %v, want unchanged", changed, err)
+	}
+	if changed, err := client.Symlink(link, file); err != nil || !changed {
+		t.Errorf("Symlink() to a new target = %v, %v, want changed", changed, err)
+	}
+	if info, _ := client.Stat(link); !info.IsLink || info.Target != file {
+		t.Errorf("Stat() of symlink = %+v, want target %s", info, file)
+	}
+	if _, err := client.Symlink(file, sub); err == nil {
+		t.Error("Symlink() over a regular file succeeded, want error")
+	}
+
+	// remove 递归删除，不存在时不修改
+	if changed, err := client.Remove(filepath.Join(dir, "a")); err != nil || !changed {
+		t.Errorf("Remove() = %v, %v, want changed", changed, err)
+	}
+	if changed, err := client.Remove(filepath.Join(dir, "a")); err != nil || changed {
+		t.Errorf("second Remove() = %v, %v, want unchanged", changed, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "a")); !os.IsNotExist(err) {
+		t.Errorf("%s still exists: %v", filepath.Join(dir, "a"), err)
+	}
+}
+
+func TestGoArch(t *testing.T) {
+	tests := map[string]string{
+		"x86_64":  "amd64",
+		"aarch64": "arm64",
+		"armv7l":  "arm",
+		"i686":    "386",
+	}
+	for arch, want := range tests {
+		if got, err := GoArch(arch); err != nil || got != want {
+			t.Errorf("GoArch(%q) = %q, %v, want %q", arch, got, err, want)
+		}
+	}
+	if _, err := GoArch("sparc64"); err == nil {
+		t.Error("GoArch(sparc64) succeeded, want error")
+	}
+}
+
+func TestBuildInfoKey(t *testing.T) {
+	settings := func(revision, modified string) []debug.BuildSetting {
+		return []debug.BuildSetting{{Key: "vcs.revision", Value: revision}, {Key: "vcs.modified", Value: modified}}
+	}
+	tests := []struct {
+		name string
+		info debug.BuildInfo
+		want string
+	}{
+		{"release", debug.BuildInfo{Main: debug.Module{Version: "v1.2.0"}}, "v1.2.0"},
+		{"pseudo version", debug.BuildInfo{Main: debug.Module{Version: "v0.0.0-20260101000000-0123456789ab"}}, "v0.0.0-20260101000000-0123456789ab"},
+		{"clean revision", debug.BuildInfo{Main: debug.Module{Version: "(devel)"}, Settings: settings("0123456789abcdef", "false")}, "0123456789ab"},
+		// 本地修改过的源码无法用版本区分，使用可执行文件的校验和
+		{"dirty version", debug.BuildInfo{Main: debug.Module{Version: "v0.0.0-20260101000000-0123456789ab+dirty"}, Settings: settings("0123456789abcdef", "true")}, ""},
+		{"dirty revision", debug.BuildInfo{Main: debug.Module{Version: "(devel)"}, Settings: settings("0123456789abcdef", "true")}, ""},
+		{"unknown", debug.BuildInfo{Main: debug.Module{Version: "(devel)"}}, ""},
+	}
+	for _, tt := range tests {
+		if got := buildInfoKey(&tt.info); got != tt.want {
+			t.Errorf("%s: buildInfoKey() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+
+	key, err := buildKey()
+	if err != nil || key == "" {
+		t.Errorf("buildKey() = %q, %v", key, err)
+	}
+}
+
+func assertFile(t *testing.T, path, content string, mode os.FileMode) {
+	t.Helper()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != content {
+		t.Errorf("%s content = %q, want %q", path, data, content)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != mode {
+		t.Errorf("%s mode = %o, want %o", path, info.Mode().Perm(), mode)
+	}
+}
@@ -0,0 +1,179 @@
+package connection
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+
+	"github.com/jimyag/ansigo/pkg/inventory"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/term"
+)
+
+// PassphrasePrompt 获取加密私钥的口令
+type PassphrasePrompt func(keyFile string) ([]byte, error)
+
+// SetPassphrasePrompt 设置加密私钥的口令输入方式
+// 未设置时，加密私钥只能通过 ansible_ssh_private_key_passphrase 变量解密
+func (m *Manager) SetPassphrasePrompt(prompt PassphrasePrompt) {
+	m.passphrasePrompt = prompt
+}
+
+// TerminalPassphrasePrompt 从终端读取私钥口令（不回显）
+func TerminalPassphrasePrompt(keyFile string) ([]byte, error) {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return nil, fmt.Errorf("cannot prompt for passphrase of %s: stdin is not a terminal", keyFile)
+	}
+
+	fmt.Fprintf(os.Stderr, "Enter passphrase for key '%s': ", keyFile)
+	passphrase, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	return passphrase, err
+}
+
+// authMethods 构建主机的认证方式，返回的 cleanup 需要在握手结束后调用
+//
+// 顺序：密码、公钥。公钥依次来自 ansible_ssh_private_key_file、ssh_config 的 IdentityFile、
+// ssh-agent（SSH_AUTH_SOCK）和默认私钥（仅在前两者都未指定时）。所有公钥合并为一个 publickey 方法，
+// 因为 golang.org/x/crypto/ssh 对同一种认证方法只尝试一次
+func (m *Manager) authMethods(host *inventory.Host, identityFiles []string) ([]ssh.AuthMethod, func(), error) {
+	var methods []ssh.AuthMethod
+	cleanup := func() {}
+
+	if password := hostVarString(host, "ansible_password", "ansible_ssh_pass"); password != "" {
+		methods = append(methods, ssh.Password(password))
+	}
+
+	passphrase := hostVarString(host, "ansible_ssh_private_key_passphrase", "ansible_private_key_passphrase")
+
+	var signers []ssh.Signer
+	keyFile := hostVarString(host, "ansible_ssh_private_key_file", "ansible_private_key_file")
+	if keyFile != "" {
+		certFile := expandHome(hostVarString(host, "ansible_ssh_certificate_file"))
+		signer, err := m.loadSigner(expandHome(keyFile), certFile, passphrase, true)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to load private key %s: %w", keyFile, err)
+		}
+		signers = append(signers, signer)
+	}
+
+	// ssh_config 中的 IdentityFile 与 OpenSSH 一样，加载失败时跳过
+	for _, file := range identityFiles {
+		if signer, err := m.loadSigner(file, "", passphrase, true); err == nil {
+			signers = append(signers, signer)
+		}
+	}
+
+	if sock := os.Getenv("SSH_AUTH_SOCK"); sock != "" {
+		if conn, err := net.Dial("unix", sock); err == nil {
+			// 签名在握手过程中通过 agent 完成，连接需保持到握手结束
+			if agentSigners, err := agent.NewClient(conn).Signers(); err == nil {
+				signers = append(signers, agentSigners...)
+			}
+			cleanup = func() { conn.Close() }
+		}
+	}
+
+	// 默认私钥：加密的默认私钥不弹出口令输入，只使用口令变量
+	if keyFile == "" && len(identityFiles) == 0 {
+		if homeDir, err := os.UserHomeDir(); err == nil {
+			for _, name := range []string{"id_rsa", "id_ecdsa", "id_ed25519"} {
+				keyPath := filepath.Join(homeDir, ".ssh", name)
+				if signer, err := m.loadSigner(keyPath, "", passphrase, false); err == nil {
+					signers = append(signers, signer)
+				}
+			}
+		}
+	}
+
+	if len(signers) > 0 {
+		methods = append(methods, ssh.PublicKeys(signers...))
+	}
+
+	return methods, cleanup, nil
+}
+
+// loadSigner 加载私钥（结果按路径缓存，每个加密私钥只需输入一次口令）
+// 存在 certFile 或与私钥同目录的 <key>-cert.pub 时，使用 OpenSSH 证书签名
+func (m *Manager) loadSigner(keyFile, certFile, passphrase string, interactive bool) (ssh.Signer, error) {
+	// 串行化加载，避免并发连接多个主机时重复输入口令
+	m.authMu.Lock()
+	defer m.authMu.Unlock()
+
+	cacheKey := keyFile + "\x00" + certFile
+	if signer, ok := m.signers[cacheKey]; ok {
+		return signer, nil
+	}
+
+	pemBytes, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	signer, err := ssh.ParsePrivateKey(pemBytes)
+	if _, encrypted := err.(*ssh.PassphraseMissingError); encrypted {
+		switch {
+		case passphrase != "":
+			signer, err = ssh.ParsePrivateKeyWithPassphrase(pemBytes, []byte(passphrase))
+		case interactive && m.passphrasePrompt != nil:
+			prompted, perr := m.passphrasePrompt(keyFile)
+			if perr != nil {
+				return nil, perr
+			}
+			signer, err = ssh.ParsePrivateKeyWithPassphrase(pemBytes, prompted)
+		default:
+			return nil, fmt.Errorf("private key is encrypted, set ansible_ssh_private_key_passphrase")
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if certFile == "" {
+		if _, err := os.Stat(keyFile + "-cert.pub"); err == nil {
+			certFile = keyFile + "-cert.pub"
+		}
+	}
+	if certFile != "" {
+		signer, err = certSigner(signer, certFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	m.signers[cacheKey] = signer
+	return signer, nil
+}
+
+// certSigner 使用 OpenSSH 证书包装私钥签名器
+func certSigner(signer ssh.Signer, certFile string) (ssh.Signer, error) {
+	data, err := os.ReadFile(certFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read certificate %s: %w", certFile, err)
+	}
+
+	pub, _, _, _, err := ssh.ParseAuthorizedKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse certificate %s: %w", certFile, err)
+	}
+
+	cert, ok := pub.(*ssh.Certificate)
+	if !ok {
+		return nil, fmt.Errorf("%s is not an OpenSSH certificate", certFile)
+	}
+
+	return ssh.NewCertSigner(cert, signer)
+}
+
+// hostVarString 按顺序返回第一个非空的字符串主机变量
+func hostVarString(host *inventory.Host, names ...string) string {
+	for _, name := range names {
+		if v, ok := host.Vars[name].(string); ok && v != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,193 @@
+package connection
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jimyag/ansigo/pkg/inventory"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// newTestKey 生成 ed25519 密钥，passphrase 不为空时写入加密的私钥文件
+func newTestKey(t *testing.T, dir, name, passphrase string) (ssh.Signer, string) {
+	t.Helper()
+
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var block *pem.Block
+	if passphrase != "" {
+		block, err = ssh.MarshalPrivateKeyWithPassphrase(priv, "", []byte(passphrase))
+	} else {
+		block, err = ssh.MarshalPrivateKey(priv, "")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyFile := filepath.Join(dir, name)
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(block), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return signer, keyFile
+}
+
+// keyHost 返回使用私钥（而不是密码）登录测试服务器的主机
+func (s *testSSHServer) keyHost(name, keyFile string) *inventory.Host {
+	host := s.host(name)
+	delete(host.Vars, "ansible_password")
+	host.Vars["ansible_ssh_private_key_file"] = keyFile
+	return host
+}
+
+func TestEncryptedKeyWithPassphraseVar(t *testing.T) {
+	t.Setenv("SSH_AUTH_SOCK", "")
+	server := newTestSSHServer(t)
+	signer, keyFile := newTestKey(t, t.TempDir(), "id_ed25519", "s3cret")
+	server.authorize(signer.PublicKey())
+
+	mgr := NewManager()
+	defer mgr.Close()
+
+	host := server.keyHost("web1", keyFile)
+	if _, err := mgr.Connect(host); err == nil {
+		t.Fatal("Connect() without passphrase succeeded, want error")
+	}
+
+	host.Vars["ansible_ssh_private_key_passphrase"] = "s3cret"
+	conn, err := mgr.Connect(host)
+	if err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+	if _, _, exitCode, err := conn.Exec("true"); err != nil || exitCode != 0 {
+		t.Fatalf("Exec() = %d, %v", exitCode, err)
+	}
+}
+
+func TestEncryptedKeyPrompt(t *testing.T) {
+	t.Setenv("SSH_AUTH_SOCK", "")
+	server := newTestSSHServer(t)
+	signer, keyFile := newTestKey(t, t.TempDir(), "id_ed25519", "s3cret")
+	server.authorize(signer.PublicKey())
+
+	prompts := 0
+	mgr := NewManager()
+	mgr.SetPassphrasePrompt(func(file string) ([]byte, error) {
+		prompts++
+		if file != keyFile {
+			t.Errorf("prompt for %s, want %s", file, keyFile)
+		}
+		return []byte("s3cret"), nil
+	})
+	defer mgr.Close()
+
+	// 同一私钥只提示一次
+	for _, name := range []string{"web1", "web2"} {
+		if _, err := mgr.Connect(server.keyHost(name, keyFile)); err != nil {
+			t.Fatalf("Connect(%s) error = %v", name, err)
+		}
+	}
+	if prompts != 1 {
+		t.Errorf("prompted %d times, want 1", prompts)
+	}
+}
+
+func TestCertificateAuth(t *testing.T) {
+	t.Setenv("SSH_AUTH_SOCK", "")
+	server := newTestSSHServer(t)
+	dir := t.TempDir()
+
+	ca, _ := newTestKey(t, dir, "ca", "")
+	signer, keyFile := newTestKey(t, dir, "id_ed25519", "")
+	server.trustUserCA(ca.PublicKey())
+
+	cert := &ssh.Certificate{
+		Key:             signer.PublicKey(),
+		CertType:        ssh.UserCert,
+		KeyId:           "tester",
+		ValidPrincipals: []string{"tester"},
+		ValidAfter:      uint64(time.Now().Add(-time.Hour).Unix()),
+		ValidBefore:     uint64(time.Now().Add(time.Hour).Unix()),
+	}
+	if err := cert.SignCert(rand.Reader, ca); err != nil {
+		t.Fatal(err)
+	}
+
+	mgr := NewManager()
+	defer mgr.Close()
+
+	// 私钥本身未被授权，没有证书时无法登录
+	if _, err := mgr.Connect(server.keyHost("web1", keyFile)); err == nil {
+		t.Fatal("Connect() without certificate succeeded, want error")
+	}
+
+	// 与私钥同目录的 <key>-cert.pub 会被自动使用
+	if err := os.WriteFile(keyFile+"-cert.pub", ssh.MarshalAuthorizedKey(cert), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	mgr = NewManager()
+	defer mgr.Close()
+	if _, err := mgr.Connect(server.keyHost("web1", keyFile)); err != nil {
+		t.Fatalf("Connect() with certificate error = %v", err)
+	}
+}
+
+func TestAgentAuth(t *testing.T) {
+	server := newTestSSHServer(t)
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.authorize(signer.PublicKey())
+
+	keyring := agent.NewKeyring()
+	if err := keyring.Add(agent.AddedKey{PrivateKey: priv}); err != nil {
+		t.Fatal(err)
+	}
+
+	sock := filepath.Join(t.TempDir(), "agent.sock")
+	listener, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			c, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				agent.ServeAgent(keyring, c)
+				c.Close()
+			}()
+		}
+	}()
+	t.Setenv("SSH_AUTH_SOCK", sock)
+
+	mgr := NewManager()
+	defer mgr.Close()
+
+	host := server.host("web1")
+	delete(host.Vars, "ansible_password")
+	if _, err := mgr.Connect(host); err != nil {
+		t.Fatalf("Connect() via agent error = %v", err)
+	}
+}
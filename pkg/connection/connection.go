@@ -0,0 +1,137 @@
+package connection
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jimyag/ansigo/pkg/errors"
+	"github.com/jimyag/ansigo/pkg/inventory"
+)
+
+// Connection 到目标主机的连接，模块通过它执行命令和传输文件
+// 目前有 SSH（SSHConnection）、本地（LocalConnection）和容器（ContainerConnection）三种实现
+type Connection interface {
+	// Exec 执行命令（默认 30 秒超时），命令以非零状态退出不是错误，通过 exitCode 返回
+	Exec(cmd string) (stdout, stderr []byte, exitCode int, err error)
+	// ExecWithTimeout 执行命令（带超时）
+	ExecWithTimeout(cmd string, timeout time.Duration) (stdout, stderr []byte, exitCode int, err error)
+	// ExecWithBecome 使用权限提升执行命令（默认 30 秒超时）
+	ExecWithBecome(cmd string, becomeUser, becomeMethod string) (stdout, stderr []byte, exitCode int, err error)
+	// ExecWithBecomeTimeout 使用权限提升执行命令（带超时）
+	ExecWithBecomeTimeout(cmd string, becomeUser, becomeMethod string, timeout time.Duration) (stdout, stderr []byte, exitCode int, err error)
+	// ExecuteCommand 执行命令并返回标准输出，非零退出状态视为错误
+	ExecuteCommand(cmd string) ([]byte, error)
+
+	// PutFile 上传本地文件（新文件 0644，已存在的文件保持原有权限）
+	PutFile(localPath, remotePath string) error
+	// Upload 把 r 的内容原子地写入远程文件，mode 为 0 时新文件 0644，已存在的文件保持原有权限
+	Upload(r io.Reader, remotePath string, mode os.FileMode) error
+	// GetFile 下载远程文件到本地
+	GetFile(remotePath, localPath string) error
+
+	// Close 释放连接
+	Close() error
+}
+
+// 连接类型（ansible_connection）
+const (
+	ConnectionSSH    = "ssh"
+	ConnectionLocal  = "local"
+	ConnectionDocker = "docker"
+	ConnectionPodman = "podman"
+)
+
+// localhostNames 未设置 ansible_connection 时使用本地连接的 inventory 主机名
+var localhostNames = map[string]bool{
+	"localhost": true,
+	"127.0.0.1": true,
+	"::1":       true,
+}
+
+// IsLocalhost 判断主机名是否指向控制节点本身
+// 未设置 ansible_connection 的同名主机、不在 inventory 中的同名 delegate_to 目标都使用本地连接
+func IsLocalhost(name string) bool {
+	return localhostNames[name]
+}
+
+// Connect 连接到主机
+// 按 ansible_connection 选择连接方式：local 在控制节点本地执行，docker/podman 在容器内执行，
+// ssh（默认）使用连接池中的 SSH 连接。
+// 未设置 ansible_connection 时，名为 localhost/127.0.0.1 的主机使用本地连接
+func (m *Manager) Connect(host *inventory.Host) (Connection, error) {
+	switch connType := connectionType(host); connType {
+	case ConnectionLocal:
+		return NewLocalConnection(host), nil
+	case ConnectionDocker, ConnectionPodman:
+		conn := NewContainerConnection(host, connType)
+		if err := conn.connect(); err != nil {
+			return nil, err
+		}
+		return conn, nil
+	case ConnectionSSH, "paramiko", "smart":
+		return m.connectSSH(host)
+	default:
+		return nil, errors.NewUnsupportedConnectionError(host.Name, connType)
+	}
+}
+
+// connectionType 返回主机使用的连接类型
+func connectionType(host *inventory.Host) string {
+	if connType := hostVarString(host, "ansible_connection"); connType != "" {
+		return connType
+	}
+	if localhostNames[host.Name] {
+		return ConnectionLocal
+	}
+	return ConnectionSSH
+}
+
+// becomeCommand 构建权限提升命令
+// become_user 默认为 root，become_method 默认为 sudo
+func becomeCommand(cmd, becomeUser, becomeMethod string) (string, error) {
+	// 如果没有指定 become_user，默认为 root
+	if becomeUser == "" {
+		becomeUser = "root"
+	}
+
+	// 如果没有指定 become_method，默认为 sudo
+	if becomeMethod == "" {
+		becomeMethod = "sudo"
+	}
+
+	// 使用 -n 选项避免密码提示（假设配置了 NOPASSWD）
+	// 使用 -u 指定目标用户
+	switch becomeMethod {
+	case "sudo":
+		if becomeUser == "root" {
+			return fmt.Sprintf("sudo -n sh -c %s", shellQuote(cmd)), nil
+		}
+		return fmt.Sprintf("sudo -n -u %s sh -c %s", becomeUser, shellQuote(cmd)), nil
+	case "su":
+		// su 方式（不太常用）
+		return fmt.Sprintf("su - %s -c %s", becomeUser, shellQuote(cmd)), nil
+	default:
+		return "", fmt.Errorf("unsupported become method: %s", becomeMethod)
+	}
+}
+
+// shellQuote 为 shell 命令添加引号
+func shellQuote(s string) string {
+	// 简单实现：使用单引号，并转义内部的单引号
+	return "'" + strings.ReplaceAll(s, "'", "'\\''") + "'"
+}
+
+// executeCommand 执行命令并返回标准输出，非零退出状态视为错误
+func executeCommand(c Connection, cmd string) ([]byte, error) {
+	stdout, _, exitCode, err := c.Exec(cmd)
+	if err != nil {
+		return nil, err
+	}
+	if exitCode != 0 {
+		return nil, fmt.Errorf("command failed with exit code %d", exitCode)
+	}
+	return stdout, nil
+}
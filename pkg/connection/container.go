@@ -0,0 +1,231 @@
+package connection
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/jimyag/ansigo/pkg/errors"
+	"github.com/jimyag/ansigo/pkg/inventory"
+)
+
+// ContainerConnection 通过控制节点上的 docker/podman 命令在容器内执行命令和传输文件
+// （ansible_connection=docker 或 podman）
+//
+// 命令通过 `<runtime> exec` 执行，文件内容经 `exec -i` 的 stdin 流入容器，
+// 因此容器内只需要 /bin/sh、cat、chmod、mv，不需要 sshd
+type ContainerConnection struct {
+	host      *inventory.Host
+	runtime   string   // docker 或 podman
+	container string   // 容器名或 ID
+	user      string   // exec -u 使用的用户，为空时使用容器默认用户
+	command   []string // 运行时命令及其全局参数
+}
+
+// NewContainerConnection 创建容器连接，runtime 为 docker 或 podman
+//
+// 使用的主机变量（<runtime> 为 docker 或 podman）：
+//   - ansible_<runtime>_host / ansible_host：容器名，未设置时为 inventory 主机名
+//   - ansible_<runtime>_user / ansible_user：容器内执行命令的用户
+//   - ansible_<runtime>_executable：运行时命令，默认为 runtime
+//   - ansible_<runtime>_extra_args：运行时的全局参数（如 docker 的 -H）
+func NewContainerConnection(host *inventory.Host, runtime string) *ContainerConnection {
+	container := hostVarString(host, "ansible_"+runtime+"_host", "ansible_host")
+	if container == "" {
+		container = host.Name
+	}
+
+	executable := hostVarString(host, "ansible_"+runtime+"_executable")
+	if executable == "" {
+		executable = runtime
+	}
+	command := append([]string{executable}, strings.Fields(hostVarString(host, "ansible_"+runtime+"_extra_args"))...)
+
+	return &ContainerConnection{
+		host:      host,
+		runtime:   runtime,
+		container: container,
+		user:      hostVarString(host, "ansible_"+runtime+"_user", "ansible_user"),
+		command:   command,
+	}
+}
+
+// connect 检查容器是否在运行，运行时命令不存在或容器未运行时返回不可达错误
+func (c *ContainerConnection) connect() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	args := c.runtimeArgs("inspect", "--format", "{{.State.Running}}", c.container)
+	out, err := exec.CommandContext(ctx, c.command[0], args...).CombinedOutput()
+	if err != nil {
+		if msg := strings.TrimSpace(string(out)); msg != "" {
+			err = fmt.Errorf("%s", msg)
+		}
+		return errors.NewUnreachableError(c.host.Name, fmt.Errorf("%s container %s: %w", c.runtime, c.container, err))
+	}
+	if strings.TrimSpace(string(out)) != "true" {
+		return errors.NewUnreachableError(c.host.Name, fmt.Errorf("%s container %s is not running", c.runtime, c.container))
+	}
+	return nil
+}
+
+// runtimeArgs 返回运行时的全局参数加上 args
+func (c *ContainerConnection) runtimeArgs(args ...string) []string {
+	return append(append([]string{}, c.command[1:]...), args...)
+}
+
+// execCommand 构建在容器内通过 /bin/sh -c 执行 cmd 的命令，interactive 为 true 时把 stdin 传入容器
+func (c *ContainerConnection) execCommand(ctx context.Context, cmd string, interactive bool) *exec.Cmd {
+	args := c.runtimeArgs("exec")
+	if c.user != "" {
+		args = append(args, "-u", c.user)
+	}
+	if interactive {
+		args = append(args, "-i")
+	}
+	args = append(args, c.container, "/bin/sh", "-c", cmd)
+
+	command := exec.CommandContext(ctx, c.command[0], args...)
+	// 超时杀掉运行时命令后，不再等待仍持有输出管道的子进程
+	command.WaitDelay = time.Second
+	return command
+}
+
+// Exec 执行命令
+func (c *ContainerConnection) Exec(cmd string) (stdout, stderr []byte, exitCode int, err error) {
+	return c.ExecWithTimeout(cmd, 30*time.Second)
+}
+
+// ExecWithTimeout 在容器内执行命令（带超时）
+func (c *ContainerConnection) ExecWithTimeout(cmd string, timeout time.Duration) (stdout, stderr []byte, exitCode int, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var stdoutBuf, stderrBuf bytes.Buffer
+	command := c.execCommand(ctx, cmd, false)
+	command.Stdout = &stdoutBuf
+	command.Stderr = &stderrBuf
+
+	err = command.Run()
+	if ctx.Err() == context.DeadlineExceeded {
+		return nil, nil, -1, errors.NewTimeoutError(c.host.Name, cmd, timeout)
+	}
+
+	stdout = stdoutBuf.Bytes()
+	stderr = stderrBuf.Bytes()
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			return stdout, stderr, exitErr.ExitCode(), nil
+		}
+		return stdout, stderr, -1, errors.NewUnreachableError(c.host.Name, err)
+	}
+	return stdout, stderr, 0, nil
+}
+
+// ExecWithBecome 使用权限提升执行命令
+func (c *ContainerConnection) ExecWithBecome(cmd string, becomeUser, becomeMethod string) (stdout, stderr []byte, exitCode int, err error) {
+	return c.ExecWithBecomeTimeout(cmd, becomeUser, becomeMethod, 30*time.Second)
+}
+
+// ExecWithBecomeTimeout 使用权限提升执行命令（带超时）
+func (c *ContainerConnection) ExecWithBecomeTimeout(cmd string, becomeUser, becomeMethod string, timeout time.Duration) (stdout, stderr []byte, exitCode int, err error) {
+	becomeCmd, err := becomeCommand(cmd, becomeUser, becomeMethod)
+	if err != nil {
+		return nil, nil, -1, err
+	}
+	return c.ExecWithTimeout(becomeCmd, timeout)
+}
+
+// Start 在容器内启动命令（exec -i，不超时）
+func (c *ContainerConnection) Start(cmd string) (*Process, error) {
+	return startCommand(c.execCommand(context.Background(), cmd, true))
+}
+
+// StartWithBecome 使用权限提升在容器内启动命令
+func (c *ContainerConnection) StartWithBecome(cmd string, becomeUser, becomeMethod string) (*Process, error) {
+	becomeCmd, err := becomeCommand(cmd, becomeUser, becomeMethod)
+	if err != nil {
+		return nil, err
+	}
+	return c.Start(becomeCmd)
+}
+
+// ExecuteCommand 执行命令并返回标准输出
+func (c *ContainerConnection) ExecuteCommand(cmd string) ([]byte, error) {
+	return executeCommand(c, cmd)
+}
+
+// PutFile 上传本地文件到容器（流式传输，原子替换）
+// 新文件使用 0644 权限，已存在的文件保持原有权限
+func (c *ContainerConnection) PutFile(localPath, remotePath string) error {
+	f, err := os.Open(localPath)
+	if err != nil {
+		return fmt.Errorf("failed to read local file: %w", err)
+	}
+	defer f.Close()
+
+	return c.Upload(f, remotePath, 0)
+}
+
+// Upload 把 r 的内容写入容器内的文件
+// 与 SSH 的 shell 方式相同：数据经 stdin 流入同目录的临时文件，设置权限后再重命名
+func (c *ContainerConnection) Upload(r io.Reader, remotePath string, mode os.FileMode) error {
+	var stderr bytes.Buffer
+	command := c.execCommand(context.Background(), shellUploadCommand(remotePath, mode), true)
+	command.Stdin = r
+	command.Stderr = &stderr
+
+	if err := command.Run(); err != nil {
+		return fmt.Errorf("failed to upload %s: %s", remotePath, commandError(err, &stderr))
+	}
+	return nil
+}
+
+// GetFile 从容器下载文件（流式传输，本地原子写入，权限 0644）
+func (c *ContainerConnection) GetFile(remotePath, localPath string) error {
+	tmp, err := os.CreateTemp(filepath.Dir(localPath), "."+filepath.Base(localPath)+".ansigo-*")
+	if err != nil {
+		return fmt.Errorf("failed to write local file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	var stderr bytes.Buffer
+	command := c.execCommand(context.Background(), "cat "+shellPath(remotePath), false)
+	command.Stdout = tmp
+	command.Stderr = &stderr
+
+	err = command.Run()
+	if cerr := tmp.Close(); err == nil && cerr != nil {
+		return fmt.Errorf("failed to write local file: %w", cerr)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read remote file %s: %s", remotePath, commandError(err, &stderr))
+	}
+
+	if err := os.Chmod(tmp.Name(), defaultFileMode); err != nil {
+		return fmt.Errorf("failed to write local file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), localPath); err != nil {
+		return fmt.Errorf("failed to write local file: %w", err)
+	}
+	return nil
+}
+
+// Close 容器连接没有需要释放的资源
+func (c *ContainerConnection) Close() error {
+	return nil
+}
+
+// commandError 返回运行时命令失败的原因，优先使用 stderr 的内容
+func commandError(err error, stderr *bytes.Buffer) string {
+	if msg := strings.TrimSpace(stderr.String()); msg != "" {
+		return msg
+	}
+	return err.Error()
+}
@@ -0,0 +1,185 @@
+package connection
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jimyag/ansigo/pkg/errors"
+	"github.com/jimyag/ansigo/pkg/inventory"
+)
+
+// fakeRuntime 模拟 docker/podman 命令：inspect 报告容器状态，exec 在本地执行容器内的命令
+// 每次调用的参数记录在同目录的 calls.log 中
+const fakeRuntime = `#!/bin/sh
+echo "$*" >> "$(dirname "$0")/calls.log"
+while [ "$1" = -H ]; do shift 2; done
+case "$1" in
+inspect)
+	case "$4" in
+	running) echo true ;;
+	stopped) echo false ;;
+	*) echo "Error: No such object: $4" >&2; exit 1 ;;
+	esac ;;
+exec)
+	shift
+	while [ "$1" = -u ] || [ "$1" = -i ]; do
+		[ "$1" = -u ] && shift
+		shift
+	done
+	shift
+	exec "$@" ;;
+*)
+	exit 125 ;;
+esac
+`
+
+// newFakeContainerHost 返回使用假运行时的容器主机和记录调用参数的文件路径
+func newFakeContainerHost(t *testing.T, runtime, container string) (*inventory.Host, string) {
+	t.Helper()
+
+	dir := t.TempDir()
+	executable := filepath.Join(dir, runtime)
+	if err := os.WriteFile(executable, []byte(fakeRuntime), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	host := &inventory.Host{
+		Name: container,
+		Vars: map[string]interface{}{
+			"ansible_connection":                 runtime,
+			"ansible_" + runtime + "_executable": executable,
+		},
+	}
+	return host, filepath.Join(dir, "calls.log")
+}
+
+func TestContainerConnect(t *testing.T) {
+	mgr := NewManager()
+	defer mgr.Close()
+
+	for _, runtime := range []string{ConnectionDocker, ConnectionPodman} {
+		t.Run(runtime, func(t *testing.T) {
+			host, _ := newFakeContainerHost(t, runtime, "running")
+			conn, err := mgr.Connect(host)
+			if err != nil {
+				t.Fatalf("Connect() error = %v", err)
+			}
+			if _, ok := conn.(*ContainerConnection); !ok {
+				t.Errorf("Connect() = %T, want *ContainerConnection", conn)
+			}
+		})
+	}
+
+	tests := []struct {
+		name    string
+		host    func() *inventory.Host
+		wantMsg string
+	}{
+		{
+			name: "stopped",
+			host: func() *inventory.Host {
+				host, _ := newFakeContainerHost(t, "docker", "stopped")
+				return host
+			},
+			wantMsg: "is not running",
+		},
+		{
+			name: "missing",
+			host: func() *inventory.Host {
+				host, _ := newFakeContainerHost(t, "docker", "missing")
+				return host
+			},
+			wantMsg: "No such object: missing",
+		},
+		{
+			name: "no runtime",
+			host: func() *inventory.Host {
+				host, _ := newFakeContainerHost(t, "docker", "running")
+				host.Vars["ansible_docker_executable"] = filepath.Join(t.TempDir(), "docker")
+				return host
+			},
+			wantMsg: "no such file",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := mgr.Connect(tt.host())
+			if !errors.IsUnreachable(err) {
+				t.Fatalf("Connect() error = %v, want unreachable", err)
+			}
+			if !strings.Contains(err.Error(), tt.wantMsg) {
+				t.Errorf("Connect() error = %v, want it to contain %q", err, tt.wantMsg)
+			}
+		})
+	}
+}
+
+func TestContainerExec(t *testing.T) {
+	host, callsLog := newFakeContainerHost(t, "podman", "running")
+	host.Vars["ansible_host"] = "app-1"
+	host.Vars["ansible_user"] = "app"
+	host.Vars["ansible_podman_extra_args"] = "-H unix:///run/podman.sock"
+	conn := NewContainerConnection(host, ConnectionPodman)
+
+	stdout, stderr, exitCode, err := conn.Exec("echo out; echo err >&2; exit 3")
+	if err != nil {
+		t.Fatalf("Exec() error = %v", err)
+	}
+	if exitCode != 3 || string(stdout) != "out\n" || string(stderr) != "err\n" {
+		t.Errorf("Exec() = %q, %q, %d", stdout, stderr, exitCode)
+	}
+
+	calls, err := os.ReadFile(callsLog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "-H unix:///run/podman.sock exec -u app app-1 /bin/sh -c echo out; echo err >&2; exit 3\n"
+	if string(calls) != want {
+		t.Errorf("runtime called with %q, want %q", calls, want)
+	}
+
+	if _, err := conn.ExecuteCommand("false"); err == nil {
+		t.Error("ExecuteCommand(false) error = nil, want error")
+	}
+}
+
+func TestContainerTransfer(t *testing.T) {
+	host, _ := newFakeContainerHost(t, "docker", "running")
+	conn := NewContainerConnection(host, ConnectionDocker)
+	dir := t.TempDir()
+
+	// 新文件使用指定权限
+	dest := filepath.Join(dir, "app.conf")
+	if err := conn.Upload(strings.NewReader("port = 80\n"), dest, 0o600); err != nil {
+		t.Fatalf("Upload() error = %v", err)
+	}
+	assertFile(t, dest, "port = 80\n", 0o600)
+
+	// mode 为 0 时保持已有权限
+	src := filepath.Join(dir, "src")
+	if err := os.WriteFile(src, []byte("port = 8080\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.PutFile(src, dest); err != nil {
+		t.Fatalf("PutFile() error = %v", err)
+	}
+	assertFile(t, dest, "port = 8080\n", 0o600)
+
+	local := filepath.Join(dir, "fetched")
+	if err := conn.GetFile(dest, local); err != nil {
+		t.Fatalf("GetFile() error = %v", err)
+	}
+	assertFile(t, local, "port = 8080\n", 0o644)
+
+	// 远程文件不存在时报错，不创建本地文件
+	missing := filepath.Join(dir, "missing-copy")
+	if err := conn.GetFile(filepath.Join(dir, "missing"), missing); err == nil {
+		t.Error("GetFile() of missing file error = nil, want error")
+	}
+	if _, err := os.Stat(missing); !os.IsNotExist(err) {
+		t.Errorf("GetFile() of missing file created %s", missing)
+	}
+}
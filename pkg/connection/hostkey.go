@@ -0,0 +1,262 @@
+package connection
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/jimyag/ansigo/pkg/errors"
+	"github.com/jimyag/ansigo/pkg/inventory"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// HostKeyChecking 主机密钥检查模式
+type HostKeyChecking string
+
+const (
+	// HostKeyStrict 只接受 known_hosts 中已记录的密钥
+	HostKeyStrict HostKeyChecking = "strict"
+	// HostKeyAcceptNew 未知主机自动记录到 known_hosts，已记录主机的密钥变化时拒绝连接
+	HostKeyAcceptNew HostKeyChecking = "accept-new"
+	// HostKeyOff 不检查主机密钥
+	HostKeyOff HostKeyChecking = "off"
+)
+
+// knownHostsMu 串行化对 known_hosts 文件的写入
+var knownHostsMu sync.Mutex
+
+// ParseHostKeyChecking 解析主机密钥检查模式
+// 除 strict/accept-new/off 外，还接受 Ansible 风格的布尔值（true 等价于 strict，false 等价于 off）
+func ParseHostKeyChecking(value interface{}) (HostKeyChecking, error) {
+	switch v := value.(type) {
+	case bool:
+		if v {
+			return HostKeyStrict, nil
+		}
+		return HostKeyOff, nil
+	case string:
+		switch strings.ToLower(strings.TrimSpace(v)) {
+		case "strict", "yes", "true", "1":
+			return HostKeyStrict, nil
+		case "accept-new", "accept_new":
+			return HostKeyAcceptNew, nil
+		case "off", "no", "false", "0":
+			return HostKeyOff, nil
+		}
+	}
+	return "", fmt.Errorf("invalid host key checking mode: %v (must be strict, accept-new or off)", value)
+}
+
+// SetHostKeyChecking 设置默认的主机密钥检查模式（可被 ansible_host_key_checking 覆盖）
+func (m *Manager) SetHostKeyChecking(mode HostKeyChecking) {
+	m.hostKeyChecking = mode
+}
+
+// SetKnownHostsFile 设置自定义 known_hosts 文件路径（可被 ansible_ssh_known_hosts_file 覆盖）
+// 为空时使用 ~/.ssh/known_hosts
+func (m *Manager) SetKnownHostsFile(path string) {
+	m.knownHostsFile = path
+}
+
+// hostKeyCallback 根据主机变量、ssh 参数、Manager 设置和 ssh_config 构建主机密钥校验回调，
+// 同时返回 known_hosts 中为该主机记录的密钥算法（为空时使用 x/crypto 的默认顺序）
+//
+// 检查模式的优先级（从高到低）：
+//  1. ansible_host_key_checking
+//  2. ansible_ssh_common_args/ansible_ssh_extra_args 中的 -o StrictHostKeyChecking
+//  3. Manager 设置（命令行 -host-key-checking）
+//  4. ssh_config 中的 StrictHostKeyChecking
+//  5. strict（与 Ansible 默认的 host_key_checking=True 一致）
+//
+// known_hosts 文件按 ansible_ssh_known_hosts_file、-o UserKnownHostsFile、Manager 设置、
+// ssh_config 中的 UserKnownHostsFile、~/.ssh/known_hosts 的顺序确定
+func (m *Manager) hostKeyCallback(host *inventory.Host, params *sshParams) (ssh.HostKeyCallback, []string, error) {
+	argMode, err := sshArgsOption(host, "stricthostkeychecking")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var mode HostKeyChecking
+	if v, ok := host.Vars["ansible_host_key_checking"]; ok {
+		if mode, err = ParseHostKeyChecking(v); err != nil {
+			return nil, nil, err
+		}
+	} else if argMode != "" {
+		if mode, err = parseStrictHostKeyChecking(argMode); err != nil {
+			return nil, nil, err
+		}
+	} else if m.hostKeyChecking != "" {
+		mode = m.hostKeyChecking
+	} else if params.strictHostKeyChecking != "" {
+		if mode, err = parseStrictHostKeyChecking(params.strictHostKeyChecking); err != nil {
+			return nil, nil, fmt.Errorf("invalid StrictHostKeyChecking in ssh config: %w", err)
+		}
+	} else {
+		mode = HostKeyStrict
+	}
+
+	if mode == HostKeyOff {
+		return ssh.InsecureIgnoreHostKey(), nil, nil
+	}
+
+	argFile, err := sshArgsOption(host, "userknownhostsfile")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	file := hostVarString(host, "ansible_ssh_known_hosts_file")
+	for _, v := range []string{argFile, m.knownHostsFile, params.userKnownHostsFile} {
+		if file == "" {
+			file = v
+		}
+	}
+	if file != "" {
+		// UserKnownHostsFile 可以列出多个文件，只使用第一个
+		if fields := strings.Fields(file); len(fields) > 0 {
+			file = fields[0]
+		}
+		hostname, _, _ := net.SplitHostPort(params.addr)
+		file = expandSSHTokens(file, hostname, params.user)
+	} else {
+		homeDir, err := os.UserHomeDir()
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to locate home directory for known_hosts: %w", err)
+		}
+		file = filepath.Join(homeDir, ".ssh", "known_hosts")
+	}
+
+	callback := func(addr string, remote net.Addr, key ssh.PublicKey) error {
+		return verifyHostKey(host.Name, file, mode, addr, remote, key)
+	}
+	return callback, knownHostKeyAlgorithms(file, params.addr), nil
+}
+
+// parseStrictHostKeyChecking 解析 OpenSSH 的 StrictHostKeyChecking 选项
+// ask 无法交互确认，按 yes 处理
+func parseStrictHostKeyChecking(value string) (HostKeyChecking, error) {
+	if strings.EqualFold(strings.TrimSpace(value), "ask") {
+		return HostKeyStrict, nil
+	}
+	return ParseHostKeyChecking(value)
+}
+
+// knownHostKeyAlgorithms 返回 known_hosts 中为 addr 记录的密钥对应的主机密钥算法
+//
+// x/crypto 默认优先协商 ECDSA，只记录了 ssh-ed25519 的主机会因为服务端出示 ECDSA 密钥而被判定为密钥不匹配，
+// 因此与 OpenSSH 一样优先使用已记录的密钥类型。没有记录时返回 nil
+func knownHostKeyAlgorithms(file, addr string) []string {
+	knownHostsMu.Lock()
+	defer knownHostsMu.Unlock()
+
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil
+	}
+	remote := &net.TCPAddr{IP: net.ParseIP(host)}
+	remote.Port, _ = strconv.Atoi(port)
+
+	// 用不会匹配的密钥查询，KeyError.Want 中是该主机所有已记录的密钥
+	keyErr, ok := checkKnownHosts(file, addr, remote, probeKey{}).(*knownhosts.KeyError)
+	if !ok {
+		return nil
+	}
+
+	var algorithms []string
+	seen := make(map[string]bool)
+	for _, known := range keyErr.Want {
+		algos := []string{known.Key.Type()}
+		// RSA 密钥可以使用 SHA-2 签名算法
+		if algos[0] == ssh.KeyAlgoRSA {
+			algos = []string{ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256, ssh.KeyAlgoRSA}
+		}
+		for _, algo := range algos {
+			if !seen[algo] {
+				seen[algo] = true
+				algorithms = append(algorithms, algo)
+			}
+		}
+	}
+	return algorithms
+}
+
+// probeKey 用于查询 known_hosts 的占位密钥，不与任何记录匹配
+type probeKey struct{}
+
+func (probeKey) Type() string                                 { return "" }
+func (probeKey) Marshal() []byte                              { return nil }
+func (probeKey) Verify(data []byte, sig *ssh.Signature) error { return fmt.Errorf("probe key") }
+
+// verifyHostKey 校验主机密钥
+func verifyHostKey(hostname, file string, mode HostKeyChecking, addr string, remote net.Addr, key ssh.PublicKey) error {
+	knownHostsMu.Lock()
+	defer knownHostsMu.Unlock()
+
+	err := checkKnownHosts(file, addr, remote, key)
+	if err == nil {
+		return nil
+	}
+
+	keyErr, ok := err.(*knownhosts.KeyError)
+	if !ok {
+		return err
+	}
+
+	// Want 非空表示该主机已有记录但密钥不一致
+	if len(keyErr.Want) > 0 {
+		return errors.NewHostKeyMismatchError(hostname, addr, keyErr)
+	}
+
+	if mode == HostKeyStrict {
+		return errors.NewHostKeyUnknownError(hostname, addr, keyErr)
+	}
+
+	return appendKnownHost(file, addr, key)
+}
+
+// checkKnownHosts 在 known_hosts 文件中查找主机密钥
+// 文件不存在时视为没有任何记录
+func checkKnownHosts(file, addr string, remote net.Addr, key ssh.PublicKey) error {
+	if _, err := os.Stat(file); os.IsNotExist(err) {
+		return &knownhosts.KeyError{}
+	}
+
+	callback, err := knownhosts.New(file)
+	if err != nil {
+		return fmt.Errorf("failed to load known_hosts %s: %w", file, err)
+	}
+	return callback(addr, remote, key)
+}
+
+// appendKnownHost 将主机密钥追加到 known_hosts 文件
+func appendKnownHost(file, addr string, key ssh.PublicKey) error {
+	if err := os.MkdirAll(filepath.Dir(file), 0o700); err != nil {
+		return fmt.Errorf("failed to create known_hosts directory: %w", err)
+	}
+
+	f, err := os.OpenFile(file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to open known_hosts %s: %w", file, err)
+	}
+	defer f.Close()
+
+	line := knownhosts.Line([]string{knownhosts.Normalize(addr)}, key)
+	if _, err := f.WriteString(line + "\n"); err != nil {
+		return fmt.Errorf("failed to write known_hosts %s: %w", file, err)
+	}
+	return nil
+}
+
+// expandHome 展开路径开头的 ~
+func expandHome(path string) string {
+	if path == "~" || strings.HasPrefix(path, "~/") {
+		if homeDir, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(homeDir, path[1:])
+		}
+	}
+	return path
+}
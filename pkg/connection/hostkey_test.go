@@ -0,0 +1,263 @@
+package connection
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/jimyag/ansigo/pkg/errors"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+func TestParseHostKeyChecking(t *testing.T) {
+	tests := []struct {
+		value   interface{}
+		want    HostKeyChecking
+		wantErr bool
+	}{
+		{value: "strict", want: HostKeyStrict},
+		{value: "accept-new", want: HostKeyAcceptNew},
+		{value: "off", want: HostKeyOff},
+		{value: "yes", want: HostKeyStrict},
+		{value: "False", want: HostKeyOff},
+		{value: true, want: HostKeyStrict},
+		{value: false, want: HostKeyOff},
+		{value: "sometimes", wantErr: true},
+		{value: 1, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseHostKeyChecking(tt.value)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseHostKeyChecking(%v) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseHostKeyChecking(%v) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
+
+// knownHostsLine 返回测试服务器在 known_hosts 中的记录行
+func (s *testSSHServer) knownHostsLine(key ssh.PublicKey) string {
+	addr := s.listener.Addr().(*net.TCPAddr)
+	hostport := net.JoinHostPort("127.0.0.1", strconv.Itoa(addr.Port))
+	return knownhosts.Line([]string{knownhosts.Normalize(hostport)}, key) + "\n"
+}
+
+func TestHostKeyAcceptNew(t *testing.T) {
+	server := newTestSSHServer(t)
+	os.Remove(server.knownHosts)
+	mgr := NewManager()
+	mgr.SetHostKeyChecking(HostKeyAcceptNew)
+	defer mgr.Close()
+
+	if _, err := mgr.Connect(server.host("web1")); err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+
+	data, err := os.ReadFile(server.knownHosts)
+	if err != nil {
+		t.Fatalf("known_hosts not written: %v", err)
+	}
+	if string(data) != server.knownHostsLine(server.hostKey.PublicKey()) {
+		t.Errorf("known_hosts = %q", data)
+	}
+
+	// 重新握手时应命中已记录的密钥，且不重复追加
+	mgr.Close()
+	if _, err := mgr.Connect(server.host("web1")); err != nil {
+		t.Fatalf("Connect() with recorded key error = %v", err)
+	}
+	data, _ = os.ReadFile(server.knownHosts)
+	if n := strings.Count(string(data), "\n"); n != 1 {
+		t.Errorf("known_hosts has %d lines, want 1", n)
+	}
+}
+
+func TestHostKeyStrictUnknown(t *testing.T) {
+	server := newTestSSHServer(t)
+	os.Remove(server.knownHosts)
+	mgr := NewManager()
+	mgr.SetHostKeyChecking(HostKeyStrict)
+	defer mgr.Close()
+
+	_, err := mgr.Connect(server.host("web1"))
+	execErr, ok := errors.AsExecutionError(err)
+	if !ok || execErr.Type != errors.ErrHostKeyUnknown {
+		t.Fatalf("Connect() error = %v, want host key unknown error", err)
+	}
+	if _, statErr := os.Stat(server.knownHosts); !os.IsNotExist(statErr) {
+		t.Errorf("strict mode must not write known_hosts")
+	}
+}
+
+func TestHostKeyMismatch(t *testing.T) {
+	server := newTestSSHServer(t)
+
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(server.knownHosts, []byte(server.knownHostsLine(otherKey)), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	mgr := NewManager()
+	defer mgr.Close()
+
+	_, err = mgr.Connect(server.host("web1"))
+	execErr, ok := errors.AsExecutionError(err)
+	if !ok || execErr.Type != errors.ErrHostKeyMismatch {
+		t.Fatalf("Connect() error = %v, want host key mismatch error", err)
+	}
+	if errors.IsUnreachable(err) {
+		t.Errorf("host key mismatch must not be reported as unreachable")
+	}
+
+	// 关闭检查后可以连接
+	host := server.host("web1")
+	host.Vars["ansible_host_key_checking"] = false
+	if _, err := mgr.Connect(host); err != nil {
+		t.Errorf("Connect() with checking off error = %v", err)
+	}
+}
+
+func TestHostKeyDefaultStrict(t *testing.T) {
+	server := newTestSSHServer(t)
+	os.Remove(server.knownHosts)
+	mgr := NewManager()
+	defer mgr.Close()
+
+	_, err := mgr.Connect(server.host("web1"))
+	if execErr, ok := errors.AsExecutionError(err); !ok || execErr.Type != errors.ErrHostKeyUnknown {
+		t.Fatalf("Connect() error = %v, want host key unknown error", err)
+	}
+}
+
+func TestHostKeyOptionsFromSSHArgs(t *testing.T) {
+	server := newTestSSHServer(t)
+	os.Remove(server.knownHosts)
+	mgr := NewManager()
+	defer mgr.Close()
+
+	// 与 tests/inventory 中的写法一致
+	host := server.host("web1")
+	delete(host.Vars, "ansible_ssh_known_hosts_file")
+	host.Vars["ansible_ssh_common_args"] = "-o StrictHostKeyChecking=no -o UserKnownHostsFile=/dev/null"
+	if _, err := mgr.Connect(host); err != nil {
+		t.Fatalf("Connect() with StrictHostKeyChecking=no error = %v", err)
+	}
+
+	// UserKnownHostsFile 决定记录密钥的文件，ssh 参数优先于 Manager 设置
+	mgr.SetHostKeyChecking(HostKeyStrict)
+	knownHosts := filepath.Join(t.TempDir(), "known_hosts")
+	host = server.host("web2")
+	delete(host.Vars, "ansible_ssh_known_hosts_file")
+	host.Vars["ansible_ssh_extra_args"] = "-o 'StrictHostKeyChecking accept-new' -oUserKnownHostsFile=" + knownHosts
+	if _, err := mgr.Connect(host); err != nil {
+		t.Fatalf("Connect() with StrictHostKeyChecking=accept-new error = %v", err)
+	}
+	if data, err := os.ReadFile(knownHosts); err != nil || string(data) != server.knownHostsLine(server.hostKey.PublicKey()) {
+		t.Errorf("UserKnownHostsFile = %q, %v", data, err)
+	}
+
+	// ansible_host_key_checking 优先于 ssh 参数
+	host = server.host("web3")
+	host.Vars["ansible_ssh_common_args"] = "-o StrictHostKeyChecking=no"
+	host.Vars["ansible_host_key_checking"] = true
+	if _, err := mgr.Connect(host); !isHostKeyUnknown(err) {
+		t.Errorf("Connect() with ansible_host_key_checking=true error = %v, want host key unknown error", err)
+	}
+}
+
+func TestHostKeyOptionsFromSSHConfig(t *testing.T) {
+	server := newTestSSHServer(t)
+	os.Remove(server.knownHosts)
+
+	// 与 tests/docker/control-node.Dockerfile 中的配置一致
+	config := filepath.Join(t.TempDir(), "config")
+	if err := os.WriteFile(config, []byte("Host *\n    StrictHostKeyChecking no\n    UserKnownHostsFile=/dev/null\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	host := server.host("web1")
+	delete(host.Vars, "ansible_ssh_known_hosts_file")
+	host.Vars["ansible_ssh_config"] = config
+
+	mgr := NewManager()
+	defer mgr.Close()
+	if _, err := mgr.Connect(host); err != nil {
+		t.Fatalf("Connect() with ssh config StrictHostKeyChecking no error = %v", err)
+	}
+
+	// 命令行指定的模式优先于 ssh_config
+	strict := NewManager()
+	strict.SetHostKeyChecking(HostKeyStrict)
+	defer strict.Close()
+	if _, err := strict.Connect(host); !isHostKeyUnknown(err) {
+		t.Errorf("Connect() with strict manager error = %v, want host key unknown error", err)
+	}
+}
+
+func TestHostKeyAlgorithmsFromKnownHosts(t *testing.T) {
+	// 服务器同时提供 ECDSA 和 ed25519 密钥，known_hosts 中只记录了 ed25519
+	ecdsaKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(ecdsaKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := newTestSSHServer(t, signer)
+
+	mgr := NewManager()
+	defer mgr.Close()
+	if _, err := mgr.Connect(server.host("web1")); err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+
+	addr := net.JoinHostPort("127.0.0.1", strconv.Itoa(server.port()))
+	if got := knownHostKeyAlgorithms(server.knownHosts, addr); !reflect.DeepEqual(got, []string{ssh.KeyAlgoED25519}) {
+		t.Errorf("knownHostKeyAlgorithms() = %v", got)
+	}
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaPub, err := ssh.NewPublicKey(&rsaKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(t.TempDir(), "known_hosts")
+	if err := os.WriteFile(file, []byte(server.knownHostsLine(rsaPub)+server.knownHostsLine(signer.PublicKey())), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256, ssh.KeyAlgoRSA, ssh.KeyAlgoECDSA256}
+	if got := knownHostKeyAlgorithms(file, addr); !reflect.DeepEqual(got, want) {
+		t.Errorf("knownHostKeyAlgorithms() = %v, want %v", got, want)
+	}
+	if got := knownHostKeyAlgorithms(file, "10.0.0.1:22"); got != nil {
+		t.Errorf("knownHostKeyAlgorithms() for unknown host = %v, want nil", got)
+	}
+}
+
+func isHostKeyUnknown(err error) bool {
+	execErr, ok := errors.AsExecutionError(err)
+	return ok && execErr.Type == errors.ErrHostKeyUnknown
+}
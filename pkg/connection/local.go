@@ -0,0 +1,188 @@
+package connection
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"time"
+
+	"github.com/jimyag/ansigo/pkg/errors"
+	"github.com/jimyag/ansigo/pkg/inventory"
+)
+
+// LocalConnection 在控制节点本地执行命令和读写文件（ansible_connection=local）
+type LocalConnection struct {
+	host *inventory.Host
+}
+
+// NewLocalConnection 创建本地连接
+func NewLocalConnection(host *inventory.Host) *LocalConnection {
+	return &LocalConnection{host: host}
+}
+
+// Exec 执行命令
+func (c *LocalConnection) Exec(cmd string) (stdout, stderr []byte, exitCode int, err error) {
+	return c.ExecWithTimeout(cmd, 30*time.Second)
+}
+
+// ExecWithTimeout 通过 /bin/sh -c 执行命令（带超时）
+func (c *LocalConnection) ExecWithTimeout(cmd string, timeout time.Duration) (stdout, stderr []byte, exitCode int, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var stdoutBuf, stderrBuf bytes.Buffer
+	command := exec.CommandContext(ctx, "/bin/sh", "-c", cmd)
+	command.Stdout = &stdoutBuf
+	command.Stderr = &stderrBuf
+	// 超时杀掉 sh 后，不再等待仍持有输出管道的子进程
+	command.WaitDelay = time.Second
+
+	err = command.Run()
+	if ctx.Err() == context.DeadlineExceeded {
+		return nil, nil, -1, errors.NewTimeoutError(c.host.Name, cmd, timeout)
+	}
+
+	stdout = stdoutBuf.Bytes()
+	stderr = stderrBuf.Bytes()
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			return stdout, stderr, exitErr.ExitCode(), nil
+		}
+		return stdout, stderr, -1, err
+	}
+	return stdout, stderr, 0, nil
+}
+
+// ExecWithBecome 使用权限提升执行命令
+// 当前用户已经是 become 用户时直接执行（例如以 root 运行且 become_user 为 root），不依赖 sudo
+func (c *LocalConnection) ExecWithBecome(cmd string, becomeUser, becomeMethod string) (stdout, stderr []byte, exitCode int, err error) {
+	return c.ExecWithBecomeTimeout(cmd, becomeUser, becomeMethod, 30*time.Second)
+}
+
+// ExecWithBecomeTimeout 使用权限提升执行命令（带超时），当前用户已经是 become 用户时直接执行
+func (c *LocalConnection) ExecWithBecomeTimeout(cmd string, becomeUser, becomeMethod string, timeout time.Duration) (stdout, stderr []byte, exitCode int, err error) {
+	if isCurrentUser(becomeUser) {
+		return c.ExecWithTimeout(cmd, timeout)
+	}
+
+	becomeCmd, err := becomeCommand(cmd, becomeUser, becomeMethod)
+	if err != nil {
+		return nil, nil, -1, err
+	}
+	return c.ExecWithTimeout(becomeCmd, timeout)
+}
+
+// Start 通过 /bin/sh -c 启动命令（不超时）
+func (c *LocalConnection) Start(cmd string) (*Process, error) {
+	return startCommand(exec.Command("/bin/sh", "-c", cmd))
+}
+
+// StartWithBecome 使用权限提升启动命令，当前用户已经是 become 用户时直接启动
+func (c *LocalConnection) StartWithBecome(cmd string, becomeUser, becomeMethod string) (*Process, error) {
+	if isCurrentUser(becomeUser) {
+		return c.Start(cmd)
+	}
+
+	becomeCmd, err := becomeCommand(cmd, becomeUser, becomeMethod)
+	if err != nil {
+		return nil, err
+	}
+	return c.Start(becomeCmd)
+}
+
+// ExecuteCommand 执行命令并返回标准输出
+func (c *LocalConnection) ExecuteCommand(cmd string) ([]byte, error) {
+	return executeCommand(c, cmd)
+}
+
+// PutFile 复制本地文件（原子替换）
+func (c *LocalConnection) PutFile(localPath, remotePath string) error {
+	f, err := os.Open(localPath)
+	if err != nil {
+		return fmt.Errorf("failed to read local file: %w", err)
+	}
+	defer f.Close()
+
+	return c.Upload(f, remotePath, 0)
+}
+
+// Upload 把 r 的内容原子地写入文件
+// mode 为 0 时已存在的文件保持原有权限，新文件使用 0644
+func (c *LocalConnection) Upload(r io.Reader, remotePath string, mode os.FileMode) error {
+	path := expandHome(remotePath)
+	if mode == 0 {
+		mode = defaultFileMode
+		if info, err := os.Stat(path); err == nil {
+			mode = info.Mode() & (os.ModePerm | specialModes)
+		}
+	} else {
+		mode = uploadMode(mode)
+	}
+
+	if err := writeFileAtomic(path, r, mode); err != nil {
+		return fmt.Errorf("failed to upload %s: %w", remotePath, err)
+	}
+	return nil
+}
+
+// GetFile 复制文件到 localPath（原子写入，权限 0644）
+func (c *LocalConnection) GetFile(remotePath, localPath string) error {
+	f, err := os.Open(expandHome(remotePath))
+	if err != nil {
+		return fmt.Errorf("failed to read remote file %s: %w", remotePath, err)
+	}
+	defer f.Close()
+
+	if err := writeFileAtomic(localPath, f, defaultFileMode); err != nil {
+		return fmt.Errorf("failed to write local file: %w", err)
+	}
+	return nil
+}
+
+// Close 本地连接没有需要释放的资源
+func (c *LocalConnection) Close() error {
+	return nil
+}
+
+// writeFileAtomic 先写入同目录的临时文件并设置权限，再重命名为 path
+func writeFileAtomic(path string, r io.Reader, mode os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".ansigo-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	// 写入内容前先设置权限（CreateTemp 创建的文件为 0600）；
+	// 写入会清除 setuid/setgid 位，特殊权限在写入后再设置
+	err = tmp.Chmod(mode.Perm())
+	if err == nil {
+		_, err = io.Copy(tmp, r)
+	}
+	if err == nil && mode&specialModes != 0 {
+		err = tmp.Chmod(mode)
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// isCurrentUser 判断 become 用户是否就是当前用户（为空时表示 root）
+func isCurrentUser(becomeUser string) bool {
+	if becomeUser == "" {
+		becomeUser = "root"
+	}
+	current, err := user.Current()
+	if err != nil {
+		return false
+	}
+	return current.Username == becomeUser || current.Uid == becomeUser
+}
@@ -0,0 +1,187 @@
+package connection
+
+import (
+	"io"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jimyag/ansigo/pkg/errors"
+	"github.com/jimyag/ansigo/pkg/inventory"
+)
+
+func TestConnectSelectsConnectionType(t *testing.T) {
+	mgr := NewManager()
+	defer mgr.Close()
+
+	tests := []struct {
+		name      string
+		host      *inventory.Host
+		wantLocal bool
+		wantErr   bool
+	}{
+		{
+			name:      "explicit local",
+			host:      &inventory.Host{Name: "controller", Vars: map[string]interface{}{"ansible_connection": "local"}},
+			wantLocal: true,
+		},
+		{
+			name:      "implicit localhost",
+			host:      &inventory.Host{Name: "localhost", Vars: map[string]interface{}{}},
+			wantLocal: true,
+		},
+		{
+			name:      "implicit 127.0.0.1",
+			host:      &inventory.Host{Name: "127.0.0.1", Vars: map[string]interface{}{}},
+			wantLocal: true,
+		},
+		{
+			name:    "unsupported",
+			host:    &inventory.Host{Name: "web1", Vars: map[string]interface{}{"ansible_connection": "winrm"}},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, err := mgr.Connect(tt.host)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Connect() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				if errors.IsUnreachable(err) {
+					t.Errorf("unsupported connection reported as unreachable: %v", err)
+				}
+				return
+			}
+			if _, ok := conn.(*LocalConnection); ok != tt.wantLocal {
+				t.Errorf("Connect() = %T, want local %v", conn, tt.wantLocal)
+			}
+		})
+	}
+
+	// localhost 显式指定 ssh 时不使用本地连接
+	server := newTestSSHServer(t)
+	host := server.host("localhost")
+	host.Vars["ansible_connection"] = "ssh"
+	conn, err := mgr.Connect(host)
+	if err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+	if _, ok := conn.(*SSHConnection); !ok {
+		t.Errorf("Connect() with ansible_connection=ssh = %T, want *SSHConnection", conn)
+	}
+}
+
+func TestLocalExec(t *testing.T) {
+	conn := NewLocalConnection(&inventory.Host{Name: "localhost"})
+
+	stdout, stderr, exitCode, err := conn.Exec("echo out; echo err >&2; exit 3")
+	if err != nil {
+		t.Fatalf("Exec() error = %v", err)
+	}
+	if string(stdout) != "out\n" || string(stderr) != "err\n" || exitCode != 3 {
+		t.Errorf("Exec() = %q, %q, %d", stdout, stderr, exitCode)
+	}
+
+	if _, err := conn.ExecuteCommand("false"); err == nil {
+		t.Error("ExecuteCommand(false) succeeded, want error")
+	}
+
+	_, _, _, err = conn.ExecWithTimeout("sleep 5", 100*time.Millisecond)
+	if execErr, ok := errors.AsExecutionError(err); !ok || execErr.Type != errors.ErrTimeout {
+		t.Errorf("ExecWithTimeout() error = %v, want timeout", err)
+	}
+
+	// become 用户就是当前用户时直接执行，不依赖 sudo
+	current, err := user.Current()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout, _, exitCode, err = conn.ExecWithBecome("id -un", current.Username, "sudo")
+	if err != nil || exitCode != 0 || strings.TrimSpace(string(stdout)) != current.Username {
+		t.Errorf("ExecWithBecome() = %q, %d, %v", stdout, exitCode, err)
+	}
+	_, _, _, err = conn.ExecWithBecomeTimeout("sleep 5", current.Username, "sudo", 100*time.Millisecond)
+	if execErr, ok := errors.AsExecutionError(err); !ok || execErr.Type != errors.ErrTimeout {
+		t.Errorf("ExecWithBecomeTimeout() error = %v, want timeout", err)
+	}
+}
+
+func TestLocalStart(t *testing.T) {
+	conn := NewLocalConnection(&inventory.Host{Name: "localhost"})
+
+	proc, err := conn.Start("read line; echo \"got $line\"; echo oops >&2; exit 2")
+	if err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	if _, err := io.WriteString(proc.Stdin, "hello\n"); err != nil {
+		t.Fatal(err)
+	}
+	out, err := io.ReadAll(proc.Stdout)
+	if err != nil || string(out) != "got hello\n" {
+		t.Errorf("stdout = %q, %v", out, err)
+	}
+	if err := proc.Wait(); err == nil || !strings.Contains(err.Error(), "oops") {
+		t.Errorf("Wait() error = %v, want exit error with stderr", err)
+	}
+
+	// Wait 关闭标准输入，读到 EOF 的进程正常退出；become 用户就是当前用户时不依赖 sudo
+	current, err := user.Current()
+	if err != nil {
+		t.Fatal(err)
+	}
+	proc, err = conn.StartWithBecome("cat >/dev/null", current.Username, "sudo")
+	if err != nil {
+		t.Fatalf("StartWithBecome() error = %v", err)
+	}
+	if err := proc.Wait(); err != nil {
+		t.Errorf("Wait() error = %v", err)
+	}
+}
+
+func TestLocalTransfer(t *testing.T) {
+	conn := NewLocalConnection(&inventory.Host{Name: "localhost"})
+	dir := t.TempDir()
+	dest := filepath.Join(dir, "it's a file.conf")
+
+	if err := conn.Upload(strings.NewReader("first\n"), dest, 0o600); err != nil {
+		t.Fatalf("Upload() error = %v", err)
+	}
+	assertFile(t, dest, "first\n", 0o600)
+
+	// mode 为 0 时保持已有文件的权限
+	if err := conn.Upload(strings.NewReader("second\n"), dest, 0); err != nil {
+		t.Fatalf("Upload() overwrite error = %v", err)
+	}
+	assertFile(t, dest, "second\n", 0o600)
+	assertSpecialModes(t, conn, t.TempDir())
+
+	copied := filepath.Join(dir, "copied.conf")
+	if err := conn.PutFile(dest, copied); err != nil {
+		t.Fatalf("PutFile() error = %v", err)
+	}
+	assertFile(t, copied, "second\n", 0o644)
+
+	fetched := filepath.Join(t.TempDir(), "fetched.conf")
+	if err := conn.GetFile(dest, fetched); err != nil {
+		t.Fatalf("GetFile() error = %v", err)
+	}
+	assertFile(t, fetched, "second\n", 0o644)
+
+	if err := conn.GetFile(filepath.Join(dir, "missing"), fetched); err == nil {
+		t.Error("GetFile() of missing file succeeded, want error")
+	}
+
+	// 不应留下临时文件
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Errorf("dir contains %d entries, want 2", len(entries))
+	}
+}
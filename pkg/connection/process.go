@@ -0,0 +1,94 @@
+package connection
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Starter 可以启动长时间运行的进程并通过标准输入输出与它交互的连接
+// （SSH、本地和容器连接都实现了它），远程 agent 通过它在一个会话中处理多个请求
+type Starter interface {
+	// Start 启动命令
+	Start(cmd string) (*Process, error)
+	// StartWithBecome 使用权限提升启动命令
+	StartWithBecome(cmd string, becomeUser, becomeMethod string) (*Process, error)
+}
+
+// Process 通过 Starter 启动的进程
+type Process struct {
+	Stdin  io.WriteCloser // 进程的标准输入，关闭后进程读到 EOF
+	Stdout io.Reader      // 进程的标准输出
+
+	stderr *lockedBuffer
+	wait   func() error
+	once   sync.Once
+	err    error
+}
+
+// newProcess 创建 Process，wait 等待进程退出
+func newProcess(stdin io.WriteCloser, stdout io.Reader, stderr *lockedBuffer, wait func() error) *Process {
+	return &Process{Stdin: stdin, Stdout: stdout, stderr: stderr, wait: wait}
+}
+
+// Wait 关闭标准输入并等待进程退出，进程以非零状态退出时错误中包含标准错误输出
+func (p *Process) Wait() error {
+	p.once.Do(func() {
+		p.Stdin.Close()
+		if err := p.wait(); err != nil {
+			if msg := strings.TrimSpace(p.Stderr()); msg != "" {
+				err = fmt.Errorf("%w: %s", err, msg)
+			}
+			p.err = err
+		}
+	})
+	return p.err
+}
+
+// Stderr 返回进程到目前为止的标准错误输出
+func (p *Process) Stderr() string {
+	return p.stderr.String()
+}
+
+// startCommand 启动控制节点上的命令（本地和容器连接使用），返回可以交互的 Process
+func startCommand(command *exec.Cmd) (*Process, error) {
+	stdin, err := command.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := command.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	stderr := &lockedBuffer{}
+	command.Stderr = stderr
+	// 进程退出后，不再等待仍持有输出管道的子进程
+	command.WaitDelay = time.Second
+
+	if err := command.Start(); err != nil {
+		return nil, err
+	}
+	return newProcess(stdin, stdout, stderr, command.Wait), nil
+}
+
+// lockedBuffer 可以在进程写入的同时读取的缓冲区
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
@@ -0,0 +1,348 @@
+package connection
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/jimyag/ansigo/pkg/inventory"
+)
+
+// jumpHost 跳板机
+type jumpHost struct {
+	user    string
+	host    string
+	port    int    // 为 0 时使用 ssh_config 或默认端口
+	keyFile string // ProxyCommand 中通过 -i 指定的私钥
+}
+
+func (j jumpHost) String() string {
+	addr := j.host
+	if j.port != 0 {
+		addr = net.JoinHostPort(j.host, strconv.Itoa(j.port))
+	}
+	if j.user != "" {
+		return j.user + "@" + addr
+	}
+	return addr
+}
+
+// inventoryHost 构造连接跳板机使用的主机
+// 跳板机继承目标主机的私钥、口令、ssh_config、ssh 参数和主机密钥检查设置，但不继承密码。
+// ssh 参数中的跳板机配置在拨号跳板机时被忽略。
+// 主机名作为 ssh_config 的 Host 别名解析，未指定的用户和端口由 ssh_config 或目标主机补全
+func (j jumpHost) inventoryHost(target *inventory.Host) *inventory.Host {
+	vars := make(map[string]interface{})
+	for _, name := range []string{
+		"ansible_ssh_private_key_file",
+		"ansible_private_key_file",
+		"ansible_ssh_private_key_passphrase",
+		"ansible_private_key_passphrase",
+		"ansible_ssh_certificate_file",
+		"ansible_host_key_checking",
+		"ansible_ssh_known_hosts_file",
+		"ansible_ssh_config",
+		"ansible_ssh_common_args",
+		"ansible_ssh_extra_args",
+	} {
+		if v, ok := target.Vars[name]; ok {
+			vars[name] = v
+		}
+	}
+
+	if j.port != 0 {
+		vars["ansible_port"] = j.port
+	}
+	if j.user != "" {
+		vars["ansible_user"] = j.user
+	}
+	if j.keyFile != "" {
+		vars["ansible_ssh_private_key_file"] = j.keyFile
+		delete(vars, "ansible_private_key_file")
+		delete(vars, "ansible_ssh_certificate_file")
+	}
+
+	return &inventory.Host{Name: j.host, Vars: vars}
+}
+
+// proxyJumps 从 ansible_ssh_common_args 和 ansible_ssh_extra_args 中解析跳板机链
+func proxyJumps(host *inventory.Host) ([]jumpHost, error) {
+	for _, name := range []string{"ansible_ssh_common_args", "ansible_ssh_extra_args"} {
+		args, _ := host.Vars[name].(string)
+		if args == "" {
+			continue
+		}
+		jumps, err := proxyJumpsFromArgs(args)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", name, err)
+		}
+		if len(jumps) > 0 {
+			return jumps, nil
+		}
+	}
+	return nil, nil
+}
+
+// proxyJumpsFromArgs 从 ssh 命令行参数中提取跳板机
+//
+// 支持的写法：
+//   - -J user@bastion:2222,other
+//   - -o ProxyJump=user@bastion
+//   - -o "ProxyJump user@bastion"
+//   - -o ProxyCommand="ssh -W %h:%p -q user@bastion"（-p/-l/-i/-J 会被识别）
+//
+// 其他参数被忽略
+func proxyJumpsFromArgs(args string) ([]jumpHost, error) {
+	tokens, err := splitShellArgs(args)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := 0; i < len(tokens); i++ {
+		token := tokens[i]
+
+		var value string
+		switch {
+		case token == "-J" || token == "-o":
+			if i+1 >= len(tokens) {
+				return nil, fmt.Errorf("option %s requires an argument", token)
+			}
+			i++
+			value = tokens[i]
+		case strings.HasPrefix(token, "-J") || strings.HasPrefix(token, "-o"):
+			value = token[2:]
+		default:
+			continue
+		}
+
+		if strings.HasPrefix(token, "-J") {
+			return parseJumpSpec(value)
+		}
+
+		key, val := splitSSHOption(value)
+		switch strings.ToLower(key) {
+		case "proxyjump":
+			if strings.EqualFold(val, "none") {
+				return nil, nil
+			}
+			return parseJumpSpec(val)
+		case "proxycommand":
+			if strings.EqualFold(val, "none") {
+				return nil, nil
+			}
+			return parseProxyCommand(val)
+		}
+	}
+
+	return nil, nil
+}
+
+// sshArgsOption 返回 ansible_ssh_common_args/ansible_ssh_extra_args 中 -o 指定的选项值
+// key 为小写的选项名；与 OpenSSH 一致，先出现的值生效
+func sshArgsOption(host *inventory.Host, key string) (string, error) {
+	for _, name := range []string{"ansible_ssh_common_args", "ansible_ssh_extra_args"} {
+		args, _ := host.Vars[name].(string)
+		if args == "" {
+			continue
+		}
+		tokens, err := splitShellArgs(args)
+		if err != nil {
+			return "", fmt.Errorf("invalid %s: %w", name, err)
+		}
+		for i := 0; i < len(tokens); i++ {
+			var option string
+			switch {
+			case tokens[i] == "-o" && i+1 < len(tokens):
+				i++
+				option = tokens[i]
+			case strings.HasPrefix(tokens[i], "-o"):
+				option = tokens[i][2:]
+			default:
+				continue
+			}
+			if k, v := splitSSHOption(option); strings.EqualFold(k, key) {
+				return v, nil
+			}
+		}
+	}
+	return "", nil
+}
+
+// splitSSHOption 拆分 -o 参数，支持 Key=Value 和 "Key Value" 两种写法
+func splitSSHOption(option string) (string, string) {
+	option = strings.TrimSpace(option)
+	if idx := strings.IndexAny(option, "= \t"); idx >= 0 {
+		return option[:idx], strings.TrimSpace(strings.TrimLeft(option[idx:], "= \t"))
+	}
+	return option, ""
+}
+
+// parseJumpSpec 解析 ProxyJump 规格：[user@]host[:port][,[user@]host[:port]...]
+func parseJumpSpec(spec string) ([]jumpHost, error) {
+	var jumps []jumpHost
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(part), "ssh://"))
+		if part == "" {
+			continue
+		}
+
+		var jump jumpHost
+		if idx := strings.LastIndex(part, "@"); idx >= 0 {
+			jump.user = part[:idx]
+			part = part[idx+1:]
+		}
+
+		if host, port, err := net.SplitHostPort(part); err == nil {
+			p, err := strconv.Atoi(port)
+			if err != nil {
+				return nil, fmt.Errorf("invalid port in jump host %s", part)
+			}
+			jump.host, jump.port = host, p
+		} else {
+			jump.host = strings.Trim(part, "[]")
+		}
+
+		if jump.host == "" {
+			return nil, fmt.Errorf("invalid jump host %q", spec)
+		}
+		jumps = append(jumps, jump)
+	}
+
+	if len(jumps) == 0 {
+		return nil, fmt.Errorf("empty jump host specification")
+	}
+	return jumps, nil
+}
+
+// sshOptionsWithArg 是 OpenSSH 中需要参数的选项
+const sshOptionsWithArg = "BbcDEeFIiJLlmOoPpQRSWw"
+
+// parseProxyCommand 把 "ssh -W %h:%p bastion" 形式的 ProxyCommand 转换为跳板机
+// 只支持通过 ssh -W 转发的命令，其他 ProxyCommand 无法用 ssh.Client 链式拨号实现
+func parseProxyCommand(command string) ([]jumpHost, error) {
+	tokens, err := splitShellArgs(command)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 || (tokens[0] != "ssh" && !strings.HasSuffix(tokens[0], "/ssh")) {
+		return nil, fmt.Errorf("unsupported ProxyCommand %q: only \"ssh -W %%h:%%p host\" is supported", command)
+	}
+
+	var (
+		destination string
+		user        string
+		port        int
+		keyFile     string
+		chain       []jumpHost
+		forwarding  bool
+	)
+
+	for i := 1; i < len(tokens); i++ {
+		token := tokens[i]
+		if !strings.HasPrefix(token, "-") || len(token) < 2 {
+			if destination == "" {
+				destination = token
+			}
+			continue
+		}
+
+		flag := token[1]
+		if !strings.ContainsRune(sshOptionsWithArg, rune(flag)) {
+			continue
+		}
+
+		value := token[2:]
+		if value == "" {
+			if i+1 >= len(tokens) {
+				return nil, fmt.Errorf("option -%c requires an argument in ProxyCommand %q", flag, command)
+			}
+			i++
+			value = tokens[i]
+		}
+
+		switch flag {
+		case 'W':
+			forwarding = true
+		case 'l':
+			user = value
+		case 'p':
+			if port, err = strconv.Atoi(value); err != nil {
+				return nil, fmt.Errorf("invalid port %q in ProxyCommand", value)
+			}
+		case 'i':
+			keyFile = value
+		case 'J':
+			if chain, err = parseJumpSpec(value); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if !forwarding || destination == "" {
+		return nil, fmt.Errorf("unsupported ProxyCommand %q: only \"ssh -W %%h:%%p host\" is supported", command)
+	}
+
+	jumps, err := parseJumpSpec(destination)
+	if err != nil {
+		return nil, err
+	}
+	last := &jumps[len(jumps)-1]
+	if user != "" && last.user == "" {
+		last.user = user
+	}
+	if port != 0 {
+		last.port = port
+	}
+	last.keyFile = keyFile
+
+	return append(chain, jumps...), nil
+}
+
+// splitShellArgs 按 shell 规则拆分参数，支持单引号、双引号和反斜杠转义
+func splitShellArgs(s string) ([]string, error) {
+	var (
+		args    []string
+		current strings.Builder
+		inArg   bool
+		quote   rune
+		escaped bool
+	)
+
+	for _, c := range s {
+		switch {
+		case escaped:
+			current.WriteRune(c)
+			escaped = false
+		case c == '\\' && quote != '\'':
+			escaped = true
+			inArg = true
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			} else {
+				current.WriteRune(c)
+			}
+		case c == '\'' || c == '"':
+			quote = c
+			inArg = true
+		case c == ' ' || c == '\t' || c == '\n':
+			if inArg {
+				args = append(args, current.String())
+				current.Reset()
+				inArg = false
+			}
+		default:
+			current.WriteRune(c)
+			inArg = true
+		}
+	}
+
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated quote in %q", s)
+	}
+	if inArg {
+		args = append(args, current.String())
+	}
+	return args, nil
+}
@@ -0,0 +1,119 @@
+package connection
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestProxyJumpsFromArgs(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    string
+		want    []jumpHost
+		wantErr bool
+	}{
+		{
+			name: "-J single",
+			args: "-J ops@bastion",
+			want: []jumpHost{{user: "ops", host: "bastion"}},
+		},
+		{
+			name: "-J chain with ports",
+			args: "-J ops@bastion:2222,inner",
+			want: []jumpHost{
+				{user: "ops", host: "bastion", port: 2222},
+				{host: "inner"},
+			},
+		},
+		{
+			name: "-o ProxyJump=",
+			args: "-o StrictHostKeyChecking=no -o ProxyJump=bastion",
+			want: []jumpHost{{host: "bastion"}},
+		},
+		{
+			name: "-o quoted with space",
+			args: `-o "ProxyJump ops@bastion"`,
+			want: []jumpHost{{user: "ops", host: "bastion"}},
+		},
+		{
+			name: "ProxyCommand ssh -W",
+			args: `-o ProxyCommand="ssh -W %h:%p -q -p 2222 -i ~/.ssh/bastion ops@bastion"`,
+			want: []jumpHost{{user: "ops", host: "bastion", port: 2222, keyFile: "~/.ssh/bastion"}},
+		},
+		{
+			name: "ProxyCommand with -l",
+			args: `-o 'ProxyCommand=ssh -l ops -W %h:%p bastion'`,
+			want: []jumpHost{{user: "ops", host: "bastion"}},
+		},
+		{
+			name: "no proxy",
+			args: "-o ControlMaster=auto -o ControlPersist=60s",
+			want: nil,
+		},
+		{
+			name:    "unsupported ProxyCommand",
+			args:    `-o ProxyCommand="nc -x proxy:1080 %h %p"`,
+			wantErr: true,
+		},
+		{
+			name:    "unterminated quote",
+			args:    `-o "ProxyJump bastion`,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := proxyJumpsFromArgs(tt.args)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("proxyJumpsFromArgs(%q) error = %v, wantErr %v", tt.args, err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("proxyJumpsFromArgs(%q) = %+v, want %+v", tt.args, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProxyJumpChain(t *testing.T) {
+	t.Setenv("SSH_AUTH_SOCK", "")
+	bastion := newTestSSHServer(t)
+	target := newTestSSHServer(t)
+
+	signer, keyFile := newTestKey(t, t.TempDir(), "id_ed25519", "")
+	bastion.authorize(signer.PublicKey())
+	target.authorize(signer.PublicKey())
+
+	// 跳板机使用目标主机的 known_hosts
+	f, err := os.OpenFile(target.knownHosts, os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(bastion.knownHostsLine(bastion.hostKey.PublicKey()))
+	f.Close()
+
+	mgr := NewManager()
+	defer mgr.Close()
+
+	host := target.keyHost("db1", keyFile)
+	host.Vars["ansible_ssh_common_args"] = fmt.Sprintf("-J tester@127.0.0.1:%d", bastion.port())
+
+	conn, err := mgr.Connect(host)
+	if err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+	stdout, _, exitCode, err := conn.Exec("echo via bastion")
+	if err != nil || exitCode != 0 {
+		t.Fatalf("Exec() = %d, %v", exitCode, err)
+	}
+	if string(stdout) != "via bastion\n" {
+		t.Errorf("Exec() stdout = %q", stdout)
+	}
+
+	if bastion.handshakes.Load() != 1 || target.handshakes.Load() != 1 {
+		t.Errorf("handshakes bastion=%d target=%d, want 1 and 1",
+			bastion.handshakes.Load(), target.handshakes.Load())
+	}
+}
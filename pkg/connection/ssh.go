@@ -4,133 +4,413 @@ import (
 	"bytes"
 	"context"
 	"fmt"
-	"io"
-	"os"
-	"path/filepath"
+	"net"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/jimyag/ansigo/pkg/errors"
 	"github.com/jimyag/ansigo/pkg/inventory"
+	"github.com/pkg/sftp"
 	"golang.org/x/crypto/ssh"
 )
 
-// Connection 表示一个 SSH 连接
-type Connection struct {
+// SSHConnection 表示一个 SSH 连接
+// 由 Manager 创建的连接共享同一主机的 *ssh.Client，每次执行命令时在其上新建 session
+type SSHConnection struct {
 	client *ssh.Client
 	host   *inventory.Host
+	mgr    *Manager // 所属的连接池（为 nil 时 Close 会关闭底层 client）
 }
 
 // Manager 管理 SSH 连接
+// Manager 是一个连接池：每个主机在一次运行期间只保持一个已认证的 *ssh.Client，
+// 调用 Close 时统一关闭
 type Manager struct {
-	timeout time.Duration
+	timeout         time.Duration
+	hostKeyChecking HostKeyChecking // 默认主机密钥检查模式（为空时使用 ssh_config 或 strict）
+	knownHostsFile  string          // 自定义 known_hosts 路径（为空时使用 ~/.ssh/known_hosts）
+
+	passphrasePrompt PassphrasePrompt      // 加密私钥的口令输入方式（为空时不提示）
+	authMu           sync.Mutex            // 保护 signers
+	signers          map[string]ssh.Signer // 已加载的私钥，按路径缓存
+
+	configMu   sync.Mutex            // 保护 sshConfigs
+	sshConfigs map[string]*sshConfig // 已解析的 ssh_config，按路径缓存（文件不存在时为 nil）
+
+	mu      sync.Mutex
+	clients map[string]*pooledClient // hostname -> client
+}
+
+// pooledClient 连接池中的一个 SSH 客户端
+type pooledClient struct {
+	mu     sync.Mutex    // 保护同一主机的拨号，避免并发重复握手
+	client *ssh.Client   // 已认证的客户端（尚未建立时为 nil）
+	done   chan struct{} // client 断开时关闭
+
+	sftp   *sftp.Client // 在 client 上打开的 SFTP 会话（按需创建）
+	noSFTP bool         // 远端没有 sftp-server，使用 shell 方式传输文件
+}
+
+// reset 关闭并丢弃 client 及其 SFTP 会话，调用方需持有 pc.mu
+func (pc *pooledClient) reset() error {
+	if pc.sftp != nil {
+		pc.sftp.Close()
+		pc.sftp = nil
+	}
+	pc.noSFTP = false
+
+	if pc.client == nil {
+		return nil
+	}
+	err := pc.client.Close()
+	pc.client = nil
+	return err
 }
 
 // NewManager 创建一个新的连接管理器
 func NewManager() *Manager {
 	return &Manager{
-		timeout: 30 * time.Second,
+		timeout:    30 * time.Second,
+		clients:    make(map[string]*pooledClient),
+		signers:    make(map[string]ssh.Signer),
+		sshConfigs: make(map[string]*sshConfig),
+	}
+}
+
+// connectSSH 建立 SSH 连接
+// 如果池中已有该主机的可用连接则直接复用，否则建立新连接
+func (m *Manager) connectSSH(host *inventory.Host) (*SSHConnection, error) {
+	client, err := m.getClient(host)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SSHConnection{
+		client: client,
+		host:   host,
+		mgr:    m,
+	}, nil
+}
+
+// Close 关闭池中所有连接
+func (m *Manager) Close() error {
+	m.mu.Lock()
+	clients := m.clients
+	m.clients = make(map[string]*pooledClient)
+	m.mu.Unlock()
+
+	var firstErr error
+	for _, pc := range clients {
+		pc.mu.Lock()
+		if err := pc.reset(); err != nil && firstErr == nil && !isClosedError(err) {
+			firstErr = err
+		}
+		pc.mu.Unlock()
 	}
+	return firstErr
 }
 
-// Connect 连接到主机
-func (m *Manager) Connect(host *inventory.Host) (*Connection, error) {
-	// 从 host.Vars 获取连接参数
-	ansibleHost, _ := host.Vars["ansible_host"].(string)
-	if ansibleHost == "" {
-		ansibleHost = host.Name
+// getClient 从池中获取主机的 client，不存在或已断开时重新拨号
+func (m *Manager) getClient(host *inventory.Host) (*ssh.Client, error) {
+	m.mu.Lock()
+	pc, exists := m.clients[host.Name]
+	if !exists {
+		pc = &pooledClient{}
+		m.clients[host.Name] = pc
+	}
+	m.mu.Unlock()
+
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+
+	if pc.client != nil {
+		select {
+		case <-pc.done:
+			// 连接已断开，丢弃后重新拨号
+			pc.reset()
+		default:
+			return pc.client, nil
+		}
+	}
+
+	client, err := m.dial(host)
+	if err != nil {
+		return nil, err
 	}
 
-	port := 22
-	if portStr, ok := host.Vars["ansible_port"].(string); ok {
-		if p, err := strconv.Atoi(portStr); err == nil {
-			port = p
+	pc.client = client
+	pc.done = make(chan struct{})
+	go func(c *ssh.Client, done chan struct{}) {
+		c.Wait()
+		close(done)
+	}(client, pc.done)
+
+	return client, nil
+}
+
+// reconnect 丢弃失效的 client 并重新拨号
+// 如果其他 goroutine 已经完成了重连，直接返回新的 client
+func (m *Manager) reconnect(host *inventory.Host, stale *ssh.Client) (*ssh.Client, error) {
+	m.mu.Lock()
+	pc, exists := m.clients[host.Name]
+	m.mu.Unlock()
+
+	if exists {
+		pc.mu.Lock()
+		if pc.client == stale {
+			pc.reset()
 		}
+		pc.mu.Unlock()
+	}
+
+	return m.getClient(host)
+}
+
+// sshParams 单个 SSH 连接的参数
+type sshParams struct {
+	addr          string     // host:port
+	user          string     // 登录用户
+	identityFiles []string   // ssh_config 中的 IdentityFile（仅在未设置 ansible_ssh_private_key_file 时使用）
+	jumps         []jumpHost // 跳板机链
+
+	strictHostKeyChecking string // ssh_config 中的 StrictHostKeyChecking
+	userKnownHostsFile    string // ssh_config 中的 UserKnownHostsFile
+}
+
+// resolveParams 计算连接参数
+//
+// 优先级（从高到低）：
+//  1. inventory 变量：ansible_host、ansible_port、ansible_user、ansible_ssh_private_key_file，
+//     以及 ansible_ssh_common_args/ansible_ssh_extra_args 中的 ProxyJump/ProxyCommand
+//  2. ansible_ssh_config 指定的 ssh_config 文件
+//  3. ~/.ssh/config
+//  4. 默认值：端口 22，用户 fallbackUser
+//
+// 与 Ansible 调用 ssh 时一致，ssh_config 的 Host 匹配 ansible_host（未设置时为 inventory 主机名）
+func (m *Manager) resolveParams(host *inventory.Host, fallbackUser string) (*sshParams, error) {
+	configs, err := m.sshConfigsFor(host)
+	if err != nil {
+		return nil, err
+	}
+
+	alias, _ := host.Vars["ansible_host"].(string)
+	explicitHost := alias != ""
+	if !explicitHost {
+		alias = host.Name
+	}
+
+	lookup := func(key string) string {
+		for _, cfg := range configs {
+			if v := cfg.get(alias, key); v != "" {
+				return v
+			}
+		}
+		return ""
+	}
+
+	hostname := alias
+	if !explicitHost {
+		if v := lookup("hostname"); v != "" {
+			hostname = expandSSHTokens(v, alias, "")
+		}
+	}
+
+	// YAML inventory 中端口是整数，INI 中是字符串
+	port := 0
+	switch p := host.Vars["ansible_port"].(type) {
+	case int:
+		port = p
+	case string:
+		if v, err := strconv.Atoi(p); err == nil {
+			port = v
+		}
+	}
+	if port == 0 {
+		if v := lookup("port"); v != "" {
+			if port, err = strconv.Atoi(v); err != nil {
+				return nil, fmt.Errorf("invalid Port %q in ssh config for %s", v, alias)
+			}
+		}
+	}
+	if port == 0 {
+		port = 22
 	}
 
 	user, _ := host.Vars["ansible_user"].(string)
 	if user == "" {
-		user = "root"
+		user = lookup("user")
+	}
+	if user == "" {
+		user = fallbackUser
 	}
 
-	password, _ := host.Vars["ansible_password"].(string)
-	keyFile, _ := host.Vars["ansible_ssh_private_key_file"].(string)
-
-	// 构建 SSH 配置
-	config := &ssh.ClientConfig{
-		User:            user,
-		Auth:            []ssh.AuthMethod{},
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(), // 测试用，生产环境应该验证
-		Timeout:         m.timeout,
+	params := &sshParams{
+		addr:                  net.JoinHostPort(hostname, strconv.Itoa(port)),
+		user:                  user,
+		strictHostKeyChecking: lookup("stricthostkeychecking"),
+		userKnownHostsFile:    lookup("userknownhostsfile"),
 	}
 
-	// 添加认证方式
-	if password != "" {
-		config.Auth = append(config.Auth, ssh.Password(password))
+	if hostVarString(host, "ansible_ssh_private_key_file", "ansible_private_key_file") == "" {
+		for _, cfg := range configs {
+			for _, file := range cfg.getAll(alias, "identityfile") {
+				params.identityFiles = append(params.identityFiles, expandSSHTokens(file, hostname, user))
+			}
+		}
 	}
 
-	if keyFile != "" {
-		auth, err := publicKeyAuth(keyFile)
-		if err == nil {
-			config.Auth = append(config.Auth, auth)
+	params.jumps, err = proxyJumps(host)
+	if err != nil {
+		return nil, err
+	}
+	if params.jumps == nil {
+		if v := lookup("proxyjump"); v != "" {
+			if !strings.EqualFold(v, "none") {
+				if params.jumps, err = parseJumpSpec(v); err != nil {
+					return nil, fmt.Errorf("invalid ProxyJump in ssh config for %s: %w", alias, err)
+				}
+			}
+		} else if v := lookup("proxycommand"); v != "" && !strings.EqualFold(v, "none") {
+			if params.jumps, err = parseProxyCommand(v); err != nil {
+				return nil, fmt.Errorf("invalid ProxyCommand in ssh config for %s: %w", alias, err)
+			}
 		}
 	}
 
-	// 如果没有指定认证方式，尝试默认密钥
-	if len(config.Auth) == 0 {
-		homeDir, _ := os.UserHomeDir()
-		defaultKeys := []string{
-			filepath.Join(homeDir, ".ssh", "id_rsa"),
-			filepath.Join(homeDir, ".ssh", "id_ed25519"),
+	return params, nil
+}
+
+// dial 建立到主机的 SSH 连接并完成认证
+// 配置了跳板机时，依次通过前一跳的 client 拨号到下一跳，最终 client 断开时关闭整条链
+func (m *Manager) dial(host *inventory.Host) (*ssh.Client, error) {
+	params, err := m.resolveParams(host, "root")
+	if err != nil {
+		return nil, err
+	}
+
+	var chain []*ssh.Client
+	closeChain := func() {
+		for i := len(chain) - 1; i >= 0; i-- {
+			chain[i].Close()
 		}
+	}
 
-		for _, keyPath := range defaultKeys {
-			if auth, err := publicKeyAuth(keyPath); err == nil {
-				config.Auth = append(config.Auth, auth)
+	var via *ssh.Client
+	for _, jump := range params.jumps {
+		// 跳板机未指定用户时使用目标主机的用户
+		jumpHost := jump.inventoryHost(host)
+		client, err := m.dialJump(jumpHost, params.user, via)
+		if err != nil {
+			closeChain()
+			// 跳板机主机密钥错误保持原样，其余错误归为目标主机不可达
+			if execErr, ok := errors.AsExecutionError(err); ok && execErr.Type != errors.ErrUnreachable {
+				return nil, execErr
 			}
+			return nil, errors.NewUnreachableError(host.Name, fmt.Errorf("jump host %s: %w", jump, err))
 		}
+		chain = append(chain, client)
+		via = client
+	}
+
+	client, err := m.dialHost(host, params, via)
+	if err != nil {
+		closeChain()
+		return nil, err
+	}
+
+	if len(chain) > 0 {
+		go func() {
+			client.Wait()
+			closeChain()
+		}()
+	}
+
+	return client, nil
+}
+
+// dialJump 连接跳板机（跳板机自身的 ProxyJump 配置被忽略）
+func (m *Manager) dialJump(host *inventory.Host, fallbackUser string, via *ssh.Client) (*ssh.Client, error) {
+	params, err := m.resolveParams(host, fallbackUser)
+	if err != nil {
+		return nil, err
+	}
+	params.jumps = nil
+	return m.dialHost(host, params, via)
+}
+
+// dialHost 连接单个主机，via 不为空时通过该 client 转发 TCP 连接
+func (m *Manager) dialHost(host *inventory.Host, params *sshParams, via *ssh.Client) (*ssh.Client, error) {
+	hostKeyCallback, hostKeyAlgorithms, err := m.hostKeyCallback(host, params)
+	if err != nil {
+		return nil, err
+	}
+
+	auth, cleanup, err := m.authMethods(host, params.identityFiles)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+
+	// 构建 SSH 配置
+	config := &ssh.ClientConfig{
+		User:              params.user,
+		Auth:              auth,
+		HostKeyCallback:   hostKeyCallback,
+		HostKeyAlgorithms: hostKeyAlgorithms,
+		Timeout:           m.timeout,
 	}
 
 	// 连接
-	addr := fmt.Sprintf("%s:%d", ansibleHost, port)
-	client, err := ssh.Dial("tcp", addr, config)
+	var client *ssh.Client
+	if via == nil {
+		client, err = ssh.Dial("tcp", params.addr, config)
+	} else {
+		client, err = dialVia(via, params.addr, config)
+	}
 	if err != nil {
+		// 主机密钥校验失败不是"不可达"，单独返回以免与网络故障混淆
+		if execErr, ok := errors.AsExecutionError(err); ok {
+			return nil, execErr
+		}
 		return nil, errors.NewUnreachableError(host.Name, err)
 	}
 
-	return &Connection{
-		client: client,
-		host:   host,
-	}, nil
+	return client, nil
 }
 
-// publicKeyAuth 创建公钥认证
-func publicKeyAuth(keyPath string) (ssh.AuthMethod, error) {
-	key, err := os.ReadFile(keyPath)
+// dialVia 通过已建立的 client 转发 TCP 连接并在其上完成 SSH 握手
+func dialVia(via *ssh.Client, addr string, config *ssh.ClientConfig) (*ssh.Client, error) {
+	conn, err := via.Dial("tcp", addr)
 	if err != nil {
 		return nil, err
 	}
 
-	signer, err := ssh.ParsePrivateKey(key)
+	c, chans, reqs, err := ssh.NewClientConn(conn, addr, config)
 	if err != nil {
+		conn.Close()
 		return nil, err
 	}
+	return ssh.NewClient(c, chans, reqs), nil
+}
 
-	return ssh.PublicKeys(signer), nil
+// isClosedError 判断错误是否是重复关闭连接导致的
+func isClosedError(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "use of closed network connection")
 }
 
 // Exec 执行命令
-func (c *Connection) Exec(cmd string) (stdout, stderr []byte, exitCode int, err error) {
+func (c *SSHConnection) Exec(cmd string) (stdout, stderr []byte, exitCode int, err error) {
 	return c.ExecWithTimeout(cmd, 30*time.Second)
 }
 
 // ExecWithTimeout 执行命令（带超时）
-func (c *Connection) ExecWithTimeout(cmd string, timeout time.Duration) (stdout, stderr []byte, exitCode int, err error) {
+func (c *SSHConnection) ExecWithTimeout(cmd string, timeout time.Duration) (stdout, stderr []byte, exitCode int, err error) {
 	ctx, cancel := context.WithTimeout(context.Background(), timeout)
 	defer cancel()
 
-	session, err := c.client.NewSession()
+	session, err := c.newSession()
 	if err != nil {
 		return nil, nil, -1, err
 	}
@@ -171,122 +451,89 @@ func (c *Connection) ExecWithTimeout(cmd string, timeout time.Duration) (stdout,
 	}
 }
 
-// PutFile 上传文件到远程主机
-func (c *Connection) PutFile(localPath, remotePath string) error {
-	// 读取本地文件
-	data, err := os.ReadFile(localPath)
-	if err != nil {
-		return fmt.Errorf("failed to read local file: %w", err)
-	}
-
-	// 使用 scp 协议上传
-	// 简化版：使用 cat > file 命令
+// newSession 在共享的 client 上新建 session
+// 如果 client 已失效（例如远端重启了 sshd），透明地重连一次
+func (c *SSHConnection) newSession() (*ssh.Session, error) {
 	session, err := c.client.NewSession()
-	if err != nil {
-		return err
+	if err == nil || c.mgr == nil {
+		return session, err
 	}
-	defer session.Close()
 
-	// 创建远程文件
-	cmd := fmt.Sprintf("cat > %s", remotePath)
-	stdin, err := session.StdinPipe()
-	if err != nil {
-		return err
+	client, rerr := c.mgr.reconnect(c.host, c.client)
+	if rerr != nil {
+		return nil, rerr
 	}
+	c.client = client
 
-	if err := session.Start(cmd); err != nil {
-		return err
-	}
+	return c.client.NewSession()
+}
 
-	// 写入数据
-	if _, err := io.Copy(stdin, bytes.NewReader(data)); err != nil {
-		return err
+// Close 关闭连接
+// 由连接池管理的连接只释放引用，底层 client 由 Manager.Close 统一关闭
+func (c *SSHConnection) Close() error {
+	if c.mgr != nil {
+		return nil
 	}
-	stdin.Close()
-
-	// 等待完成
-	if err := session.Wait(); err != nil {
-		return err
+	if c.client != nil {
+		return c.client.Close()
 	}
-
 	return nil
 }
 
-// GetFile 从远程主机下载文件
-func (c *Connection) GetFile(remotePath, localPath string) error {
-	// 使用 cat 命令读取远程文件
-	stdout, _, exitCode, err := c.Exec(fmt.Sprintf("cat %s", remotePath))
+// ExecWithBecome 使用权限提升执行命令
+func (c *SSHConnection) ExecWithBecome(cmd string, becomeUser, becomeMethod string) (stdout, stderr []byte, exitCode int, err error) {
+	return c.ExecWithBecomeTimeout(cmd, becomeUser, becomeMethod, 30*time.Second)
+}
+
+// ExecWithBecomeTimeout 使用权限提升执行命令（带超时）
+func (c *SSHConnection) ExecWithBecomeTimeout(cmd string, becomeUser, becomeMethod string, timeout time.Duration) (stdout, stderr []byte, exitCode int, err error) {
+	becomeCmd, err := becomeCommand(cmd, becomeUser, becomeMethod)
 	if err != nil {
-		return err
+		return nil, nil, -1, err
 	}
+	return c.ExecWithTimeout(becomeCmd, timeout)
+}
 
-	if exitCode != 0 {
-		return fmt.Errorf("failed to read remote file, exit code: %d", exitCode)
+// Start 在新的 session 中启动命令（不超时），进程退出后关闭 session
+func (c *SSHConnection) Start(cmd string) (*Process, error) {
+	session, err := c.newSession()
+	if err != nil {
+		return nil, err
 	}
 
-	// 写入本地文件
-	if err := os.WriteFile(localPath, stdout, 0o644); err != nil {
-		return fmt.Errorf("failed to write local file: %w", err)
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		session.Close()
+		return nil, err
 	}
-
-	return nil
-}
-
-// Close 关闭连接
-func (c *Connection) Close() error {
-	if c.client != nil {
-		return c.client.Close()
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		session.Close()
+		return nil, err
 	}
-	return nil
-}
+	stderr := &lockedBuffer{}
+	session.Stderr = stderr
 
-// ExecWithBecome 使用权限提升执行命令
-func (c *Connection) ExecWithBecome(cmd string, becomeUser, becomeMethod string) (stdout, stderr []byte, exitCode int, err error) {
-	// 如果没有指定 become_user，默认为 root
-	if becomeUser == "" {
-		becomeUser = "root"
-	}
-
-	// 如果没有指定 become_method，默认为 sudo
-	if becomeMethod == "" {
-		becomeMethod = "sudo"
-	}
-
-	// 构建 sudo 命令
-	// 使用 -n 选项避免密码提示（假设配置了 NOPASSWD）
-	// 使用 -u 指定目标用户
-	var sudoCmd string
-	switch becomeMethod {
-	case "sudo":
-		if becomeUser == "root" {
-			sudoCmd = fmt.Sprintf("sudo -n sh -c %s", shellQuote(cmd))
-		} else {
-			sudoCmd = fmt.Sprintf("sudo -n -u %s sh -c %s", becomeUser, shellQuote(cmd))
-		}
-	case "su":
-		// su 方式（不太常用）
-		sudoCmd = fmt.Sprintf("su - %s -c %s", becomeUser, shellQuote(cmd))
-	default:
-		return nil, nil, -1, fmt.Errorf("unsupported become method: %s", becomeMethod)
+	if err := session.Start(cmd); err != nil {
+		session.Close()
+		return nil, err
 	}
-
-	return c.ExecWithTimeout(sudoCmd, 30*time.Second)
+	return newProcess(stdin, stdout, stderr, func() error {
+		defer session.Close()
+		return session.Wait()
+	}), nil
 }
 
-// shellQuote 为 shell 命令添加引号
-func shellQuote(s string) string {
-	// 简单实现：使用单引号，并转义内部的单引号
-	return "'" + strings.ReplaceAll(s, "'", "'\\''") + "'"
-}
-
-// ExecuteCommand 执行命令并返回标准输出（用于 facts 收集）
-func (c *Connection) ExecuteCommand(cmd string) ([]byte, error) {
-	stdout, _, exitCode, err := c.Exec(cmd)
+// StartWithBecome 使用权限提升启动命令
+func (c *SSHConnection) StartWithBecome(cmd string, becomeUser, becomeMethod string) (*Process, error) {
+	becomeCmd, err := becomeCommand(cmd, becomeUser, becomeMethod)
 	if err != nil {
 		return nil, err
 	}
-	if exitCode != 0 {
-		return nil, fmt.Errorf("command failed with exit code %d", exitCode)
-	}
-	return stdout, nil
+	return c.Start(becomeCmd)
+}
+
+// ExecuteCommand 执行命令并返回标准输出（用于 facts 收集）
+func (c *SSHConnection) ExecuteCommand(cmd string) ([]byte, error) {
+	return executeCommand(c, cmd)
 }
@@ -0,0 +1,435 @@
+package connection
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/jimyag/ansigo/pkg/inventory"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// testSSHServer 测试用的进程内 SSH 服务器
+// exec 请求通过本地 sh -c 执行，支持 direct-tcpip 转发（用作跳板机）
+type testSSHServer struct {
+	t          *testing.T
+	listener   net.Listener
+	config     *ssh.ServerConfig
+	hostKey    ssh.Signer
+	knownHosts string
+	handshakes atomic.Int32
+
+	mu             sync.Mutex
+	conns          []net.Conn
+	authorizedKeys []ssh.PublicKey // 允许登录的公钥
+	userCA         ssh.PublicKey   // 信任的用户证书 CA
+	noSFTP         bool            // 拒绝 sftp subsystem（模拟没有 sftp-server 的主机）
+}
+
+// newTestSSHServer 启动测试 SSH 服务器（用户名 tester，密码 secret）
+// extraHostKeys 是服务器在 ed25519 主机密钥之外提供的密钥（不记录到 known_hosts），
+// 必须在服务器开始接受连接前添加
+func newTestSSHServer(t *testing.T, extraHostKeys ...ssh.Signer) *testSSHServer {
+	t.Helper()
+
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostKey, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &testSSHServer{
+		t:          t,
+		listener:   listener,
+		hostKey:    hostKey,
+		knownHosts: filepath.Join(t.TempDir(), "known_hosts"),
+	}
+
+	checker := &ssh.CertChecker{
+		IsUserAuthority: func(auth ssh.PublicKey) bool {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			return s.userCA != nil && bytes.Equal(auth.Marshal(), s.userCA.Marshal())
+		},
+		UserKeyFallback: func(c ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			for _, k := range s.authorizedKeys {
+				if c.User() == "tester" && bytes.Equal(k.Marshal(), key.Marshal()) {
+					return nil, nil
+				}
+			}
+			return nil, io.EOF
+		},
+	}
+
+	s.config = &ssh.ServerConfig{
+		PasswordCallback: func(c ssh.ConnMetadata, pass []byte) (*ssh.Permissions, error) {
+			if c.User() == "tester" && string(pass) == "secret" {
+				return nil, nil
+			}
+			return nil, io.EOF
+		},
+		PublicKeyCallback: checker.Authenticate,
+	}
+	s.config.AddHostKey(hostKey)
+	for _, key := range extraHostKeys {
+		s.config.AddHostKey(key)
+	}
+
+	// 默认严格检查主机密钥，预先记录服务器的密钥
+	if err := os.WriteFile(s.knownHosts, []byte(s.knownHostsLine(hostKey.PublicKey())), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	go s.serve()
+	t.Cleanup(func() {
+		listener.Close()
+		s.dropConnections()
+	})
+
+	return s
+}
+
+// authorize 允许使用该公钥登录
+func (s *testSSHServer) authorize(key ssh.PublicKey) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.authorizedKeys = append(s.authorizedKeys, key)
+}
+
+// trustUserCA 信任该 CA 签发的用户证书
+func (s *testSSHServer) trustUserCA(ca ssh.PublicKey) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.userCA = ca
+}
+
+// port 返回服务器监听的端口
+func (s *testSSHServer) port() int {
+	return s.listener.Addr().(*net.TCPAddr).Port
+}
+
+// host 返回指向该服务器的 inventory 主机（使用记录了服务器密钥的临时 known_hosts 文件）
+func (s *testSSHServer) host(name string) *inventory.Host {
+	return &inventory.Host{
+		Name: name,
+		Vars: map[string]interface{}{
+			"ansible_host":     "127.0.0.1",
+			"ansible_port":     strconv.Itoa(s.port()),
+			"ansible_user":     "tester",
+			"ansible_password": "secret",
+
+			"ansible_ssh_known_hosts_file": s.knownHosts,
+		},
+	}
+}
+
+// dropConnections 断开所有已建立的连接（模拟远端重启）
+func (s *testSSHServer) dropConnections() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, c := range s.conns {
+		c.Close()
+	}
+	s.conns = nil
+}
+
+func (s *testSSHServer) serve() {
+	for {
+		nc, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handleConn(nc)
+	}
+}
+
+func (s *testSSHServer) handleConn(nc net.Conn) {
+	_, chans, reqs, err := ssh.NewServerConn(nc, s.config)
+	if err != nil {
+		nc.Close()
+		return
+	}
+	s.handshakes.Add(1)
+	s.mu.Lock()
+	s.conns = append(s.conns, nc)
+	s.mu.Unlock()
+
+	go ssh.DiscardRequests(reqs)
+	for newChan := range chans {
+		switch newChan.ChannelType() {
+		case "session":
+			ch, chReqs, err := newChan.Accept()
+			if err != nil {
+				continue
+			}
+			go s.handleSession(ch, chReqs)
+		case "direct-tcpip":
+			go s.handleDirectTCPIP(newChan)
+		default:
+			newChan.Reject(ssh.UnknownChannelType, "unsupported channel type")
+		}
+	}
+}
+
+// handleDirectTCPIP 处理端口转发请求（ssh -W / ProxyJump）
+func (s *testSSHServer) handleDirectTCPIP(newChan ssh.NewChannel) {
+	var req struct {
+		Host       string
+		Port       uint32
+		OriginHost string
+		OriginPort uint32
+	}
+	if err := ssh.Unmarshal(newChan.ExtraData(), &req); err != nil {
+		newChan.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+
+	target, err := net.Dial("tcp", net.JoinHostPort(req.Host, strconv.Itoa(int(req.Port))))
+	if err != nil {
+		newChan.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+
+	ch, chReqs, err := newChan.Accept()
+	if err != nil {
+		target.Close()
+		return
+	}
+	go ssh.DiscardRequests(chReqs)
+
+	go func() {
+		io.Copy(target, ch)
+		target.Close()
+	}()
+	io.Copy(ch, target)
+	ch.Close()
+}
+
+func (s *testSSHServer) handleSession(ch ssh.Channel, reqs <-chan *ssh.Request) {
+	defer ch.Close()
+	for req := range reqs {
+		if req.Type == "subsystem" {
+			s.mu.Lock()
+			noSFTP := s.noSFTP
+			s.mu.Unlock()
+			if noSFTP || len(req.Payload) < 4 || string(req.Payload[4:]) != "sftp" {
+				req.Reply(false, nil)
+				continue
+			}
+			req.Reply(true, nil)
+			if server, err := sftp.NewServer(ch); err == nil {
+				server.Serve()
+			}
+			return
+		}
+
+		if req.Type != "exec" {
+			req.Reply(false, nil)
+			continue
+		}
+
+		// payload: uint32 长度 + 命令
+		if len(req.Payload) < 4 {
+			req.Reply(false, nil)
+			return
+		}
+		cmdLen := binary.BigEndian.Uint32(req.Payload[:4])
+		command := string(req.Payload[4 : 4+cmdLen])
+		req.Reply(true, nil)
+
+		cmd := exec.Command("sh", "-c", command)
+		cmd.Stdin = ch
+		cmd.Stdout = ch
+		cmd.Stderr = ch.Stderr()
+
+		status := uint32(0)
+		if err := cmd.Run(); err != nil {
+			status = 1
+			if exitErr, ok := err.(*exec.ExitError); ok {
+				status = uint32(exitErr.ExitCode())
+			}
+		}
+
+		payload := make([]byte, 4)
+		binary.BigEndian.PutUint32(payload, status)
+		ch.SendRequest("exit-status", false, payload)
+		return
+	}
+}
+
+func TestManagerReusesClient(t *testing.T) {
+	server := newTestSSHServer(t)
+	mgr := NewManager()
+	defer mgr.Close()
+
+	host := server.host("web1")
+
+	for i := 0; i < 3; i++ {
+		conn, err := mgr.Connect(host)
+		if err != nil {
+			t.Fatalf("Connect() error = %v", err)
+		}
+
+		stdout, _, exitCode, err := conn.Exec("echo hello")
+		if err != nil || exitCode != 0 {
+			t.Fatalf("Exec() = %d, %v", exitCode, err)
+		}
+		if string(stdout) != "hello\n" {
+			t.Errorf("Exec() stdout = %q, want %q", stdout, "hello\n")
+		}
+
+		// 池化连接的 Close 不应关闭共享 client
+		if err := conn.Close(); err != nil {
+			t.Errorf("Close() error = %v", err)
+		}
+	}
+
+	if got := server.handshakes.Load(); got != 1 {
+		t.Errorf("handshakes = %d, want 1", got)
+	}
+}
+
+func TestManagerConcurrentConnect(t *testing.T) {
+	server := newTestSSHServer(t)
+	mgr := NewManager()
+	defer mgr.Close()
+
+	host := server.host("web1")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			conn, err := mgr.Connect(host)
+			if err != nil {
+				t.Errorf("Connect() error = %v", err)
+				return
+			}
+			if _, _, exitCode, err := conn.Exec("true"); err != nil || exitCode != 0 {
+				t.Errorf("Exec() = %d, %v", exitCode, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := server.handshakes.Load(); got != 1 {
+		t.Errorf("handshakes = %d, want 1", got)
+	}
+}
+
+func TestManagerReconnectsDeadClient(t *testing.T) {
+	server := newTestSSHServer(t)
+	mgr := NewManager()
+	defer mgr.Close()
+
+	host := server.host("web1")
+
+	conn, err := mgr.Connect(host)
+	if err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+	if _, _, _, err := conn.Exec("true"); err != nil {
+		t.Fatalf("Exec() error = %v", err)
+	}
+
+	server.dropConnections()
+
+	// 已持有的 Connection 在下一次执行时透明重连
+	stdout, _, exitCode, err := conn.Exec("echo again")
+	if err != nil || exitCode != 0 {
+		t.Fatalf("Exec() after drop = %d, %v", exitCode, err)
+	}
+	if string(stdout) != "again\n" {
+		t.Errorf("Exec() stdout = %q, want %q", stdout, "again\n")
+	}
+
+	if got := server.handshakes.Load(); got != 2 {
+		t.Errorf("handshakes = %d, want 2", got)
+	}
+}
+
+func TestManagerClose(t *testing.T) {
+	server := newTestSSHServer(t)
+	mgr := NewManager()
+
+	host := server.host("web1")
+	if _, err := mgr.Connect(host); err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+
+	if err := mgr.Close(); err != nil {
+		t.Errorf("Close() error = %v", err)
+	}
+
+	// 关闭后再次连接会重新握手
+	conn, err := mgr.Connect(host)
+	if err != nil {
+		t.Fatalf("Connect() after Close error = %v", err)
+	}
+	defer mgr.Close()
+	if _, _, _, err := conn.Exec("true"); err != nil {
+		t.Fatalf("Exec() error = %v", err)
+	}
+
+	if got := server.handshakes.Load(); got != 2 {
+		t.Errorf("handshakes = %d, want 2", got)
+	}
+}
+
+func TestSSHStart(t *testing.T) {
+	server := newTestSSHServer(t)
+	mgr := NewManager()
+	defer mgr.Close()
+
+	conn, err := mgr.Connect(server.host("web1"))
+	if err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+	starter, ok := conn.(Starter)
+	if !ok {
+		t.Fatalf("%T does not implement Starter", conn)
+	}
+
+	// 同一个进程处理多次请求
+	proc, err := starter.Start("while read line; do echo \"got $line\"; done; echo bye >&2; exit 3")
+	if err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	reader := bufio.NewReader(proc.Stdout)
+	for _, msg := range []string{"one", "two"} {
+		if _, err := io.WriteString(proc.Stdin, msg+"\n"); err != nil {
+			t.Fatal(err)
+		}
+		line, err := reader.ReadString('\n')
+		if err != nil || line != "got "+msg+"\n" {
+			t.Errorf("response = %q, %v", line, err)
+		}
+	}
+	if err := proc.Wait(); err == nil || !strings.Contains(err.Error(), "bye") {
+		t.Errorf("Wait() error = %v, want exit error with stderr", err)
+	}
+}
@@ -0,0 +1,279 @@
+package connection
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+
+	"github.com/jimyag/ansigo/pkg/inventory"
+)
+
+// maxIncludeDepth Include 的最大嵌套层数（与 OpenSSH 一致）
+const maxIncludeDepth = 16
+
+// sshConfig 解析后的 ssh_config 文件
+// 只实现连接需要的部分：Host 块、Include 和 "Match all"，其他 Match 条件视为不匹配
+type sshConfig struct {
+	blocks []*sshConfigBlock
+}
+
+// sshConfigBlock 一个 Host（或 Match）块
+type sshConfigBlock struct {
+	patterns []string   // Host 模式，支持 *、? 和 ! 取反
+	never    bool       // 不支持的 Match 条件，永不匹配
+	options  [][]string // [key(小写), value]，按出现顺序
+}
+
+// parseSSHConfig 解析 ssh_config 文件
+func parseSSHConfig(path string) (*sshConfig, error) {
+	cfg := &sshConfig{}
+	// 第一个 Host 之前的选项对所有主机生效
+	current := &sshConfigBlock{patterns: []string{"*"}}
+	cfg.blocks = append(cfg.blocks, current)
+
+	if err := cfg.parseFile(path, &current, 0); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// parseFile 解析单个文件，Include 的文件按文本包含的方式展开
+func (c *sshConfig) parseFile(path string, current **sshConfigBlock, depth int) error {
+	if depth > maxIncludeDepth {
+		return fmt.Errorf("ssh config %s: too many nested includes", path)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		key, rest := splitSSHOption(line)
+		key = strings.ToLower(key)
+
+		switch key {
+		case "host":
+			patterns, err := splitShellArgs(rest)
+			if err != nil {
+				return fmt.Errorf("ssh config %s:%d: %w", path, lineNum, err)
+			}
+			*current = &sshConfigBlock{patterns: patterns}
+			c.blocks = append(c.blocks, *current)
+
+		case "match":
+			block := &sshConfigBlock{never: true}
+			if strings.EqualFold(strings.TrimSpace(rest), "all") {
+				block = &sshConfigBlock{patterns: []string{"*"}}
+			}
+			*current = block
+			c.blocks = append(c.blocks, block)
+
+		case "include":
+			patterns, err := splitShellArgs(rest)
+			if err != nil {
+				return fmt.Errorf("ssh config %s:%d: %w", path, lineNum, err)
+			}
+			for _, pattern := range patterns {
+				pattern = expandHome(pattern)
+				// 相对路径相对于 ~/.ssh（用户配置的约定）
+				if !filepath.IsAbs(pattern) {
+					if homeDir, err := os.UserHomeDir(); err == nil {
+						pattern = filepath.Join(homeDir, ".ssh", pattern)
+					}
+				}
+				matches, _ := filepath.Glob(pattern)
+				for _, match := range matches {
+					if err := c.parseFile(match, current, depth+1); err != nil {
+						return err
+					}
+				}
+			}
+
+		default:
+			value := rest
+			// ProxyCommand 保留原始命令行，其他选项去掉引号
+			if key != "proxycommand" {
+				if args, err := splitShellArgs(rest); err == nil && len(args) > 0 {
+					value = args[0]
+				}
+			}
+			(*current).options = append((*current).options, []string{key, value})
+		}
+	}
+
+	return scanner.Err()
+}
+
+// get 返回第一个匹配 alias 的值（OpenSSH 规则：先出现的值生效）
+func (c *sshConfig) get(alias, key string) string {
+	if values := c.getAll(alias, key); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// getAll 返回所有匹配 alias 的值（用于 IdentityFile 这类可以多次出现的选项）
+func (c *sshConfig) getAll(alias, key string) []string {
+	var values []string
+	for _, block := range c.blocks {
+		if !block.matches(alias) {
+			continue
+		}
+		for _, opt := range block.options {
+			if opt[0] == key {
+				values = append(values, opt[1])
+			}
+		}
+	}
+	return values
+}
+
+// matches 判断块是否匹配 alias
+// 任意取反模式匹配时不匹配；否则至少一个普通模式匹配时匹配
+func (b *sshConfigBlock) matches(alias string) bool {
+	if b.never {
+		return false
+	}
+
+	matched := false
+	for _, pattern := range b.patterns {
+		if strings.HasPrefix(pattern, "!") {
+			if matchGlob(pattern[1:], alias) {
+				return false
+			}
+			continue
+		}
+		if matchGlob(pattern, alias) {
+			matched = true
+		}
+	}
+	return matched
+}
+
+// matchGlob 匹配 ssh_config 的通配符（只有 * 和 ?，大小写不敏感）
+func matchGlob(pattern, name string) bool {
+	pattern = strings.ToLower(pattern)
+	name = strings.ToLower(name)
+
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for i := len(name); i >= 0; i-- {
+				if matchGlob(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if name == "" {
+				return false
+			}
+		default:
+			if name == "" || name[0] != pattern[0] {
+				return false
+			}
+		}
+		pattern = pattern[1:]
+		name = name[1:]
+	}
+	return name == ""
+}
+
+// expandSSHTokens 展开 ssh_config 中的 %h、%r、%u、%d、%% 标记和开头的 ~
+func expandSSHTokens(value, host, remoteUser string) string {
+	if !strings.Contains(value, "%") {
+		return expandHome(value)
+	}
+
+	homeDir, _ := os.UserHomeDir()
+	localUser := ""
+	if u, err := user.Current(); err == nil {
+		localUser = u.Username
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		if value[i] != '%' || i+1 >= len(value) {
+			b.WriteByte(value[i])
+			continue
+		}
+		i++
+		switch value[i] {
+		case 'h':
+			b.WriteString(host)
+		case 'r':
+			b.WriteString(remoteUser)
+		case 'u':
+			b.WriteString(localUser)
+		case 'd':
+			b.WriteString(homeDir)
+		case '%':
+			b.WriteByte('%')
+		default:
+			b.WriteByte('%')
+			b.WriteByte(value[i])
+		}
+	}
+	return expandHome(b.String())
+}
+
+// loadSSHConfig 加载并缓存 ssh_config
+// optional 为 true 时文件不存在返回 nil；否则返回错误
+func (m *Manager) loadSSHConfig(path string, optional bool) (*sshConfig, error) {
+	m.configMu.Lock()
+	defer m.configMu.Unlock()
+
+	if cfg, ok := m.sshConfigs[path]; ok {
+		return cfg, nil
+	}
+
+	cfg, err := parseSSHConfig(path)
+	if os.IsNotExist(err) && optional {
+		cfg, err = nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	m.sshConfigs[path] = cfg
+	return cfg, nil
+}
+
+// sshConfigsFor 返回主机适用的 ssh_config：ansible_ssh_config 指定的文件优先，然后是 ~/.ssh/config
+func (m *Manager) sshConfigsFor(host *inventory.Host) ([]*sshConfig, error) {
+	var configs []*sshConfig
+
+	if path := hostVarString(host, "ansible_ssh_config"); path != "" {
+		cfg, err := m.loadSSHConfig(expandHome(path), false)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load ansible_ssh_config %s: %w", path, err)
+		}
+		configs = append(configs, cfg)
+	}
+
+	if homeDir, err := os.UserHomeDir(); err == nil {
+		path := filepath.Join(homeDir, ".ssh", "config")
+		cfg, err := m.loadSSHConfig(path, true)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load ssh config %s: %w", path, err)
+		}
+		if cfg != nil {
+			configs = append(configs, cfg)
+		}
+	}
+
+	return configs, nil
+}
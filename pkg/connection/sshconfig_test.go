@@ -0,0 +1,228 @@
+package connection
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/jimyag/ansigo/pkg/inventory"
+)
+
+func TestResolveParamsSSHConfig(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+
+	// ~/.ssh/config 优先级低于 ansible_ssh_config，只补充后者未设置的参数
+	if err := os.MkdirAll(filepath.Join(home, ".ssh"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	userConfig := "Host db1\n    User ignored\n    ProxyJump ops@gateway\n"
+	if err := os.WriteFile(filepath.Join(home, ".ssh", "config"), []byte(userConfig), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	fixture, err := filepath.Abs("testdata/ssh_config")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name          string
+		host          string
+		vars          map[string]interface{}
+		wantAddr      string
+		wantUser      string
+		wantIdentity  []string
+		wantJumps     []jumpHost
+		wantErr       bool
+		skipSSHConfig bool
+	}{
+		{
+			name:         "host block fills unset params",
+			host:         "db1",
+			wantAddr:     "10.0.0.5:2222",
+			wantUser:     "dba",
+			wantIdentity: []string{filepath.Join(home, ".ssh/db_key"), filepath.Join(home, ".ssh/second key")},
+			wantJumps:    []jumpHost{{user: "ops", host: "gateway"}},
+		},
+		{
+			name: "inventory vars win over ssh config",
+			host: "db1",
+			vars: map[string]interface{}{
+				"ansible_port":                 2223,
+				"ansible_user":                 "admin",
+				"ansible_ssh_private_key_file": "/keys/admin",
+				"ansible_ssh_common_args":      "-J other",
+			},
+			wantAddr:  "10.0.0.5:2223",
+			wantUser:  "admin",
+			wantJumps: []jumpHost{{host: "other"}},
+		},
+		{
+			name: "ansible_host is matched against Host and not rewritten",
+			host: "database",
+			vars: map[string]interface{}{
+				"ansible_host": "db1",
+			},
+			wantAddr:     "db1:2222",
+			wantUser:     "dba",
+			wantIdentity: []string{filepath.Join(home, ".ssh/db_key"), filepath.Join(home, ".ssh/second key")},
+			wantJumps:    []jumpHost{{user: "ops", host: "gateway"}},
+		},
+		{
+			name:         "wildcard with token expansion",
+			host:         "web-01",
+			wantAddr:     "web-01.internal.example.com:22",
+			wantUser:     "deploy",
+			wantIdentity: []string{filepath.Join(home, ".ssh/deploy_deploy")},
+			wantJumps:    []jumpHost{{host: "bastion"}},
+		},
+		{
+			name:      "negated pattern falls through to later blocks",
+			host:      "web-legacy",
+			wantAddr:  "web-legacy:22",
+			wantUser:  "fallback",
+			wantJumps: []jumpHost{{user: "ops", host: "legacy-gw"}},
+		},
+		{
+			name:     "unsupported Match is ignored",
+			host:     "app1",
+			wantAddr: "app1:22",
+			wantUser: "fallback",
+		},
+		{
+			name:          "defaults without ssh config",
+			host:          "app1",
+			skipSSHConfig: true,
+			wantAddr:      "app1:22",
+			wantUser:      "root",
+		},
+		{
+			name: "missing ansible_ssh_config is an error",
+			host: "app1",
+			vars: map[string]interface{}{
+				"ansible_ssh_config": filepath.Join(home, "nonexistent"),
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vars := map[string]interface{}{}
+			if !tt.skipSSHConfig {
+				vars["ansible_ssh_config"] = fixture
+			}
+			for k, v := range tt.vars {
+				vars[k] = v
+			}
+			host := &inventory.Host{Name: tt.host, Vars: vars}
+
+			mgr := NewManager()
+			if tt.skipSSHConfig {
+				t.Setenv("HOME", t.TempDir())
+			}
+
+			params, err := mgr.resolveParams(host, "root")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("resolveParams() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			if params.addr != tt.wantAddr {
+				t.Errorf("addr = %q, want %q", params.addr, tt.wantAddr)
+			}
+			if params.user != tt.wantUser {
+				t.Errorf("user = %q, want %q", params.user, tt.wantUser)
+			}
+			if !reflect.DeepEqual(params.identityFiles, tt.wantIdentity) {
+				t.Errorf("identityFiles = %v, want %v", params.identityFiles, tt.wantIdentity)
+			}
+			if !reflect.DeepEqual(params.jumps, tt.wantJumps) {
+				t.Errorf("jumps = %+v, want %+v", params.jumps, tt.wantJumps)
+			}
+		})
+	}
+}
+
+func TestResolveParamsJumpHostFromSSHConfig(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	fixture, err := filepath.Abs("testdata/ssh_config")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	target := &inventory.Host{Name: "web-01", Vars: map[string]interface{}{"ansible_ssh_config": fixture}}
+	jump := jumpHost{host: "bastion"}.inventoryHost(target)
+
+	params, err := NewManager().resolveParams(jump, "deploy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if params.addr != "203.0.113.10:2200" || params.user != "jump" {
+		t.Errorf("jump params = %s@%s, want jump@203.0.113.10:2200", params.user, params.addr)
+	}
+}
+
+func TestSSHConfigInclude(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+
+	sshDir := filepath.Join(home, ".ssh")
+	if err := os.MkdirAll(filepath.Join(sshDir, "config.d"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(sshDir, "config"), []byte("Include config.d/*\n\nHost *\n    User fallback\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(sshDir, "config.d", "work"), []byte("Host build\n    User ci\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	params, err := NewManager().resolveParams(&inventory.Host{Name: "build", Vars: map[string]interface{}{}}, "root")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if params.user != "ci" {
+		t.Errorf("user = %q, want %q", params.user, "ci")
+	}
+}
+
+func TestConnectWithSSHConfig(t *testing.T) {
+	t.Setenv("SSH_AUTH_SOCK", "")
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+
+	server := newTestSSHServer(t)
+	signer, keyFile := newTestKey(t, home, "testbox_key", "")
+	server.authorize(signer.PublicKey())
+
+	config := fmt.Sprintf("Host testbox\n    HostName 127.0.0.1\n    Port %d\n    User tester\n    IdentityFile %s\n",
+		server.port(), keyFile)
+	configFile := filepath.Join(home, "ssh_config")
+	if err := os.WriteFile(configFile, []byte(config), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	mgr := NewManager()
+	defer mgr.Close()
+
+	host := &inventory.Host{
+		Name: "testbox",
+		Vars: map[string]interface{}{
+			"ansible_ssh_config":           configFile,
+			"ansible_ssh_known_hosts_file": server.knownHosts,
+		},
+	}
+	conn, err := mgr.Connect(host)
+	if err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+	if _, _, exitCode, err := conn.Exec("true"); err != nil || exitCode != 0 {
+		t.Fatalf("Exec() = %d, %v", exitCode, err)
+	}
+}
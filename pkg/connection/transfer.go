@@ -0,0 +1,293 @@
+package connection
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/jimyag/ansigo/pkg/inventory"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// defaultFileMode 新建文件且未指定权限时使用的权限
+const defaultFileMode os.FileMode = 0o644
+
+// specialModes setuid、setgid 和 sticky 位
+const specialModes = os.ModeSetuid | os.ModeSetgid | os.ModeSticky
+
+// uploadMode 把 Upload 的 mode 转换为 os.FileMode
+// mode 可以是 mode 参数解析出的 Unix 权限位（如 0o4755），也可以是带 os.ModeSetuid 等标志的 os.FileMode，
+// 两种写法的 setuid、setgid 和 sticky 位都会保留
+func uploadMode(mode os.FileMode) os.FileMode {
+	m := mode & (os.ModePerm | specialModes)
+	if mode&0o4000 != 0 {
+		m |= os.ModeSetuid
+	}
+	if mode&0o2000 != 0 {
+		m |= os.ModeSetgid
+	}
+	if mode&0o1000 != 0 {
+		m |= os.ModeSticky
+	}
+	return m
+}
+
+// octalMode 返回 chmod 使用的四位八进制权限，如 0644、4755
+func octalMode(mode os.FileMode) string {
+	m := uploadMode(mode)
+	bits := uint32(m.Perm())
+	if m&os.ModeSetuid != 0 {
+		bits |= 0o4000
+	}
+	if m&os.ModeSetgid != 0 {
+		bits |= 0o2000
+	}
+	if m&os.ModeSticky != 0 {
+		bits |= 0o1000
+	}
+	return fmt.Sprintf("%04o", bits)
+}
+
+// PutFile 上传本地文件到远程主机（流式传输，原子替换）
+// 新文件使用 0644 权限，已存在的文件保持原有权限
+func (c *SSHConnection) PutFile(localPath, remotePath string) error {
+	f, err := os.Open(localPath)
+	if err != nil {
+		return fmt.Errorf("failed to read local file: %w", err)
+	}
+	defer f.Close()
+
+	return c.Upload(f, remotePath, 0)
+}
+
+// Upload 把 r 的内容写入远程文件
+//
+// 内容先写入目标目录下的临时文件，设置权限后再重命名为 remotePath，
+// 因此读者不会看到写了一半的文件。mode 为 0 时已存在的文件保持原有权限，新文件使用 0644。
+// 优先使用 SFTP，远端没有 sftp-server 时回退到 shell（cat + mv）
+func (c *SSHConnection) Upload(r io.Reader, remotePath string, mode os.FileMode) error {
+	if client := c.sftpClient(); client != nil {
+		return sftpUpload(client, r, remotePath, mode)
+	}
+	return c.shellUpload(r, remotePath, mode)
+}
+
+// GetFile 从远程主机下载文件（流式传输，本地原子写入）
+func (c *SSHConnection) GetFile(remotePath, localPath string) error {
+	tmp, err := os.CreateTemp(filepath.Dir(localPath), "."+filepath.Base(localPath)+".ansigo-*")
+	if err != nil {
+		return fmt.Errorf("failed to write local file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if client := c.sftpClient(); client != nil {
+		err = sftpDownload(client, remotePath, tmp)
+	} else {
+		err = c.shellDownload(remotePath, tmp)
+	}
+	if cerr := tmp.Close(); err == nil && cerr != nil {
+		err = fmt.Errorf("failed to write local file: %w", cerr)
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := os.Chmod(tmp.Name(), defaultFileMode); err != nil {
+		return fmt.Errorf("failed to write local file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), localPath); err != nil {
+		return fmt.Errorf("failed to write local file: %w", err)
+	}
+	return nil
+}
+
+// sftpClient 返回连接上的 SFTP 客户端，不可用时返回 nil（使用 shell 方式传输）
+func (c *SSHConnection) sftpClient() *sftp.Client {
+	if c.mgr == nil {
+		return nil
+	}
+	return c.mgr.sftpClient(c.host, c.client)
+}
+
+// sftpClient 获取（必要时创建）主机 client 上的 SFTP 会话
+// 远端拒绝 sftp subsystem 时记录下来，之后不再尝试；
+// 其他失败（如连接已断开）返回 nil，由 shell 方式的 newSession 负责重连
+func (m *Manager) sftpClient(host *inventory.Host, client *ssh.Client) *sftp.Client {
+	m.mu.Lock()
+	pc, exists := m.clients[host.Name]
+	m.mu.Unlock()
+	if !exists {
+		return nil
+	}
+
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+
+	if pc.client != client || pc.noSFTP {
+		return nil
+	}
+	if pc.sftp != nil {
+		return pc.sftp
+	}
+
+	sc, err := sftp.NewClient(client)
+	if err != nil {
+		if strings.Contains(err.Error(), "subsystem request failed") {
+			pc.noSFTP = true
+		}
+		return nil
+	}
+
+	pc.sftp = sc
+	return sc
+}
+
+// sftpUpload 通过 SFTP 上传
+func sftpUpload(client *sftp.Client, r io.Reader, remotePath string, mode os.FileMode) error {
+	remotePath = sftpPath(remotePath)
+	if mode == 0 {
+		mode = defaultFileMode
+		if info, err := client.Stat(remotePath); err == nil {
+			mode = info.Mode() & (os.ModePerm | specialModes)
+		}
+	} else {
+		mode = uploadMode(mode)
+	}
+
+	tmp := tempPath(remotePath)
+	f, err := client.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_EXCL)
+	if err != nil {
+		return fmt.Errorf("failed to create remote file %s: %w", tmp, err)
+	}
+
+	// 写入内容前先设置权限，避免临时文件以默认权限暴露内容；
+	// 写入会清除 setuid/setgid 位，特殊权限在写入后再设置
+	err = f.Chmod(mode.Perm())
+	if err == nil {
+		_, err = io.Copy(f, r)
+	}
+	if err == nil && mode&specialModes != 0 {
+		err = f.Chmod(mode)
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = sftpRename(client, tmp, remotePath)
+	}
+	if err != nil {
+		client.Remove(tmp)
+		return fmt.Errorf("failed to upload %s: %w", remotePath, err)
+	}
+	return nil
+}
+
+// sftpRename 原子地把 tmp 重命名为 remotePath
+// 服务器不支持 posix-rename 扩展时先删除目标再重命名（SFTP v3 的 rename 不覆盖已有文件）
+func sftpRename(client *sftp.Client, tmp, remotePath string) error {
+	if _, ok := client.HasExtension("posix-rename@openssh.com"); ok {
+		return client.PosixRename(tmp, remotePath)
+	}
+	if err := client.Rename(tmp, remotePath); err == nil {
+		return nil
+	}
+	client.Remove(remotePath)
+	return client.Rename(tmp, remotePath)
+}
+
+// sftpDownload 通过 SFTP 下载
+func sftpDownload(client *sftp.Client, remotePath string, w io.Writer) error {
+	f, err := client.Open(sftpPath(remotePath))
+	if err != nil {
+		return fmt.Errorf("failed to read remote file %s: %w", remotePath, err)
+	}
+	defer f.Close()
+
+	if _, err := io.Copy(w, f); err != nil {
+		return fmt.Errorf("failed to read remote file %s: %w", remotePath, err)
+	}
+	return nil
+}
+
+// shellUpload 通过 shell 上传：数据经 stdin 流入临时文件，再 chmod + mv
+func (c *SSHConnection) shellUpload(r io.Reader, remotePath string, mode os.FileMode) error {
+	session, err := c.newSession()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	var stderr bytes.Buffer
+	session.Stdin = r
+	session.Stderr = &stderr
+
+	if err := session.Run(shellUploadCommand(remotePath, mode)); err != nil {
+		return fmt.Errorf("failed to upload %s: %s", remotePath, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// shellUploadCommand 返回从 stdin 读取内容写入 remotePath 的 shell 命令
+// mode 为 0 时已存在的文件保持原有权限，新文件使用 0644
+func shellUploadCommand(remotePath string, mode os.FileMode) string {
+	tmp := shellPath(tempPath(remotePath))
+	dest := shellPath(remotePath)
+
+	chmod := fmt.Sprintf("chmod %s %s", octalMode(mode), tmp)
+	if mode == 0 {
+		chmod = fmt.Sprintf("if [ -e %s ]; then chmod \"$(stat -c %%a %s)\" %s; else chmod %04o %s; fi",
+			dest, dest, tmp, defaultFileMode, tmp)
+	}
+
+	// umask 077 保证临时文件在设置权限前不会被其他用户读取
+	return fmt.Sprintf("umask 077 && cat > %s && %s && mv -f %s %s || { rm -f %s; exit 1; }",
+		tmp, chmod, tmp, dest, tmp)
+}
+
+// shellDownload 通过 cat 下载，输出直接写入 w
+func (c *SSHConnection) shellDownload(remotePath string, w io.Writer) error {
+	session, err := c.newSession()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	var stderr bytes.Buffer
+	session.Stdout = w
+	session.Stderr = &stderr
+
+	if err := session.Run("cat " + shellPath(remotePath)); err != nil {
+		return fmt.Errorf("failed to read remote file %s: %s", remotePath, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// tempPath 返回与 remotePath 同目录的临时文件路径（同一文件系统内 rename 才是原子的）
+func tempPath(remotePath string) string {
+	suffix := make([]byte, 8)
+	rand.Read(suffix)
+	return path.Join(path.Dir(remotePath), fmt.Sprintf(".%s.ansigo-%s", path.Base(remotePath), hex.EncodeToString(suffix)))
+}
+
+// sftpPath 把 ~/ 开头的路径转换为相对路径（SFTP 的相对路径相对于登录用户的 home 目录）
+func sftpPath(remotePath string) string {
+	if remotePath == "~" {
+		return "."
+	}
+	return strings.TrimPrefix(remotePath, "~/")
+}
+
+// shellPath 为 shell 命令引用远程路径，保留开头 ~/ 的展开
+func shellPath(remotePath string) string {
+	if strings.HasPrefix(remotePath, "~/") {
+		return `"$HOME"/` + shellQuote(remotePath[2:])
+	}
+	return shellQuote(remotePath)
+}
@@ -0,0 +1,148 @@
+package connection
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestUploadAndGetFile(t *testing.T) {
+	for _, transport := range []string{"sftp", "shell"} {
+		t.Run(transport, func(t *testing.T) {
+			server := newTestSSHServer(t)
+			server.noSFTP = transport == "shell"
+
+			mgr := NewManager()
+			defer mgr.Close()
+
+			conn, err := mgr.Connect(server.host("web1"))
+			if err != nil {
+				t.Fatalf("Connect() error = %v", err)
+			}
+
+			if got := conn.(*SSHConnection).sftpClient() != nil; got != (transport == "sftp") {
+				t.Fatalf("sftp available = %v, want %v", got, transport == "sftp")
+			}
+
+			// 路径中的空格和单引号需要正确处理
+			dir := t.TempDir()
+			dest := filepath.Join(dir, "it's a file.conf")
+
+			if err := conn.Upload(strings.NewReader("first\n"), dest, 0o600); err != nil {
+				t.Fatalf("Upload() error = %v", err)
+			}
+			assertFile(t, dest, "first\n", 0o600)
+
+			// mode 为 0 时保持已有文件的权限
+			if err := conn.Upload(strings.NewReader("second\n"), dest, 0); err != nil {
+				t.Fatalf("Upload() overwrite error = %v", err)
+			}
+			assertFile(t, dest, "second\n", 0o600)
+
+			// setuid 等特殊权限位被保留，mode 为 0 时也保持
+			assertSpecialModes(t, conn, t.TempDir())
+
+			// 新文件默认 0644
+			local := filepath.Join(t.TempDir(), "local.bin")
+			large := bytes.Repeat([]byte("0123456789abcdef"), 256*1024)
+			if err := os.WriteFile(local, large, 0o600); err != nil {
+				t.Fatal(err)
+			}
+			putDest := filepath.Join(dir, "large.bin")
+			if err := conn.PutFile(local, putDest); err != nil {
+				t.Fatalf("PutFile() error = %v", err)
+			}
+			assertFile(t, putDest, string(large), 0o644)
+
+			fetched := filepath.Join(t.TempDir(), "fetched.bin")
+			if err := conn.GetFile(putDest, fetched); err != nil {
+				t.Fatalf("GetFile() error = %v", err)
+			}
+			assertFile(t, fetched, string(large), 0o644)
+
+			if err := conn.GetFile(filepath.Join(dir, "missing"), fetched); err == nil {
+				t.Error("GetFile() of missing file succeeded, want error")
+			}
+
+			// 不应留下临时文件
+			entries, err := os.ReadDir(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(entries) != 2 {
+				var names []string
+				for _, e := range entries {
+					names = append(names, e.Name())
+				}
+				t.Errorf("remote dir contains %v, want only the two uploaded files", names)
+			}
+		})
+	}
+}
+
+func TestUploadIntoMissingDirectory(t *testing.T) {
+	server := newTestSSHServer(t)
+	mgr := NewManager()
+	defer mgr.Close()
+
+	conn, err := mgr.Connect(server.host("web1"))
+	if err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+
+	dest := filepath.Join(t.TempDir(), "missing-dir", "file")
+	if err := conn.Upload(strings.NewReader("data"), dest, 0o644); err == nil {
+		t.Error("Upload() into missing directory succeeded, want error")
+	}
+}
+
+// assertFile 检查文件内容和权限
+func assertFile(t *testing.T, path, content string, mode os.FileMode) {
+	t.Helper()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read %s: %v", path, err)
+	}
+	if string(data) != content {
+		t.Errorf("%s has %d bytes, want %d", path, len(data), len(content))
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != mode {
+		t.Errorf("%s mode = %04o, want %04o", path, info.Mode().Perm(), mode)
+	}
+}
+
+// assertSpecialModes 上传带 setuid 位的文件，检查权限位被完整保留
+// mode 既可以是 Unix 权限位，也可以是 os.FileMode 标志
+func assertSpecialModes(t *testing.T, conn Connection, dir string) {
+	t.Helper()
+
+	for i, mode := range []os.FileMode{0o4755, os.ModeSetuid | 0o750} {
+		dest := filepath.Join(dir, fmt.Sprintf("tool%d", i))
+		if err := conn.Upload(strings.NewReader("#!/bin/sh\n"), dest, mode); err != nil {
+			t.Fatalf("Upload(%v) error = %v", mode, err)
+		}
+		want := uploadMode(mode)
+		if info, err := os.Stat(dest); err != nil || info.Mode() != want {
+			t.Errorf("Upload(%04o) mode = %v, want %v", uint32(mode), info.Mode(), want)
+		}
+
+		if err := conn.Upload(strings.NewReader("#!/bin/sh\nexit 0\n"), dest, 0); err != nil {
+			t.Fatalf("Upload() overwrite error = %v", err)
+		}
+		if info, err := os.Stat(dest); err != nil || info.Mode() != want {
+			t.Errorf("Upload() overwrite mode = %v, want %v", info.Mode(), want)
+		}
+	}
+	if got := octalMode(0o4755); got != "4755" {
+		t.Errorf("octalMode(0o4755) = %s", got)
+	}
+}
@@ -1,6 +1,7 @@
 package errors
 
 import (
+	"errors"
 	"fmt"
 	"time"
 )
@@ -21,6 +22,10 @@ const (
 	ErrInvalidArgs
 	// ErrModuleNotFound 模块未找到
 	ErrModuleNotFound
+	// ErrHostKeyMismatch 主机密钥与 known_hosts 记录不一致（可能存在中间人攻击）
+	ErrHostKeyMismatch
+	// ErrHostKeyUnknown 严格模式下主机密钥不在 known_hosts 中
+	ErrHostKeyUnknown
 )
 
 // ExecutionError 统一的执行错误类型
@@ -89,3 +94,66 @@ func NewParseError(filePath string, cause error) *ExecutionError {
 		Retriable: false,
 	}
 }
+
+// NewInvalidArgsError 创建模块参数错误，param 是出错的参数名
+func NewInvalidArgsError(task, module, param string, cause error) *ExecutionError {
+	return &ExecutionError{
+		Type:      ErrInvalidArgs,
+		Task:      task,
+		Module:    module,
+		Message:   fmt.Sprintf("invalid arguments for module %s in task '%s': %v", module, task, cause),
+		Cause:     cause,
+		Retriable: false,
+		Details:   map[string]interface{}{"param": param},
+	}
+}
+
+// NewHostKeyMismatchError 创建主机密钥不匹配错误
+func NewHostKeyMismatchError(host, addr string, cause error) *ExecutionError {
+	return &ExecutionError{
+		Type:      ErrHostKeyMismatch,
+		Host:      host,
+		Message:   fmt.Sprintf("REMOTE HOST IDENTIFICATION HAS CHANGED for %s, possible man-in-the-middle attack: %v", addr, cause),
+		Cause:     cause,
+		Retriable: false,
+	}
+}
+
+// NewHostKeyUnknownError 创建主机密钥未知错误
+func NewHostKeyUnknownError(host, addr string, cause error) *ExecutionError {
+	return &ExecutionError{
+		Type:      ErrHostKeyUnknown,
+		Host:      host,
+		Message:   fmt.Sprintf("host key for %s is not in known_hosts and host key checking is strict", addr),
+		Cause:     cause,
+		Retriable: false,
+	}
+}
+
+// NewUnsupportedConnectionError 创建不支持的连接类型错误（ansible_connection 配置错误，不按不可达处理）
+func NewUnsupportedConnectionError(host, connType string) *ExecutionError {
+	return &ExecutionError{
+		Type:      ErrInvalidArgs,
+		Host:      host,
+		Message:   fmt.Sprintf("unsupported connection type: %s", connType),
+		Retriable: false,
+	}
+}
+
+// AsExecutionError 从错误链中提取 ExecutionError
+func AsExecutionError(err error) (*ExecutionError, bool) {
+	var execErr *ExecutionError
+	if errors.As(err, &execErr) {
+		return execErr, true
+	}
+	return nil, false
+}
+
+// IsUnreachable 判断错误是否表示主机不可达
+// 非 ExecutionError 的连接错误也按不可达处理
+func IsUnreachable(err error) bool {
+	if execErr, ok := AsExecutionError(err); ok {
+		return execErr.Type == ErrUnreachable
+	}
+	return err != nil
+}
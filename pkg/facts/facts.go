@@ -12,7 +12,7 @@ import (
 type Facts map[string]interface{}
 
 // GatherFacts collects system information from the remote host
-func GatherFacts(conn *connection.Connection) (Facts, error) {
+func GatherFacts(conn connection.Connection) (Facts, error) {
 	facts := make(Facts)
 
 	// Gather basic system facts
@@ -25,19 +25,20 @@ func GatherFacts(conn *connection.Connection) (Facts, error) {
 		return nil, fmt.Errorf("failed to gather architecture facts: %w", err)
 	}
 
-	// Gather distribution facts (Linux only)
+	// Gather distribution and package manager facts (Linux only)
 	if facts["ansible_system"] == "Linux" {
 		if err := gatherDistributionFacts(conn, facts); err != nil {
 			// Non-fatal - just log and continue
 			// Some systems may not have standard release files
 		}
+		facts["ansible_pkg_mgr"] = DetectPkgMgr(conn)
 	}
 
 	return facts, nil
 }
 
 // gatherSystemFacts gathers OS type information
-func gatherSystemFacts(conn *connection.Connection, facts Facts) error {
+func gatherSystemFacts(conn connection.Connection, facts Facts) error {
 	// Get OS type using uname -s
 	output, err := conn.ExecuteCommand("uname -s")
 	if err != nil {
@@ -52,7 +53,7 @@ func gatherSystemFacts(conn *connection.Connection, facts Facts) error {
 }
 
 // gatherArchitectureFacts gathers CPU architecture information
-func gatherArchitectureFacts(conn *connection.Connection, facts Facts) error {
+func gatherArchitectureFacts(conn connection.Connection, facts Facts) error {
 	// Get architecture using uname -m
 	output, err := conn.ExecuteCommand("uname -m")
 	if err != nil {
@@ -66,8 +67,39 @@ func gatherArchitectureFacts(conn *connection.Connection, facts Facts) error {
 	return nil
 }
 
+// pkgMgrs maps package manager commands, in detection order, to ansible_pkg_mgr values
+var pkgMgrs = []struct {
+	command string
+	name    string
+}{
+	{"apt-get", "apt"},
+	{"dnf", "dnf"},
+	{"yum", "yum"},
+	{"zypper", "zypper"},
+	{"apk", "apk"},
+}
+
+// DetectPkgMgr returns the ansible_pkg_mgr of the host: the first supported package manager
+// found in PATH, or "unknown"
+func DetectPkgMgr(conn connection.Connection) string {
+	commands := make([]string, len(pkgMgrs))
+	for i, mgr := range pkgMgrs {
+		commands[i] = mgr.command
+	}
+	output, err := conn.ExecuteCommand(fmt.Sprintf("for m in %s; do if command -v $m >/dev/null 2>&1; then echo $m; break; fi; done", strings.Join(commands, " ")))
+	if err == nil {
+		found := strings.TrimSpace(string(output))
+		for _, mgr := range pkgMgrs {
+			if mgr.command == found {
+				return mgr.name
+			}
+		}
+	}
+	return "unknown"
+}
+
 // gatherDistributionFacts gathers Linux distribution information
-func gatherDistributionFacts(conn *connection.Connection, facts Facts) error {
+func gatherDistributionFacts(conn connection.Connection, facts Facts) error {
 	// Try to get distribution from /etc/os-release (modern Linux)
 	output, err := conn.ExecuteCommand("cat /etc/os-release")
 	if err == nil {
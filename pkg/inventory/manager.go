@@ -2,12 +2,15 @@ package inventory
 
 import (
 	"fmt"
+	"path/filepath"
 	"strings"
 )
 
 // Manager 是 Inventory 管理器
 type Manager struct {
-	inventory *Inventory
+	inventory     *Inventory
+	inventoryDirs *VarsDirs // inventory 文件所在目录的 group_vars/ 和 host_vars/
+	playbookDirs  *VarsDirs // playbook 所在目录的 group_vars/ 和 host_vars/
 }
 
 // NewManager 创建一个新的 Manager
@@ -20,8 +23,7 @@ func (m *Manager) Load(path string) error {
 	// 根据文件扩展名选择解析器
 	var parser Parser
 	if strings.HasSuffix(path, ".yml") || strings.HasSuffix(path, ".yaml") {
-		// TODO: 实现 YAML 解析器
-		return fmt.Errorf("YAML inventory not yet supported")
+		parser = NewYAMLParser()
 	} else {
 		parser = NewINIParser()
 	}
@@ -31,10 +33,48 @@ func (m *Manager) Load(path string) error {
 		return err
 	}
 
+	dirs, err := LoadVarsDirs(filepath.Dir(path), inv)
+	if err != nil {
+		return err
+	}
+
 	m.inventory = inv
+	m.inventoryDirs = dirs
+	m.playbookDirs = nil
+	m.mergeVars()
 	return nil
 }
 
+// LoadPlaybookVars 加载 playbook 所在目录 dir 下的 group_vars/ 和 host_vars/
+// 它们的优先级高于 inventory 目录下的同类文件
+func (m *Manager) LoadPlaybookVars(dir string) error {
+	dirs, err := LoadVarsDirs(dir, m.inventory)
+	if err != nil {
+		return err
+	}
+
+	m.playbookDirs = dirs
+	m.mergeVars()
+	return nil
+}
+
+// InventoryVarsDirs 返回 inventory 目录下 group_vars/ 和 host_vars/ 中的变量（可能为 nil）
+func (m *Manager) InventoryVarsDirs() *VarsDirs {
+	return m.inventoryDirs
+}
+
+// PlaybookVarsDirs 返回 playbook 目录下 group_vars/ 和 host_vars/ 中的变量（可能为 nil）
+func (m *Manager) PlaybookVarsDirs() *VarsDirs {
+	return m.playbookDirs
+}
+
+// mergeVars 重新合并每个主机的变量，加入 group_vars/ 和 host_vars/ 中的变量
+func (m *Manager) mergeVars() {
+	for _, host := range m.inventory.Hosts {
+		host.Vars = mergeHostVars(m.inventory, host, m.inventoryDirs, m.playbookDirs)
+	}
+}
+
 // GetHost 获取单个主机
 func (m *Manager) GetHost(name string) (*Host, error) {
 	host, exists := m.inventory.Hosts[name]
@@ -44,31 +84,29 @@ func (m *Manager) GetHost(name string) (*Host, error) {
 	return host, nil
 }
 
+// HostGroups 返回主机所属的组及其所有祖先组（包括 all），按深度从浅到深排序
+// 组变量按这个顺序合并，子组的变量覆盖父组
+func (m *Manager) HostGroups(name string) []*Group {
+	host, exists := m.inventory.Hosts[name]
+	if !exists {
+		return nil
+	}
+	return ancestorGroups(m.inventory, host.Groups)
+}
+
 // GetHosts 根据模式获取主机列表
+// pattern 支持完整的 Ansible 主机模式语法，详见 ResolvePattern
 func (m *Manager) GetHosts(pattern string) ([]*Host, error) {
-	var hosts []*Host
+	hostnames, err := m.ResolvePattern(pattern)
+	if err != nil {
+		return nil, err
+	}
 
-	// 简单模式匹配
-	switch pattern {
-	case "all":
-		// 返回所有主机
-		for _, host := range m.inventory.Hosts {
+	hosts := make([]*Host, 0, len(hostnames))
+	for _, hostname := range hostnames {
+		if host, exists := m.inventory.Hosts[hostname]; exists {
 			hosts = append(hosts, host)
 		}
-	default:
-		// 假设是组名
-		group, exists := m.inventory.Groups[pattern]
-		if !exists {
-			return nil, fmt.Errorf("group not found: %s", pattern)
-		}
-
-		// 收集组中的所有主机（包括子组）
-		hostnames := m.collectGroupHosts(group)
-		for _, hostname := range hostnames {
-			if host, exists := m.inventory.Hosts[hostname]; exists {
-				hosts = append(hosts, host)
-			}
-		}
 	}
 
 	if len(hosts) == 0 {
@@ -212,43 +212,13 @@ func (p *INIParser) parseChild(inv *Inventory, line, group string) error {
 func (p *INIParser) postProcess(inv *Inventory) error {
 	// 为每个主机合并变量（按优先级）
 	for _, host := range inv.Hosts {
-		mergedVars := p.mergeHostVars(inv, host)
-		host.Vars = mergedVars
+		host.OwnVars = host.Vars
+		host.Vars = mergeHostVars(inv, host)
 	}
 
 	return nil
 }
 
-// mergeHostVars 合并主机的所有变量
-func (p *INIParser) mergeHostVars(inv *Inventory, host *Host) map[string]interface{} {
-	result := make(map[string]interface{})
-
-	// 1. all 组变量（最低优先级）
-	if allGroup, exists := inv.Groups["all"]; exists {
-		for k, v := range allGroup.Vars {
-			result[k] = v
-		}
-	}
-
-	// 2. 父组变量
-	// 3. 子组变量
-	// 简化版：直接合并所有组变量
-	for _, groupName := range host.Groups {
-		if group, exists := inv.Groups[groupName]; exists {
-			for k, v := range group.Vars {
-				result[k] = v
-			}
-		}
-	}
-
-	// 4. 主机变量（最高优先级）
-	for k, v := range host.Vars {
-		result[k] = v
-	}
-
-	return result
-}
-
 // contains 检查切片是否包含元素
 func contains(slice []string, item string) bool {
 	for _, s := range slice {
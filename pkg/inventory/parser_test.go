@@ -163,6 +163,35 @@ env=prod`,
 				"domain":       "example.com",
 			},
 		},
+		{
+			name: "child group vars override parent group vars",
+			content: `[nginx]
+web1
+
+[prod:children]
+webservers
+
+[webservers:children]
+nginx
+
+[nginx:vars]
+tier=nginx
+
+[webservers:vars]
+tier=web
+region=eu
+
+[prod:vars]
+tier=prod
+region=us
+env=prod`,
+			hostname: "web1",
+			want: map[string]interface{}{
+				"tier":   "nginx",
+				"region": "eu",
+				"env":    "prod",
+			},
+		},
 	}
 
 	for _, tt := range tests {
@@ -0,0 +1,310 @@
+package inventory
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// subscriptPattern 匹配形如 webservers[0]、webservers[-1]、webservers[0:2]、webservers[1:] 的下标
+var subscriptPattern = regexp.MustCompile(`^(.+)\[(?:(-?[0-9]+)|([0-9]+)?\s*:\s*([0-9]+)?)\]$`)
+
+// subscript 表示模式中的下标或切片
+type subscript struct {
+	start int
+	end   int  // 包含端点，与 Ansible 一致
+	slice bool // 是否是 [start:end] 形式
+	open  bool // 是否省略了 end，如 [1:]
+}
+
+// ResolvePattern 按 Ansible 主机模式语法解析 pattern，返回匹配的主机名（顺序稳定）
+//
+// 支持的语法：
+//   - 并集：web:db 或 web,db
+//   - 交集：web:&prod
+//   - 排除：all:!db01
+//   - 通配符：*.example.com、web?
+//   - 正则：~web\d+
+//   - 切片：webservers[0]、webservers[-1]、webservers[0:2]、webservers[1:]
+//   - 组名或主机名
+func (m *Manager) ResolvePattern(pattern string) ([]string, error) {
+	terms := splitPattern(pattern)
+
+	// 按 Ansible 的顺序处理：先并集，再交集，最后排除
+	var unions, intersections, exclusions []string
+	for _, term := range terms {
+		switch {
+		case strings.HasPrefix(term, "!"):
+			exclusions = append(exclusions, term[1:])
+		case strings.HasPrefix(term, "&"):
+			intersections = append(intersections, term[1:])
+		default:
+			unions = append(unions, term)
+		}
+	}
+
+	// 只有交集或排除时，以 all 作为起点
+	if len(unions) == 0 {
+		unions = []string{"all"}
+	}
+
+	var result []string
+	seen := make(map[string]bool)
+
+	for _, term := range unions {
+		matched, err := m.matchTerm(term)
+		if err != nil {
+			return nil, err
+		}
+		for _, hostname := range matched {
+			if !seen[hostname] {
+				seen[hostname] = true
+				result = append(result, hostname)
+			}
+		}
+	}
+
+	for _, term := range intersections {
+		matched, err := m.matchTerm(term)
+		if err != nil {
+			return nil, err
+		}
+		result = filterHosts(result, matched, true)
+	}
+
+	for _, term := range exclusions {
+		matched, err := m.matchTerm(term)
+		if err != nil {
+			return nil, err
+		}
+		result = filterHosts(result, matched, false)
+	}
+
+	return result, nil
+}
+
+// splitPattern 拆分模式字符串
+// 包含逗号时按逗号拆分；否则按冒号拆分，但不拆分方括号内的内容（如 web[0:2]）
+func splitPattern(pattern string) []string {
+	var parts []string
+
+	if strings.Contains(pattern, ",") {
+		parts = strings.Split(pattern, ",")
+	} else {
+		depth := 0
+		start := 0
+		for i, c := range pattern {
+			switch c {
+			case '[':
+				depth++
+			case ']':
+				if depth > 0 {
+					depth--
+				}
+			case ':':
+				if depth == 0 {
+					parts = append(parts, pattern[start:i])
+					start = i + 1
+				}
+			}
+		}
+		parts = append(parts, pattern[start:])
+	}
+
+	terms := make([]string, 0, len(parts))
+	for _, p := range parts {
+		if p = strings.TrimSpace(p); p != "" {
+			terms = append(terms, p)
+		}
+	}
+	return terms
+}
+
+// matchTerm 匹配单个模式项（可能带下标）
+func (m *Manager) matchTerm(term string) ([]string, error) {
+	expr, sub, err := parseSubscript(term)
+	if err != nil {
+		return nil, err
+	}
+
+	hosts, err := m.enumerateMatches(expr)
+	if err != nil {
+		return nil, err
+	}
+
+	if sub != nil {
+		hosts = sub.apply(hosts)
+	}
+	return hosts, nil
+}
+
+// parseSubscript 从模式项中拆出下标部分
+// 正则模式（~ 开头）不解析下标，方括号属于正则本身
+func parseSubscript(term string) (string, *subscript, error) {
+	if strings.HasPrefix(term, "~") {
+		return term, nil, nil
+	}
+
+	match := subscriptPattern.FindStringSubmatch(term)
+	if match == nil {
+		return term, nil, nil
+	}
+
+	sub := &subscript{}
+	if match[2] != "" {
+		idx, err := strconv.Atoi(match[2])
+		if err != nil {
+			return "", nil, fmt.Errorf("invalid subscript in pattern %s: %w", term, err)
+		}
+		sub.start, sub.end = idx, idx
+	} else {
+		sub.slice = true
+		if match[3] != "" {
+			sub.start, _ = strconv.Atoi(match[3])
+		}
+		if match[4] != "" {
+			sub.end, _ = strconv.Atoi(match[4])
+		} else {
+			sub.open = true
+		}
+	}
+
+	return match[1], sub, nil
+}
+
+// apply 对主机列表应用下标
+func (s *subscript) apply(hosts []string) []string {
+	if !s.slice {
+		idx := s.start
+		if idx < 0 {
+			idx += len(hosts)
+		}
+		if idx < 0 || idx >= len(hosts) {
+			return nil
+		}
+		return []string{hosts[idx]}
+	}
+
+	end := s.end
+	if s.open || end >= len(hosts) {
+		end = len(hosts) - 1
+	}
+	if s.start > end {
+		return nil
+	}
+	return hosts[s.start : end+1]
+}
+
+// enumerateMatches 匹配组名和主机名
+// 先匹配组；没有组匹配，或者是通配符/正则模式时，再匹配主机名
+func (m *Manager) enumerateMatches(expr string) ([]string, error) {
+	matcher, err := newNameMatcher(expr)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []string
+	seen := make(map[string]bool)
+	add := func(hostname string) {
+		if !seen[hostname] {
+			seen[hostname] = true
+			result = append(result, hostname)
+		}
+	}
+
+	groupNames := make([]string, 0, len(m.inventory.Groups))
+	for name := range m.inventory.Groups {
+		groupNames = append(groupNames, name)
+	}
+	sort.Strings(groupNames)
+
+	matchedGroup := false
+	for _, name := range groupNames {
+		if matcher(name) {
+			matchedGroup = true
+			for _, hostname := range m.collectGroupHosts(m.inventory.Groups[name]) {
+				add(hostname)
+			}
+		}
+	}
+
+	if !matchedGroup || strings.HasPrefix(expr, "~") || strings.ContainsAny(expr, ".?*[") {
+		for _, hostname := range m.orderedHostNames() {
+			if matcher(hostname) {
+				add(hostname)
+			}
+		}
+	}
+
+	return result, nil
+}
+
+// newNameMatcher 根据模式表达式创建名称匹配函数
+func newNameMatcher(expr string) (func(string) bool, error) {
+	if strings.HasPrefix(expr, "~") {
+		re, err := regexp.Compile("^(?:" + expr[1:] + ")")
+		if err != nil {
+			return nil, fmt.Errorf("invalid regex in pattern %s: %w", expr, err)
+		}
+		return re.MatchString, nil
+	}
+
+	if strings.ContainsAny(expr, "*?[") {
+		if _, err := path.Match(expr, ""); err != nil {
+			return nil, fmt.Errorf("invalid wildcard pattern %s: %w", expr, err)
+		}
+		return func(name string) bool {
+			ok, _ := path.Match(expr, name)
+			return ok
+		}, nil
+	}
+
+	return func(name string) bool {
+		return name == expr
+	}, nil
+}
+
+// orderedHostNames 按 inventory 中的定义顺序返回所有主机名
+func (m *Manager) orderedHostNames() []string {
+	names := make([]string, 0, len(m.inventory.Hosts))
+	seen := make(map[string]bool)
+
+	if all, exists := m.inventory.Groups["all"]; exists {
+		for _, hostname := range m.collectGroupHosts(all) {
+			if _, ok := m.inventory.Hosts[hostname]; ok && !seen[hostname] {
+				seen[hostname] = true
+				names = append(names, hostname)
+			}
+		}
+	}
+
+	// 理论上所有主机都在 all 中，这里兜底处理并保证顺序稳定
+	var rest []string
+	for hostname := range m.inventory.Hosts {
+		if !seen[hostname] {
+			rest = append(rest, hostname)
+		}
+	}
+	sort.Strings(rest)
+
+	return append(names, rest...)
+}
+
+// filterHosts 保留（keep=true）或移除（keep=false）出现在 matched 中的主机，保持原有顺序
+func filterHosts(hosts, matched []string, keep bool) []string {
+	set := make(map[string]bool, len(matched))
+	for _, h := range matched {
+		set[h] = true
+	}
+
+	result := make([]string, 0, len(hosts))
+	for _, h := range hosts {
+		if set[h] == keep {
+			result = append(result, h)
+		}
+	}
+	return result
+}
@@ -0,0 +1,170 @@
+package inventory
+
+import (
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestGetHostsPattern(t *testing.T) {
+	content := `[webservers]
+web1.example.com
+web2.example.com
+web3.example.com
+
+[dbservers]
+db01
+db02
+
+[prod:children]
+webservers
+dbservers
+
+[staging]
+web3.example.com
+stage-db`
+
+	tmpfile, err := os.CreateTemp("", "inventory-*.ini")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	if _, err := tmpfile.Write([]byte(content)); err != nil {
+		t.Fatal(err)
+	}
+	tmpfile.Close()
+
+	mgr := NewManager()
+	if err := mgr.Load(tmpfile.Name()); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		pattern string
+		want    []string
+		wantErr bool
+	}{
+		{
+			name:    "all",
+			pattern: "all",
+			want:    []string{"web1.example.com", "web2.example.com", "web3.example.com", "db01", "db02", "stage-db"},
+		},
+		{
+			name:    "single group",
+			pattern: "dbservers",
+			want:    []string{"db01", "db02"},
+		},
+		{
+			name:    "group with children",
+			pattern: "prod",
+			want:    []string{"web1.example.com", "web2.example.com", "web3.example.com", "db01", "db02"},
+		},
+		{
+			name:    "bare host",
+			pattern: "db02",
+			want:    []string{"db02"},
+		},
+		{
+			name:    "union with colon",
+			pattern: "dbservers:staging",
+			want:    []string{"db01", "db02", "web3.example.com", "stage-db"},
+		},
+		{
+			name:    "comma separated hosts",
+			pattern: "db02, web1.example.com",
+			want:    []string{"db02", "web1.example.com"},
+		},
+		{
+			name:    "intersection",
+			pattern: "webservers:&staging",
+			want:    []string{"web3.example.com"},
+		},
+		{
+			name:    "exclusion",
+			pattern: "all:!db01",
+			want:    []string{"web1.example.com", "web2.example.com", "web3.example.com", "db02", "stage-db"},
+		},
+		{
+			name:    "exclusion without union starts from all",
+			pattern: "!prod",
+			want:    []string{"stage-db"},
+		},
+		{
+			name:    "operators are applied after unions",
+			pattern: "!db01:dbservers",
+			want:    []string{"db02"},
+		},
+		{
+			name:    "wildcard",
+			pattern: "*.example.com",
+			want:    []string{"web1.example.com", "web2.example.com", "web3.example.com"},
+		},
+		{
+			name:    "wildcard matches groups",
+			pattern: "db*",
+			want:    []string{"db01", "db02"},
+		},
+		{
+			name:    "regex",
+			pattern: `~(web|db)0?[12]`,
+			want:    []string{"web1.example.com", "web2.example.com", "db01", "db02"},
+		},
+		{
+			name:    "index",
+			pattern: "webservers[0]",
+			want:    []string{"web1.example.com"},
+		},
+		{
+			name:    "negative index",
+			pattern: "webservers[-1]",
+			want:    []string{"web3.example.com"},
+		},
+		{
+			name:    "inclusive slice",
+			pattern: "webservers[0:1]",
+			want:    []string{"web1.example.com", "web2.example.com"},
+		},
+		{
+			name:    "open slice",
+			pattern: "webservers[1:]",
+			want:    []string{"web2.example.com", "web3.example.com"},
+		},
+		{
+			name:    "slice combined with exclusion",
+			pattern: "webservers[0:2]:!web2.example.com",
+			want:    []string{"web1.example.com", "web3.example.com"},
+		},
+		{
+			name:    "no match",
+			pattern: "nosuchgroup",
+			wantErr: true,
+		},
+		{
+			name:    "invalid regex",
+			pattern: "~web(",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hosts, err := mgr.GetHosts(tt.pattern)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("GetHosts(%q) error = %v, wantErr %v", tt.pattern, err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			got := make([]string, len(hosts))
+			for i, h := range hosts {
+				got[i] = h.Name
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("GetHosts(%q) = %v, want %v", tt.pattern, got, tt.want)
+			}
+		})
+	}
+}
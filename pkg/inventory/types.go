@@ -2,9 +2,10 @@ package inventory
 
 // Host 表示一个主机
 type Host struct {
-	Name   string                 // Inventory hostname (alias)
-	Vars   map[string]interface{} // 包含 ansible_host, ansible_port 等
-	Groups []string               // 所属组名
+	Name    string                 // Inventory hostname (alias)
+	Vars    map[string]interface{} // 包含 ansible_host, ansible_port 等（已合并组变量）
+	OwnVars map[string]interface{} // 主机自身定义的变量（不含组变量）
+	Groups  []string               // 所属组名
 }
 
 // Group 表示一个主机组
@@ -0,0 +1,164 @@
+package inventory
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// varsFileExtensions group_vars/ 和 host_vars/ 中变量文件可以使用的扩展名（也可以没有扩展名）
+var varsFileExtensions = []string{".yml", ".yaml", ".json"}
+
+// VarsDirs 某个目录下 group_vars/ 和 host_vars/ 中的变量
+type VarsDirs struct {
+	Dir    string                            // group_vars/ 和 host_vars/ 所在的目录
+	Groups map[string]map[string]interface{} // 组名 -> 变量
+	Hosts  map[string]map[string]interface{} // 主机名 -> 变量
+}
+
+// GroupVars 返回组在 group_vars/ 中定义的变量，d 为 nil 时返回 nil
+func (d *VarsDirs) GroupVars(name string) map[string]interface{} {
+	if d == nil {
+		return nil
+	}
+	return d.Groups[name]
+}
+
+// HostVars 返回主机在 host_vars/ 中定义的变量，d 为 nil 时返回 nil
+func (d *VarsDirs) HostVars(name string) map[string]interface{} {
+	if d == nil {
+		return nil
+	}
+	return d.Hosts[name]
+}
+
+// LoadVarsDirs 加载 dir 下的 group_vars/ 和 host_vars/
+// 只加载 inventory 中存在的组和主机，每个组（主机）的变量可以是以下任意形式，按顺序合并：
+//   - group_vars/web（没有扩展名的 YAML 文件）或 group_vars/web/ 目录，目录中的文件按名称排序后合并
+//   - group_vars/web.yml、group_vars/web.yaml、group_vars/web.json
+func LoadVarsDirs(dir string, inv *Inventory) (*VarsDirs, error) {
+	dirs := &VarsDirs{
+		Dir:    dir,
+		Groups: make(map[string]map[string]interface{}),
+		Hosts:  make(map[string]map[string]interface{}),
+	}
+
+	for name := range inv.Groups {
+		vars, err := loadNamedVars(filepath.Join(dir, "group_vars"), name)
+		if err != nil {
+			return nil, err
+		}
+		if vars != nil {
+			dirs.Groups[name] = vars
+		}
+	}
+
+	for name := range inv.Hosts {
+		vars, err := loadNamedVars(filepath.Join(dir, "host_vars"), name)
+		if err != nil {
+			return nil, err
+		}
+		if vars != nil {
+			dirs.Hosts[name] = vars
+		}
+	}
+
+	return dirs, nil
+}
+
+// loadNamedVars 加载 dir 中名为 name 的变量文件或目录，都不存在时返回 nil
+func loadNamedVars(dir, name string) (map[string]interface{}, error) {
+	var files []string
+	for _, ext := range append([]string{""}, varsFileExtensions...) {
+		path := filepath.Join(dir, name+ext)
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		if !info.IsDir() {
+			files = append(files, path)
+			continue
+		}
+		if ext == "" {
+			dirFiles, err := varsDirFiles(path)
+			if err != nil {
+				return nil, err
+			}
+			files = append(files, dirFiles...)
+		}
+	}
+
+	if len(files) == 0 {
+		return nil, nil
+	}
+
+	vars := make(map[string]interface{})
+	for _, file := range files {
+		fileVars, err := ReadVarsFile(file)
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range fileVars {
+			vars[k] = v
+		}
+	}
+	return vars, nil
+}
+
+// varsDirFiles 返回目录中（包括子目录）的变量文件，按名称排序
+// 跳过隐藏文件、编辑器备份文件（~ 结尾）和其他扩展名的文件
+func varsDirFiles(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read vars directory: %w", err)
+	}
+
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	sort.Strings(names)
+
+	var files []string
+	for _, name := range names {
+		if strings.HasPrefix(name, ".") || strings.HasSuffix(name, "~") {
+			continue
+		}
+		path := filepath.Join(dir, name)
+		ext := filepath.Ext(name)
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read vars file: %w", err)
+		}
+
+		switch {
+		case info.IsDir() && ext == "":
+			subFiles, err := varsDirFiles(path)
+			if err != nil {
+				return nil, err
+			}
+			files = append(files, subFiles...)
+		case !info.IsDir() && (ext == "" || contains(varsFileExtensions, ext)):
+			files = append(files, path)
+		}
+	}
+	return files, nil
+}
+
+// ReadVarsFile 读取 YAML（或 JSON）变量文件，文件必须是一个字典（空文件没有变量）
+func ReadVarsFile(path string) (map[string]interface{}, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read vars file: %w", err)
+	}
+
+	vars := make(map[string]interface{})
+	if err := yaml.Unmarshal(data, &vars); err != nil {
+		return nil, fmt.Errorf("failed to parse vars file %s: %w", path, err)
+	}
+	return vars, nil
+}
@@ -0,0 +1,95 @@
+package inventory
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestManagerLoadVarsDirs(t *testing.T) {
+	invDir := t.TempDir()
+	playbookDir := t.TempDir()
+	files := map[string]string{
+		filepath.Join(invDir, "hosts.ini"): `[nginx]
+web1 inline_host=true
+
+[web:children]
+nginx
+
+[all:vars]
+source=inline_all
+`,
+		filepath.Join(invDir, "group_vars", "all.yml"):            "source: inv_all\nansible_user: deploy\n",
+		filepath.Join(invDir, "group_vars", "nginx.yaml"):         "source: inv_nginx\n",
+		filepath.Join(invDir, "group_vars", "web", "10-main.yml"): "source: inv_web\nweb_port: 80\n",
+		filepath.Join(invDir, "group_vars", "web", "20-tls.json"): `{"web_port": 443}`,
+		filepath.Join(invDir, "group_vars", "web", "README.md"):   "not: loaded\n",
+		filepath.Join(invDir, "group_vars", "web", ".hidden.yml"): "hidden: true\n",
+		filepath.Join(invDir, "group_vars", "missing.yml"):        "unused: true\n",
+		filepath.Join(invDir, "host_vars", "web1"):                "host_file: inv\n",
+		filepath.Join(playbookDir, "group_vars", "all.yml"):       "source: pb_all\n",
+		filepath.Join(playbookDir, "group_vars", "web.yml"):       "web_port: 8443\n",
+		filepath.Join(playbookDir, "host_vars", "web1.yml"):       "host_file: pb\n",
+	}
+	for path, content := range files {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	m := NewManager()
+	if err := m.Load(filepath.Join(invDir, "hosts.ini")); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	// 目录中的文件按名称合并，只加载变量文件；inventory 中不存在的组不加载
+	dirs := m.InventoryVarsDirs()
+	if got, want := dirs.GroupVars("web"), map[string]interface{}{"source": "inv_web", "web_port": 443}; !reflect.DeepEqual(got, want) {
+		t.Errorf("group_vars/web = %v, want %v", got, want)
+	}
+	if _, ok := dirs.Groups["missing"]; ok {
+		t.Error("group_vars/missing.yml loaded for a group that is not in the inventory")
+	}
+
+	host, _ := m.GetHost("web1")
+	want := map[string]interface{}{
+		"source":       "inv_nginx", // 子组的 group_vars 覆盖父组和 all
+		"ansible_user": "deploy",
+		"web_port":     443,
+		"inline_host":  "true",
+		"host_file":    "inv",
+	}
+	if !reflect.DeepEqual(host.Vars, want) {
+		t.Errorf("host vars = %v, want %v", host.Vars, want)
+	}
+
+	// playbook 目录下的文件优先于 inventory 目录下的同类文件
+	if err := m.LoadPlaybookVars(playbookDir); err != nil {
+		t.Fatalf("LoadPlaybookVars() error = %v", err)
+	}
+	host, _ = m.GetHost("web1")
+	for k, v := range map[string]interface{}{"source": "inv_nginx", "web_port": 8443, "host_file": "pb"} {
+		if host.Vars[k] != v {
+			t.Errorf("after LoadPlaybookVars: %s = %v, want %v", k, host.Vars[k], v)
+		}
+	}
+}
+
+func TestLoadVarsDirsInvalidFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "group_vars", "all.yml")
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte("- not a dict\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := LoadVarsDirs(dir, NewInventory()); err == nil {
+		t.Error("LoadVarsDirs() expected error for a vars file that is not a dictionary")
+	}
+}
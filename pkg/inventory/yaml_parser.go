@@ -0,0 +1,285 @@
+package inventory
+
+import (
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/jimyag/ansigo/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// YAMLParser 解析 YAML 格式的 inventory
+//
+// 支持 Ansible 的标准结构：
+//
+//	all:
+//	  hosts:
+//	    host1:
+//	      ansible_host: 10.0.0.1
+//	  vars:
+//	    ntp_server: ntp.example.com
+//	  children:
+//	    webservers:
+//	      hosts:
+//	        web1:
+//	      children:
+//	        ...
+type YAMLParser struct{}
+
+// yamlGroup 对应 YAML 中一个组的定义
+type yamlGroup struct {
+	Hosts    map[string]map[string]interface{} `yaml:"hosts"`
+	Vars     map[string]interface{}            `yaml:"vars"`
+	Children map[string]*yamlGroup             `yaml:"children"`
+}
+
+// NewYAMLParser 创建一个新的 YAML 解析器
+func NewYAMLParser() *YAMLParser {
+	return &YAMLParser{}
+}
+
+// Parse 解析 YAML 格式的 inventory 文件
+func (p *YAMLParser) Parse(filePath string) (*Inventory, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read inventory file: %w", err)
+	}
+
+	var root map[string]*yamlGroup
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return nil, errors.NewParseError(filePath, err)
+	}
+
+	inv := NewInventory()
+
+	// 顶层的组（除 all 外）都视为 all 的子组
+	for _, name := range sortedKeys(root) {
+		if err := p.parseGroup(inv, name, root[name], ""); err != nil {
+			return nil, errors.NewParseError(filePath, err)
+		}
+		if name != "all" {
+			p.linkChild(inv, "all", name)
+		}
+	}
+
+	// 没有任何显式组的主机归入 ungrouped
+	for _, hostname := range inv.Groups["all"].Hosts {
+		host := inv.Hosts[hostname]
+		if len(host.Groups) == 0 {
+			host.Groups = append(host.Groups, "ungrouped")
+			inv.Groups["ungrouped"].Hosts = append(inv.Groups["ungrouped"].Hosts, hostname)
+		}
+	}
+
+	// 后处理：按组的层级合并变量
+	p.postProcess(inv)
+
+	return inv, nil
+}
+
+// parseGroup 递归解析组定义
+func (p *YAMLParser) parseGroup(inv *Inventory, name string, def *yamlGroup, parent string) error {
+	group := p.ensureGroup(inv, name)
+
+	if parent != "" {
+		p.linkChild(inv, parent, name)
+	}
+
+	// 空组（如 `webservers:` 或 `webservers: {}`）
+	if def == nil {
+		return nil
+	}
+
+	for k, v := range def.Vars {
+		group.Vars[k] = v
+	}
+
+	for _, hostname := range sortedKeys(def.Hosts) {
+		p.addHost(inv, hostname, def.Hosts[hostname], name)
+	}
+
+	for _, childName := range sortedKeys(def.Children) {
+		if childName == "all" {
+			return fmt.Errorf("group 'all' cannot be a child of '%s'", name)
+		}
+		if err := p.parseGroup(inv, childName, def.Children[childName], name); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// addHost 添加主机到组
+func (p *YAMLParser) addHost(inv *Inventory, hostname string, vars map[string]interface{}, group string) {
+	host, exists := inv.Hosts[hostname]
+	if !exists {
+		host = &Host{
+			Name:   hostname,
+			Vars:   make(map[string]interface{}),
+			Groups: []string{},
+		}
+		inv.Hosts[hostname] = host
+	}
+
+	// 同一主机可以在多个组中定义变量，后出现的覆盖先出现的
+	for k, v := range vars {
+		host.Vars[k] = v
+	}
+
+	// all 组不记录在 host.Groups 中，与 INI 解析器保持一致
+	if group != "all" {
+		if !contains(host.Groups, group) {
+			host.Groups = append(host.Groups, group)
+		}
+		if !contains(inv.Groups[group].Hosts, hostname) {
+			inv.Groups[group].Hosts = append(inv.Groups[group].Hosts, hostname)
+		}
+	}
+
+	if !contains(inv.Groups["all"].Hosts, hostname) {
+		inv.Groups["all"].Hosts = append(inv.Groups["all"].Hosts, hostname)
+	}
+}
+
+// ensureGroup 确保组存在
+func (p *YAMLParser) ensureGroup(inv *Inventory, name string) *Group {
+	group, exists := inv.Groups[name]
+	if !exists {
+		group = &Group{
+			Name:     name,
+			Hosts:    []string{},
+			Children: []string{},
+			Vars:     make(map[string]interface{}),
+			Parents:  []string{},
+		}
+		inv.Groups[name] = group
+	}
+	return group
+}
+
+// linkChild 建立父子组关系
+func (p *YAMLParser) linkChild(inv *Inventory, parent, child string) {
+	if g, exists := inv.Groups[parent]; exists && !contains(g.Children, child) {
+		g.Children = append(g.Children, child)
+	}
+	if c, exists := inv.Groups[child]; exists && !contains(c.Parents, parent) {
+		c.Parents = append(c.Parents, parent)
+	}
+}
+
+// postProcess 后处理：合并变量到主机
+func (p *YAMLParser) postProcess(inv *Inventory) {
+	for _, host := range inv.Hosts {
+		host.OwnVars = host.Vars
+		host.Vars = mergeHostVars(inv, host)
+	}
+}
+
+// mergeHostVars 合并主机的所有变量
+// 优先级：all 组 < 父组 < 子组 < 主机变量，同一深度的组按名称排序
+// dirs 是 group_vars/ 和 host_vars/ 所在的目录（inventory 目录在前，playbook 目录在后），优先级为：
+// inventory 中的组变量 < group_vars/all < 其他 group_vars（按组深度） < inventory 中的主机变量 < host_vars
+func mergeHostVars(inv *Inventory, host *Host, dirs ...*VarsDirs) map[string]interface{} {
+	result := make(map[string]interface{})
+	merge := func(vars map[string]interface{}) {
+		for k, v := range vars {
+			result[k] = v
+		}
+	}
+
+	groups := ancestorGroups(inv, host.Groups)
+	for _, group := range groups {
+		merge(group.Vars)
+	}
+	for _, d := range dirs {
+		merge(d.GroupVars("all"))
+	}
+	for _, d := range dirs {
+		for _, group := range groups {
+			if group.Name != "all" {
+				merge(d.GroupVars(group.Name))
+			}
+		}
+	}
+
+	merge(host.OwnVars)
+	for _, d := range dirs {
+		merge(d.HostVars(host.Name))
+	}
+
+	return result
+}
+
+// ancestorGroups 返回给定组及其所有祖先组（包括 all），按深度从浅到深排序
+// 深度从 all（0）开始计算，同一深度的组按名称排序
+func ancestorGroups(inv *Inventory, groupNames []string) []*Group {
+	seen := make(map[string]bool)
+	var collect func(name string)
+	collect = func(name string) {
+		if seen[name] {
+			return
+		}
+		if _, exists := inv.Groups[name]; !exists {
+			return
+		}
+		seen[name] = true
+		for _, parent := range inv.Groups[name].Parents {
+			collect(parent)
+		}
+	}
+
+	collect("all")
+	for _, name := range groupNames {
+		collect(name)
+	}
+
+	groups := make([]*Group, 0, len(seen))
+	depths := make(map[string]int, len(seen))
+	for name := range seen {
+		groups = append(groups, inv.Groups[name])
+		depths[name] = groupDepth(inv, name, map[string]bool{})
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		di, dj := depths[groups[i].Name], depths[groups[j].Name]
+		if di != dj {
+			return di < dj
+		}
+		return groups[i].Name < groups[j].Name
+	})
+
+	return groups
+}
+
+// groupDepth 计算组相对 all 的深度（取最长路径）
+// 没有声明父组的组视为 all 的直接子组
+func groupDepth(inv *Inventory, name string, visiting map[string]bool) int {
+	if name == "all" {
+		return 0
+	}
+	group, exists := inv.Groups[name]
+	if !exists || visiting[name] {
+		return 1
+	}
+	visiting[name] = true
+	defer delete(visiting, name)
+
+	depth := 1
+	for _, parent := range group.Parents {
+		if d := groupDepth(inv, parent, visiting) + 1; d > depth {
+			depth = d
+		}
+	}
+	return depth
+}
+
+// sortedKeys 返回排序后的 map 键，保证解析结果稳定
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
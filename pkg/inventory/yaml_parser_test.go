@@ -0,0 +1,241 @@
+package inventory
+
+import (
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestParseYAML(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		wantErr bool
+		check   func(*testing.T, *Inventory)
+	}{
+		{
+			name: "hosts under all are ungrouped",
+			content: `all:
+  hosts:
+    db01:
+      ansible_host: 10.0.0.5
+      ansible_port: 2222`,
+			check: func(t *testing.T, inv *Inventory) {
+				host := inv.Hosts["db01"]
+				if host == nil {
+					t.Fatal("db01 host not found")
+				}
+				if host.Vars["ansible_port"] != 2222 {
+					t.Errorf("Expected ansible_port=2222 (int), got %#v", host.Vars["ansible_port"])
+				}
+				if !reflect.DeepEqual(host.Groups, []string{"ungrouped"}) {
+					t.Errorf("Expected groups [ungrouped], got %v", host.Groups)
+				}
+				if !contains(inv.Groups["ungrouped"].Hosts, "db01") {
+					t.Errorf("Expected db01 in ungrouped, got %v", inv.Groups["ungrouped"].Hosts)
+				}
+				if !contains(inv.Groups["all"].Hosts, "db01") {
+					t.Errorf("Expected db01 in all, got %v", inv.Groups["all"].Hosts)
+				}
+			},
+		},
+		{
+			name: "nested children",
+			content: `all:
+  children:
+    prod:
+      children:
+        webservers:
+          hosts:
+            web1:
+            web2:
+        dbservers:
+          hosts:
+            db1:`,
+			check: func(t *testing.T, inv *Inventory) {
+				prod := inv.Groups["prod"]
+				if prod == nil {
+					t.Fatal("prod group not found")
+				}
+				if !reflect.DeepEqual(prod.Children, []string{"dbservers", "webservers"}) {
+					t.Errorf("Expected prod children [dbservers webservers], got %v", prod.Children)
+				}
+				if !reflect.DeepEqual(inv.Groups["webservers"].Parents, []string{"prod"}) {
+					t.Errorf("Expected webservers parents [prod], got %v", inv.Groups["webservers"].Parents)
+				}
+				if !reflect.DeepEqual(inv.Groups["webservers"].Hosts, []string{"web1", "web2"}) {
+					t.Errorf("Expected webservers hosts [web1 web2], got %v", inv.Groups["webservers"].Hosts)
+				}
+				if len(inv.Groups["ungrouped"].Hosts) != 0 {
+					t.Errorf("Expected no ungrouped hosts, got %v", inv.Groups["ungrouped"].Hosts)
+				}
+			},
+		},
+		{
+			name: "typed vars",
+			content: `all:
+  vars:
+    ntp_servers:
+      - 0.pool.ntp.org
+      - 1.pool.ntp.org
+  children:
+    webservers:
+      vars:
+        http_port: 80
+        tls: true
+        limits:
+          nofile: 65535
+      hosts:
+        web1:`,
+			check: func(t *testing.T, inv *Inventory) {
+				vars := inv.Hosts["web1"].Vars
+				if vars["http_port"] != 80 {
+					t.Errorf("Expected http_port=80 (int), got %#v", vars["http_port"])
+				}
+				if vars["tls"] != true {
+					t.Errorf("Expected tls=true (bool), got %#v", vars["tls"])
+				}
+				limits, ok := vars["limits"].(map[string]interface{})
+				if !ok || limits["nofile"] != 65535 {
+					t.Errorf("Expected limits.nofile=65535, got %#v", vars["limits"])
+				}
+				servers, ok := vars["ntp_servers"].([]interface{})
+				if !ok || len(servers) != 2 {
+					t.Errorf("Expected 2 ntp_servers, got %#v", vars["ntp_servers"])
+				}
+			},
+		},
+		{
+			name: "top level groups become children of all",
+			content: `webservers:
+  hosts:
+    web1:`,
+			check: func(t *testing.T, inv *Inventory) {
+				if !contains(inv.Groups["all"].Children, "webservers") {
+					t.Errorf("Expected webservers to be a child of all, got %v", inv.Groups["all"].Children)
+				}
+				if !contains(inv.Groups["all"].Hosts, "web1") {
+					t.Errorf("Expected web1 in all, got %v", inv.Groups["all"].Hosts)
+				}
+			},
+		},
+		{
+			name: "all cannot be a child",
+			content: `all:
+  children:
+    webservers:
+      children:
+        all:`,
+			wantErr: true,
+		},
+		{
+			name:    "invalid yaml",
+			content: "all: [",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// 创建临时文件
+			tmpfile, err := os.CreateTemp("", "inventory-*.yml")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.Remove(tmpfile.Name())
+
+			if _, err := tmpfile.Write([]byte(tt.content)); err != nil {
+				t.Fatal(err)
+			}
+			tmpfile.Close()
+
+			// 解析
+			parser := NewYAMLParser()
+			inv, err := parser.Parse(tmpfile.Name())
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Parse() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+
+			if !tt.wantErr && tt.check != nil {
+				tt.check(t, inv)
+			}
+		})
+	}
+}
+
+func TestYAMLMergeHostVars(t *testing.T) {
+	content := `all:
+  vars:
+    env: dev
+    domain: example.com
+    tier: base
+  children:
+    prod:
+      vars:
+        env: prod
+        tier: prod
+      children:
+        webservers:
+          vars:
+            tier: web
+          hosts:
+            web1:
+              ansible_host: 192.168.1.10
+            web2:
+              tier: canary`
+
+	tmpfile, err := os.CreateTemp("", "inventory-*.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	if _, err := tmpfile.Write([]byte(content)); err != nil {
+		t.Fatal(err)
+	}
+	tmpfile.Close()
+
+	// 通过 Manager 加载，验证扩展名分派
+	mgr := NewManager()
+	if err := mgr.Load(tmpfile.Name()); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		hostname string
+		want     map[string]interface{}
+	}{
+		{
+			hostname: "web1",
+			want: map[string]interface{}{
+				"ansible_host": "192.168.1.10",
+				"env":          "prod",
+				"domain":       "example.com",
+				"tier":         "web",
+			},
+		},
+		{
+			hostname: "web2",
+			want: map[string]interface{}{
+				"env":  "prod",
+				"tier": "canary",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.hostname, func(t *testing.T) {
+			host, err := mgr.GetHost(tt.hostname)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for key, wantVal := range tt.want {
+				if gotVal, exists := host.Vars[key]; !exists || gotVal != wantVal {
+					t.Errorf("Host.Vars[%s] = %v, want %v", key, gotVal, wantVal)
+				}
+			}
+		})
+	}
+}
@@ -74,6 +74,14 @@ func (a *AnsibleLogger) TaskResult(status, host, msg string, changed, failed, sk
 	fmt.Println(output)
 }
 
+// TaskRetry 打印 until 条件未满足、即将重试的提示
+func (a *AnsibleLogger) TaskRetry(host, taskName string, retriesLeft int) {
+	if a.quiet {
+		return
+	}
+	fmt.Printf("%sFAILED - RETRYING: [%s]: %s (%d retries left).%s\n", ColorYellow, host, taskName, retriesLeft, ColorReset)
+}
+
 // PlayRecap 打印 Play 总结
 func (a *AnsibleLogger) PlayRecap(stats map[string]*PlayStats) {
 	if a.quiet {
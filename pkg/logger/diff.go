@@ -0,0 +1,255 @@
+package logger
+
+import (
+	"fmt"
+	"strings"
+)
+
+// diffContext 统一格式 diff 中每个修改块前后保留的上下文行数
+const diffContext = 3
+
+// Diff 打印彩色的统一格式 diff（--diff），内容相同时不输出
+func (a *AnsibleLogger) Diff(before, after, beforeHeader, afterHeader string) {
+	if a.quiet {
+		return
+	}
+
+	diff := UnifiedDiff(before, after, "before: "+beforeHeader, "after: "+afterHeader)
+	if diff == "" {
+		return
+	}
+
+	for _, line := range strings.SplitAfter(diff, "\n") {
+		if line == "" {
+			continue
+		}
+		color := ""
+		switch {
+		case strings.HasPrefix(line, "-"):
+			color = ColorRed
+		case strings.HasPrefix(line, "+"):
+			color = ColorGreen
+		case strings.HasPrefix(line, "@@"):
+			color = ColorCyan
+		}
+		if color == "" {
+			fmt.Print(line)
+		} else {
+			fmt.Print(color + strings.TrimSuffix(line, "\n") + ColorReset + "\n")
+		}
+	}
+	fmt.Println()
+}
+
+// diffOp diff 中的一行：' ' 未修改，'-' 删除，'+' 新增
+type diffOp struct {
+	kind byte
+	text string
+}
+
+// UnifiedDiff 生成 before 到 after 的统一格式 diff，内容相同时返回空字符串
+// 没有以换行结尾的最后一行后面跟 "\ No newline at end of file"，与 diff -u 相同
+func UnifiedDiff(before, after, beforeHeader, afterHeader string) string {
+	if before == after {
+		return ""
+	}
+
+	ops := diffLines(splitLines(before), splitLines(after))
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "--- %s\n+++ %s\n", beforeHeader, afterHeader)
+
+	// 按修改的位置分组：两处修改之间的未修改行不超过 2*diffContext 时合并为一个修改块
+	for start := 0; start < len(ops); {
+		first := nextChange(ops, start)
+		if first < 0 {
+			break
+		}
+		last := first
+		for next := nextChange(ops, last+1); next >= 0 && next-last-1 <= 2*diffContext; next = nextChange(ops, last+1) {
+			last = next
+		}
+
+		from := max(first-diffContext, 0)
+		to := min(last+diffContext+1, len(ops))
+		writeHunk(&b, ops, from, to)
+		start = to
+	}
+	return b.String()
+}
+
+// splitLines 按行拆分，每行保留结尾的换行符
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// nextChange 返回 start 之后第一个修改行的位置，没有时返回 -1
+func nextChange(ops []diffOp, start int) int {
+	for i := start; i < len(ops); i++ {
+		if ops[i].kind != ' ' {
+			return i
+		}
+	}
+	return -1
+}
+
+// writeHunk 输出 ops[from:to] 组成的修改块
+func writeHunk(b *strings.Builder, ops []diffOp, from, to int) {
+	// 修改块之前两边各有多少行
+	beforeLine, afterLine := 0, 0
+	for _, op := range ops[:from] {
+		if op.kind != '+' {
+			beforeLine++
+		}
+		if op.kind != '-' {
+			afterLine++
+		}
+	}
+	beforeCount, afterCount := 0, 0
+	for _, op := range ops[from:to] {
+		if op.kind != '+' {
+			beforeCount++
+		}
+		if op.kind != '-' {
+			afterCount++
+		}
+	}
+
+	fmt.Fprintf(b, "@@ -%s +%s @@\n", hunkRange(beforeLine, beforeCount), hunkRange(afterLine, afterCount))
+	for _, op := range ops[from:to] {
+		b.WriteByte(op.kind)
+		b.WriteString(op.text)
+		if !strings.HasSuffix(op.text, "\n") {
+			b.WriteString("\n\\ No newline at end of file\n")
+		}
+	}
+}
+
+// hunkRange 格式化修改块头部的行范围，count 为 1 时省略，为 0 时起始行是修改块之前的一行
+func hunkRange(linesBefore, count int) string {
+	switch count {
+	case 0:
+		return fmt.Sprintf("%d,0", linesBefore)
+	case 1:
+		return fmt.Sprintf("%d", linesBefore+1)
+	default:
+		return fmt.Sprintf("%d,%d", linesBefore+1, count)
+	}
+}
+
+// maxDiffEdits Myers 搜索的最大编辑距离，trace 占用的内存约为 maxDiffEdits² 个 int
+// 超过时不再寻找最短编辑序列，把不同的部分整体作为删除和添加输出
+const maxDiffEdits = 1000
+
+// diffLines 计算把 a 变成 b 的编辑序列
+// 相同的开头和结尾直接保留，中间部分用 Myers 算法计算最短编辑序列
+func diffLines(a, b []string) []diffOp {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	var ops []diffOp
+	for _, line := range a[:prefix] {
+		ops = append(ops, diffOp{' ', line})
+	}
+	ops = append(ops, myersDiff(a[prefix:len(a)-suffix], b[prefix:len(b)-suffix])...)
+	for _, line := range a[len(a)-suffix:] {
+		ops = append(ops, diffOp{' ', line})
+	}
+	return ops
+}
+
+// myersDiff 用 Myers 算法计算把 a 变成 b 的最短编辑序列
+// 编辑距离超过 maxDiffEdits 时删除 a 的全部行并添加 b 的全部行
+func myersDiff(a, b []string) []diffOp {
+	n, m := len(a), len(b)
+	offset := n + m + 1
+	v := make([]int, 2*offset+1)
+
+	// trace[d] 记录第 d 步开始前对角线 -d-1..d+1 能到达的最远位置，用于回溯编辑路径
+	// 第 d 步只会用到这些对角线，内存与编辑距离的平方成正比，而不是与文件行数的平方成正比
+	var trace [][]int
+search:
+	for d := 0; d <= n+m; d++ {
+		if d > maxDiffEdits {
+			return replaceLines(a, b)
+		}
+		trace = append(trace, append([]int(nil), v[offset-d-1:offset+d+2]...))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				break search
+			}
+		}
+	}
+
+	// 从终点回溯，得到逆序的编辑序列
+	var ops []diffOp
+	x, y := n, m
+	for d := len(trace) - 1; d >= 0; d-- {
+		// 对角线 k 在 trace[d] 中的下标为 k+d+1
+		v := trace[d]
+		k := x - y
+		prevK := k - 1
+		if k == -d || (k != d && v[k+d] < v[k+d+2]) {
+			prevK = k + 1
+		}
+		prevX := v[prevK+d+1]
+		prevY := prevX - prevK
+
+		for x > prevX && y > prevY {
+			ops = append(ops, diffOp{' ', a[x-1]})
+			x--
+			y--
+		}
+		if d > 0 {
+			if x == prevX {
+				ops = append(ops, diffOp{'+', b[y-1]})
+				y--
+			} else {
+				ops = append(ops, diffOp{'-', a[x-1]})
+				x--
+			}
+		}
+	}
+
+	for i, j := 0, len(ops)-1; i < j; i, j = i+1, j-1 {
+		ops[i], ops[j] = ops[j], ops[i]
+	}
+	return ops
+}
+
+// replaceLines 返回删除 a 的全部行、再添加 b 的全部行的编辑序列
+func replaceLines(a, b []string) []diffOp {
+	ops := make([]diffOp, 0, len(a)+len(b))
+	for _, line := range a {
+		ops = append(ops, diffOp{'-', line})
+	}
+	for _, line := range b {
+		ops = append(ops, diffOp{'+', line})
+	}
+	return ops
+}
@@ -0,0 +1,105 @@
+package logger
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	tests := []struct {
+		name   string
+		before string
+		after  string
+		want   string
+	}{
+		{name: "same", before: "a\nb\n", after: "a\nb\n", want: ""},
+		{
+			name:   "new file",
+			before: "",
+			after:  "port = 8080\n",
+			want:   "--- a\n+++ b\n@@ -0,0 +1 @@\n+port = 8080\n",
+		},
+		{
+			name:   "nearby changes share a hunk",
+			before: "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12\n",
+			after:  "1\n2\n3\n4\n5\nsix\n7\n8\n9\n10\n11\n",
+			want: "--- a\n+++ b\n" +
+				"@@ -3,10 +3,9 @@\n 3\n 4\n 5\n-6\n+six\n 7\n 8\n 9\n 10\n 11\n-12\n",
+		},
+		{
+			name:   "separate hunks",
+			before: "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n",
+			after:  "one\n2\n3\n4\n5\n6\n7\n8\n9\nten\n",
+			want: "--- a\n+++ b\n" +
+				"@@ -1,4 +1,4 @@\n-1\n+one\n 2\n 3\n 4\n" +
+				"@@ -7,4 +7,4 @@\n 7\n 8\n 9\n-10\n+ten\n",
+		},
+		{
+			name:   "no newline at end of file",
+			before: "a\nb",
+			after:  "a\nb\n",
+			want:   "--- a\n+++ b\n@@ -1,2 +1,2 @@\n a\n-b\n\\ No newline at end of file\n+b\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := UnifiedDiff(tt.before, tt.after, "a", "b"); got != tt.want {
+				t.Errorf("UnifiedDiff() =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUnifiedDiffLargeFiles(t *testing.T) {
+	var before, after strings.Builder
+	for i := 0; i < 50000; i++ {
+		fmt.Fprintf(&before, "a%d\n", i)
+		fmt.Fprintf(&after, "b%d\n", i)
+	}
+
+	// 完全不同的大文件：超过 maxDiffEdits 后整体替换，而不是占用 O((n+m)²) 的内存
+	got := UnifiedDiff(before.String(), after.String(), "a", "b")
+	if !strings.HasPrefix(got, "--- a\n+++ b\n@@ -1,50000 +1,50000 @@\n-a0\n") {
+		t.Errorf("UnifiedDiff() starts with %q", got[:40])
+	}
+	if n := strings.Count(got, "\n-a"); n != 50000 {
+		t.Errorf("UnifiedDiff() removes %d lines, want 50000", n)
+	}
+
+	// 只有一行不同时只输出一个修改块
+	changed := strings.Replace(before.String(), "a25000\n", "changed\n", 1)
+	want := "--- a\n+++ b\n@@ -24998,7 +24998,7 @@\n a24997\n a24998\n a24999\n-a25000\n+changed\n a25001\n a25002\n a25003\n"
+	if got := UnifiedDiff(before.String(), changed, "a", "b"); got != want {
+		t.Errorf("UnifiedDiff() =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestDiffLinesReconstructs(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	randomLines := func() []string {
+		lines := make([]string, rng.Intn(40))
+		for i := range lines {
+			lines[i] = string(rune('a' + rng.Intn(4)))
+		}
+		return lines
+	}
+
+	for i := 0; i < 500; i++ {
+		a, b := randomLines(), randomLines()
+		var gotA, gotB []string
+		for _, op := range diffLines(a, b) {
+			if op.kind != '+' {
+				gotA = append(gotA, op.text)
+			}
+			if op.kind != '-' {
+				gotB = append(gotB, op.text)
+			}
+		}
+		if strings.Join(gotA, ",") != strings.Join(a, ",") || strings.Join(gotB, ",") != strings.Join(b, ",") {
+			t.Fatalf("diffLines(%v, %v) does not reproduce the inputs: %v, %v", a, b, gotA, gotB)
+		}
+	}
+}
@@ -0,0 +1,216 @@
+package module
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/jimyag/ansigo/pkg/agent"
+	"github.com/jimyag/ansigo/pkg/connection"
+	"github.com/jimyag/ansigo/pkg/logger"
+)
+
+// remoteAgent 在目标主机上运行的 agent，模块用完后调用 Close 结束 agent 进程
+type remoteAgent struct {
+	*agent.Client
+	proc    *connection.Process
+	cleanup func() // 删除只为这次任务上传的 agent，为 nil 时 agent 留在缓存中
+}
+
+// Close 结束 agent 进程
+func (a *remoteAgent) Close() error {
+	err := a.proc.Wait()
+	if a.cleanup != nil {
+		a.cleanup()
+	}
+	return err
+}
+
+// startAgent 任务启用了远程 agent（AgentArg）时在目标主机上启动 agent
+// 没有启用或 agent 不可用（没有对应架构的 agent、目标主机不是 Linux、连接不支持交互式进程等）时返回 nil，
+// 模块回退到 shell 命令；不可用的原因在 -v 时输出
+func startAgent(conn connection.Connection, args map[string]interface{}, become bool, becomeUser, becomeMethod string) *remoteAgent {
+	arch, ok := args[AgentArg].(string)
+	if !ok {
+		return nil
+	}
+
+	a, err := launchAgent(conn, arch, become, becomeUser, becomeMethod)
+	if err != nil {
+		logger.Debugf("remote agent unavailable, using shell commands: %v", err)
+		return nil
+	}
+	return a
+}
+
+// launchAgent 上传（如果需要）并启动 agent，完成握手
+func launchAgent(conn connection.Connection, arch string, become bool, becomeUser, becomeMethod string) (*remoteAgent, error) {
+	starter, ok := conn.(connection.Starter)
+	if !ok {
+		return nil, fmt.Errorf("connection does not support long running processes")
+	}
+
+	// 没有收集 facts 时检测目标主机的系统和架构
+	if arch == "" {
+		stdout, _, exitCode, err := conn.Exec("uname -sm")
+		if err != nil {
+			return nil, err
+		}
+		fields := strings.Fields(string(stdout))
+		if exitCode != 0 || len(fields) != 2 {
+			return nil, fmt.Errorf("failed to detect architecture: %q", strings.TrimSpace(string(stdout)))
+		}
+		if fields[0] != "Linux" {
+			return nil, fmt.Errorf("agent is not available for %s", fields[0])
+		}
+		arch = fields[1]
+	}
+
+	goarch, err := agent.GoArch(arch)
+	if err != nil {
+		return nil, err
+	}
+	local, err := agent.Binary(goarch)
+	if err != nil {
+		return nil, err
+	}
+	// become 为非特权用户时无法执行登录用户 ~/.ansible/tmp 中的 agent，
+	// 和 library 模块一样上传到系统临时目录并只授权给 become 用户
+	var remote string
+	var cleanup func()
+	if mt := NewModuleTransfer(conn, become, becomeUser); mt.becomeUser != "" {
+		remote, cleanup, err = installAgentForBecome(mt, local)
+	} else {
+		remote, err = installAgent(conn, local)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var proc *connection.Process
+	if become {
+		proc, err = starter.StartWithBecome(shellQuote(remote), becomeUser, becomeMethod)
+	} else {
+		proc, err = starter.Start(shellQuote(remote))
+	}
+	if err != nil {
+		if cleanup != nil {
+			cleanup()
+		}
+		return nil, err
+	}
+
+	a := &remoteAgent{proc: proc, cleanup: cleanup}
+	a.Client, err = agent.NewClient(proc.Stdout, proc.Stdin)
+	if err != nil {
+		if werr := a.Close(); werr != nil {
+			err = fmt.Errorf("%v (%v)", err, werr)
+		}
+		return nil, err
+	}
+	return a, nil
+}
+
+// agentChecksums 控制节点上 agent 文件的 SHA256 校验和缓存，避免每个任务都重新计算
+var agentChecksums sync.Map
+
+// installAgent 把 agent 上传到目标主机的 ~/.ansible/tmp/ansigo-agent-<校验和>，已经上传过时直接使用
+// 文件名包含校验和，所以不同版本的 agent 不会互相覆盖；上传是原子的，不会使用写了一半的文件
+func installAgent(conn connection.Connection, local string) (string, error) {
+	sum, ok := agentChecksums.Load(local)
+	if !ok {
+		f, err := os.Open(local)
+		if err != nil {
+			return "", err
+		}
+		h := sha256.New()
+		_, err = io.Copy(h, f)
+		f.Close()
+		if err != nil {
+			return "", err
+		}
+		sum = hex.EncodeToString(h.Sum(nil))
+		agentChecksums.Store(local, sum)
+	}
+	name := "ansigo-agent-" + sum.(string)[:16]
+
+	// 一次往返同时得到临时目录的绝对路径（上传时远程不会展开 ~）和 agent 是否已经存在
+	stdout, stderr, exitCode, err := conn.Exec(fmt.Sprintf("umask 77 && mkdir -p ~/.ansible/tmp && cd ~/.ansible/tmp && pwd && if [ -x %s ]; then echo cached; fi", name))
+	if err != nil {
+		return "", err
+	}
+	lines := strings.Split(strings.TrimSpace(string(stdout)), "\n")
+	if exitCode != 0 || lines[0] == "" {
+		return "", fmt.Errorf("failed to prepare agent directory: %s", strings.TrimSpace(string(stderr)))
+	}
+	remote := path.Join(lines[0], name)
+	if len(lines) > 1 && lines[1] == "cached" {
+		return remote, nil
+	}
+
+	f, err := os.Open(local)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	if err := conn.Upload(f, remote, 0o755); err != nil {
+		return "", fmt.Errorf("failed to upload agent: %w", err)
+	}
+	return remote, nil
+}
+
+// installAgentForBecome 把 agent 上传到系统临时目录中只为这次任务创建的目录，并授权非特权 become 用户执行
+// 其他用户可以写入系统临时目录，所以不缓存，返回的 cleanup 删除目录
+func installAgentForBecome(mt *ModuleTransfer, local string) (string, func(), error) {
+	dir, err := mt.PrepareRemoteDir()
+	if err != nil {
+		return "", nil, err
+	}
+	cleanup := func() { _ = mt.Cleanup(dir) }
+
+	remote, err := mt.TransferModule(local, dir)
+	if err == nil {
+		err = mt.GrantAccess(remote)
+	}
+	if err != nil {
+		cleanup()
+		return "", nil, fmt.Errorf("failed to upload agent: %w", err)
+	}
+	return remote, cleanup, nil
+}
+
+// agentAttributes 把 mode/owner/group 参数转换为 agent 的文件属性
+// 符号权限（如 u+x）agent 无法处理，返回 ok=false，模块使用 shell 命令
+func agentAttributes(args map[string]interface{}) (agent.Attributes, bool) {
+	var attrs agent.Attributes
+	if modeArg, ok := args["mode"]; ok && modeArg != nil {
+		mode, numeric := numericMode(modeArg)
+		if !numeric {
+			return attrs, false
+		}
+		attrs.Mode = uint32(mode)
+	}
+	attrs.Owner, _ = args["owner"].(string)
+	attrs.Group, _ = args["group"].(string)
+	return attrs, true
+}
+
+// agentFileAttributes 把 agent 返回的文件属性转换为 fileAttributes，文件不存在时返回 nil
+func agentFileAttributes(info *agent.FileInfo) *fileAttributes {
+	if !info.Exists {
+		return nil
+	}
+	return &fileAttributes{
+		Mode:  info.ModeString(),
+		Owner: info.Owner,
+		Group: info.Group,
+		UID:   strconv.Itoa(info.UID),
+		GID:   strconv.Itoa(info.GID),
+	}
+}
@@ -0,0 +1,213 @@
+package module
+
+import (
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strconv"
+	"syscall"
+	"testing"
+
+	"github.com/jimyag/ansigo/pkg/agent"
+	"github.com/jimyag/ansigo/pkg/connection"
+)
+
+func TestHostArgs(t *testing.T) {
+	tests := []struct {
+		name string
+		vars map[string]interface{}
+		want map[string]interface{}
+	}{
+		{
+			name: "none",
+			vars: map[string]interface{}{"ansible_architecture": "x86_64"},
+			want: map[string]interface{}{},
+		},
+		{
+			name: "interpreter and agent",
+			vars: map[string]interface{}{
+				"ansible_python_interpreter": "/usr/bin/python3.11",
+				"ansigo_agent":               "yes",
+				"ansible_architecture":       "aarch64",
+			},
+			want: map[string]interface{}{
+				PythonInterpreterArg: "/usr/bin/python3.11",
+				AgentArg:             "aarch64",
+			},
+		},
+		{
+			name: "agent without facts",
+			vars: map[string]interface{}{"ansigo_agent": true},
+			want: map[string]interface{}{AgentArg: ""},
+		},
+		{
+			name: "agent disabled",
+			vars: map[string]interface{}{"ansigo_agent": "false", "ansible_architecture": "x86_64"},
+			want: map[string]interface{}{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := HostArgs(tt.vars); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("HostArgs() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAgentAttributes(t *testing.T) {
+	attrs, ok := agentAttributes(map[string]interface{}{"mode": "0640", "owner": "app", "group": "0"})
+	if !ok || attrs != (agent.Attributes{Mode: 0o640, Owner: "app", Group: "0"}) {
+		t.Errorf("agentAttributes() = %+v, %v", attrs, ok)
+	}
+	attrs, ok = agentAttributes(map[string]interface{}{"mode": 0o755})
+	if !ok || attrs.Mode != 0o755 {
+		t.Errorf("agentAttributes() with int mode = %+v, %v", attrs, ok)
+	}
+	// 符号权限由 shell 命令处理
+	if _, ok := agentAttributes(map[string]interface{}{"mode": "u+x"}); ok {
+		t.Error("agentAttributes() with symbolic mode ok = true, want false")
+	}
+}
+
+func TestLocalModulesWithAgent(t *testing.T) {
+	if testing.Short() {
+		t.Skip("building the agent is slow")
+	}
+	if runtime.GOOS != "linux" {
+		t.Skip("agent only runs on Linux")
+	}
+	goBin, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go toolchain not found")
+	}
+
+	// 预先编译 agent，Binary 通过 ANSIGO_AGENT_DIR 找到它
+	agentDir := t.TempDir()
+	build := exec.Command(goBin, "build", "-o", filepath.Join(agentDir, agent.BinaryName(runtime.GOARCH)), agent.Package)
+	build.Env = append(os.Environ(), "CGO_ENABLED=0")
+	if out, err := build.CombinedOutput(); err != nil {
+		t.Fatalf("go build agent: %v\n%s", err, out)
+	}
+	t.Setenv("ANSIGO_AGENT_DIR", agentDir)
+	// agent 上传到 ~/.ansible/tmp
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+
+	dir := t.TempDir()
+
+	t.Run("template", func(t *testing.T) {
+		dest := filepath.Join(dir, "app.conf")
+		first, second := runModule(t, "template", map[string]interface{}{
+			"_rendered_content": "port = 8080\n",
+			"dest":              dest,
+			"mode":              "0640",
+			AgentArg:            "",
+		})
+		if !first.Changed || second.Changed {
+			t.Errorf("changed = %v, %v, want true, false", first.Changed, second.Changed)
+		}
+		assertLocalFile(t, dest, "port = 8080\n", 0o640)
+	})
+
+	t.Run("lineinfile", func(t *testing.T) {
+		path := filepath.Join(dir, "hosts")
+		first, second := runModule(t, "lineinfile", map[string]interface{}{
+			"path":   path,
+			"regexp": "^10\\.0\\.0\\.5 ",
+			"line":   "10.0.0.5 lb1",
+			"create": true,
+			AgentArg: "",
+		})
+		if !first.Changed || second.Changed {
+			t.Errorf("changed = %v, %v, want true, false", first.Changed, second.Changed)
+		}
+		assertLocalFile(t, path, "10.0.0.5 lb1\n", 0o644)
+	})
+
+	t.Run("file", func(t *testing.T) {
+		base := filepath.Join(dir, "srv")
+		sub := filepath.Join(base, "app", "data")
+		steps := []struct {
+			args map[string]interface{}
+			want bool // 第一次执行是否修改
+		}{
+			{map[string]interface{}{"path": sub, "state": "directory", "mode": "0750"}, true},
+			{map[string]interface{}{"path": filepath.Join(sub, "ready"), "state": "touch", "mode": "0600"}, true},
+			{map[string]interface{}{"path": base, "state": "directory", "recurse": true, "mode": "0700"}, true},
+			{map[string]interface{}{"path": filepath.Join(base, "current"), "state": "link", "src": sub}, true},
+			{map[string]interface{}{"path": filepath.Join(sub, "ready"), "state": "file", "mode": "0700"}, false},
+		}
+		for _, step := range steps {
+			step.args[AgentArg] = ""
+			first, second := runModule(t, "file", step.args)
+			if first.Changed != step.want || second.Changed {
+				t.Errorf("file %v changed = %v, %v, want %v, false", step.args, first.Changed, second.Changed, step.want)
+			}
+		}
+		for _, path := range []string{base, sub, filepath.Join(sub, "ready")} {
+			if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0o700 {
+				t.Errorf("%s mode = %v, %v, want 0700", path, info.Mode().Perm(), err)
+			}
+		}
+		if target, err := os.Readlink(filepath.Join(base, "current")); err != nil || target != sub {
+			t.Errorf("link target = %q, %v, want %s", target, err, sub)
+		}
+
+		first, second := runModule(t, "file", map[string]interface{}{"path": base, "state": "absent", AgentArg: ""})
+		if !first.Changed || second.Changed {
+			t.Errorf("absent changed = %v, %v, want true, false", first.Changed, second.Changed)
+		}
+		if _, err := os.Lstat(base); !os.IsNotExist(err) {
+			t.Errorf("%s still exists: %v", base, err)
+		}
+	})
+
+	t.Run("unprivileged become", func(t *testing.T) {
+		if os.Geteuid() != 0 {
+			t.Skip("becoming another user requires root")
+		}
+		if _, err := exec.LookPath("runuser"); err != nil {
+			t.Skip("runuser not found")
+		}
+		// nobody 可以写入的目录
+		shared, err := os.MkdirTemp("", "ansigo-become-")
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { os.RemoveAll(shared) })
+		if err := os.Chmod(shared, 0o777); err != nil {
+			t.Fatal(err)
+		}
+		before, _ := filepath.Glob(filepath.Join(systemTmpDir, "ansigo-*"))
+
+		// file 模块的 shell 命令不使用 become，文件属于 nobody 说明是 agent 以 become 用户创建的
+		conn := runuserConn{localConn().(*connection.LocalConnection)}
+		path := filepath.Join(shared, "ready")
+		result, err := NewExecutor().Execute(conn, "file", map[string]interface{}{"path": path, "state": "touch", AgentArg: ""}, true, "nobody", "sudo")
+		if err != nil || result.Failed || !result.Changed {
+			t.Fatalf("Execute() = %+v, %v", result, err)
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if owner, err := user.LookupId(strconv.Itoa(int(info.Sys().(*syscall.Stat_t).Uid))); err != nil || owner.Username != "nobody" {
+			t.Errorf("%s owner = %v, %v, want nobody", path, owner, err)
+		}
+		// 为 become 用户上传的 agent 在任务结束后删除
+		if after, _ := filepath.Glob(filepath.Join(systemTmpDir, "ansigo-*")); len(after) != len(before) {
+			t.Errorf("agent dirs left in %s: %v", systemTmpDir, after)
+		}
+	})
+
+	// 模块通过 agent 而不是 shell 命令修改文件：agent 只上传了一次
+	agents, err := filepath.Glob(filepath.Join(home, ".ansible", "tmp", "ansigo-agent-*"))
+	if err != nil || len(agents) != 1 {
+		t.Errorf("uploaded agents = %v, %v, want 1", agents, err)
+	}
+}
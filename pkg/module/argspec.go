@@ -0,0 +1,304 @@
+package module
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// RequiredIf 参数 Key 的值为 Value 时，Requires 中的参数必须指定（AnyOf 为 true 时只需指定其中一个）
+type RequiredIf struct {
+	Key      string
+	Value    interface{}
+	Requires []string
+	AnyOf    bool
+}
+
+// ArgError 模块参数错误，Param 是出错的参数名
+type ArgError struct {
+	Module string
+	Param  string
+	Msg    string
+}
+
+func (e *ArgError) Error() string {
+	return e.Msg
+}
+
+// newArgError 创建参数错误
+func newArgError(module, param, format string, args ...interface{}) *ArgError {
+	return &ArgError{Module: module, Param: param, Msg: fmt.Sprintf(format, args...)}
+}
+
+// ValidateArgs 按模块的参数定义检查参数，返回转换后的参数：别名替换为参数名，加入默认值，
+// 值按参数类型转换（如 "yes" 转换为 true，逗号分隔的字符串转换为列表）
+// 还没有渲染的模板（加载 playbook 时检查）不检查类型和取值；runner 传入的 _ansible_ 内部参数不检查
+// 模块没有参数定义时返回参数的副本
+func (s *Spec) ValidateArgs(args map[string]interface{}) (map[string]interface{}, error) {
+	result := make(map[string]interface{}, len(args))
+	if s.Args == nil {
+		for k, v := range args {
+			result[k] = v
+		}
+		return result, nil
+	}
+
+	// 别名 -> 参数名
+	names := make(map[string]string)
+	for name, arg := range s.Args {
+		names[name] = name
+		for _, alias := range arg.Aliases {
+			names[alias] = name
+		}
+	}
+
+	keys := make([]string, 0, len(args))
+	for k := range args {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	given := make(map[string]string) // 参数名 -> 实际使用的名称（可能是别名）
+	for _, key := range keys {
+		if strings.HasPrefix(key, "_ansible_") {
+			result[key] = args[key]
+			continue
+		}
+		name, ok := names[key]
+		if !ok && key == "_raw_params" {
+			return nil, newArgError(s.Name, key, "module %s does not support free-form arguments: %v", s.Name, args[key])
+		}
+		if !ok {
+			return nil, newArgError(s.Name, key, "unsupported parameter '%s' for module %s (supported: %s)", key, s.Name, strings.Join(s.supportedArgs(), ", "))
+		}
+		if prev, dup := given[name]; dup {
+			return nil, newArgError(s.Name, key, "parameter '%s' is specified more than once (as '%s' and '%s')", name, prev, key)
+		}
+		given[name] = key
+		result[name] = args[key]
+	}
+
+	for _, group := range s.MutuallyExclusive {
+		var present []string
+		for _, name := range group {
+			if result[name] != nil {
+				present = append(present, name)
+			}
+		}
+		if len(present) > 1 {
+			return nil, newArgError(s.Name, present[1], "parameters are mutually exclusive: %s", strings.Join(present, "|"))
+		}
+	}
+
+	for _, name := range sortedArgNames(s.Args) {
+		arg := s.Args[name]
+		if result[name] == nil && arg.Default != nil {
+			result[name] = arg.Default
+		}
+		value := result[name]
+		if value == nil {
+			if arg.Required {
+				return nil, newArgError(s.Name, name, "missing required parameter '%s' for module %s", name, s.Name)
+			}
+			continue
+		}
+		if unresolved(value) {
+			continue
+		}
+
+		converted, err := convertArg(arg.Type, value)
+		if err != nil {
+			return nil, newArgError(s.Name, given[name], "parameter '%s' %v", given[name], err)
+		}
+		if len(arg.Choices) > 0 && !inChoices(converted, arg.Choices) {
+			return nil, newArgError(s.Name, given[name], "value of parameter '%s' must be one of: %s, got: %v", given[name], joinValues(arg.Choices), converted)
+		}
+		result[name] = converted
+	}
+
+	for _, group := range s.RequiredOneOf {
+		if !anyPresent(result, group) {
+			return nil, newArgError(s.Name, group[0], "one of the following parameters is required: %s", strings.Join(group, ", "))
+		}
+	}
+
+	for _, cond := range s.RequiredIf {
+		value := result[cond.Key]
+		if value == nil || unresolved(value) || fmt.Sprint(value) != fmt.Sprint(cond.Value) {
+			continue
+		}
+		var missing []string
+		for _, name := range cond.Requires {
+			if result[name] == nil {
+				missing = append(missing, name)
+			}
+		}
+		if len(missing) == 0 || (cond.AnyOf && len(missing) < len(cond.Requires)) {
+			continue
+		}
+		verb := "all"
+		if cond.AnyOf {
+			verb = "any"
+		}
+		return nil, newArgError(s.Name, missing[0], "%s is %v but %s of the following are missing: %s", cond.Key, cond.Value, verb, strings.Join(missing, ", "))
+	}
+
+	return result, nil
+}
+
+// supportedArgs 返回模块支持的参数名（不含以 _ 开头的内部参数），按名称排序
+func (s *Spec) supportedArgs() []string {
+	var names []string
+	for _, name := range sortedArgNames(s.Args) {
+		if !strings.HasPrefix(name, "_") {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// sortedArgNames 返回参数名，按名称排序
+func sortedArgNames(args map[string]ArgSpec) []string {
+	names := make([]string, 0, len(args))
+	for name := range args {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// unresolved 判断值是否是还没有渲染的模板
+func unresolved(value interface{}) bool {
+	s, ok := value.(string)
+	return ok && (strings.Contains(s, "{{") || strings.Contains(s, "{%"))
+}
+
+// anyPresent 判断 names 中是否至少有一个参数被指定
+func anyPresent(args map[string]interface{}, names []string) bool {
+	for _, name := range names {
+		if args[name] != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// inChoices 判断值是否是允许的值之一（按字符串形式比较）
+func inChoices(value interface{}, choices []interface{}) bool {
+	for _, choice := range choices {
+		if fmt.Sprint(choice) == fmt.Sprint(value) {
+			return true
+		}
+	}
+	return false
+}
+
+// joinValues 用逗号连接值
+func joinValues(values []interface{}) string {
+	parts := make([]string, len(values))
+	for i, v := range values {
+		parts[i] = fmt.Sprint(v)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// convertArg 按参数类型转换值，规则与 Ansible 相同
+func convertArg(typ string, value interface{}) (interface{}, error) {
+	switch typ {
+	case "", "raw":
+		return value, nil
+	case "str", "path":
+		switch v := value.(type) {
+		case string:
+			return v, nil
+		case int, int64, float64, bool:
+			return fmt.Sprint(v), nil
+		}
+	case "int":
+		switch v := value.(type) {
+		case int:
+			return v, nil
+		case int64:
+			return int(v), nil
+		case float64:
+			if v == float64(int(v)) {
+				return int(v), nil
+			}
+		case string:
+			if n, err := strconv.Atoi(strings.TrimSpace(v)); err == nil {
+				return n, nil
+			}
+		}
+	case "float":
+		switch v := value.(type) {
+		case float64:
+			return v, nil
+		case int:
+			return float64(v), nil
+		case int64:
+			return float64(v), nil
+		case string:
+			if f, err := strconv.ParseFloat(strings.TrimSpace(v), 64); err == nil {
+				return f, nil
+			}
+		}
+	case "bool":
+		if b, ok := convertBool(value); ok {
+			return b, nil
+		}
+	case "list":
+		switch v := value.(type) {
+		case []interface{}:
+			return v, nil
+		case string:
+			// 逗号分隔的字符串转换为列表
+			var items []interface{}
+			for _, item := range strings.Split(v, ",") {
+				if item = strings.TrimSpace(item); item != "" {
+					items = append(items, item)
+				}
+			}
+			return items, nil
+		case int, int64, float64, bool:
+			return []interface{}{v}, nil
+		}
+	case "dict":
+		if v, ok := value.(map[string]interface{}); ok {
+			return v, nil
+		}
+	case "mode":
+		// 文件权限：YAML 中的 0644 已经被解析为整数 420，转换为八进制字符串；符号权限（如 u+rwx）保持不变
+		switch v := value.(type) {
+		case string:
+			return v, nil
+		case int:
+			return fmt.Sprintf("%04o", v), nil
+		case int64:
+			return fmt.Sprintf("%04o", v), nil
+		}
+	default:
+		return nil, fmt.Errorf("has unknown type %s", typ)
+	}
+	return nil, fmt.Errorf("is of type %T and we were unable to convert to %s: %v", value, typ, value)
+}
+
+// convertBool 把 YAML 和模板渲染结果中常见的布尔值写法转换为 bool
+func convertBool(value interface{}) (bool, bool) {
+	switch v := value.(type) {
+	case bool:
+		return v, true
+	case int:
+		if v == 0 || v == 1 {
+			return v == 1, true
+		}
+	case string:
+		switch strings.ToLower(strings.TrimSpace(v)) {
+		case "yes", "on", "true", "1", "y", "t":
+			return true, true
+		case "no", "off", "false", "0", "n", "f":
+			return false, true
+		}
+	}
+	return false, false
+}
@@ -0,0 +1,125 @@
+package module
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSpecValidateArgs(t *testing.T) {
+	spec := &Spec{
+		Name: "example",
+		Args: map[string]ArgSpec{
+			"path":    {Type: "path", Required: true, Aliases: []string{"dest"}},
+			"state":   {Type: "str", Default: "present", Choices: []interface{}{"present", "absent"}},
+			"line":    {Type: "str"},
+			"mode":    {Type: "mode"},
+			"create":  {Type: "bool"},
+			"count":   {Type: "int"},
+			"names":   {Type: "list"},
+			"before":  {Type: "str"},
+			"after":   {Type: "str"},
+			"options": {Type: "dict"},
+		},
+		MutuallyExclusive: [][]string{{"before", "after"}},
+		RequiredIf:        []RequiredIf{{Key: "state", Value: "present", Requires: []string{"line"}}},
+	}
+
+	tests := []struct {
+		name      string
+		args      map[string]interface{}
+		want      map[string]interface{}
+		wantParam string
+	}{
+		{
+			name: "aliases, defaults and coercion",
+			args: map[string]interface{}{
+				"dest":                "/etc/app.conf",
+				"line":                80,
+				"mode":                0644,
+				"create":              "yes",
+				"count":               "3",
+				"names":               "a, b,c",
+				"_ansible_check_mode": true,
+			},
+			want: map[string]interface{}{
+				"path":                "/etc/app.conf",
+				"state":               "present",
+				"line":                "80",
+				"mode":                "0644",
+				"create":              true,
+				"count":               3,
+				"names":               []interface{}{"a", "b", "c"},
+				"_ansible_check_mode": true,
+			},
+		},
+		{
+			name: "symbolic mode and templates are kept",
+			args: map[string]interface{}{"path": "/tmp/x", "state": "{{ wanted }}", "mode": "u+rwx", "count": "{{ n }}"},
+			want: map[string]interface{}{"path": "/tmp/x", "state": "{{ wanted }}", "mode": "u+rwx", "count": "{{ n }}"},
+		},
+		{name: "unknown parameter", args: map[string]interface{}{"path": "/tmp/x", "line": "a", "mdoe": "0644"}, wantParam: "mdoe"},
+		{name: "missing required", args: map[string]interface{}{"line": "a"}, wantParam: "path"},
+		{name: "free-form args", args: map[string]interface{}{"_raw_params": "path=/tmp/x"}, wantParam: "_raw_params"},
+		{name: "alias and name", args: map[string]interface{}{"path": "/a", "dest": "/b", "line": "a"}, wantParam: "path"},
+		{name: "bad choice", args: map[string]interface{}{"path": "/tmp/x", "state": "latest"}, wantParam: "state"},
+		{name: "bad bool", args: map[string]interface{}{"path": "/tmp/x", "line": "a", "create": "maybe"}, wantParam: "create"},
+		{name: "bad int", args: map[string]interface{}{"path": "/tmp/x", "line": "a", "count": 1.5}, wantParam: "count"},
+		{name: "bad dict", args: map[string]interface{}{"path": "/tmp/x", "line": "a", "options": "a=b"}, wantParam: "options"},
+		{name: "mutually exclusive", args: map[string]interface{}{"path": "/tmp/x", "line": "a", "before": "x", "after": "y"}, wantParam: "after"},
+		{name: "required if", args: map[string]interface{}{"path": "/tmp/x"}, wantParam: "line"},
+		{name: "required if not triggered", args: map[string]interface{}{"path": "/tmp/x", "state": "absent"}, want: map[string]interface{}{"path": "/tmp/x", "state": "absent"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := spec.ValidateArgs(tt.args)
+			if tt.wantParam != "" {
+				argErr, ok := err.(*ArgError)
+				if !ok {
+					t.Fatalf("ValidateArgs() error = %v, want *ArgError", err)
+				}
+				if argErr.Param != tt.wantParam {
+					t.Errorf("ValidateArgs() param = %q, want %q (%v)", argErr.Param, tt.wantParam, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ValidateArgs() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ValidateArgs() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuiltinArgSpecs(t *testing.T) {
+	tests := []struct {
+		module  string
+		args    map[string]interface{}
+		wantErr bool
+	}{
+		{module: "command", args: map[string]interface{}{"_raw_params": "uptime", "chdir": "/tmp"}},
+		{module: "command", args: map[string]interface{}{"chdir": "/tmp"}, wantErr: true},
+		{module: "shell", args: map[string]interface{}{"cmd": "uptime", "argv": []interface{}{"uptime"}}, wantErr: true},
+		{module: "copy", args: map[string]interface{}{"dest": "/tmp/x", "content": "hi", "src": "a"}, wantErr: true},
+		{module: "file", args: map[string]interface{}{"name": "/tmp/x", "state": "link"}, wantErr: true},
+		{module: "lineinfile", args: map[string]interface{}{"path": "/tmp/x", "regexp": "^a", "state": "absent"}},
+		{module: "systemd", args: map[string]interface{}{"name": "nginx"}, wantErr: true},
+		{module: "set_fact", args: map[string]interface{}{"anything": 1}},
+		{module: "package", args: map[string]interface{}{"name": "nginx", "state": "latest", "use": "apt"}},
+		{module: "apt", args: map[string]interface{}{"update_cache": "yes", "cache_valid_time": "3600"}},
+		{module: "ansible.builtin.dnf", args: map[string]interface{}{"state": "present"}, wantErr: true},
+		{module: "apk", args: map[string]interface{}{"name": "curl", "state": "purged"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		spec, ok := Lookup(tt.module)
+		if !ok {
+			t.Fatalf("module %s not registered", tt.module)
+		}
+		if _, err := spec.ValidateArgs(tt.args); (err != nil) != tt.wantErr {
+			t.Errorf("%s ValidateArgs(%v) error = %v, wantErr %v", tt.module, tt.args, err, tt.wantErr)
+		}
+	}
+}
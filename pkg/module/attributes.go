@@ -0,0 +1,125 @@
+package module
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/jimyag/ansigo/pkg/connection"
+)
+
+// fileAttributes 远程文件的权限、所有者和组
+type fileAttributes struct {
+	Mode  string // 八进制权限，如 644、4755
+	Owner string
+	Group string
+	UID   string
+	GID   string
+}
+
+// statAttributes 读取远程文件的权限、所有者和组，文件不存在或无法读取时返回 nil
+func statAttributes(conn connection.Connection, path string) *fileAttributes {
+	result, err := executeCommand(conn, fmt.Sprintf("stat -c '%%a %%U %%G %%u %%g' %s", path))
+	if err != nil || result.RC != 0 {
+		return nil
+	}
+
+	fields := strings.Fields(result.Stdout)
+	if len(fields) != 5 {
+		return nil
+	}
+	return &fileAttributes{Mode: fields[0], Owner: fields[1], Group: fields[2], UID: fields[3], GID: fields[4]}
+}
+
+// attributesWouldChange 判断按 mode/owner/group 参数设置后文件属性是否会变化（check 模式使用）
+// 文件不存在时只要指定了属性就视为会变化；符号权限（如 u+x）无法预先判断，同样视为会变化
+func attributesWouldChange(current *fileAttributes, args map[string]interface{}) bool {
+	modeArg, hasMode := args["mode"]
+	owner, _ := args["owner"].(string)
+	group, _ := args["group"].(string)
+
+	if current == nil {
+		return hasMode || owner != "" || group != ""
+	}
+
+	if hasMode {
+		mode, numeric := numericMode(modeArg)
+		currentMode, err := strconv.ParseUint(current.Mode, 8, 32)
+		if !numeric || err != nil || uint32(mode) != uint32(currentMode) {
+			return true
+		}
+	}
+	if owner != "" && owner != current.Owner && owner != current.UID {
+		return true
+	}
+	if group != "" && group != current.Group && group != current.GID {
+		return true
+	}
+	return false
+}
+
+// applyAttributes 按 mode/owner/group 参数设置文件属性，返回属性是否发生变化
+// check 模式下只判断是否会变化，不修改文件
+func applyAttributes(conn connection.Connection, path string, args map[string]interface{}) (bool, error) {
+	before := statAttributes(conn, path)
+	if checkMode(args) {
+		return attributesWouldChange(before, args), nil
+	}
+
+	applied := false
+
+	// 应用 mode（权限）
+	if modeInterface, ok := args["mode"]; ok {
+		modeStr := ""
+		switch v := modeInterface.(type) {
+		case string:
+			modeStr = v
+		case int:
+			modeStr = fmt.Sprintf("%o", v)
+		case int64:
+			modeStr = fmt.Sprintf("%o", v)
+		case float64:
+			modeStr = fmt.Sprintf("%o", int(v))
+		}
+
+		if modeStr != "" {
+			chmodCmd := fmt.Sprintf("chmod %s %s", modeStr, path)
+			chmodResult, err := executeCommand(conn, chmodCmd)
+			if err != nil || chmodResult.RC != 0 {
+				return false, fmt.Errorf("failed to chmod: %s", chmodResult.Stderr)
+			}
+			applied = true
+		}
+	}
+
+	// 应用 owner
+	if owner, ok := args["owner"].(string); ok && owner != "" {
+		chownCmd := fmt.Sprintf("chown %s %s", owner, path)
+		chownResult, err := executeCommand(conn, chownCmd)
+		if err != nil || chownResult.RC != 0 {
+			return false, fmt.Errorf("failed to chown: %s", chownResult.Stderr)
+		}
+		applied = true
+	}
+
+	// 应用 group
+	if group, ok := args["group"].(string); ok && group != "" {
+		chgrpCmd := fmt.Sprintf("chgrp %s %s", group, path)
+		chgrpResult, err := executeCommand(conn, chgrpCmd)
+		if err != nil || chgrpResult.RC != 0 {
+			return false, fmt.Errorf("failed to chgrp: %s", chgrpResult.Stderr)
+		}
+		applied = true
+	}
+
+	if !applied {
+		return false, nil
+	}
+
+	// 比较设置前后的属性，无法读取时按已变化处理
+	after := statAttributes(conn, path)
+	if before == nil || after == nil {
+		return true, nil
+	}
+	return *before != *after, nil
+}
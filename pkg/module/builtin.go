@@ -0,0 +1,229 @@
+package module
+
+import (
+	"github.com/jimyag/ansigo/pkg/connection"
+)
+
+// builtinPrefix 内置模块 FQCN 的前缀
+const builtinPrefix = "ansible.builtin."
+
+// newBuiltinRegistry 创建包含所有内置模块的注册表，每个模块都可以用 ansible.builtin.<name> 引用
+func newBuiltinRegistry() *Registry {
+	e := &Executor{}
+	builtins := []Spec{
+		{
+			Name: "ping",
+			Module: ModuleFunc(func(conn connection.Connection, _ map[string]interface{}, _ bool, _, _ string) (*Result, error) {
+				return e.executePing(conn)
+			}),
+			Args: map[string]ArgSpec{"data": {Type: "str"}},
+		},
+		{
+			Name:   "raw",
+			Module: ModuleFunc(e.executeRaw),
+			Args: map[string]ArgSpec{
+				"_raw_params": {Type: "str"},
+				"cmd":         {Type: "str"},
+			},
+			MutuallyExclusive: [][]string{{"_raw_params", "cmd"}},
+			RequiredOneOf:     [][]string{{"_raw_params", "cmd"}},
+		},
+		{
+			Name:   "command",
+			Module: ModuleFunc(e.executeCommand),
+			Args: withArgs(commandArgs, map[string]ArgSpec{
+				"argv": {Type: "list"},
+			}),
+			MutuallyExclusive: [][]string{{"_raw_params", "cmd", "argv"}},
+			RequiredOneOf:     [][]string{{"_raw_params", "cmd", "argv"}},
+		},
+		{
+			Name:   "shell",
+			Module: ModuleFunc(e.executeShell),
+			Args: withArgs(commandArgs, map[string]ArgSpec{
+				"executable": {Type: "path"},
+			}),
+			MutuallyExclusive: [][]string{{"_raw_params", "cmd"}},
+			RequiredOneOf:     [][]string{{"_raw_params", "cmd"}},
+		},
+		{
+			Name:   "copy",
+			Module: ModuleFunc(e.executeCopy),
+			Args: map[string]ArgSpec{
+				"src":     {Type: "path"},
+				"content": {Type: "str"},
+				"dest":    {Type: "path", Required: true},
+				"mode":    {Type: "mode"},
+			},
+			MutuallyExclusive: [][]string{{"src", "content"}},
+			RequiredOneOf:     [][]string{{"src", "content"}},
+		},
+		{
+			Name:   "debug",
+			Module: argsOnly(e.executeDebug),
+			Args: map[string]ArgSpec{
+				"_raw_params": {Type: "str"},
+				"msg":         {Type: "raw"},
+				"var":         {Type: "str"},
+			},
+			MutuallyExclusive: [][]string{{"_raw_params", "msg", "var"}},
+		},
+		// set_fact 的所有参数都是要设置的变量
+		{Name: "set_fact", Module: argsOnly(e.executeSetFact)},
+		{
+			Name:   "include_vars",
+			Module: argsOnly(e.executeIncludeVars),
+			Args: map[string]ArgSpec{
+				"_raw_params": {Type: "path"},
+				"file":        {Type: "path"},
+				"name":        {Type: "str"},
+			},
+			MutuallyExclusive: [][]string{{"_raw_params", "file"}},
+			RequiredOneOf:     [][]string{{"_raw_params", "file"}},
+		},
+		{
+			Name:   "file",
+			Module: &FileModule{},
+			Args: withArgs(fileAttributeArgs, map[string]ArgSpec{
+				"path":    {Type: "path", Required: true, Aliases: []string{"dest", "name"}},
+				"state":   {Type: "str", Choices: []interface{}{"file", "directory", "absent", "touch", "link"}},
+				"src":     {Type: "path"},
+				"recurse": {Type: "bool"},
+			}),
+			RequiredIf: []RequiredIf{{Key: "state", Value: "link", Requires: []string{"src"}}},
+		},
+		{
+			Name:   "template",
+			Module: &TemplateModule{},
+			Args: withArgs(fileAttributeArgs, map[string]ArgSpec{
+				"src":      {Type: "path", Required: true},
+				"dest":     {Type: "path", Required: true},
+				"backup":   {Type: "bool"},
+				"validate": {Type: "str"},
+			}),
+		},
+		{
+			Name:   "lineinfile",
+			Module: &LineinfileModule{},
+			Args: map[string]ArgSpec{
+				"path":         {Type: "path", Required: true, Aliases: []string{"dest", "destfile", "name"}},
+				"state":        {Type: "str", Default: "present", Choices: []interface{}{"present", "absent"}},
+				"line":         {Type: "str", Aliases: []string{"value"}},
+				"regexp":       {Type: "str", Aliases: []string{"regex"}},
+				"insertafter":  {Type: "str"},
+				"insertbefore": {Type: "str"},
+				"create":       {Type: "bool"},
+			},
+			MutuallyExclusive: [][]string{{"insertafter", "insertbefore"}},
+			RequiredIf:        []RequiredIf{{Key: "state", Value: "present", Requires: []string{"line"}}},
+		},
+		{
+			Name:   "service",
+			Module: &ServiceModule{},
+			Args:   serviceArgs,
+		},
+		{
+			Name:   "systemd",
+			Module: &SystemdModule{},
+			Args: withArgs(serviceArgs, map[string]ArgSpec{
+				"name":          {Type: "str", Required: true, Aliases: []string{"service", "unit"}},
+				"daemon_reload": {Type: "bool", Aliases: []string{"daemon-reload"}},
+			}),
+			RequiredOneOf: [][]string{{"state", "enabled", "daemon_reload"}},
+		},
+		{
+			Name:   "get_url",
+			Module: &GetUrlModule{},
+			Args: withArgs(fileAttributeArgs, map[string]ArgSpec{
+				"url":      {Type: "str", Required: true},
+				"dest":     {Type: "path", Required: true},
+				"force":    {Type: "bool"},
+				"checksum": {Type: "str"},
+			}),
+		},
+		{
+			Name:   "fail",
+			Module: &FailModule{},
+			Args:   map[string]ArgSpec{"msg": {Type: "str"}},
+		},
+		{
+			Name:   "package",
+			Module: &PackageModule{},
+			Args: withArgs(packageArgs, map[string]ArgSpec{
+				"use": {Type: "str", Default: "auto", Choices: []interface{}{"auto", "apt", "dnf", "yum", "apk", "zypper"}},
+			}),
+			RequiredOneOf: packageRequiredOneOf,
+		},
+	}
+	// 每个包管理器也可以作为模块直接使用
+	for _, pkgMgr := range []string{"apt", "dnf", "yum", "apk", "zypper"} {
+		builtins = append(builtins, Spec{
+			Name:          pkgMgr,
+			Module:        &PackageModule{Manager: pkgMgr},
+			Args:          packageArgs,
+			RequiredOneOf: packageRequiredOneOf,
+		})
+	}
+
+	r := NewRegistry()
+	for _, spec := range builtins {
+		spec.Aliases = append(spec.Aliases, builtinPrefix+spec.Name)
+		if err := r.Register(spec); err != nil {
+			panic(err)
+		}
+	}
+	return r
+}
+
+// commandArgs command 和 shell 模块共同的参数
+var commandArgs = map[string]ArgSpec{
+	"_raw_params": {Type: "str"},
+	"cmd":         {Type: "str"},
+	"chdir":       {Type: "path"},
+	"creates":     {Type: "path"},
+	"removes":     {Type: "path"},
+}
+
+// fileAttributeArgs 设置文件属性的模块共同的参数
+var fileAttributeArgs = map[string]ArgSpec{
+	"mode":  {Type: "mode"},
+	"owner": {Type: "str"},
+	"group": {Type: "str"},
+}
+
+// serviceArgs service 模块的参数（systemd 模块在此基础上增加参数）
+var serviceArgs = map[string]ArgSpec{
+	"name":    {Type: "str", Required: true},
+	"state":   {Type: "str", Choices: []interface{}{"started", "stopped", "restarted", "reloaded"}},
+	"enabled": {Type: "bool"},
+}
+
+// packageArgs package 和各个包管理器模块的参数
+var packageArgs = map[string]ArgSpec{
+	"name":             {Type: "list", Aliases: []string{"pkg", "package"}},
+	"state":            {Type: "str", Default: "present", Choices: []interface{}{"present", "installed", "latest", "absent", "removed"}},
+	"update_cache":     {Type: "bool", Aliases: []string{"update-cache"}},
+	"cache_valid_time": {Type: "int"},
+}
+
+// packageRequiredOneOf 包管理模块至少需要安装的包或者更新缓存
+var packageRequiredOneOf = [][]string{{"name", "update_cache", "cache_valid_time"}}
+
+// withArgs 返回 base 加上 extra 的参数定义，extra 中的定义优先
+func withArgs(base, extra map[string]ArgSpec) map[string]ArgSpec {
+	args := make(map[string]ArgSpec, len(base)+len(extra))
+	for name, arg := range base {
+		args[name] = arg
+	}
+	for name, arg := range extra {
+		args[name] = arg
+	}
+	return args
+}
+
+// argsOnly 把只使用参数、不访问目标主机的模块（如 debug、set_fact）转换为 Module
+func argsOnly(f func(args map[string]interface{}) (*Result, error)) Module {
+	return ModuleFunc(func(_ connection.Connection, args map[string]interface{}, _ bool, _, _ string) (*Result, error) {
+		return f(args)
+	})
+}
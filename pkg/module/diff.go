@@ -0,0 +1,36 @@
+package module
+
+import (
+	"strings"
+
+	"github.com/jimyag/ansigo/pkg/connection"
+)
+
+// maxDiffSize 超过这个大小的文件不生成 diff（与 Ansible 的 max_diff_size 默认值相同）
+const maxDiffSize = 104448
+
+// newDiff 返回 path 修改前后的内容
+// 内容超过 maxDiffSize 或者是二进制内容时返回 nil，不输出 diff
+func newDiff(path, before, after string) *Diff {
+	if len(before) > maxDiffSize || len(after) > maxDiffSize {
+		return nil
+	}
+	if strings.ContainsRune(before, 0) || strings.ContainsRune(after, 0) {
+		return nil
+	}
+	return &Diff{
+		Before:       before,
+		After:        after,
+		BeforeHeader: path,
+		AfterHeader:  path,
+	}
+}
+
+// readRemoteFile 读取远程文件的原始内容（不去除首尾空白），文件不存在或无法读取时返回空字符串
+func readRemoteFile(conn connection.Connection, path string) string {
+	stdout, _, exitCode, err := conn.Exec("cat " + path)
+	if err != nil || exitCode != 0 {
+		return ""
+	}
+	return string(stdout)
+}
@@ -1,66 +1,44 @@
 package module
 
 import (
+	"crypto/sha1"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"io"
+	"os"
 	"strings"
 
 	"github.com/jimyag/ansigo/pkg/connection"
+	"gopkg.in/yaml.v3"
 )
 
-// Executor 模块执行器
-type Executor struct{}
+// Executor 模块执行器，按模块名在注册表中查找模块并执行
+type Executor struct {
+	registry *Registry
+}
 
-// NewExecutor 创建一个新的模块执行器
+// NewExecutor 创建一个使用默认注册表的模块执行器
 func NewExecutor() *Executor {
-	return &Executor{}
+	return &Executor{registry: DefaultRegistry}
+}
+
+// NewExecutorWithRegistry 创建一个使用指定注册表的模块执行器
+func NewExecutorWithRegistry(registry *Registry) *Executor {
+	return &Executor{registry: registry}
 }
 
-// Execute 执行模块
-func (e *Executor) Execute(conn *connection.Connection, moduleName string, args map[string]interface{}, become bool, becomeUser, becomeMethod string) (*Result, error) {
-	switch moduleName {
-	case "ping":
-		return e.executePing(conn)
-	case "raw":
-		return e.executeRaw(conn, args, become, becomeUser, becomeMethod)
-	case "command":
-		return e.executeCommand(conn, args, become, becomeUser, becomeMethod)
-	case "shell":
-		return e.executeShell(conn, args, become, becomeUser, becomeMethod)
-	case "copy":
-		return e.executeCopy(conn, args, become, becomeUser, becomeMethod)
-	case "debug":
-		return e.executeDebug(args)
-	case "set_fact":
-		return e.executeSetFact(args)
-	case "file":
-		fileModule := &FileModule{}
-		return fileModule.Execute(conn, args, become, becomeUser, becomeMethod)
-	case "template":
-		templateModule := &TemplateModule{}
-		return templateModule.Execute(conn, args, become, becomeUser, becomeMethod)
-	case "lineinfile":
-		lineinfileModule := &LineinfileModule{}
-		return lineinfileModule.Execute(conn, args, become, becomeUser, becomeMethod)
-	case "service":
-		serviceModule := &ServiceModule{}
-		return serviceModule.Execute(conn, args, become, becomeUser, becomeMethod)
-	case "systemd":
-		systemdModule := &SystemdModule{}
-		return systemdModule.Execute(conn, args, become, becomeUser, becomeMethod)
-	case "get_url":
-		getUrlModule := &GetUrlModule{}
-		return getUrlModule.Execute(conn, args, become, becomeUser, becomeMethod)
-	case "fail":
-		failModule := &FailModule{}
-		return failModule.Execute(conn, args, become, becomeUser, becomeMethod)
-	default:
+// Execute 执行模块，moduleName 可以是模块名或别名（如 ansible.builtin.copy）
+func (e *Executor) Execute(conn connection.Connection, moduleName string, args map[string]interface{}, become bool, becomeUser, becomeMethod string) (*Result, error) {
+	spec, ok := e.registry.Lookup(moduleName)
+	if !ok {
 		return nil, fmt.Errorf("unsupported module: %s", moduleName)
 	}
+	return spec.Module.Execute(conn, args, become, becomeUser, becomeMethod)
 }
 
 // executePing 执行 ping 模块
-func (e *Executor) executePing(conn *connection.Connection) (*Result, error) {
+func (e *Executor) executePing(conn connection.Connection) (*Result, error) {
 	// Ansible 的 ping 模块只是测试连接性，不需要 Python
 	// 我们简单返回 pong
 	result := &Result{
@@ -72,7 +50,7 @@ func (e *Executor) executePing(conn *connection.Connection) (*Result, error) {
 }
 
 // executeRaw 执行 raw 模块
-func (e *Executor) executeRaw(conn *connection.Connection, args map[string]interface{}, become bool, becomeUser, becomeMethod string) (*Result, error) {
+func (e *Executor) executeRaw(conn connection.Connection, args map[string]interface{}, become bool, becomeUser, becomeMethod string) (*Result, error) {
 	// raw 模块直接执行命令
 	cmd, ok := args["_raw_params"].(string)
 	if !ok {
@@ -84,6 +62,11 @@ func (e *Executor) executeRaw(conn *connection.Connection, args map[string]inter
 		}
 	}
 
+	// raw 命令的效果无法预知，check 模式下不执行
+	if checkMode(args) {
+		return skippedInCheckMode("skipped, running in check mode"), nil
+	}
+
 	stdout, stderr, exitCode, err := e.execCommand(conn, cmd, become, becomeUser, becomeMethod)
 	if err != nil {
 		return &Result{
@@ -109,7 +92,7 @@ func (e *Executor) executeRaw(conn *connection.Connection, args map[string]inter
 }
 
 // executeCommand 执行 command 模块
-func (e *Executor) executeCommand(conn *connection.Connection, args map[string]interface{}, become bool, becomeUser, becomeMethod string) (*Result, error) {
+func (e *Executor) executeCommand(conn connection.Connection, args map[string]interface{}, become bool, becomeUser, becomeMethod string) (*Result, error) {
 	// command 模块执行命令，但不使用 shell 解析
 	// 获取命令参数
 	var cmd string
@@ -133,6 +116,11 @@ func (e *Executor) executeCommand(conn *connection.Connection, args map[string]i
 		}, nil
 	}
 
+	// creates/removes 条件不满足时不执行命令
+	if result, err := e.checkCreatesRemoves(conn, args, become, becomeUser, becomeMethod); result != nil || err != nil {
+		return result, err
+	}
+
 	// 获取工作目录
 	chdir, _ := args["chdir"].(string)
 	if chdir != "" {
@@ -165,7 +153,7 @@ func (e *Executor) executeCommand(conn *connection.Connection, args map[string]i
 }
 
 // executeShell 执行 shell 模块
-func (e *Executor) executeShell(conn *connection.Connection, args map[string]interface{}, become bool, becomeUser, becomeMethod string) (*Result, error) {
+func (e *Executor) executeShell(conn connection.Connection, args map[string]interface{}, become bool, becomeUser, becomeMethod string) (*Result, error) {
 	// shell 模块通过 shell 执行命令，支持管道、重定向等
 	var cmd string
 	if rawCmd, ok := args["_raw_params"].(string); ok {
@@ -179,6 +167,11 @@ func (e *Executor) executeShell(conn *connection.Connection, args map[string]int
 		}, nil
 	}
 
+	// creates/removes 条件不满足时不执行命令
+	if result, err := e.checkCreatesRemoves(conn, args, become, becomeUser, becomeMethod); result != nil || err != nil {
+		return result, err
+	}
+
 	// 获取工作目录
 	chdir, _ := args["chdir"].(string)
 
@@ -220,7 +213,7 @@ func (e *Executor) executeShell(conn *connection.Connection, args map[string]int
 }
 
 // executeCopy 执行 copy 模块
-func (e *Executor) executeCopy(conn *connection.Connection, args map[string]interface{}, become bool, becomeUser, becomeMethod string) (*Result, error) {
+func (e *Executor) executeCopy(conn connection.Connection, args map[string]interface{}, become bool, becomeUser, becomeMethod string) (*Result, error) {
 	// copy 模块用于文件传输
 	dest, ok := args["dest"].(string)
 	if !ok {
@@ -230,57 +223,63 @@ func (e *Executor) executeCopy(conn *connection.Connection, args map[string]inte
 		}, nil
 	}
 
+	// --diff 时在覆盖 dest 之前读取原内容
+	var diff *Diff
+	if diffMode(args) {
+		diff = e.copyDiff(conn, args, dest, become, becomeUser, becomeMethod)
+	}
+
+	// 与 check 模式使用同一比较：内容和 mode 都一致时不传输文件
+	check := e.checkCopy(conn, args, dest, become, becomeUser, becomeMethod)
+	check.Diff = diff
+	if check.Failed || !check.Changed || checkMode(args) {
+		return check, nil
+	}
+
+	// 八进制权限在创建文件时设置；符号权限（如 u+rw）在上传后用 chmod 设置
+	mode, numeric := numericMode(args["mode"])
+
 	// 检查是否有 content 参数
 	if content, hasContent := args["content"].(string); hasContent {
 		// 使用 content 参数直接写入
-		writeCmd := fmt.Sprintf("cat > %s << 'ANSIGO_EOF'\n%s\nANSIGO_EOF", dest, content)
-		_, stderr, exitCode, err := conn.Exec(writeCmd)
-		if err != nil {
+		if err := putFile(conn, strings.NewReader(content), dest, mode, become, becomeUser, becomeMethod); err != nil {
 			return &Result{
 				Failed: true,
 				Msg:    fmt.Sprintf("failed to write content: %s", err.Error()),
-				RC:     exitCode,
-				Stderr: string(stderr),
 			}, nil
 		}
-		if exitCode != 0 {
+	} else {
+		// 否则需要 src 参数
+		src, ok := args["src"].(string)
+		if !ok {
 			return &Result{
 				Failed: true,
-				Msg:    "failed to write content to destination",
-				RC:     exitCode,
-				Stderr: string(stderr),
+				Msg:    "copy module requires either 'src' or 'content' argument",
 			}, nil
 		}
 
-		return &Result{
-			Changed: true,
-			Dest:    dest,
-		}, nil
-	}
-
-	// 否则需要 src 参数
-	src, ok := args["src"].(string)
-	if !ok {
-		return &Result{
-			Failed: true,
-			Msg:    "copy module requires either 'src' or 'content' argument",
-		}, nil
-	}
+		// src 是控制节点上的路径，流式上传，不整体读入内存
+		f, err := os.Open(src)
+		if err != nil {
+			return &Result{
+				Failed: true,
+				Msg:    fmt.Sprintf("failed to copy file: %s", err.Error()),
+			}, nil
+		}
+		defer f.Close()
 
-	// 传输文件（这里需要本地文件系统访问）
-	// 注意：在实际实现中，我们需要从控制节点读取文件
-	// 简化版本：假设 src 是控制节点上的路径
-	if err := conn.PutFile(src, dest); err != nil {
-		return &Result{
-			Failed: true,
-			Msg:    fmt.Sprintf("failed to copy file: %s", err.Error()),
-		}, nil
+		if err := putFile(conn, f, dest, mode, become, becomeUser, becomeMethod); err != nil {
+			return &Result{
+				Failed: true,
+				Msg:    fmt.Sprintf("failed to copy file: %s", err.Error()),
+			}, nil
+		}
 	}
 
-	// 设置文件权限（如果指定）
-	if mode, ok := args["mode"].(string); ok {
-		chmodCmd := fmt.Sprintf("chmod %s %s", mode, dest)
-		_, _, exitCode, err := conn.Exec(chmodCmd)
+	// 设置符号权限（如果指定）
+	if modeStr, ok := args["mode"].(string); ok && !numeric {
+		chmodCmd := fmt.Sprintf("chmod %s %s", modeStr, shellQuote(dest))
+		_, _, exitCode, err := e.execCommand(conn, chmodCmd, become, becomeUser, becomeMethod)
 		if err != nil || exitCode != 0 {
 			return &Result{
 				Failed: true,
@@ -290,12 +289,148 @@ func (e *Executor) executeCopy(conn *connection.Connection, args map[string]inte
 		}
 	}
 
+	return check, nil
+}
+
+// checkCreatesRemoves 处理 command/shell 模块的 creates/removes 参数
+// creates 指定的路径已存在或 removes 指定的路径不存在时返回跳过执行的结果；
+// 条件满足时，check 模式下返回命令将会执行的结果，否则返回 nil 表示需要执行命令
+func (e *Executor) checkCreatesRemoves(conn connection.Connection, args map[string]interface{}, become bool, becomeUser, becomeMethod string) (*Result, error) {
+	creates, _ := args["creates"].(string)
+	removes, _ := args["removes"].(string)
+	chdir, _ := args["chdir"].(string)
+
+	for _, cond := range []struct {
+		path       string
+		wantExists bool
+	}{
+		{path: creates, wantExists: false},
+		{path: removes, wantExists: true},
+	} {
+		if cond.path == "" {
+			continue
+		}
+
+		// 相对路径相对于 chdir
+		p := cond.path
+		if chdir != "" && !strings.HasPrefix(p, "/") {
+			p = chdir + "/" + p
+		}
+
+		_, _, exitCode, err := e.execCommand(conn, "test -e "+shellQuote(p), become, becomeUser, becomeMethod)
+		if err != nil {
+			return nil, err
+		}
+		if exists := exitCode == 0; exists != cond.wantExists {
+			state := "exists"
+			if !exists {
+				state = "does not exist"
+			}
+			return &Result{
+				Changed: false,
+				Stdout:  fmt.Sprintf("skipped, since %s %s", cond.path, state),
+				Msg:     fmt.Sprintf("Did not run command since '%s' %s", cond.path, state),
+			}, nil
+		}
+	}
+
+	if !checkMode(args) {
+		return nil, nil
+	}
+
+	// 命令的效果无法预知：声明了 creates/removes 时报告命令将会执行，否则跳过
+	msg := "Command would have run if not in check mode"
+	if creates == "" && removes == "" {
+		return skippedInCheckMode(msg), nil
+	}
 	return &Result{
-		Changed: true, // 文件已传输，标记为 changed
-		Dest:    dest,
+		Changed: true,
+		Msg:     msg,
 	}, nil
 }
 
+// checkCopy 比较本地内容和远程文件的校验和及权限，只报告是否会修改 dest（check 模式和实际执行共用）
+func (e *Executor) checkCopy(conn connection.Connection, args map[string]interface{}, dest string, become bool, becomeUser, becomeMethod string) *Result {
+	hash := sha1.New()
+	if content, hasContent := args["content"].(string); hasContent {
+		hash.Write([]byte(content))
+	} else {
+		src, ok := args["src"].(string)
+		if !ok {
+			return &Result{
+				Failed: true,
+				Msg:    "copy module requires either 'src' or 'content' argument",
+			}
+		}
+
+		f, err := os.Open(src)
+		if err != nil {
+			return &Result{
+				Failed: true,
+				Msg:    fmt.Sprintf("failed to copy file: %s", err.Error()),
+			}
+		}
+		defer f.Close()
+
+		if _, err := io.Copy(hash, f); err != nil {
+			return &Result{
+				Failed: true,
+				Msg:    fmt.Sprintf("failed to copy file: %s", err.Error()),
+			}
+		}
+	}
+	checksum := hex.EncodeToString(hash.Sum(nil))
+
+	changed := true
+	stdout, _, exitCode, err := e.execCommand(conn, "sha1sum "+shellQuote(dest), become, becomeUser, becomeMethod)
+	if err == nil && exitCode == 0 {
+		fields := strings.Fields(string(stdout))
+		changed = len(fields) == 0 || fields[0] != checksum
+	}
+	// copy 只设置 mode
+	if mode, ok := args["mode"]; ok && !changed {
+		changed = attributesWouldChange(statAttributes(conn, shellQuote(dest)), map[string]interface{}{"mode": mode})
+	}
+
+	return &Result{
+		Changed:  changed,
+		Dest:     dest,
+		Checksum: checksum,
+	}
+}
+
+// copyDiff 返回 dest 原内容和将要写入的内容，内容相同或无法读取 src 时返回 nil
+// 只读取 maxDiffSize 以内的内容，更大的文件由 newDiff 跳过
+func (e *Executor) copyDiff(conn connection.Connection, args map[string]interface{}, dest string, become bool, becomeUser, becomeMethod string) *Diff {
+	after, hasContent := args["content"].(string)
+	if !hasContent {
+		src, _ := args["src"].(string)
+		f, err := os.Open(src)
+		if err != nil {
+			return nil
+		}
+		defer f.Close()
+
+		data, err := io.ReadAll(io.LimitReader(f, maxDiffSize+1))
+		if err != nil {
+			return nil
+		}
+		after = string(data)
+	}
+
+	before := ""
+	cmd := fmt.Sprintf("head -c %d %s", maxDiffSize+1, shellQuote(dest))
+	stdout, _, exitCode, err := e.execCommand(conn, cmd, become, becomeUser, becomeMethod)
+	if err == nil && exitCode == 0 {
+		before = string(stdout)
+	}
+
+	if before == after {
+		return nil
+	}
+	return newDiff(dest, before, after)
+}
+
 // executeDebug 执行 debug 模块
 func (e *Executor) executeDebug(args map[string]interface{}) (*Result, error) {
 	// debug 模块用于输出调试信息，不需要连接
@@ -329,12 +464,58 @@ func (e *Executor) executeSetFact(args map[string]interface{}) (*Result, error)
 	// 这些 facts 会被 runner 注册到变量管理器中
 	result.AnsibleFacts = make(map[string]interface{})
 	for key, value := range args {
+		// 跳过 runner 传入的内部参数（如 _ansible_check_mode）
+		if strings.HasPrefix(key, "_ansible_") {
+			continue
+		}
 		result.AnsibleFacts[key] = value
 	}
 
 	return result, nil
 }
 
+// executeIncludeVars 执行 include_vars 模块
+// 从控制节点读取 YAML 或 JSON 变量文件，变量作为 ansible_facts 返回，
+// runner 把它们保存为 include_vars 变量（优先级低于 set_fact）
+func (e *Executor) executeIncludeVars(args map[string]interface{}) (*Result, error) {
+	file, _ := args["file"].(string)
+	if file == "" {
+		file, _ = args["_raw_params"].(string)
+	}
+	if file == "" {
+		return &Result{
+			Failed: true,
+			Msg:    "include_vars module requires 'file' or '_raw_params' argument",
+		}, nil
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return &Result{
+			Failed: true,
+			Msg:    fmt.Sprintf("failed to read vars file: %s", err.Error()),
+		}, nil
+	}
+
+	vars := make(map[string]interface{})
+	if err := yaml.Unmarshal(data, &vars); err != nil {
+		return &Result{
+			Failed: true,
+			Msg:    fmt.Sprintf("failed to parse vars file %s: %s", file, err.Error()),
+		}, nil
+	}
+
+	// name 参数把所有变量放到一个字典变量中
+	if name, _ := args["name"].(string); name != "" {
+		vars = map[string]interface{}{name: vars}
+	}
+
+	return &Result{
+		Msg:          fmt.Sprintf("included vars from %s", file),
+		AnsibleFacts: vars,
+	}, nil
+}
+
 // shellQuote 对 shell 命令进行引号转义
 func shellQuote(s string) string {
 	// 简单实现：使用单引号包裹，并转义内部的单引号
@@ -352,7 +533,7 @@ func (r *Result) ToJSON() (string, error) {
 }
 
 // execCommand 执行命令的辅助函数，处理 become
-func (e *Executor) execCommand(conn *connection.Connection, cmd string, become bool, becomeUser, becomeMethod string) (stdout, stderr []byte, exitCode int, err error) {
+func (e *Executor) execCommand(conn connection.Connection, cmd string, become bool, becomeUser, becomeMethod string) (stdout, stderr []byte, exitCode int, err error) {
 	if become {
 		return conn.ExecWithBecome(cmd, becomeUser, becomeMethod)
 	}
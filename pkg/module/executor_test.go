@@ -1,6 +1,9 @@
 package module
 
 import (
+	"os"
+	"path/filepath"
+	"reflect"
 	"testing"
 )
 
@@ -196,3 +199,69 @@ func TestResult_Changed(t *testing.T) {
 		})
 	}
 }
+
+func TestExecutor_executeSetFact(t *testing.T) {
+	// --check/--diff 传入的内部参数不能作为 fact 泄漏到主机变量
+	result, err := NewExecutor().executeSetFact(map[string]interface{}{
+		"app_port":   8080,
+		CheckModeArg: true,
+		DiffArg:      true,
+	})
+	if err != nil {
+		t.Fatalf("executeSetFact() error = %v", err)
+	}
+	if want := map[string]interface{}{"app_port": 8080}; !reflect.DeepEqual(result.AnsibleFacts, want) {
+		t.Errorf("executeSetFact() facts = %v, want %v", result.AnsibleFacts, want)
+	}
+}
+
+func TestExecutor_executeIncludeVars(t *testing.T) {
+	executor := NewExecutor()
+	file := filepath.Join(t.TempDir(), "vars.yml")
+	if err := os.WriteFile(file, []byte("port: 80\nusers: [alice]\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		args      map[string]interface{}
+		wantFacts map[string]interface{}
+		wantFail  bool
+	}{
+		{
+			name:      "file",
+			args:      map[string]interface{}{"file": file},
+			wantFacts: map[string]interface{}{"port": 80, "users": []interface{}{"alice"}},
+		},
+		{
+			name:      "free form with name",
+			args:      map[string]interface{}{"_raw_params": file, "name": "app"},
+			wantFacts: map[string]interface{}{"app": map[string]interface{}{"port": 80, "users": []interface{}{"alice"}}},
+		},
+		{
+			name:     "missing file",
+			args:     map[string]interface{}{"file": file + ".missing"},
+			wantFail: true,
+		},
+		{
+			name:     "no file",
+			args:     map[string]interface{}{},
+			wantFail: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := executor.executeIncludeVars(tt.args)
+			if err != nil {
+				t.Fatalf("executeIncludeVars() error = %v", err)
+			}
+			if result.Failed != tt.wantFail {
+				t.Fatalf("executeIncludeVars() Failed = %v, want %v (%s)", result.Failed, tt.wantFail, result.Msg)
+			}
+			if !tt.wantFail && !reflect.DeepEqual(result.AnsibleFacts, tt.wantFacts) {
+				t.Errorf("executeIncludeVars() facts = %v, want %v", result.AnsibleFacts, tt.wantFacts)
+			}
+		})
+	}
+}
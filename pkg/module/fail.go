@@ -9,7 +9,7 @@ import (
 type FailModule struct{}
 
 // Execute 执行 fail 模块
-func (m *FailModule) Execute(conn *connection.Connection, args map[string]interface{}, become bool, becomeUser, becomeMethod string) (*Result, error) {
+func (m *FailModule) Execute(conn connection.Connection, args map[string]interface{}, become bool, becomeUser, becomeMethod string) (*Result, error) {
 	result := &Result{
 		Failed: true,
 	}
@@ -7,6 +7,7 @@ import (
 	"strings"
 	"syscall"
 
+	"github.com/jimyag/ansigo/pkg/agent"
 	"github.com/jimyag/ansigo/pkg/connection"
 )
 
@@ -21,7 +22,7 @@ type execResult struct {
 }
 
 // executeCommand 执行命令并返回包装后的结果
-func executeCommand(conn *connection.Connection, cmd string) (*execResult, error) {
+func executeCommand(conn connection.Connection, cmd string) (*execResult, error) {
 	stdout, stderr, exitCode, err := conn.Exec(cmd)
 	if err != nil {
 		return nil, err
@@ -34,7 +35,7 @@ func executeCommand(conn *connection.Connection, cmd string) (*execResult, error
 }
 
 // Execute 执行 file 模块
-func (m *FileModule) Execute(conn *connection.Connection, args map[string]interface{}, become bool, becomeUser, becomeMethod string) (*Result, error) {
+func (m *FileModule) Execute(conn connection.Connection, args map[string]interface{}, become bool, becomeUser, becomeMethod string) (*Result, error) {
 	result := &Result{}
 
 	// 获取必需参数 path
@@ -60,6 +61,14 @@ func (m *FileModule) Execute(conn *connection.Connection, args map[string]interf
 		}
 	}
 
+	// 启用了远程 agent 时由 agent 检查和修改文件，代替 test、stat、chmod、chown 等多次 shell 调用
+	if attrs, ok := agentAttributes(args); ok {
+		if a := startAgent(conn, args, become, becomeUser, becomeMethod); a != nil {
+			defer a.Close()
+			return m.executeWithAgent(a, path, state, attrs, args), nil
+		}
+	}
+
 	// 根据 state 执行不同操作
 	switch state {
 	case "file":
@@ -67,7 +76,7 @@ func (m *FileModule) Execute(conn *connection.Connection, args map[string]interf
 	case "directory":
 		return m.ensureDirectory(conn, path, args)
 	case "absent":
-		return m.ensureAbsent(conn, path)
+		return m.ensureAbsent(conn, path, args)
 	case "touch":
 		return m.touchFile(conn, path, args)
 	case "link":
@@ -80,7 +89,7 @@ func (m *FileModule) Execute(conn *connection.Connection, args map[string]interf
 }
 
 // ensureFile 确保文件存在
-func (m *FileModule) ensureFile(conn *connection.Connection, path string, args map[string]interface{}) (*Result, error) {
+func (m *FileModule) ensureFile(conn connection.Connection, path string, args map[string]interface{}) (*Result, error) {
 	result := &Result{}
 
 	// 检查文件是否存在
@@ -114,7 +123,7 @@ func (m *FileModule) ensureFile(conn *connection.Connection, path string, args m
 }
 
 // ensureDirectory 确保目录存在
-func (m *FileModule) ensureDirectory(conn *connection.Connection, path string, args map[string]interface{}) (*Result, error) {
+func (m *FileModule) ensureDirectory(conn connection.Connection, path string, args map[string]interface{}) (*Result, error) {
 	result := &Result{}
 
 	// 检查目录是否存在
@@ -128,6 +137,12 @@ func (m *FileModule) ensureDirectory(conn *connection.Connection, path string, a
 
 	exists := checkResult.RC == 0
 
+	if !exists && checkMode(args) {
+		result.Changed = true
+		result.Msg = fmt.Sprintf("directory %s would be created", path)
+		return result, nil
+	}
+
 	if !exists {
 		// 创建目录
 		mkdirCmd := fmt.Sprintf("mkdir -p %s", path)
@@ -162,7 +177,7 @@ func (m *FileModule) ensureDirectory(conn *connection.Connection, path string, a
 }
 
 // ensureAbsent 确保文件/目录不存在
-func (m *FileModule) ensureAbsent(conn *connection.Connection, path string) (*Result, error) {
+func (m *FileModule) ensureAbsent(conn connection.Connection, path string, args map[string]interface{}) (*Result, error) {
 	result := &Result{}
 
 	// 检查路径是否存在
@@ -181,6 +196,12 @@ func (m *FileModule) ensureAbsent(conn *connection.Connection, path string) (*Re
 		return result, nil
 	}
 
+	if checkMode(args) {
+		result.Changed = true
+		result.Msg = fmt.Sprintf("%s would be removed", path)
+		return result, nil
+	}
+
 	// 删除文件或目录
 	rmCmd := fmt.Sprintf("rm -rf %s", path)
 	rmResult, err := executeCommand(conn, rmCmd)
@@ -196,7 +217,7 @@ func (m *FileModule) ensureAbsent(conn *connection.Connection, path string) (*Re
 }
 
 // touchFile 创建空文件或更新时间戳
-func (m *FileModule) touchFile(conn *connection.Connection, path string, args map[string]interface{}) (*Result, error) {
+func (m *FileModule) touchFile(conn connection.Connection, path string, args map[string]interface{}) (*Result, error) {
 	result := &Result{}
 
 	// 检查文件是否存在
@@ -210,6 +231,12 @@ func (m *FileModule) touchFile(conn *connection.Connection, path string, args ma
 
 	exists := checkResult.RC == 0
 
+	if checkMode(args) {
+		result.Changed = !exists || attributesWouldChange(statAttributes(conn, path), args)
+		result.Msg = fmt.Sprintf("file %s would be touched", path)
+		return result, nil
+	}
+
 	// 执行 touch 命令
 	touchCmd := fmt.Sprintf("touch %s", path)
 	touchResult, err := executeCommand(conn, touchCmd)
@@ -244,7 +271,7 @@ func (m *FileModule) touchFile(conn *connection.Connection, path string, args ma
 }
 
 // createLink 创建符号链接
-func (m *FileModule) createLink(conn *connection.Connection, path string, args map[string]interface{}) (*Result, error) {
+func (m *FileModule) createLink(conn connection.Connection, path string, args map[string]interface{}) (*Result, error) {
 	result := &Result{}
 
 	// 获取 src 参数
@@ -275,8 +302,16 @@ func (m *FileModule) createLink(conn *connection.Connection, path string, args m
 			return result, nil
 		}
 		// 链接存在但目标不对，需要更新
-		rmCmd := fmt.Sprintf("rm -f %s", path)
-		_, _ = executeCommand(conn, rmCmd)
+		if !checkMode(args) {
+			rmCmd := fmt.Sprintf("rm -f %s", path)
+			_, _ = executeCommand(conn, rmCmd)
+		}
+	}
+
+	if checkMode(args) {
+		result.Changed = true
+		result.Msg = fmt.Sprintf("link %s -> %s would be created", path, src)
+		return result, nil
 	}
 
 	// 创建符号链接
@@ -293,56 +328,127 @@ func (m *FileModule) createLink(conn *connection.Connection, path string, args m
 	return result, nil
 }
 
-// applyPermissions 应用权限、所有者和组
-func (m *FileModule) applyPermissions(conn *connection.Connection, path string, args map[string]interface{}) (bool, error) {
-	changed := false
+// executeWithAgent 通过远程 agent 执行 file 模块：一次 stat 判断当前状态，一次请求完成修改
+func (m *FileModule) executeWithAgent(a *remoteAgent, path, state string, attrs agent.Attributes, args map[string]interface{}) *Result {
+	result := &Result{}
+	fail := func(format string, err error) *Result {
+		result.Failed = true
+		result.Msg = fmt.Sprintf(format, err)
+		return result
+	}
 
-	// 应用 mode（权限）
-	if modeInterface, ok := args["mode"]; ok {
-		modeStr := ""
-		switch v := modeInterface.(type) {
-		case string:
-			modeStr = v
-		case int:
-			modeStr = fmt.Sprintf("%o", v)
-		case int64:
-			modeStr = fmt.Sprintf("%o", v)
-		case float64:
-			modeStr = fmt.Sprintf("%o", int(v))
+	info, err := a.Stat(path)
+	if err != nil {
+		return fail("failed to check path: %v", err)
+	}
+	recurse, _ := convertBool(args["recurse"])
+	check := checkMode(args)
+
+	switch state {
+	case "file":
+		if !info.Exists {
+			result.Failed = true
+			result.Msg = fmt.Sprintf("file not found: %s (use state=touch to create)", path)
+			return result
+		}
+		if check {
+			result.Changed = attributesWouldChange(agentFileAttributes(info), args)
+		} else if result.Changed, err = a.SetAttributes(path, attrs); err != nil {
+			return fail("failed to set attributes: %v", err)
 		}
+		result.Msg = fmt.Sprintf("file %s is present", path)
 
-		if modeStr != "" {
-			chmodCmd := fmt.Sprintf("chmod %s %s", modeStr, path)
-			chmodResult, err := executeCommand(conn, chmodCmd)
-			if err != nil || chmodResult.RC != 0 {
-				return false, fmt.Errorf("failed to chmod: %s", chmodResult.Stderr)
+	case "directory":
+		if check {
+			// check 模式下不逐个比较目录中的文件，递归时按会修改处理
+			result.Changed = !info.Exists || attributesWouldChange(agentFileAttributes(info), args) ||
+				(recurse && attrs != agent.Attributes{})
+			if !info.Exists {
+				result.Msg = fmt.Sprintf("directory %s would be created", path)
+				return result
+			}
+		} else {
+			if result.Changed, err = a.Mkdir(path, attrs); err != nil {
+				return fail("failed to create directory: %v", err)
+			}
+			if recurse {
+				changed, err := a.SetAttributesRecursive(path, attrs)
+				if err != nil {
+					return fail("failed to set attributes: %v", err)
+				}
+				result.Changed = result.Changed || changed
 			}
-			changed = true
 		}
-	}
+		if result.Changed {
+			result.Msg = fmt.Sprintf("directory %s created", path)
+		} else {
+			result.Msg = fmt.Sprintf("directory %s already exists", path)
+		}
 
-	// 应用 owner
-	if ownerInterface, ok := args["owner"]; ok {
-		if owner, ok := ownerInterface.(string); ok && owner != "" {
-			chownCmd := fmt.Sprintf("chown %s %s", owner, path)
-			chownResult, err := executeCommand(conn, chownCmd)
-			if err != nil || chownResult.RC != 0 {
-				return false, fmt.Errorf("failed to chown: %s", chownResult.Stderr)
-			}
-			changed = true
+	case "absent":
+		if !info.Exists {
+			result.Msg = fmt.Sprintf("path %s does not exist", path)
+			return result
 		}
-	}
+		result.Changed = true
+		if check {
+			result.Msg = fmt.Sprintf("%s would be removed", path)
+			return result
+		}
+		if _, err := a.Remove(path); err != nil {
+			return fail("failed to remove: %v", err)
+		}
+		result.Msg = fmt.Sprintf("removed %s", path)
 
-	// 应用 group
-	if groupInterface, ok := args["group"]; ok {
-		if group, ok := groupInterface.(string); ok && group != "" {
-			chgrpCmd := fmt.Sprintf("chgrp %s %s", group, path)
-			chgrpResult, err := executeCommand(conn, chgrpCmd)
-			if err != nil || chgrpResult.RC != 0 {
-				return false, fmt.Errorf("failed to chgrp: %s", chgrpResult.Stderr)
-			}
-			changed = true
+	case "touch":
+		if check {
+			result.Changed = !info.Exists || attributesWouldChange(agentFileAttributes(info), args)
+			result.Msg = fmt.Sprintf("file %s would be touched", path)
+			return result
+		}
+		if result.Changed, err = a.Touch(path, attrs); err != nil {
+			return fail("failed to touch file: %v", err)
 		}
+		if info.Exists {
+			result.Msg = fmt.Sprintf("file %s timestamp updated", path)
+		} else {
+			result.Msg = fmt.Sprintf("file %s created", path)
+		}
+
+	case "link":
+		src, ok := args["src"].(string)
+		if !ok {
+			result.Failed = true
+			result.Msg = "state=link requires src parameter"
+			return result
+		}
+		if info.IsLink && info.Target == src {
+			result.Msg = fmt.Sprintf("link %s -> %s already correct", path, src)
+			return result
+		}
+		result.Changed = true
+		if check {
+			result.Msg = fmt.Sprintf("link %s -> %s would be created", path, src)
+			return result
+		}
+		if _, err := a.Symlink(path, src); err != nil {
+			result.Changed = false
+			return fail("failed to create link: %v", err)
+		}
+		result.Msg = fmt.Sprintf("created link %s -> %s", path, src)
+
+	default:
+		result.Failed = true
+		result.Msg = fmt.Sprintf("invalid state: %s", state)
+	}
+	return result
+}
+
+// applyPermissions 应用权限、所有者和组
+func (m *FileModule) applyPermissions(conn connection.Connection, path string, args map[string]interface{}) (bool, error) {
+	changed, err := applyAttributes(conn, path, args)
+	if err != nil {
+		return false, err
 	}
 
 	// 处理 recurse（递归应用权限到目录）
@@ -355,7 +461,13 @@ func (m *FileModule) applyPermissions(conn *connection.Connection, path string,
 			recurse = v == "yes" || v == "true"
 		}
 
-		if recurse {
+		if recurse && checkMode(args) {
+			// check 模式下无法逐个比较目录中的文件，按会修改处理
+			_, hasMode := args["mode"]
+			_, hasOwner := args["owner"]
+			_, hasGroup := args["group"]
+			changed = changed || hasMode || hasOwner || hasGroup
+		} else if recurse {
 			// 检查是否是目录
 			checkCmd := fmt.Sprintf("test -d %s", path)
 			checkResult, _ := executeCommand(conn, checkCmd)
@@ -396,7 +508,7 @@ func parseMode(modeStr string) (os.FileMode, error) {
 }
 
 // 辅助函数：获取文件信息
-func getFileInfo(conn *connection.Connection, path string) (os.FileMode, int, int, error) {
+func getFileInfo(conn connection.Connection, path string) (os.FileMode, int, int, error) {
 	statCmd := fmt.Sprintf("stat -c '%%a %%u %%g' %s", path)
 	result, err := executeCommand(conn, statCmd)
 	if err != nil || result.RC != 0 {
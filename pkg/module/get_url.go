@@ -2,8 +2,11 @@ package module
 
 import (
 	"fmt"
+	"net/http"
+	"path"
 	"strings"
 
+	"github.com/google/uuid"
 	"github.com/jimyag/ansigo/pkg/connection"
 )
 
@@ -12,7 +15,7 @@ import (
 type GetUrlModule struct{}
 
 // Execute 执行 get_url 模块
-func (m *GetUrlModule) Execute(conn *connection.Connection, args map[string]interface{}, become bool, becomeUser, becomeMethod string) (*Result, error) {
+func (m *GetUrlModule) Execute(conn connection.Connection, args map[string]interface{}, become bool, becomeUser, becomeMethod string) (*Result, error) {
 	result := &Result{}
 
 	// 获取必需参数 url
@@ -119,6 +122,13 @@ func (m *GetUrlModule) Execute(conn *connection.Connection, args map[string]inte
 		}
 	}
 
+	// check 模式下不下载
+	if checkMode(args) {
+		result.Changed = true
+		result.Msg = fmt.Sprintf("file would be downloaded from %s to %s", url, dest)
+		return result, nil
+	}
+
 	// 创建目标目录（如果不存在）
 	if err := m.createDestDir(conn, dest, become, becomeUser, becomeMethod); err != nil {
 		result.Failed = true
@@ -126,8 +136,9 @@ func (m *GetUrlModule) Execute(conn *connection.Connection, args map[string]inte
 		return result, nil
 	}
 
-	// 下载文件
-	if err := m.downloadFile(conn, url, dest, become, becomeUser, becomeMethod); err != nil {
+	// 下载到目标目录下的临时文件，校验通过后再原子替换，避免留下不完整或校验失败的文件
+	tmp := path.Join(path.Dir(dest), "."+path.Base(dest)+".ansigo-"+uuid.New().String()[:8])
+	if err := m.downloadFile(conn, url, tmp, become, becomeUser, becomeMethod); err != nil {
 		result.Failed = true
 		result.Msg = fmt.Sprintf("failed to download file: %v", err)
 		return result, nil
@@ -135,19 +146,26 @@ func (m *GetUrlModule) Execute(conn *connection.Connection, args map[string]inte
 
 	// 验证 checksum（如果指定）
 	if checksum != "" {
-		valid, err := m.verifyChecksum(conn, dest, checksum, become, becomeUser, becomeMethod)
-		if err != nil {
-			result.Failed = true
-			result.Msg = fmt.Sprintf("failed to verify checksum: %v", err)
-			return result, nil
-		}
-		if !valid {
+		valid, err := m.verifyChecksum(conn, tmp, checksum, become, becomeUser, becomeMethod)
+		if err != nil || !valid {
+			m.removeFile(conn, tmp, become, becomeUser, becomeMethod)
 			result.Failed = true
-			result.Msg = "checksum verification failed"
+			if err != nil {
+				result.Msg = fmt.Sprintf("failed to verify checksum: %v", err)
+			} else {
+				result.Msg = "checksum verification failed"
+			}
 			return result, nil
 		}
 	}
 
+	if err := m.installFile(conn, tmp, dest, become, becomeUser, becomeMethod); err != nil {
+		m.removeFile(conn, tmp, become, becomeUser, becomeMethod)
+		result.Failed = true
+		result.Msg = fmt.Sprintf("failed to install file: %v", err)
+		return result, nil
+	}
+
 	// 设置文件权限（如果指定）
 	if mode != "" {
 		if err := m.setFileMode(conn, dest, mode, become, becomeUser, becomeMethod); err != nil {
@@ -172,7 +190,7 @@ func (m *GetUrlModule) Execute(conn *connection.Connection, args map[string]inte
 }
 
 // checkFileExists 检查文件是否存在
-func (m *GetUrlModule) checkFileExists(conn *connection.Connection, path string, become bool, becomeUser, becomeMethod string) (bool, error) {
+func (m *GetUrlModule) checkFileExists(conn connection.Connection, path string, become bool, becomeUser, becomeMethod string) (bool, error) {
 	cmd := fmt.Sprintf("test -f %s", path)
 
 	var exitCode int
@@ -192,7 +210,7 @@ func (m *GetUrlModule) checkFileExists(conn *connection.Connection, path string,
 }
 
 // createDestDir 创建目标目录
-func (m *GetUrlModule) createDestDir(conn *connection.Connection, dest string, become bool, becomeUser, becomeMethod string) error {
+func (m *GetUrlModule) createDestDir(conn connection.Connection, dest string, become bool, becomeUser, becomeMethod string) error {
 	// 提取目录路径
 	cmd := fmt.Sprintf("mkdir -p $(dirname %s)", dest)
 
@@ -213,11 +231,11 @@ func (m *GetUrlModule) createDestDir(conn *connection.Connection, dest string, b
 	return nil
 }
 
-// downloadFile 下载文件
-func (m *GetUrlModule) downloadFile(conn *connection.Connection, url, dest string, become bool, becomeUser, becomeMethod string) error {
-	// 使用 curl 或 wget 下载文件
-	// 优先使用 curl，如果不存在则使用 wget
-	cmd := fmt.Sprintf("if command -v curl >/dev/null 2>&1; then curl -fsSL -o %s %s; elif command -v wget >/dev/null 2>&1; then wget -q -O %s %s; else echo 'neither curl nor wget found' >&2; exit 1; fi", dest, url, dest, url)
+// downloadFile 在远程主机上下载文件
+// 优先使用远程的 curl 或 wget；两者都不存在时由控制节点下载，再通过连接上传（SFTP 或 shell）
+func (m *GetUrlModule) downloadFile(conn connection.Connection, url, dest string, become bool, becomeUser, becomeMethod string) error {
+	cmd := fmt.Sprintf("if command -v curl >/dev/null 2>&1; then curl -fsSL -o %s %s; elif command -v wget >/dev/null 2>&1; then wget -q -O %s %s; else exit 127; fi",
+		shellQuote(dest), shellQuote(url), shellQuote(dest), shellQuote(url))
 
 	var stderr []byte
 	var exitCode int
@@ -229,6 +247,10 @@ func (m *GetUrlModule) downloadFile(conn *connection.Connection, url, dest strin
 		_, stderr, exitCode, err = conn.Exec(cmd)
 	}
 
+	if err == nil && exitCode == 127 {
+		return m.downloadViaController(conn, url, dest, become, becomeUser, becomeMethod)
+	}
+
 	if err != nil || exitCode != 0 {
 		return fmt.Errorf("download failed: %s", strings.TrimSpace(string(stderr)))
 	}
@@ -236,8 +258,57 @@ func (m *GetUrlModule) downloadFile(conn *connection.Connection, url, dest strin
 	return nil
 }
 
+// downloadViaController 在控制节点下载并流式上传到远程主机
+func (m *GetUrlModule) downloadViaController(conn connection.Connection, url, dest string, become bool, becomeUser, becomeMethod string) error {
+	resp, err := http.Get(url)
+	if err != nil {
+		return fmt.Errorf("download failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("download failed: HTTP %s", resp.Status)
+	}
+
+	return putFile(conn, resp.Body, dest, 0o600, become, becomeUser, becomeMethod)
+}
+
+// installFile 把下载好的临时文件重命名为目标文件
+// 新文件使用 0644（之后按 mode 参数调整），已存在的文件保持原有权限
+func (m *GetUrlModule) installFile(conn connection.Connection, tmp, dest string, become bool, becomeUser, becomeMethod string) error {
+	quotedTmp, quotedDest := shellQuote(tmp), shellQuote(dest)
+	cmd := fmt.Sprintf("if [ -e %s ]; then chmod \"$(stat -c %%a %s)\" %s; else chmod 0644 %s; fi && mv -f %s %s",
+		quotedDest, quotedDest, quotedTmp, quotedTmp, quotedTmp, quotedDest)
+
+	var stderr []byte
+	var exitCode int
+	var err error
+
+	if become {
+		_, stderr, exitCode, err = conn.ExecWithBecome(cmd, becomeUser, becomeMethod)
+	} else {
+		_, stderr, exitCode, err = conn.Exec(cmd)
+	}
+
+	if err != nil || exitCode != 0 {
+		return fmt.Errorf("failed to move file into place: %s", strings.TrimSpace(string(stderr)))
+	}
+
+	return nil
+}
+
+// removeFile 删除远程文件（忽略错误）
+func (m *GetUrlModule) removeFile(conn connection.Connection, path string, become bool, becomeUser, becomeMethod string) {
+	cmd := "rm -f " + shellQuote(path)
+	if become {
+		conn.ExecWithBecome(cmd, becomeUser, becomeMethod)
+	} else {
+		conn.Exec(cmd)
+	}
+}
+
 // verifyChecksum 验证文件 checksum
-func (m *GetUrlModule) verifyChecksum(conn *connection.Connection, path, checksum string, become bool, becomeUser, becomeMethod string) (bool, error) {
+func (m *GetUrlModule) verifyChecksum(conn connection.Connection, path, checksum string, become bool, becomeUser, becomeMethod string) (bool, error) {
 	// checksum 格式: "sha256:abc123..." 或 "md5:def456..."
 	parts := strings.SplitN(checksum, ":", 2)
 	if len(parts) != 2 {
@@ -279,7 +350,7 @@ func (m *GetUrlModule) verifyChecksum(conn *connection.Connection, path, checksu
 }
 
 // setFileMode 设置文件权限
-func (m *GetUrlModule) setFileMode(conn *connection.Connection, path, mode string, become bool, becomeUser, becomeMethod string) error {
+func (m *GetUrlModule) setFileMode(conn connection.Connection, path, mode string, become bool, becomeUser, becomeMethod string) error {
 	cmd := fmt.Sprintf("chmod %s %s", mode, path)
 
 	var stderr []byte
@@ -300,7 +371,7 @@ func (m *GetUrlModule) setFileMode(conn *connection.Connection, path, mode strin
 }
 
 // setFileOwner 设置文件所有者和组
-func (m *GetUrlModule) setFileOwner(conn *connection.Connection, path, owner, group string, become bool, becomeUser, becomeMethod string) error {
+func (m *GetUrlModule) setFileOwner(conn connection.Connection, path, owner, group string, become bool, becomeUser, becomeMethod string) error {
 	ownerGroup := owner
 	if group != "" {
 		if owner != "" {
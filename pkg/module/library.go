@@ -0,0 +1,216 @@
+package module
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/jimyag/ansigo/pkg/connection"
+)
+
+// DefaultPythonInterpreter 没有设置 ansible_python_interpreter 时执行 Python 模块的解释器
+const DefaultPythonInterpreter = "/usr/bin/python3"
+
+// libraryExtensions library 目录中模块文件的扩展名，按顺序查找
+var libraryExtensions = []string{"", ".py", ".sh"}
+
+// findLibraryModule 在 library 目录中查找模块文件 <name>、<name>.py 或 <name>.sh
+func findLibraryModule(dirs []string, name string) (string, bool) {
+	if name == "" || strings.HasPrefix(name, ".") || strings.ContainsAny(name, `/\`) {
+		return "", false
+	}
+	for _, dir := range dirs {
+		for _, ext := range libraryExtensions {
+			path := filepath.Join(dir, name+ext)
+			if info, err := os.Stat(path); err == nil && info.Mode().IsRegular() {
+				return path, true
+			}
+		}
+	}
+	return "", false
+}
+
+// LibraryDirs 返回 -M（--module-path）参数中用 : 分隔的 library 目录，未指定时使用环境变量 ANSIBLE_LIBRARY
+func LibraryDirs(modulePath string) []string {
+	if modulePath == "" {
+		modulePath = os.Getenv("ANSIBLE_LIBRARY")
+	}
+	var dirs []string
+	for _, dir := range filepath.SplitList(modulePath) {
+		if dir != "" {
+			dirs = append(dirs, dir)
+		}
+	}
+	return dirs
+}
+
+// LibraryModule library 目录中的模块文件（Python 或 shell 等脚本），按 Ansible 的 JSON 参数协议执行：
+// 模块文件和 JSON 参数文件上传到远程临时目录，以参数文件路径为唯一的命令行参数运行模块，
+// 模块在标准输出打印 JSON 格式的结果
+type LibraryModule struct {
+	Path string // 控制节点上的模块文件
+}
+
+// Execute 上传并执行模块，解析模块输出的 JSON 结果
+func (m *LibraryModule) Execute(conn connection.Connection, args map[string]interface{}, become bool, becomeUser, becomeMethod string) (*Result, error) {
+	interpreter, err := moduleInterpreter(m.Path, args)
+	if err != nil {
+		return nil, err
+	}
+
+	// 解释器只用于执行模块，不传给模块
+	moduleArgs := make(map[string]interface{}, len(args))
+	for k, v := range args {
+		if k != PythonInterpreterArg {
+			moduleArgs[k] = v
+		}
+	}
+
+	mt := NewModuleTransfer(conn, become, becomeUser)
+	remoteDir, err := mt.PrepareRemoteDir()
+	if err != nil {
+		return nil, err
+	}
+	defer mt.Cleanup(remoteDir)
+
+	argsPath, err := mt.TransferArgs(moduleArgs, remoteDir)
+	if err != nil {
+		return nil, err
+	}
+	modulePath, err := mt.TransferModule(m.Path, remoteDir)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := mt.GrantAccess(argsPath, modulePath); err != nil {
+		return nil, err
+	}
+
+	cmd := shellQuote(modulePath) + " " + shellQuote(argsPath)
+	if interpreter != "" {
+		cmd = interpreter + " " + cmd
+	}
+
+	var stdout, stderr []byte
+	var exitCode int
+	if become {
+		stdout, stderr, exitCode, err = conn.ExecWithBecome(cmd, becomeUser, becomeMethod)
+	} else {
+		stdout, stderr, exitCode, err = conn.Exec(cmd)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute module %s: %w", filepath.Base(m.Path), err)
+	}
+
+	return parseModuleResult(stdout, stderr, exitCode), nil
+}
+
+// moduleInterpreter 返回执行模块文件的解释器，空字符串表示按文件的 shebang 直接执行
+// Python 模块（shebang 中是 python，或没有 shebang 的 .py 文件）使用 ansible_python_interpreter，
+// 没有 shebang 的其他文件使用 /bin/sh
+func moduleInterpreter(path string, args map[string]interface{}) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read module %s: %w", path, err)
+	}
+	defer f.Close()
+
+	firstLine, _ := bufio.NewReader(f).ReadString('\n')
+	python := strings.HasSuffix(path, ".py")
+	if shebang, ok := strings.CutPrefix(firstLine, "#!"); ok {
+		fields := strings.Fields(shebang)
+		if len(fields) > 1 && filepath.Base(fields[0]) == "env" {
+			fields = fields[1:]
+		}
+		if len(fields) == 0 || !strings.HasPrefix(filepath.Base(fields[0]), "python") {
+			return "", nil
+		}
+		python = true
+	}
+	if !python {
+		return "/bin/sh", nil
+	}
+
+	if interpreter, ok := args[PythonInterpreterArg].(string); ok && interpreter != "" {
+		return interpreter, nil
+	}
+	return DefaultPythonInterpreter, nil
+}
+
+// parseModuleResult 解析模块输出的 JSON 结果
+// JSON 之前的其他输出（如登录提示）被忽略；没有 JSON 输出时任务失败，结果中包含模块的输出
+// 结果中没有 failed 时按 rc 判断是否失败（与 Ansible 相同），所有字段都保存在 Data 中供 register 使用
+func parseModuleResult(stdout, stderr []byte, exitCode int) *Result {
+	data, ok := moduleJSON(stdout)
+	if !ok {
+		return &Result{
+			Failed: true,
+			Msg:    "MODULE FAILURE: module did not return JSON, see stdout/stderr for the exact error",
+			RC:     exitCode,
+			Stdout: string(stdout),
+			Stderr: string(stderr),
+		}
+	}
+
+	result := &Result{Data: data}
+	result.Changed, _ = convertBool(data["changed"])
+	result.Skipped, _ = convertBool(data["skipped"])
+	if msg, ok := data["msg"]; ok && msg != nil {
+		result.Msg = fmt.Sprint(msg)
+	}
+	if rc, ok := data["rc"].(float64); ok {
+		result.RC = int(rc)
+	}
+	if failed, ok := data["failed"]; ok {
+		result.Failed, _ = convertBool(failed)
+	} else {
+		result.Failed = result.RC != 0
+	}
+	result.Stdout, _ = data["stdout"].(string)
+	result.Stderr, _ = data["stderr"].(string)
+	result.AnsibleFacts, _ = data["ansible_facts"].(map[string]interface{})
+	result.Diff = moduleDiff(data["diff"])
+	return result
+}
+
+// moduleJSON 从模块输出中找到以 { 开头的行，解析从这一行开始的 JSON 对象
+func moduleJSON(stdout []byte) (map[string]interface{}, bool) {
+	for start := 0; start < len(stdout); {
+		line := stdout[start:]
+		if end := bytes.IndexByte(line, '\n'); end >= 0 {
+			line = line[:end+1]
+		}
+		if bytes.HasPrefix(bytes.TrimSpace(line), []byte("{")) {
+			var data map[string]interface{}
+			if err := json.NewDecoder(bytes.NewReader(stdout[start:])).Decode(&data); err == nil {
+				return data, true
+			}
+		}
+		start += len(line)
+	}
+	return nil, false
+}
+
+// moduleDiff 把模块返回的 diff（对象或对象列表）转换为 Diff，before 和 after 不是字符串时忽略
+func moduleDiff(value interface{}) *Diff {
+	if list, ok := value.([]interface{}); ok && len(list) > 0 {
+		value = list[0]
+	}
+	diff, ok := value.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	before, ok1 := diff["before"].(string)
+	after, ok2 := diff["after"].(string)
+	if !ok1 || !ok2 {
+		return nil
+	}
+	result := &Diff{Before: before, After: after}
+	result.BeforeHeader, _ = diff["before_header"].(string)
+	result.AfterHeader, _ = diff["after_header"].(string)
+	return result
+}
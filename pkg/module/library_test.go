@@ -0,0 +1,291 @@
+package module
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/jimyag/ansigo/pkg/connection"
+)
+
+func TestParseModuleResult(t *testing.T) {
+	tests := []struct {
+		name     string
+		stdout   string
+		exitCode int
+		want     Result
+	}{
+		{
+			name:   "noise before json",
+			stdout: "Last login: today\n{\"changed\": true, \"msg\": \"done\", \"size\": 3, \"ansible_facts\": {\"a\": 1}}\n",
+			want: Result{
+				Changed:      true,
+				Msg:          "done",
+				AnsibleFacts: map[string]interface{}{"a": float64(1)},
+				Data: map[string]interface{}{
+					"changed": true, "msg": "done", "size": float64(3),
+					"ansible_facts": map[string]interface{}{"a": float64(1)},
+				},
+			},
+		},
+		{
+			name:     "failed from rc",
+			stdout:   `{"rc": 2, "stdout": "out", "diff": [{"before": "a\n", "after": "b\n"}]}`,
+			exitCode: 2,
+			want: Result{
+				Failed: true,
+				RC:     2,
+				Stdout: "out",
+				Diff:   &Diff{Before: "a\n", After: "b\n"},
+				Data: map[string]interface{}{
+					"rc": float64(2), "stdout": "out",
+					"diff": []interface{}{map[string]interface{}{"before": "a\n", "after": "b\n"}},
+				},
+			},
+		},
+		{
+			name:     "failed flag wins over exit code",
+			stdout:   `{"failed": false, "rc": 1}`,
+			exitCode: 1,
+			want:     Result{RC: 1, Data: map[string]interface{}{"failed": false, "rc": float64(1)}},
+		},
+		{
+			name:     "no json",
+			stdout:   "Traceback (most recent call last):\n",
+			exitCode: 1,
+			want: Result{
+				Failed: true,
+				Msg:    "MODULE FAILURE: module did not return JSON, see stdout/stderr for the exact error",
+				RC:     1,
+				Stdout: "Traceback (most recent call last):\n",
+				Stderr: "boom",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := parseModuleResult([]byte(tt.stdout), []byte("boom"), tt.exitCode)
+			if !reflect.DeepEqual(*got, tt.want) {
+				t.Errorf("parseModuleResult() = %+v, want %+v", *got, tt.want)
+			}
+		})
+	}
+}
+
+func TestModuleInterpreter(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		file    string
+		content string
+		args    map[string]interface{}
+		want    string
+	}{
+		{file: "a", content: "#!/usr/bin/python\nimport json\n", want: DefaultPythonInterpreter},
+		{file: "b", content: "#!/usr/bin/env python3\n", args: map[string]interface{}{PythonInterpreterArg: "/opt/py/bin/python"}, want: "/opt/py/bin/python"},
+		{file: "c.py", content: "import json\n", want: DefaultPythonInterpreter},
+		{file: "d", content: "#!/bin/bash\necho {}\n", want: ""},
+		{file: "e.sh", content: "echo {}\n", want: "/bin/sh"},
+	}
+
+	for _, tt := range tests {
+		path := filepath.Join(dir, tt.file)
+		if err := os.WriteFile(path, []byte(tt.content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		got, err := moduleInterpreter(path, tt.args)
+		if err != nil {
+			t.Fatalf("moduleInterpreter(%s) error = %v", tt.file, err)
+		}
+		if got != tt.want {
+			t.Errorf("moduleInterpreter(%s) = %q, want %q", tt.file, got, tt.want)
+		}
+	}
+}
+
+func TestLibraryModuleExecute(t *testing.T) {
+	first, second := t.TempDir(), t.TempDir()
+	shellModule := `#!/bin/sh
+# 参数文件是唯一的命令行参数
+printf '{"changed": true, "args": %s}\n' "$(cat "$1")"
+`
+	if err := os.WriteFile(filepath.Join(first, "echo_args.sh"), []byte(shellModule), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	// 先添加的目录优先
+	if err := os.WriteFile(filepath.Join(second, "echo_args"), []byte("#!/bin/sh\necho '{\"failed\": true}'\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	r := NewRegistry()
+	if _, ok := r.Lookup("echo_args"); ok {
+		t.Fatal("Lookup() found a module before AddLibrary")
+	}
+	r.AddLibrary(first, second)
+	for _, name := range []string{"../echo_args", ".echo_args", "missing"} {
+		if _, ok := r.Lookup(name); ok {
+			t.Errorf("Lookup(%q) found a module", name)
+		}
+	}
+
+	result, err := NewExecutorWithRegistry(r).Execute(localConn(), "echo_args", map[string]interface{}{
+		"name":               "web",
+		CheckModeArg:         true,
+		PythonInterpreterArg: "/usr/bin/python3",
+	}, false, "", "")
+	if err != nil {
+		t.Fatalf("Execute() error = %v", err)
+	}
+	if result.Failed || !result.Changed {
+		t.Fatalf("Execute() = %+v, want changed", result)
+	}
+	want := map[string]interface{}{"name": "web", CheckModeArg: true}
+	if got := result.Data["args"]; !reflect.DeepEqual(got, want) {
+		t.Errorf("module args = %v, want %v", got, want)
+	}
+}
+
+func TestLibraryModuleExecutePython(t *testing.T) {
+	if _, err := exec.LookPath(DefaultPythonInterpreter); err != nil {
+		t.Skipf("%s not found", DefaultPythonInterpreter)
+	}
+
+	dir := t.TempDir()
+	pythonModule := `#!/usr/bin/python
+# WANT_JSON
+import json, sys
+args = json.load(open(sys.argv[1]))
+print(json.dumps({"changed": False, "ansible_facts": {"greeting": "hello " + args["name"]}}))
+`
+	if err := os.WriteFile(filepath.Join(dir, "greet.py"), []byte(pythonModule), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	r := NewRegistry()
+	r.AddLibrary(dir)
+	result, err := NewExecutorWithRegistry(r).Execute(localConn(), "greet", map[string]interface{}{"name": "ansigo"}, false, "", "")
+	if err != nil {
+		t.Fatalf("Execute() error = %v", err)
+	}
+	if result.Failed {
+		t.Fatalf("Execute() failed: %s %s", result.Msg, result.Stderr)
+	}
+	if got := result.AnsibleFacts["greeting"]; got != "hello ansigo" {
+		t.Errorf("greeting = %v, want hello ansigo", got)
+	}
+}
+
+// runuserConn 用 runuser 实现 become 的本地连接（只有 root 可以使用），用于测试非特权 become 用户
+type runuserConn struct {
+	*connection.LocalConnection
+}
+
+func (c runuserConn) ExecWithBecome(cmd, becomeUser, becomeMethod string) ([]byte, []byte, int, error) {
+	return c.Exec("runuser -u " + becomeUser + " -- sh -c " + shellQuote(cmd))
+}
+
+func (c runuserConn) StartWithBecome(cmd, becomeUser, becomeMethod string) (*connection.Process, error) {
+	return c.Start("runuser -u " + becomeUser + " -- sh -c " + shellQuote(cmd))
+}
+
+func TestModuleTransferPermissions(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	dir := t.TempDir()
+	modulePath := filepath.Join(dir, "mod.sh")
+	if err := os.WriteFile(modulePath, []byte("#!/bin/sh\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// transfer 上传参数和模块，检查目录和文件的权限
+	transfer := func(mt *ModuleTransfer) (remoteDir, argsPath, remoteModule string) {
+		t.Helper()
+		remoteDir, err := mt.PrepareRemoteDir()
+		if err != nil {
+			t.Fatalf("PrepareRemoteDir() error = %v", err)
+		}
+		t.Cleanup(func() { mt.Cleanup(remoteDir) })
+		if argsPath, err = mt.TransferArgs(map[string]interface{}{"password": "secret"}, remoteDir); err != nil {
+			t.Fatalf("TransferArgs() error = %v", err)
+		}
+		if remoteModule, err = mt.TransferModule(modulePath, remoteDir); err != nil {
+			t.Fatalf("TransferModule() error = %v", err)
+		}
+		if err := mt.GrantAccess(argsPath, remoteModule); err != nil {
+			t.Fatalf("GrantAccess() error = %v", err)
+		}
+		// 参数中可能有密码，其他用户不能读取
+		for path, executable := range map[string]bool{argsPath: false, remoteModule: true} {
+			info, err := os.Stat(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Mode().Perm()&0o007 != 0 || (info.Mode().Perm()&0o100 != 0) != executable {
+				t.Errorf("%s mode = %o", path, info.Mode().Perm())
+			}
+		}
+		return remoteDir, argsPath, remoteModule
+	}
+
+	remoteDir, _, _ := transfer(NewModuleTransfer(localConn(), false, ""))
+	if filepath.Dir(remoteDir) != filepath.Join(home, ".ansible", "tmp") {
+		t.Errorf("remote dir = %s, want under ~/.ansible/tmp", remoteDir)
+	}
+	if info, err := os.Stat(remoteDir); err != nil || info.Mode().Perm() != 0o700 {
+		t.Errorf("remote dir mode = %v, %v, want 0700", info.Mode().Perm(), err)
+	}
+
+	// become 到 root 时仍然使用登录用户的临时目录
+	if remoteDir, _, _ := transfer(NewModuleTransfer(localConn(), true, "root")); filepath.Dir(remoteDir) != filepath.Join(home, ".ansible", "tmp") {
+		t.Errorf("remote dir with become root = %s, want under ~/.ansible/tmp", remoteDir)
+	}
+
+	if os.Geteuid() != 0 {
+		t.Skip("changing file ownership to another user requires root")
+	}
+	remoteDir, argsPath, _ := transfer(NewModuleTransfer(localConn(), true, "nobody"))
+	if filepath.Dir(remoteDir) != systemTmpDir {
+		t.Errorf("remote dir with unprivileged become = %s, want under %s", remoteDir, systemTmpDir)
+	}
+	if info, err := os.Stat(remoteDir); err != nil || info.Mode().Perm() != 0o755 {
+		t.Errorf("remote dir mode = %v, %v, want 0755", info.Mode().Perm(), err)
+	}
+	// become 用户（通过 ACL 或所有者）可以读取参数文件
+	if out, err := exec.Command("runuser", "-u", "nobody", "--", "cat", argsPath).CombinedOutput(); err != nil || !strings.Contains(string(out), "secret") {
+		t.Errorf("nobody cannot read %s: %v %s", argsPath, err, out)
+	}
+}
+
+func TestLibraryModuleExecuteBecomeUnprivileged(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("becoming another user requires root")
+	}
+	if _, err := exec.LookPath("runuser"); err != nil {
+		t.Skip("runuser not found")
+	}
+
+	dir := t.TempDir()
+	module := `#!/bin/sh
+printf '{"changed": false, "user": "%s", "args": %s}\n' "$(id -un)" "$(cat "$1")"
+`
+	if err := os.WriteFile(filepath.Join(dir, "whoami_args"), []byte(module), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	r := NewRegistry()
+	r.AddLibrary(dir)
+
+	conn := runuserConn{localConn().(*connection.LocalConnection)}
+	result, err := NewExecutorWithRegistry(r).Execute(conn, "whoami_args", map[string]interface{}{"name": "web"}, true, "nobody", "sudo")
+	if err != nil {
+		t.Fatalf("Execute() error = %v", err)
+	}
+	if result.Failed || result.Data["user"] != "nobody" {
+		t.Fatalf("Execute() = %+v, want module run as nobody", result)
+	}
+	if got := result.Data["args"]; !reflect.DeepEqual(got, map[string]interface{}{"name": "web"}) {
+		t.Errorf("module args = %v", got)
+	}
+}
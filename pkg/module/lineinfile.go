@@ -1,10 +1,12 @@
 package module
 
 import (
+	"errors"
 	"fmt"
 	"regexp"
 	"strings"
 
+	"github.com/jimyag/ansigo/pkg/agent"
 	"github.com/jimyag/ansigo/pkg/connection"
 )
 
@@ -13,7 +15,7 @@ import (
 type LineinfileModule struct{}
 
 // Execute 执行 lineinfile 模块
-func (m *LineinfileModule) Execute(conn *connection.Connection, args map[string]interface{}, become bool, becomeUser, becomeMethod string) (*Result, error) {
+func (m *LineinfileModule) Execute(conn connection.Connection, args map[string]interface{}, become bool, becomeUser, becomeMethod string) (*Result, error) {
 	result := &Result{}
 
 	// 获取必需参数 path
@@ -74,10 +76,15 @@ func (m *LineinfileModule) Execute(conn *connection.Connection, args map[string]
 		}
 	}
 
+	// 启用了远程 agent 时通过 agent 读写文件，否则使用 shell 命令
+	file := &textFile{conn: conn, path: path}
+	if a := startAgent(conn, args, become, becomeUser, becomeMethod); a != nil {
+		defer a.Close()
+		file.agent = a
+	}
+
 	// 检查文件是否存在
-	checkCmd := fmt.Sprintf("test -f %s", path)
-	checkResult, _ := executeCommand(conn, checkCmd)
-	fileExists := checkResult != nil && checkResult.RC == 0
+	fileExists := file.exists()
 
 	// 如果文件不存在
 	if !fileExists {
@@ -99,12 +106,17 @@ func (m *LineinfileModule) Execute(conn *connection.Connection, args map[string]
 		}
 
 		// 创建文件
-		if state == "present" {
-			touchCmd := fmt.Sprintf("touch %s", path)
-			touchResult, err := executeCommand(conn, touchCmd)
-			if err != nil || touchResult.RC != 0 {
+		if state == "present" && checkMode(args) {
+			result.Changed = true
+			result.Msg = fmt.Sprintf("file %s would be created", path)
+			if diffMode(args) {
+				result.Diff = newDiff(path, "", line+"\n")
+			}
+			return result, nil
+		} else if state == "present" {
+			if err := file.create(); err != nil {
 				result.Failed = true
-				result.Msg = fmt.Sprintf("failed to create file: %s", touchResult.Stderr)
+				result.Msg = fmt.Sprintf("failed to create file: %v", err)
 				return result, nil
 			}
 			fileExists = true
@@ -117,28 +129,106 @@ func (m *LineinfileModule) Execute(conn *connection.Connection, args map[string]
 	}
 
 	// 读取文件内容
-	catCmd := fmt.Sprintf("cat %s", path)
-	catResult, err := executeCommand(conn, catCmd)
-	if err != nil || catResult.RC != 0 {
+	fileContent, err := file.read()
+	if err != nil {
 		result.Failed = true
-		result.Msg = fmt.Sprintf("failed to read file: %s", catResult.Stderr)
+		result.Msg = fmt.Sprintf("failed to read file: %v", err)
 		return result, nil
 	}
 
-	fileContent := catResult.Stdout
-	lines := strings.Split(fileContent, "\n")
-
 	// 处理 state=absent
 	if state == "absent" {
-		return m.ensureAbsent(conn, path, lines, line, regexpCompiled, result)
+		return m.ensureAbsent(file, fileContent, line, regexpCompiled, args, result)
 	}
 
 	// 处理 state=present
-	return m.ensurePresent(conn, path, lines, line, regexpCompiled, args, result)
+	return m.ensurePresent(file, fileContent, line, regexpCompiled, args, result)
+}
+
+// textFile lineinfile 处理的远程文本文件：启用了远程 agent 时通过 agent 读写，否则使用 shell 命令
+// read 返回的内容去掉了末尾的换行，write 写入时再补上
+type textFile struct {
+	conn  connection.Connection
+	agent *remoteAgent
+	path  string
+}
+
+// exists 检查文件是否存在
+func (f *textFile) exists() bool {
+	if f.agent != nil {
+		info, err := f.agent.Stat(f.path)
+		return err == nil && info.Exists && !info.IsDir
+	}
+	checkResult, _ := executeCommand(f.conn, fmt.Sprintf("test -f %s", f.path))
+	return checkResult != nil && checkResult.RC == 0
+}
+
+// create 创建空文件
+func (f *textFile) create() error {
+	if f.agent != nil {
+		_, err := f.agent.Write(f.path, nil, agent.Attributes{})
+		return err
+	}
+	return runFileCommand(f.conn, fmt.Sprintf("touch %s", f.path))
+}
+
+// read 读取文件内容
+func (f *textFile) read() (string, error) {
+	if f.agent != nil {
+		content, err := f.agent.Read(f.path)
+		return strings.TrimRight(string(content), "\n"), err
+	}
+	catResult, err := executeCommand(f.conn, fmt.Sprintf("cat %s", f.path))
+	if err != nil {
+		return "", err
+	}
+	if catResult.RC != 0 {
+		return "", errors.New(catResult.Stderr)
+	}
+	return catResult.Stdout, nil
+}
+
+// write 写入文件内容
+func (f *textFile) write(content string) error {
+	if f.agent != nil {
+		_, err := f.agent.Write(f.path, []byte(content+"\n"), agent.Attributes{})
+		return err
+	}
+	return runFileCommand(f.conn, fmt.Sprintf("cat > %s << 'ANSIGO_LINEINFILE_EOF'\n%s\nANSIGO_LINEINFILE_EOF", f.path, content))
+}
+
+// runFileCommand 执行命令，命令失败时把 stderr 作为错误返回
+func runFileCommand(conn connection.Connection, cmd string) error {
+	cmdResult, err := executeCommand(conn, cmd)
+	if err != nil {
+		return err
+	}
+	if cmdResult.RC != 0 {
+		return errors.New(cmdResult.Stderr)
+	}
+	return nil
+}
+
+// splitLines 把文件内容按行拆分，空文件没有任何行
+func splitLines(content string) []string {
+	if content == "" {
+		return nil
+	}
+	return strings.Split(content, "\n")
+}
+
+// fileText 把按行拆分处理的文件内容还原为写入文件的文本（非空时以换行结尾），用于 diff
+func fileText(content string) string {
+	if content == "" {
+		return ""
+	}
+	return content + "\n"
 }
 
 // ensurePresent 确保行存在
-func (m *LineinfileModule) ensurePresent(conn *connection.Connection, path string, lines []string, line string, regexpCompiled *regexp.Regexp, args map[string]interface{}, result *Result) (*Result, error) {
+func (m *LineinfileModule) ensurePresent(file *textFile, fileContent string, line string, regexpCompiled *regexp.Regexp, args map[string]interface{}, result *Result) (*Result, error) {
+	lines := splitLines(fileContent)
+
 	// 查找匹配的行
 	matchedLineIndex := -1
 	if regexpCompiled != nil {
@@ -221,24 +311,31 @@ func (m *LineinfileModule) ensurePresent(conn *connection.Connection, path strin
 		result.Changed = true
 	}
 
+	newContent := strings.Join(lines, "\n")
+	if diffMode(args) {
+		result.Diff = newDiff(file.path, fileText(fileContent), fileText(newContent))
+	}
+
+	if checkMode(args) {
+		result.Msg = "line would be added or modified"
+		return result, nil
+	}
+
 	// 写回文件
-	if result.Changed {
-		newContent := strings.Join(lines, "\n")
-		writeCmd := fmt.Sprintf("cat > %s << 'ANSIGO_LINEINFILE_EOF'\n%s\nANSIGO_LINEINFILE_EOF", path, newContent)
-		writeResult, err := executeCommand(conn, writeCmd)
-		if err != nil || writeResult.RC != 0 {
-			result.Failed = true
-			result.Msg = fmt.Sprintf("failed to write file: %s", writeResult.Stderr)
-			return result, nil
-		}
-		result.Msg = "line added or modified"
+	if err := file.write(newContent); err != nil {
+		result.Failed = true
+		result.Msg = fmt.Sprintf("failed to write file: %v", err)
+		return result, nil
 	}
+	result.Msg = "line added or modified"
 
 	return result, nil
 }
 
 // ensureAbsent 确保行不存在
-func (m *LineinfileModule) ensureAbsent(conn *connection.Connection, path string, lines []string, line string, regexpCompiled *regexp.Regexp, result *Result) (*Result, error) {
+func (m *LineinfileModule) ensureAbsent(file *textFile, fileContent string, line string, regexpCompiled *regexp.Regexp, args map[string]interface{}, result *Result) (*Result, error) {
+	lines := splitLines(fileContent)
+
 	// 查找并删除匹配的行
 	newLines := []string{}
 	removed := false
@@ -267,13 +364,21 @@ func (m *LineinfileModule) ensureAbsent(conn *connection.Connection, path string
 		return result, nil
 	}
 
-	// 写回文件
 	newContent := strings.Join(newLines, "\n")
-	writeCmd := fmt.Sprintf("cat > %s << 'ANSIGO_LINEINFILE_EOF'\n%s\nANSIGO_LINEINFILE_EOF", path, newContent)
-	writeResult, err := executeCommand(conn, writeCmd)
-	if err != nil || writeResult.RC != 0 {
+	if diffMode(args) {
+		result.Diff = newDiff(file.path, fileText(fileContent), fileText(newContent))
+	}
+
+	if checkMode(args) {
+		result.Changed = true
+		result.Msg = "line would be removed"
+		return result, nil
+	}
+
+	// 写回文件
+	if err := file.write(newContent); err != nil {
 		result.Failed = true
-		result.Msg = fmt.Sprintf("failed to write file: %s", writeResult.Stderr)
+		result.Msg = fmt.Sprintf("failed to write file: %v", err)
 		return result, nil
 	}
 
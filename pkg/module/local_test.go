@@ -0,0 +1,316 @@
+package module
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/jimyag/ansigo/pkg/connection"
+	"github.com/jimyag/ansigo/pkg/inventory"
+)
+
+// localConn 返回在本机执行的连接，用于不依赖 SSH 服务器测试模块
+func localConn() connection.Connection {
+	return connection.NewLocalConnection(&inventory.Host{Name: "localhost"})
+}
+
+// runModule 执行模块两次，返回两次的结果（用于检查幂等性）
+func runModule(t *testing.T, name string, args map[string]interface{}) (first, second *Result) {
+	t.Helper()
+
+	executor := NewExecutor()
+	for i, res := range []**Result{&first, &second} {
+		copied := make(map[string]interface{}, len(args))
+		for k, v := range args {
+			copied[k] = v
+		}
+		result, err := executor.Execute(localConn(), name, copied, false, "", "")
+		if err != nil {
+			t.Fatalf("%s run %d error = %v", name, i+1, err)
+		}
+		if result.Failed {
+			t.Fatalf("%s run %d failed: %s", name, i+1, result.Msg)
+		}
+		*res = result
+	}
+	return first, second
+}
+
+func TestLocalModules(t *testing.T) {
+	dir := t.TempDir()
+
+	t.Run("copy", func(t *testing.T) {
+		dest := filepath.Join(dir, "copy.txt")
+		first, second := runModule(t, "copy", map[string]interface{}{
+			"content": "hello\n",
+			"dest":    dest,
+			"mode":    "0640",
+		})
+		if !first.Changed || second.Changed {
+			t.Errorf("changed = %v, %v, want true, false", first.Changed, second.Changed)
+		}
+		assertLocalFile(t, dest, "hello\n", 0o640)
+	})
+
+	t.Run("template", func(t *testing.T) {
+		dest := filepath.Join(dir, "app.conf")
+		first, second := runModule(t, "template", map[string]interface{}{
+			"_rendered_content": "port = 8080\n",
+			"dest":              dest,
+		})
+		if !first.Changed || second.Changed {
+			t.Errorf("changed = %v, %v, want true, false", first.Changed, second.Changed)
+		}
+		assertLocalFile(t, dest, "port = 8080\n", 0o644)
+	})
+
+	t.Run("template setuid", func(t *testing.T) {
+		dest := filepath.Join(dir, "tool")
+		first, second := runModule(t, "template", map[string]interface{}{
+			"_rendered_content": "#!/bin/sh\n",
+			"dest":              dest,
+			"mode":              "04755",
+		})
+		if !first.Changed || second.Changed {
+			t.Errorf("changed = %v, %v, want true, false", first.Changed, second.Changed)
+		}
+		if info, err := os.Stat(dest); err != nil || info.Mode() != os.ModeSetuid|0o755 {
+			t.Errorf("%s mode = %v, %v, want setuid 0755", dest, info.Mode(), err)
+		}
+	})
+
+	t.Run("file directory", func(t *testing.T) {
+		path := filepath.Join(dir, "sub", "dir")
+		first, second := runModule(t, "file", map[string]interface{}{
+			"path":  path,
+			"state": "directory",
+		})
+		if !first.Changed || second.Changed {
+			t.Errorf("changed = %v, %v, want true, false", first.Changed, second.Changed)
+		}
+		if info, err := os.Stat(path); err != nil || !info.IsDir() {
+			t.Errorf("%s is not a directory: %v", path, err)
+		}
+	})
+
+	t.Run("lineinfile", func(t *testing.T) {
+		path := filepath.Join(dir, "hosts")
+		if err := os.WriteFile(path, []byte("127.0.0.1 localhost\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		first, second := runModule(t, "lineinfile", map[string]interface{}{
+			"path":   path,
+			"regexp": "^10\\.0\\.0\\.5 ",
+			"line":   "10.0.0.5 lb1",
+		})
+		if !first.Changed || second.Changed {
+			t.Errorf("changed = %v, %v, want true, false", first.Changed, second.Changed)
+		}
+		assertLocalFile(t, path, "127.0.0.1 localhost\n10.0.0.5 lb1\n", 0o644)
+	})
+
+	t.Run("file mode", func(t *testing.T) {
+		path := filepath.Join(dir, "secret")
+		if err := os.WriteFile(path, []byte("s3cret"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		first, second := runModule(t, "file", map[string]interface{}{
+			"path": path,
+			"mode": "0600",
+		})
+		if !first.Changed || second.Changed {
+			t.Errorf("changed = %v, %v, want true, false", first.Changed, second.Changed)
+		}
+		assertLocalFile(t, path, "s3cret", 0o600)
+	})
+
+	t.Run("command", func(t *testing.T) {
+		result, err := NewExecutor().Execute(localConn(), "command", map[string]interface{}{
+			"_raw_params": "echo hi",
+		}, false, "", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Failed || result.Stdout != "hi" {
+			t.Errorf("command result = %+v", result)
+		}
+	})
+
+	t.Run("command creates", func(t *testing.T) {
+		marker := filepath.Join(dir, "marker")
+		first, second := runModule(t, "command", map[string]interface{}{
+			"_raw_params": "touch marker",
+			"chdir":       dir,
+			"creates":     "marker",
+		})
+		if !first.Changed || second.Changed {
+			t.Errorf("changed = %v, %v, want true, false", first.Changed, second.Changed)
+		}
+		if _, err := os.Stat(marker); err != nil {
+			t.Errorf("command did not run: %v", err)
+		}
+	})
+}
+
+func TestLocalModulesCheckMode(t *testing.T) {
+	dir := t.TempDir()
+	existing := filepath.Join(dir, "existing.conf")
+	if err := os.WriteFile(existing, []byte("port = 8080\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	missing := filepath.Join(dir, "missing")
+
+	tests := []struct {
+		name        string
+		module      string
+		args        map[string]interface{}
+		wantChanged bool
+		wantSkipped bool
+	}{
+		{name: "copy new file", module: "copy", args: map[string]interface{}{"content": "hello\n", "dest": missing}, wantChanged: true},
+		{name: "copy same content", module: "copy", args: map[string]interface{}{"content": "port = 8080\n", "dest": existing, "mode": "0644"}},
+		{name: "copy mode differs", module: "copy", args: map[string]interface{}{"content": "port = 8080\n", "dest": existing, "mode": "0600"}, wantChanged: true},
+		{name: "template same content", module: "template", args: map[string]interface{}{"_rendered_content": "port = 8080\n", "dest": existing}},
+		{name: "template new content", module: "template", args: map[string]interface{}{"_rendered_content": "port = 9090\n", "dest": existing}, wantChanged: true},
+		{name: "file directory", module: "file", args: map[string]interface{}{"path": missing, "state": "directory"}, wantChanged: true},
+		{name: "file absent", module: "file", args: map[string]interface{}{"path": existing, "state": "absent"}, wantChanged: true},
+		{name: "file touch", module: "file", args: map[string]interface{}{"path": missing, "state": "touch"}, wantChanged: true},
+		{name: "file mode", module: "file", args: map[string]interface{}{"path": existing, "mode": "0600"}, wantChanged: true},
+		{name: "file link", module: "file", args: map[string]interface{}{"path": missing, "src": existing, "state": "link"}, wantChanged: true},
+		{name: "lineinfile add", module: "lineinfile", args: map[string]interface{}{"path": existing, "line": "host = 0.0.0.0"}, wantChanged: true},
+		{name: "lineinfile present", module: "lineinfile", args: map[string]interface{}{"path": existing, "regexp": "^port", "line": "port = 8080"}},
+		{name: "lineinfile remove", module: "lineinfile", args: map[string]interface{}{"path": existing, "regexp": "^port", "state": "absent"}, wantChanged: true},
+		{name: "lineinfile create", module: "lineinfile", args: map[string]interface{}{"path": missing, "line": "a", "create": true}, wantChanged: true},
+		{name: "command", module: "command", args: map[string]interface{}{"_raw_params": "touch " + missing}, wantSkipped: true},
+		{name: "shell", module: "shell", args: map[string]interface{}{"_raw_params": "echo hi > " + missing}, wantSkipped: true},
+		{name: "raw", module: "raw", args: map[string]interface{}{"_raw_params": "touch " + missing}, wantSkipped: true},
+		{name: "command creates", module: "command", args: map[string]interface{}{"_raw_params": "touch " + missing, "creates": missing}, wantChanged: true},
+		{name: "command creates exists", module: "command", args: map[string]interface{}{"_raw_params": "rm " + existing, "creates": existing}},
+		{name: "shell removes missing", module: "shell", args: map[string]interface{}{"_raw_params": "rm " + missing, "removes": missing}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.args[CheckModeArg] = true
+			result, err := NewExecutor().Execute(localConn(), tt.module, tt.args, false, "", "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.Failed {
+				t.Fatalf("%s failed: %s", tt.module, result.Msg)
+			}
+			if result.Changed != tt.wantChanged || result.Skipped != tt.wantSkipped {
+				t.Errorf("changed, skipped = %v, %v, want %v, %v (msg: %s)",
+					result.Changed, result.Skipped, tt.wantChanged, tt.wantSkipped, result.Msg)
+			}
+
+			// check 模式不修改主机
+			assertLocalFile(t, existing, "port = 8080\n", 0o644)
+			if _, err := os.Lstat(missing); !os.IsNotExist(err) {
+				t.Errorf("%s was created in check mode", missing)
+			}
+		})
+	}
+}
+
+// assertLocalFile 检查文件内容和权限
+func assertLocalFile(t *testing.T, path, content string, mode os.FileMode) {
+	t.Helper()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read %s: %v", path, err)
+	}
+	if string(data) != content {
+		t.Errorf("%s = %q, want %q", path, data, content)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != mode {
+		t.Errorf("%s mode = %04o, want %04o", path, info.Mode().Perm(), mode)
+	}
+}
+
+func TestLocalModulesDiff(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.conf")
+
+	tests := []struct {
+		name       string
+		module     string
+		args       map[string]interface{}
+		wantBefore string
+		wantAfter  string
+	}{
+		{
+			name:       "template",
+			module:     "template",
+			args:       map[string]interface{}{"_rendered_content": "port = 9090\nhost = 0.0.0.0\n", "dest": path},
+			wantBefore: "port = 8080\nhost = 0.0.0.0\n",
+			wantAfter:  "port = 9090\nhost = 0.0.0.0\n",
+		},
+		{
+			name:       "copy",
+			module:     "copy",
+			args:       map[string]interface{}{"content": "port = 9090\n", "dest": path},
+			wantBefore: "port = 8080\nhost = 0.0.0.0\n",
+			wantAfter:  "port = 9090\n",
+		},
+		{
+			name:       "lineinfile",
+			module:     "lineinfile",
+			args:       map[string]interface{}{"path": path, "regexp": "^port", "line": "port = 9090"},
+			wantBefore: "port = 8080\nhost = 0.0.0.0\n",
+			wantAfter:  "port = 9090\nhost = 0.0.0.0\n",
+		},
+		{
+			name:       "lineinfile absent",
+			module:     "lineinfile",
+			args:       map[string]interface{}{"path": path, "regexp": "^host", "state": "absent"},
+			wantBefore: "port = 8080\nhost = 0.0.0.0\n",
+			wantAfter:  "port = 8080\n",
+		},
+	}
+
+	for _, tt := range tests {
+		for _, check := range []bool{true, false} {
+			t.Run(fmt.Sprintf("%s check=%v", tt.name, check), func(t *testing.T) {
+				if err := os.WriteFile(path, []byte("port = 8080\nhost = 0.0.0.0\n"), 0o644); err != nil {
+					t.Fatal(err)
+				}
+
+				args := map[string]interface{}{DiffArg: true, CheckModeArg: check}
+				for k, v := range tt.args {
+					args[k] = v
+				}
+				result, err := NewExecutor().Execute(localConn(), tt.module, args, false, "", "")
+				if err != nil {
+					t.Fatal(err)
+				}
+				if result.Failed || !result.Changed {
+					t.Fatalf("result = %+v, want changed", result)
+				}
+				want := &Diff{Before: tt.wantBefore, After: tt.wantAfter, BeforeHeader: path, AfterHeader: path}
+				if !reflect.DeepEqual(result.Diff, want) {
+					t.Errorf("Diff = %+v, want %+v", result.Diff, want)
+				}
+
+				wantContent := tt.wantAfter
+				if check {
+					wantContent = tt.wantBefore
+				}
+				assertLocalFile(t, path, wantContent, 0o644)
+			})
+		}
+	}
+
+	// 没有 --diff 时不返回 Diff
+	first, _ := runModule(t, "template", map[string]interface{}{"_rendered_content": "x\n", "dest": path})
+	if first.Diff != nil {
+		t.Errorf("Diff = %+v without diff mode, want nil", first.Diff)
+	}
+}
@@ -0,0 +1,461 @@
+package module
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jimyag/ansigo/pkg/connection"
+	"github.com/jimyag/ansigo/pkg/facts"
+)
+
+// PkgMgrArg runner 传给模块的内部参数，值是目标主机的包管理器（apt、dnf、yum、apk、zypper），
+// 来自 ansible_pkg_mgr 或 ansible_os_family facts；没有时 package 模块在目标主机上检测
+const PkgMgrArg = "_ansible_pkg_mgr"
+
+// osFamilyPkgMgrs 没有 ansible_pkg_mgr 时按 ansible_os_family 选择的包管理器
+// RedHat 系列可能是 dnf 也可能是 yum，在目标主机上检测
+var osFamilyPkgMgrs = map[string]string{
+	"Debian": "apt",
+	"Alpine": "apk",
+	"Suse":   "zypper",
+}
+
+// pkgMgrFromFacts 根据 ansible_pkg_mgr 和 ansible_os_family 返回包管理器，无法确定时返回空字符串
+func pkgMgrFromFacts(vars map[string]interface{}) string {
+	if pkgMgr, ok := vars["ansible_pkg_mgr"].(string); ok && pkgMgr != "" && pkgMgr != "unknown" {
+		return pkgMgr
+	}
+	family, _ := vars["ansible_os_family"].(string)
+	return osFamilyPkgMgrs[family]
+}
+
+// packageTimeout 包管理器命令的超时，安装、升级和更新缓存可能需要下载很多数据，远超普通命令的 30 秒
+const packageTimeout = time.Hour
+
+// packageManager 包管理器后端使用的命令，安装、删除和升级命令后面加上包名
+type packageManager struct {
+	// installed 检查一个包是否已安装的命令，包名是 shell 变量 $p，已安装时退出状态为 0
+	// 包名可以带包管理器支持的版本（如 apt 的 nginx=1.18.0-1），这时只有安装了这个版本才算已安装
+	installed string
+	// checkName 检查包名的写法是否支持，为 nil 时不检查
+	checkName func(name string) error
+	install   string
+	remove    string
+	upgrade   string
+	// upgradable 检查已安装的包是否有新版本的命令（后面加上包名），hasUpgrades 根据结果判断
+	upgradable  string
+	hasUpgrades func(r *execResult) (bool, error)
+	updateCache string
+	// cachePaths 更新缓存时修改的文件或目录，cache_valid_time 根据它们中最新的修改时间判断缓存是否过期
+	cachePaths []string
+}
+
+// rpmInstalled rpm 系列包管理器检查包是否已安装（包名也可以是包提供的功能）
+// rpm -q 本身支持 name-version 和 name-version-release 形式的包名
+const rpmInstalled = `rpm -q --quiet "$p" || rpm -q --quiet --whatprovides "$p"`
+
+// aptInstalled 检查包是否已安装，name=version 时比较版本（版本可以使用 apt 支持的 * 通配符）
+const aptInstalled = `n=${p%%=*}; v=${p#"$n"}; v=${v#=}; ` +
+	`s=$(dpkg-query -W -f='${Status} ${Version}\n' "$n" 2>/dev/null) && case "$s" in *" installed "${v:-*}) true ;; *) false ;; esac`
+
+// rejectVersionComparisons 拒绝带 <、> 版本比较的包名：无法判断已安装的版本是否满足条件
+func rejectVersionComparisons(usage string) func(name string) error {
+	return func(name string) error {
+		if strings.ContainsAny(name, "<>") {
+			return fmt.Errorf("version comparisons are not supported in %q, use %s", name, usage)
+		}
+		return nil
+	}
+}
+
+// packageManagers 支持的包管理器后端
+var packageManagers = map[string]*packageManager{
+	"apt": {
+		installed:   aptInstalled,
+		checkName:   rejectVersionComparisons("name=version"),
+		install:     "DEBIAN_FRONTEND=noninteractive apt-get install -y -q",
+		remove:      "DEBIAN_FRONTEND=noninteractive apt-get remove -y -q",
+		upgrade:     "DEBIAN_FRONTEND=noninteractive apt-get install -y -q --only-upgrade",
+		upgradable:  "apt-get install -s --only-upgrade",
+		hasUpgrades: outputMatches(regexp.MustCompile(`(?m)^Inst `)),
+		// apt-get update 不一定修改 lists 目录，更新成功后记录时间
+		updateCache: "apt-get update -q && mkdir -p /var/lib/apt/periodic && touch /var/lib/apt/periodic/update-success-stamp",
+		cachePaths:  []string{"/var/lib/apt/periodic/update-success-stamp", "/var/lib/apt/lists"},
+	},
+	"dnf": rpmPackageManager("dnf", "upgrade", "/var/cache/dnf"),
+	"yum": rpmPackageManager("yum", "update", "/var/cache/yum"),
+	"apk": {
+		installed:   `apk info -e "$p" >/dev/null`,
+		install:     "apk add -q",
+		remove:      "apk del -q",
+		upgrade:     "apk upgrade -q",
+		upgradable:  "apk upgrade -s",
+		hasUpgrades: outputMatches(regexp.MustCompile(`(?m)^\(\d+/\d+\) Upgrading `)),
+		updateCache: "apk update -q",
+		cachePaths:  []string{"/var/cache/apk"},
+	},
+	"zypper": {
+		// zypper 的 name=version 转换为 rpm -q 使用的 name-version
+		installed:  `q=$p; case "$q" in *=*) q="${q%%=*}-${q#*=}" ;; esac; rpm -q --quiet "$q" || rpm -q --quiet --whatprovides "$q"`,
+		checkName:  rejectVersionComparisons("name=version"),
+		install:    "zypper --non-interactive install",
+		remove:     "zypper --non-interactive remove",
+		upgrade:    "zypper --non-interactive update",
+		upgradable: "zypper --non-interactive update --dry-run",
+		hasUpgrades: func(r *execResult) (bool, error) {
+			if r.RC != 0 {
+				return false, errors.New(commandOutput(r))
+			}
+			return !strings.Contains(r.Stdout, "Nothing to do"), nil
+		},
+		updateCache: "zypper --non-interactive refresh",
+		cachePaths:  []string{"/var/cache/zypp/raw"},
+	},
+}
+
+// rpmPackageManager 返回 dnf 或 yum 后端，它们的命令行相同
+// check-update 有可用更新时退出状态为 100
+func rpmPackageManager(command, upgrade, cacheDir string) *packageManager {
+	return &packageManager{
+		installed: rpmInstalled,
+		checkName: func(name string) error {
+			if strings.HasPrefix(name, "@") {
+				return fmt.Errorf("package groups and modules are not supported: %s", name)
+			}
+			if strings.ContainsAny(name, "<>=") {
+				return fmt.Errorf("version comparisons are not supported in %q, use name-version", name)
+			}
+			return nil
+		},
+		install:    command + " install -y -q",
+		remove:     command + " remove -y -q",
+		upgrade:    command + " " + upgrade + " -y -q",
+		upgradable: command + " check-update -q",
+		hasUpgrades: func(r *execResult) (bool, error) {
+			switch r.RC {
+			case 0:
+				return false, nil
+			case 100:
+				return true, nil
+			}
+			return false, errors.New(commandOutput(r))
+		},
+		updateCache: command + " makecache -q",
+		cachePaths:  []string{cacheDir},
+	}
+}
+
+// outputMatches 返回根据模拟执行的输出判断是否有可用更新的函数
+func outputMatches(pattern *regexp.Regexp) func(r *execResult) (bool, error) {
+	return func(r *execResult) (bool, error) {
+		if r.RC != 0 {
+			return false, errors.New(commandOutput(r))
+		}
+		return pattern.MatchString(r.Stdout), nil
+	}
+}
+
+// PackageModule package、apt、dnf、yum、apk 和 zypper 模块实现
+// Manager 为空（package 模块）时按 use 参数、ansible_pkg_mgr/ansible_os_family facts 选择包管理器，
+// 都没有时在目标主机上检测；只有安装、删除或升级了包时才报告 changed
+type PackageModule struct {
+	Manager string
+}
+
+// packageRun 一次模块执行使用的连接和权限提升设置
+type packageRun struct {
+	conn         connection.Connection
+	become       bool
+	becomeUser   string
+	becomeMethod string
+}
+
+// run 执行命令，超时是 packageTimeout
+func (r *packageRun) run(cmd string) (*execResult, error) {
+	var stdout, stderr []byte
+	var exitCode int
+	var err error
+	if r.become {
+		stdout, stderr, exitCode, err = r.conn.ExecWithBecomeTimeout(cmd, r.becomeUser, r.becomeMethod, packageTimeout)
+	} else {
+		stdout, stderr, exitCode, err = r.conn.ExecWithTimeout(cmd, packageTimeout)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &execResult{
+		RC:     exitCode,
+		Stdout: strings.TrimSpace(string(stdout)),
+		Stderr: strings.TrimSpace(string(stderr)),
+	}, nil
+}
+
+// Execute 执行包管理模块
+func (m *PackageModule) Execute(conn connection.Connection, args map[string]interface{}, become bool, becomeUser, becomeMethod string) (*Result, error) {
+	result := &Result{}
+	r := &packageRun{conn: conn, become: become, becomeUser: becomeUser, becomeMethod: becomeMethod}
+
+	pkgMgr := m.pkgMgr(conn, args)
+	mgr, ok := packageManagers[pkgMgr]
+	if !ok {
+		result.Failed = true
+		result.Msg = fmt.Sprintf("could not find a supported package manager (found %q), use one of: apt, dnf, yum, apk, zypper", pkgMgr)
+		return result, nil
+	}
+
+	names := packageNames(args["name"])
+	if mgr.checkName != nil {
+		for _, name := range names {
+			if err := mgr.checkName(name); err != nil {
+				result.Failed = true
+				result.Msg = err.Error()
+				return result, nil
+			}
+		}
+	}
+	state, _ := args["state"].(string)
+	switch state {
+	case "", "installed":
+		state = "present"
+	case "removed":
+		state = "absent"
+	}
+	check := checkMode(args)
+
+	result.Data = map[string]interface{}{
+		"pkg_mgr":       pkgMgr,
+		"cache_updated": false,
+	}
+
+	// cache_valid_time 隐含 update_cache
+	cacheValidTime, _ := args["cache_valid_time"].(int)
+	update, _ := args["update_cache"].(bool)
+	if (update || cacheValidTime > 0) && !check {
+		updated, err := r.updateCache(mgr, cacheValidTime)
+		if err != nil {
+			result.Failed = true
+			result.Msg = fmt.Sprintf("failed to update package cache: %v", err)
+			return result, nil
+		}
+		result.Data["cache_updated"] = updated
+	}
+
+	if len(names) == 0 {
+		result.Msg = "package cache is up to date"
+		if updated, _ := result.Data["cache_updated"].(bool); updated {
+			result.Msg = "package cache updated"
+		}
+		return result, nil
+	}
+
+	installed, err := r.installedPackages(mgr, names)
+	if err != nil {
+		result.Failed = true
+		result.Msg = fmt.Sprintf("failed to query installed packages: %v", err)
+		return result, nil
+	}
+	var present, missing []string
+	for _, name := range names {
+		if installed[name] {
+			present = append(present, name)
+		} else {
+			missing = append(missing, name)
+		}
+	}
+
+	// 需要执行的操作，key 是结果中记录对应包的字段
+	type action struct {
+		key     string
+		verb    string
+		command string
+		names   []string
+	}
+	var actions []action
+	switch state {
+	case "present":
+		if len(missing) > 0 {
+			actions = append(actions, action{"installed", "install", mgr.install, missing})
+		}
+	case "latest":
+		if len(missing) > 0 {
+			actions = append(actions, action{"installed", "install", mgr.install, missing})
+		}
+		if len(present) > 0 {
+			hasUpgrades, err := r.hasUpgrades(mgr, present)
+			if err != nil {
+				result.Failed = true
+				result.Msg = fmt.Sprintf("failed to check for package upgrades: %v", err)
+				return result, nil
+			}
+			if hasUpgrades {
+				actions = append(actions, action{"upgraded", "upgrade", mgr.upgrade, present})
+			}
+		}
+	case "absent":
+		if len(present) > 0 {
+			actions = append(actions, action{"removed", "remove", mgr.remove, present})
+		}
+	default:
+		result.Failed = true
+		result.Msg = fmt.Sprintf("invalid state: %s (must be present/absent/latest)", state)
+		return result, nil
+	}
+
+	if len(actions) == 0 {
+		result.Msg = fmt.Sprintf("all packages are %s", unchangedPackageStates[state])
+		return result, nil
+	}
+
+	var msgs []string
+	for _, a := range actions {
+		if !check {
+			cmdResult, err := r.run(a.command + " " + shellQuoteAll(a.names))
+			if err != nil {
+				result.Failed = true
+				result.Msg = err.Error()
+				return result, nil
+			}
+			result.Stdout = cmdResult.Stdout
+			result.Stderr = cmdResult.Stderr
+			result.RC = cmdResult.RC
+			if cmdResult.RC != 0 {
+				result.Failed = true
+				result.Msg = fmt.Sprintf("failed to %s %s: %s", a.verb, strings.Join(a.names, ", "), commandOutput(cmdResult))
+				return result, nil
+			}
+		}
+		// 前面的操作成功、后面的操作失败时，结果中也记录已经修改的包
+		result.Changed = true
+		result.Data[a.key] = a.names
+		msgs = append(msgs, fmt.Sprintf("%s: %s", a.key, strings.Join(a.names, ", ")))
+	}
+	result.Msg = strings.Join(msgs, "; ")
+	if check {
+		result.Msg = "would be " + result.Msg
+	}
+	return result, nil
+}
+
+// unchangedPackageStates 没有需要安装、删除或升级的包时，结果消息中包的状态
+var unchangedPackageStates = map[string]string{
+	"present": "installed",
+	"latest":  "up to date",
+	"absent":  "absent",
+}
+
+// pkgMgr 返回模块使用的包管理器
+func (m *PackageModule) pkgMgr(conn connection.Connection, args map[string]interface{}) string {
+	if m.Manager != "" {
+		return m.Manager
+	}
+	if use, ok := args["use"].(string); ok && use != "" && use != "auto" {
+		return use
+	}
+	if pkgMgr, ok := args[PkgMgrArg].(string); ok && pkgMgr != "" {
+		return pkgMgr
+	}
+	return facts.DetectPkgMgr(conn)
+}
+
+// installedPackages 返回 names 中已安装的包
+func (r *packageRun) installedPackages(mgr *packageManager, names []string) (map[string]bool, error) {
+	query, err := r.run(fmt.Sprintf(`for p in %s; do if %s; then echo "$p"; fi; done`, shellQuoteAll(names), mgr.installed))
+	if err != nil {
+		return nil, err
+	}
+	if query.RC != 0 {
+		return nil, errors.New(commandOutput(query))
+	}
+	installed := make(map[string]bool)
+	for _, name := range strings.Split(query.Stdout, "\n") {
+		if name != "" {
+			installed[name] = true
+		}
+	}
+	return installed, nil
+}
+
+// hasUpgrades 检查已安装的包是否有新版本
+func (r *packageRun) hasUpgrades(mgr *packageManager, names []string) (bool, error) {
+	upgradable, err := r.run(mgr.upgradable + " " + shellQuoteAll(names))
+	if err != nil {
+		return false, err
+	}
+	return mgr.hasUpgrades(upgradable)
+}
+
+// updateCache 更新包缓存，cacheValidTime 大于 0 并且缓存在这么多秒内更新过时不更新，返回是否更新了缓存
+func (r *packageRun) updateCache(mgr *packageManager, cacheValidTime int) (bool, error) {
+	if cacheValidTime > 0 {
+		// 第一行是当前时间，之后是缓存文件的修改时间
+		stat, err := r.run(fmt.Sprintf("date +%%s; stat -c %%Y %s 2>/dev/null", shellQuoteAll(mgr.cachePaths)))
+		if err != nil {
+			return false, err
+		}
+		times := strings.Fields(stat.Stdout)
+		if len(times) > 1 {
+			now, _ := strconv.ParseInt(times[0], 10, 64)
+			var newest int64
+			for _, t := range times[1:] {
+				if mtime, err := strconv.ParseInt(t, 10, 64); err == nil && mtime > newest {
+					newest = mtime
+				}
+			}
+			if now-newest < int64(cacheValidTime) {
+				return false, nil
+			}
+		}
+	}
+
+	update, err := r.run(mgr.updateCache)
+	if err != nil {
+		return false, err
+	}
+	if update.RC != 0 {
+		return false, errors.New(commandOutput(update))
+	}
+	return true, nil
+}
+
+// commandOutput 返回命令失败时的错误输出，没有标准错误输出时使用标准输出
+func commandOutput(r *execResult) string {
+	if r.Stderr != "" {
+		return r.Stderr
+	}
+	return r.Stdout
+}
+
+// packageNames 把 name 参数（字符串、逗号分隔的字符串或列表）转换为包名列表
+func packageNames(value interface{}) []string {
+	var names []string
+	switch v := value.(type) {
+	case string:
+		for _, name := range strings.Split(v, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				names = append(names, name)
+			}
+		}
+	case []interface{}:
+		for _, item := range v {
+			if name := strings.TrimSpace(fmt.Sprint(item)); name != "" {
+				names = append(names, name)
+			}
+		}
+	case []string:
+		names = append(names, v...)
+	}
+	return names
+}
+
+// shellQuoteAll 转义每个参数并用空格连接
+func shellQuoteAll(values []string) string {
+	quoted := make([]string, len(values))
+	for i, v := range values {
+		quoted[i] = shellQuote(v)
+	}
+	return strings.Join(quoted, " ")
+}
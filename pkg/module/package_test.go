@@ -0,0 +1,262 @@
+package module
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// fakePackageManager 注册一个用文件记录已安装的包、可升级的包和缓存的包管理器，不修改系统
+func fakePackageManager(t *testing.T, installed, upgrades []string) (state, cache string) {
+	t.Helper()
+
+	dir := t.TempDir()
+	state = filepath.Join(dir, "installed")
+	upgradesFile := filepath.Join(dir, "upgrades")
+	cache = filepath.Join(dir, "cache")
+	for path, lines := range map[string][]string{state: installed, upgradesFile: upgrades} {
+		content := strings.Join(lines, "\n")
+		if content != "" {
+			content += "\n"
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// drop 从文件中删除参数中的包
+	drop := func(file string) string {
+		return `f() { for p; do grep -vx "$p" ` + file + ` > ` + file + `.tmp; mv ` + file + `.tmp ` + file + `; done; }; f`
+	}
+	packageManagers["fake"] = &packageManager{
+		installed:   `grep -qx "$p" ` + state,
+		install:     `f() { for p; do echo "$p" >> ` + state + `; done; }; f`,
+		remove:      drop(state),
+		upgrade:     drop(upgradesFile),
+		upgradable:  `f() { for p; do grep -qx "$p" ` + upgradesFile + ` && echo "Inst $p"; done; true; }; f`,
+		hasUpgrades: outputMatches(regexp.MustCompile(`(?m)^Inst `)),
+		updateCache: "touch " + cache,
+		cachePaths:  []string{cache},
+	}
+	t.Cleanup(func() { delete(packageManagers, "fake") })
+	return state, cache
+}
+
+// runPackage 使用 fake 包管理器执行模块
+func runPackage(t *testing.T, args map[string]interface{}) *Result {
+	t.Helper()
+
+	result, err := (&PackageModule{Manager: "fake"}).Execute(localConn(), args, false, "", "")
+	if err != nil {
+		t.Fatalf("Execute() error = %v", err)
+	}
+	return result
+}
+
+func readLines(t *testing.T, path string) []string {
+	t.Helper()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Fields(string(data))
+}
+
+func TestPackageModule(t *testing.T) {
+	t.Run("present", func(t *testing.T) {
+		state, _ := fakePackageManager(t, []string{"curl"}, nil)
+		args := map[string]interface{}{"name": []interface{}{"curl", "nginx"}, "state": "present"}
+
+		first := runPackage(t, args)
+		if first.Failed || !first.Changed || !reflect.DeepEqual(first.Data["installed"], []string{"nginx"}) {
+			t.Errorf("first run = %+v", first)
+		}
+		second := runPackage(t, args)
+		if second.Failed || second.Changed {
+			t.Errorf("second run = %+v, want unchanged", second)
+		}
+		if got := readLines(t, state); !reflect.DeepEqual(got, []string{"curl", "nginx"}) {
+			t.Errorf("installed = %v", got)
+		}
+	})
+
+	t.Run("absent", func(t *testing.T) {
+		state, _ := fakePackageManager(t, []string{"curl", "nginx"}, nil)
+		args := map[string]interface{}{"name": "nginx,vim", "state": "removed"}
+
+		first := runPackage(t, args)
+		if first.Failed || !first.Changed || !reflect.DeepEqual(first.Data["removed"], []string{"nginx"}) {
+			t.Errorf("first run = %+v", first)
+		}
+		if second := runPackage(t, args); second.Failed || second.Changed {
+			t.Errorf("second run = %+v, want unchanged", second)
+		}
+		if got := readLines(t, state); !reflect.DeepEqual(got, []string{"curl"}) {
+			t.Errorf("installed = %v", got)
+		}
+	})
+
+	t.Run("latest", func(t *testing.T) {
+		fakePackageManager(t, []string{"curl", "nginx"}, []string{"nginx"})
+		args := map[string]interface{}{"name": []interface{}{"curl", "nginx", "vim"}, "state": "latest"}
+
+		first := runPackage(t, args)
+		if first.Failed || !first.Changed {
+			t.Fatalf("first run = %+v", first)
+		}
+		if !reflect.DeepEqual(first.Data["installed"], []string{"vim"}) || !reflect.DeepEqual(first.Data["upgraded"], []string{"curl", "nginx"}) {
+			t.Errorf("first run data = %v", first.Data)
+		}
+		if second := runPackage(t, args); second.Failed || second.Changed {
+			t.Errorf("second run = %+v, want unchanged", second)
+		}
+	})
+
+	t.Run("check mode", func(t *testing.T) {
+		state, _ := fakePackageManager(t, []string{"curl"}, []string{"curl"})
+		for _, st := range []string{"present", "latest", "absent"} {
+			name := "nginx"
+			if st != "present" {
+				name = "curl"
+			}
+			result := runPackage(t, map[string]interface{}{"name": name, "state": st, CheckModeArg: true})
+			if result.Failed || !result.Changed {
+				t.Errorf("state=%s check mode = %+v, want changed", st, result)
+			}
+		}
+		if got := readLines(t, state); !reflect.DeepEqual(got, []string{"curl"}) {
+			t.Errorf("check mode modified installed packages: %v", got)
+		}
+	})
+
+	t.Run("cache", func(t *testing.T) {
+		_, cache := fakePackageManager(t, nil, nil)
+
+		// cache_valid_time 隐含 update_cache，缓存在有效期内时不更新
+		args := map[string]interface{}{"cache_valid_time": 3600}
+		first := runPackage(t, args)
+		if first.Failed || first.Changed || first.Data["cache_updated"] != true {
+			t.Errorf("first run = %+v, want cache updated without change", first)
+		}
+		if _, err := os.Stat(cache); err != nil {
+			t.Errorf("cache not updated: %v", err)
+		}
+		if second := runPackage(t, args); second.Failed || second.Data["cache_updated"] != false {
+			t.Errorf("second run = %+v, want cache still valid", second)
+		}
+		if third := runPackage(t, map[string]interface{}{"update_cache": true}); third.Data["cache_updated"] != true {
+			t.Errorf("update_cache without cache_valid_time = %+v, want cache updated", third)
+		}
+	})
+
+	t.Run("install failure", func(t *testing.T) {
+		fakePackageManager(t, nil, nil)
+		packageManagers["fake"].install = "echo 'E: Unable to locate package' >&2; exit 100; true"
+		result := runPackage(t, map[string]interface{}{"name": "nginx"})
+		if !result.Failed || result.Changed || !strings.Contains(result.Msg, "Unable to locate package") {
+			t.Errorf("result = %+v, want failure", result)
+		}
+	})
+}
+
+func TestPackageModulePkgMgr(t *testing.T) {
+	tests := []struct {
+		name   string
+		module *PackageModule
+		args   map[string]interface{}
+		want   string
+	}{
+		{name: "module", module: &PackageModule{Manager: "apk"}, args: map[string]interface{}{PkgMgrArg: "apt"}, want: "apk"},
+		{name: "use", module: &PackageModule{}, args: map[string]interface{}{"use": "dnf", PkgMgrArg: "apt"}, want: "dnf"},
+		{name: "facts", module: &PackageModule{}, args: map[string]interface{}{"use": "auto", PkgMgrArg: "zypper"}, want: "zypper"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.module.pkgMgr(localConn(), tt.args); got != tt.want {
+				t.Errorf("pkgMgr() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	facts := []struct {
+		vars map[string]interface{}
+		want string
+	}{
+		{vars: map[string]interface{}{"ansible_pkg_mgr": "yum", "ansible_os_family": "RedHat"}, want: "yum"},
+		{vars: map[string]interface{}{"ansible_pkg_mgr": "unknown", "ansible_os_family": "Debian"}, want: "apt"},
+		{vars: map[string]interface{}{"ansible_os_family": "Alpine"}, want: "apk"},
+		{vars: map[string]interface{}{"ansible_os_family": "RedHat"}, want: ""},
+	}
+	for _, tt := range facts {
+		if got := pkgMgrFromFacts(tt.vars); got != tt.want {
+			t.Errorf("pkgMgrFromFacts(%v) = %q, want %q", tt.vars, got, tt.want)
+		}
+	}
+}
+
+func TestPackageModuleVersionSpecs(t *testing.T) {
+	// dpkg-query 只报告已安装的 nginx 1.18.0-1 和已删除但保留配置的 vim
+	bin := t.TempDir()
+	dpkgQuery := `#!/bin/sh
+eval "pkg=\${$#}"
+case "$pkg" in
+nginx) echo "install ok installed 1.18.0-1" ;;
+vim) echo "deinstall ok config-files 2:8.2" ;;
+*) exit 1 ;;
+esac
+`
+	if err := os.WriteFile(filepath.Join(bin, "dpkg-query"), []byte(dpkgQuery), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	// check 模式下不会执行 apt-get，只检查哪些包需要安装
+	tests := []struct {
+		name    string
+		install []string
+	}{
+		{name: "nginx"},
+		{name: "nginx=1.18.0-1"},
+		{name: "nginx=1.18*"},
+		{name: "nginx=1.20.0-1", install: []string{"nginx=1.20.0-1"}},
+		{name: "vim", install: []string{"vim"}},
+		{name: "curl=7.0", install: []string{"curl=7.0"}},
+	}
+	for _, tt := range tests {
+		result, err := (&PackageModule{Manager: "apt"}).Execute(localConn(), map[string]interface{}{"name": tt.name, CheckModeArg: true}, false, "", "")
+		if err != nil {
+			t.Fatalf("Execute(%s) error = %v", tt.name, err)
+		}
+		if result.Failed || result.Changed != (tt.install != nil) {
+			t.Errorf("Execute(%s) = %+v, want changed %v", tt.name, result, tt.install != nil)
+		}
+		if got, _ := result.Data["installed"].([]string); !reflect.DeepEqual(got, tt.install) {
+			t.Errorf("Execute(%s) installed = %v, want %v", tt.name, got, tt.install)
+		}
+	}
+
+	// 无法判断是否已安装的写法直接报错，不会每次都重新安装
+	unsupported := []struct {
+		manager string
+		name    string
+		want    string
+	}{
+		{"apt", "nginx>=1.18", "version comparisons are not supported"},
+		{"dnf", "@web-server", "package groups and modules are not supported"},
+		{"dnf", "nginx >= 1.18", "version comparisons are not supported"},
+		{"zypper", "nginx<2", "version comparisons are not supported"},
+	}
+	for _, tt := range unsupported {
+		result, err := (&PackageModule{Manager: tt.manager}).Execute(localConn(), map[string]interface{}{"name": tt.name}, false, "", "")
+		if err != nil {
+			t.Fatalf("Execute(%s) error = %v", tt.name, err)
+		}
+		if !result.Failed || !strings.Contains(result.Msg, tt.want) {
+			t.Errorf("%s Execute(%s) = %+v, want failure %q", tt.manager, tt.name, result, tt.want)
+		}
+	}
+}
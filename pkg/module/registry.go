@@ -0,0 +1,145 @@
+package module
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"sync"
+
+	"github.com/jimyag/ansigo/pkg/connection"
+)
+
+// Module 模块接口，内置模块和嵌入 ansigo 的程序注册的模块都实现它
+type Module interface {
+	Execute(conn connection.Connection, args map[string]interface{}, become bool, becomeUser, becomeMethod string) (*Result, error)
+}
+
+// ModuleFunc 把函数转换为 Module
+type ModuleFunc func(conn connection.Connection, args map[string]interface{}, become bool, becomeUser, becomeMethod string) (*Result, error)
+
+// Execute 调用 f
+func (f ModuleFunc) Execute(conn connection.Connection, args map[string]interface{}, become bool, becomeUser, becomeMethod string) (*Result, error) {
+	return f(conn, args, become, becomeUser, becomeMethod)
+}
+
+// ArgSpec 模块参数的定义（与 Ansible 模块的 argument_spec 相同）
+type ArgSpec struct {
+	Type     string        // 参数类型：str、int、float、bool、list、dict、path、mode（文件权限）、raw（空表示 raw）
+	Required bool          // 是否必须指定
+	Default  interface{}   // 未指定时的默认值
+	Choices  []interface{} // 允许的值，为空时不限制
+	Aliases  []string      // 参数的其他名称
+}
+
+// Spec 模块的注册信息
+type Spec struct {
+	Name    string             // 模块名，如 copy
+	Aliases []string           // 模块的其他名称，如 FQCN ansible.builtin.copy
+	Args    map[string]ArgSpec // 参数定义，为 nil 时不检查参数；接受自由格式参数的模块定义 _raw_params
+	Module  Module             // 模块实现
+
+	MutuallyExclusive [][]string   // 每组中最多只能指定一个参数
+	RequiredOneOf     [][]string   // 每组中至少要指定一个参数
+	RequiredIf        []RequiredIf // 某个参数取特定值时必须指定的参数
+}
+
+// Registry 模块注册表，按模块名和别名查找模块
+// 没有注册的模块在 library 目录中查找（见 AddLibrary）
+type Registry struct {
+	mu      sync.RWMutex
+	modules map[string]*Spec // 模块名和别名 -> 注册信息
+	library []string         // 查找模块文件的 library 目录，按顺序查找
+}
+
+// NewRegistry 创建一个空的模块注册表
+func NewRegistry() *Registry {
+	return &Registry{modules: make(map[string]*Spec)}
+}
+
+// Register 注册模块，模块名或别名已经被注册时返回错误
+func (r *Registry) Register(spec Spec) error {
+	if spec.Name == "" {
+		return fmt.Errorf("module name must not be empty")
+	}
+	if spec.Module == nil {
+		return fmt.Errorf("module %s has no implementation", spec.Name)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	names := append([]string{spec.Name}, spec.Aliases...)
+	for _, name := range names {
+		if _, exists := r.modules[name]; exists {
+			return fmt.Errorf("module %s is already registered", name)
+		}
+	}
+	for _, name := range names {
+		r.modules[name] = &spec
+	}
+	return nil
+}
+
+// AddLibrary 添加查找模块文件的 library 目录，先添加的目录优先
+func (r *Registry) AddLibrary(dirs ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, dir := range dirs {
+		if dir != "" && !slices.Contains(r.library, dir) {
+			r.library = append(r.library, dir)
+		}
+	}
+}
+
+// Lookup 按模块名或别名查找模块
+// 没有注册的模块在 library 目录中查找模块文件，找到时返回以 JSON 参数协议执行它的 LibraryModule（不检查参数）
+func (r *Registry) Lookup(name string) (*Spec, bool) {
+	r.mu.RLock()
+	spec, ok := r.modules[name]
+	library := r.library
+	r.mu.RUnlock()
+	if ok {
+		return spec, true
+	}
+
+	path, ok := findLibraryModule(library, name)
+	if !ok {
+		return nil, false
+	}
+	return &Spec{Name: name, Module: &LibraryModule{Path: path}}, true
+}
+
+// Names 返回所有模块名（不含别名），按名称排序
+func (r *Registry) Names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var names []string
+	for name, spec := range r.modules {
+		if name == spec.Name {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// DefaultRegistry 默认的模块注册表，包含所有内置模块
+// 嵌入 ansigo 的程序在解析 playbook 之前用 Register 注册自己的模块
+var DefaultRegistry = newBuiltinRegistry()
+
+// Register 在默认注册表中注册模块
+func Register(spec Spec) error {
+	return DefaultRegistry.Register(spec)
+}
+
+// AddLibrary 为默认注册表添加 library 目录
+func AddLibrary(dirs ...string) {
+	DefaultRegistry.AddLibrary(dirs...)
+}
+
+// Lookup 在默认注册表中查找模块
+func Lookup(name string) (*Spec, bool) {
+	return DefaultRegistry.Lookup(name)
+}
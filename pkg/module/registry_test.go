@@ -0,0 +1,55 @@
+package module
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/jimyag/ansigo/pkg/connection"
+)
+
+func TestRegistry(t *testing.T) {
+	r := NewRegistry()
+	echo := ModuleFunc(func(_ connection.Connection, args map[string]interface{}, _ bool, _, _ string) (*Result, error) {
+		return &Result{Msg: args["msg"].(string)}, nil
+	})
+
+	if err := r.Register(Spec{Name: "echo", Aliases: []string{"example.tools.echo"}, Module: echo}); err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+
+	for _, spec := range []Spec{
+		{Name: "echo", Module: echo}, // 模块名重复
+		{Name: "other", Aliases: []string{"example.tools.echo"}, Module: echo}, // 别名重复
+		{Name: "", Module: echo},
+		{Name: "empty"},
+	} {
+		if err := r.Register(spec); err == nil {
+			t.Errorf("Register(%+v) expected error", spec)
+		}
+	}
+	if _, ok := r.Lookup("other"); ok {
+		t.Error("failed Register() left the module in the registry")
+	}
+
+	// 执行器按别名查找模块
+	result, err := NewExecutorWithRegistry(r).Execute(nil, "example.tools.echo", map[string]interface{}{"msg": "hi"}, false, "", "")
+	if err != nil || result.Msg != "hi" {
+		t.Errorf("Execute() = %+v, %v", result, err)
+	}
+	if _, err := NewExecutorWithRegistry(r).Execute(nil, "copy", nil, false, "", ""); err == nil {
+		t.Error("Execute() expected error for a module that is not registered")
+	}
+
+	if got := r.Names(); !reflect.DeepEqual(got, []string{"echo"}) {
+		t.Errorf("Names() = %v, want [echo]", got)
+	}
+}
+
+func TestBuiltinModulesHaveFQCN(t *testing.T) {
+	for _, name := range DefaultRegistry.Names() {
+		spec, ok := Lookup("ansible.builtin." + name)
+		if !ok || spec.Name != name {
+			t.Errorf("Lookup(ansible.builtin.%s) = %v, %v", name, spec, ok)
+		}
+	}
+}
@@ -12,7 +12,7 @@ import (
 type ServiceModule struct{}
 
 // Execute 执行 service 模块
-func (m *ServiceModule) Execute(conn *connection.Connection, args map[string]interface{}, become bool, becomeUser, becomeMethod string) (*Result, error) {
+func (m *ServiceModule) Execute(conn connection.Connection, args map[string]interface{}, become bool, becomeUser, becomeMethod string) (*Result, error) {
 	result := &Result{}
 
 	// 获取必需参数 name
@@ -64,7 +64,7 @@ func (m *ServiceModule) Execute(conn *connection.Connection, args map[string]int
 
 	// 处理 state
 	if state != "" {
-		stateChanged, err := m.manageState(conn, name, state, useSystemd)
+		stateChanged, err := m.manageState(conn, name, state, useSystemd, checkMode(args))
 		if err != nil {
 			result.Failed = true
 			result.Msg = err.Error()
@@ -77,7 +77,7 @@ func (m *ServiceModule) Execute(conn *connection.Connection, args map[string]int
 
 	// 处理 enabled
 	if enabled != nil {
-		enabledChanged, err := m.manageEnabled(conn, name, *enabled, useSystemd)
+		enabledChanged, err := m.manageEnabled(conn, name, *enabled, useSystemd, checkMode(args))
 		if err != nil {
 			result.Failed = true
 			result.Msg = err.Error()
@@ -99,15 +99,15 @@ func (m *ServiceModule) Execute(conn *connection.Connection, args map[string]int
 }
 
 // detectSystemd 检测系统是否使用 systemd
-func (m *ServiceModule) detectSystemd(conn *connection.Connection) bool {
+func (m *ServiceModule) detectSystemd(conn connection.Connection) bool {
 	// 检查 systemctl 命令是否存在
 	checkCmd := "command -v systemctl"
 	checkResult, err := executeCommand(conn, checkCmd)
 	return err == nil && checkResult.RC == 0
 }
 
-// manageState 管理服务状态
-func (m *ServiceModule) manageState(conn *connection.Connection, name string, state string, useSystemd bool) (bool, error) {
+// manageState 管理服务状态，check 为 true 时只判断是否需要修改
+func (m *ServiceModule) manageState(conn connection.Connection, name string, state string, useSystemd bool, check bool) (bool, error) {
 	// 获取当前服务状态
 	isRunning, err := m.isServiceRunning(conn, name, useSystemd)
 	if err != nil {
@@ -158,6 +158,10 @@ func (m *ServiceModule) manageState(conn *connection.Connection, name string, st
 		return false, fmt.Errorf("invalid state: %s (must be started/stopped/restarted/reloaded)", state)
 	}
 
+	if needChange && check {
+		return true, nil
+	}
+
 	if needChange {
 		result, err := executeCommand(conn, cmd)
 		if err != nil || result.RC != 0 {
@@ -169,8 +173,8 @@ func (m *ServiceModule) manageState(conn *connection.Connection, name string, st
 	return false, nil
 }
 
-// manageEnabled 管理服务开机自启
-func (m *ServiceModule) manageEnabled(conn *connection.Connection, name string, enabled bool, useSystemd bool) (bool, error) {
+// manageEnabled 管理服务开机自启，check 为 true 时只判断是否需要修改
+func (m *ServiceModule) manageEnabled(conn connection.Connection, name string, enabled bool, useSystemd bool, check bool) (bool, error) {
 	// 检查当前 enabled 状态
 	isEnabled, err := m.isServiceEnabled(conn, name, useSystemd)
 	if err != nil {
@@ -182,6 +186,10 @@ func (m *ServiceModule) manageEnabled(conn *connection.Connection, name string,
 		return false, nil
 	}
 
+	if check {
+		return true, nil
+	}
+
 	// 需要修改 enabled 状态
 	var cmd string
 	if useSystemd {
@@ -220,7 +228,7 @@ func (m *ServiceModule) manageEnabled(conn *connection.Connection, name string,
 }
 
 // isServiceRunning 检查服务是否正在运行
-func (m *ServiceModule) isServiceRunning(conn *connection.Connection, name string, useSystemd bool) (bool, error) {
+func (m *ServiceModule) isServiceRunning(conn connection.Connection, name string, useSystemd bool) (bool, error) {
 	var cmd string
 	if useSystemd {
 		cmd = fmt.Sprintf("systemctl is-active %s", name)
@@ -243,7 +251,7 @@ func (m *ServiceModule) isServiceRunning(conn *connection.Connection, name strin
 }
 
 // isServiceEnabled 检查服务是否开机自启
-func (m *ServiceModule) isServiceEnabled(conn *connection.Connection, name string, useSystemd bool) (bool, error) {
+func (m *ServiceModule) isServiceEnabled(conn connection.Connection, name string, useSystemd bool) (bool, error) {
 	var cmd string
 	if useSystemd {
 		cmd = fmt.Sprintf("systemctl is-enabled %s", name)
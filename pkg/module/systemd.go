@@ -12,7 +12,7 @@ import (
 type SystemdModule struct{}
 
 // Execute 执行 systemd 模块
-func (m *SystemdModule) Execute(conn *connection.Connection, args map[string]interface{}, become bool, becomeUser, becomeMethod string) (*Result, error) {
+func (m *SystemdModule) Execute(conn connection.Connection, args map[string]interface{}, become bool, becomeUser, becomeMethod string) (*Result, error) {
 	result := &Result{}
 
 	// 获取必需参数 name
@@ -68,11 +68,12 @@ func (m *SystemdModule) Execute(conn *connection.Connection, args map[string]int
 		return result, nil
 	}
 
+	check := checkMode(args)
 	changed := false
 
 	// 执行 daemon_reload（如果需要）
 	if daemonReload {
-		reloadChanged, err := m.reloadDaemon(conn, become, becomeUser, becomeMethod)
+		reloadChanged, err := m.reloadDaemon(conn, check, become, becomeUser, becomeMethod)
 		if err != nil {
 			result.Failed = true
 			result.Msg = err.Error()
@@ -85,7 +86,7 @@ func (m *SystemdModule) Execute(conn *connection.Connection, args map[string]int
 
 	// 处理 state
 	if state != "" {
-		stateChanged, err := m.manageState(conn, name, state, become, becomeUser, becomeMethod)
+		stateChanged, err := m.manageState(conn, name, state, check, become, becomeUser, becomeMethod)
 		if err != nil {
 			result.Failed = true
 			result.Msg = err.Error()
@@ -98,7 +99,7 @@ func (m *SystemdModule) Execute(conn *connection.Connection, args map[string]int
 
 	// 处理 enabled
 	if enabled != nil {
-		enabledChanged, err := m.manageEnabled(conn, name, *enabled, become, becomeUser, becomeMethod)
+		enabledChanged, err := m.manageEnabled(conn, name, *enabled, check, become, becomeUser, becomeMethod)
 		if err != nil {
 			result.Failed = true
 			result.Msg = err.Error()
@@ -119,8 +120,12 @@ func (m *SystemdModule) Execute(conn *connection.Connection, args map[string]int
 	return result, nil
 }
 
-// reloadDaemon 重新加载 systemd daemon
-func (m *SystemdModule) reloadDaemon(conn *connection.Connection, become bool, becomeUser, becomeMethod string) (bool, error) {
+// reloadDaemon 重新加载 systemd daemon，check 为 true 时不执行
+func (m *SystemdModule) reloadDaemon(conn connection.Connection, check bool, become bool, becomeUser, becomeMethod string) (bool, error) {
+	if check {
+		return true, nil
+	}
+
 	cmd := "systemctl daemon-reload"
 	var stderr []byte
 	var exitCode int
@@ -140,8 +145,8 @@ func (m *SystemdModule) reloadDaemon(conn *connection.Connection, become bool, b
 	return true, nil
 }
 
-// manageState 管理服务状态
-func (m *SystemdModule) manageState(conn *connection.Connection, name string, state string, become bool, becomeUser, becomeMethod string) (bool, error) {
+// manageState 管理服务状态，check 为 true 时只判断是否需要修改
+func (m *SystemdModule) manageState(conn connection.Connection, name string, state string, check bool, become bool, becomeUser, becomeMethod string) (bool, error) {
 	// 获取当前服务状态
 	isRunning, err := m.isServiceRunning(conn, name, become, becomeUser, becomeMethod)
 	if err != nil {
@@ -176,6 +181,10 @@ func (m *SystemdModule) manageState(conn *connection.Connection, name string, st
 		return false, fmt.Errorf("invalid state: %s (must be started/stopped/restarted/reloaded)", state)
 	}
 
+	if needChange && check {
+		return true, nil
+	}
+
 	if needChange {
 		var stderr []byte
 		var exitCode int
@@ -196,8 +205,8 @@ func (m *SystemdModule) manageState(conn *connection.Connection, name string, st
 	return false, nil
 }
 
-// manageEnabled 管理服务开机自启
-func (m *SystemdModule) manageEnabled(conn *connection.Connection, name string, enabled bool, become bool, becomeUser, becomeMethod string) (bool, error) {
+// manageEnabled 管理服务开机自启，check 为 true 时只判断是否需要修改
+func (m *SystemdModule) manageEnabled(conn connection.Connection, name string, enabled bool, check bool, become bool, becomeUser, becomeMethod string) (bool, error) {
 	// 检查当前 enabled 状态
 	isEnabled, err := m.isServiceEnabled(conn, name, become, becomeUser, becomeMethod)
 	if err != nil {
@@ -209,6 +218,10 @@ func (m *SystemdModule) manageEnabled(conn *connection.Connection, name string,
 		return false, nil
 	}
 
+	if check {
+		return true, nil
+	}
+
 	// 需要修改 enabled 状态
 	var cmd string
 	if enabled {
@@ -234,7 +247,7 @@ func (m *SystemdModule) manageEnabled(conn *connection.Connection, name string,
 }
 
 // isServiceRunning 检查服务是否正在运行
-func (m *SystemdModule) isServiceRunning(conn *connection.Connection, name string, become bool, becomeUser, becomeMethod string) (bool, error) {
+func (m *SystemdModule) isServiceRunning(conn connection.Connection, name string, become bool, becomeUser, becomeMethod string) (bool, error) {
 	cmd := fmt.Sprintf("systemctl is-active %s", name)
 
 	var stdout []byte
@@ -256,7 +269,7 @@ func (m *SystemdModule) isServiceRunning(conn *connection.Connection, name strin
 }
 
 // isServiceEnabled 检查服务是否开机自启
-func (m *SystemdModule) isServiceEnabled(conn *connection.Connection, name string, become bool, becomeUser, becomeMethod string) (bool, error) {
+func (m *SystemdModule) isServiceEnabled(conn connection.Connection, name string, become bool, becomeUser, becomeMethod string) (bool, error) {
 	cmd := fmt.Sprintf("systemctl is-enabled %s", name)
 
 	var stdout []byte
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"strings"
 
+	"github.com/jimyag/ansigo/pkg/agent"
 	"github.com/jimyag/ansigo/pkg/connection"
 )
 
@@ -13,7 +14,7 @@ type TemplateModule struct{}
 
 // Execute 执行 template 模块
 // 注意：模板渲染由 runner 预处理，这里只负责文件传输和权限设置
-func (m *TemplateModule) Execute(conn *connection.Connection, args map[string]interface{}, become bool, becomeUser, becomeMethod string) (*Result, error) {
+func (m *TemplateModule) Execute(conn connection.Connection, args map[string]interface{}, become bool, becomeUser, becomeMethod string) (*Result, error) {
 	result := &Result{}
 
 	// 获取必需参数：dest（目标路径）
@@ -46,6 +47,14 @@ func (m *TemplateModule) Execute(conn *connection.Connection, args map[string]in
 		return result, nil
 	}
 
+	// 启用了远程 agent 时由 agent 比较、写入文件并设置属性，代替多次 shell 调用
+	if attrs, ok := agentAttributes(args); ok {
+		if a := startAgent(conn, args, become, becomeUser, becomeMethod); a != nil {
+			defer a.Close()
+			return m.executeWithAgent(conn, a, dest, content, attrs, args), nil
+		}
+	}
+
 	// 检查目标文件是否存在
 	changed := false
 	checkCmd := fmt.Sprintf("test -f %s", dest)
@@ -73,6 +82,29 @@ func (m *TemplateModule) Execute(conn *connection.Connection, args map[string]in
 		changed = true
 	}
 
+	// --diff 时返回修改前后的内容
+	if changed && diffMode(args) {
+		before := ""
+		if fileExists {
+			before = readRemoteFile(conn, dest)
+		}
+		result.Diff = newDiff(dest, before, content)
+	}
+
+	// check 模式下只报告内容和权限是否会变化
+	if checkMode(args) {
+		result.Changed = changed || attributesWouldChange(statAttributes(conn, dest), args)
+		if result.Changed {
+			result.Msg = fmt.Sprintf("template would be rendered to %s", dest)
+		} else {
+			result.Msg = fmt.Sprintf("template already up to date at %s", dest)
+		}
+		result.Data = map[string]interface{}{
+			"dest": dest,
+		}
+		return result, nil
+	}
+
 	// 如果需要备份，先备份原文件
 	if backup, ok := args["backup"].(bool); ok && backup && !changed {
 		// 只有文件存在且内容不同时才备份
@@ -82,20 +114,18 @@ func (m *TemplateModule) Execute(conn *connection.Connection, args map[string]in
 		}
 	}
 
-	// 写入内容到目标文件
+	// 写入内容到目标文件（原子替换，八进制 mode 在创建时设置）
 	if changed {
-		// 使用 heredoc 写入内容，避免特殊字符问题
-		writeCmd := fmt.Sprintf("cat > %s << 'ANSIGO_TEMPLATE_EOF'\n%s\nANSIGO_TEMPLATE_EOF", dest, content)
-		writeResult, err := executeCommand(conn, writeCmd)
-		if err != nil || writeResult.RC != 0 {
+		mode, _ := numericMode(args["mode"])
+		if err := putFile(conn, strings.NewReader(content), dest, mode, become, becomeUser, becomeMethod); err != nil {
 			result.Failed = true
-			result.Msg = fmt.Sprintf("failed to write template to dest: %s", writeResult.Stderr)
+			result.Msg = fmt.Sprintf("failed to write template to dest: %s", err.Error())
 			return result, nil
 		}
 	}
 
 	// 应用权限、所有者、组
-	permChanged, err := m.applyPermissions(conn, dest, args)
+	permChanged, err := applyAttributes(conn, dest, args)
 	if err != nil {
 		result.Failed = true
 		result.Msg = err.Error()
@@ -107,17 +137,10 @@ func (m *TemplateModule) Execute(conn *connection.Connection, args map[string]in
 	}
 
 	// 如果指定了 validate，执行验证命令
-	if validateInterface, ok := args["validate"]; ok {
-		if validate, ok := validateInterface.(string); ok && validate != "" {
-			// %s 会被替换为目标文件路径
-			validateCmd := fmt.Sprintf(validate, dest)
-			validateResult, err := executeCommand(conn, validateCmd)
-			if err != nil || validateResult.RC != 0 {
-				result.Failed = true
-				result.Msg = fmt.Sprintf("validation failed: %s", validateResult.Stderr)
-				return result, nil
-			}
-		}
+	if err := validateDest(conn, dest, args); err != nil {
+		result.Failed = true
+		result.Msg = err.Error()
+		return result, nil
 	}
 
 	result.Changed = changed
@@ -133,57 +156,88 @@ func (m *TemplateModule) Execute(conn *connection.Connection, args map[string]in
 	return result, nil
 }
 
-// applyPermissions 应用权限、所有者和组（复用 file 模块的逻辑）
-func (m *TemplateModule) applyPermissions(conn *connection.Connection, path string, args map[string]interface{}) (bool, error) {
-	changed := false
+// executeWithAgent 通过远程 agent 部署模板：一次读取比较内容，一次原子写入并设置属性
+func (m *TemplateModule) executeWithAgent(conn connection.Connection, a *remoteAgent, dest, content string, attrs agent.Attributes, args map[string]interface{}) *Result {
+	result := &Result{
+		Data: map[string]interface{}{
+			"dest": dest,
+		},
+	}
 
-	// 应用 mode（权限）
-	if modeInterface, ok := args["mode"]; ok {
-		modeStr := ""
-		switch v := modeInterface.(type) {
-		case string:
-			modeStr = v
-		case int:
-			modeStr = fmt.Sprintf("%o", v)
-		case int64:
-			modeStr = fmt.Sprintf("%o", v)
-		case float64:
-			modeStr = fmt.Sprintf("%o", int(v))
+	info, err := a.Stat(dest)
+	if err != nil {
+		result.Failed = true
+		result.Msg = err.Error()
+		return result
+	}
+	before := ""
+	if info.Exists {
+		existing, err := a.Read(dest)
+		if err != nil {
+			result.Failed = true
+			result.Msg = err.Error()
+			return result
 		}
+		before = string(existing)
+	}
+	changed := !info.Exists || before != content
 
-		if modeStr != "" {
-			chmodCmd := fmt.Sprintf("chmod %s %s", modeStr, path)
-			chmodResult, err := executeCommand(conn, chmodCmd)
-			if err != nil || chmodResult.RC != 0 {
-				return false, fmt.Errorf("failed to chmod: %s", chmodResult.Stderr)
-			}
-			changed = true
-		}
+	// --diff 时返回修改前后的内容
+	if changed && diffMode(args) {
+		result.Diff = newDiff(dest, before, content)
 	}
 
-	// 应用 owner
-	if ownerInterface, ok := args["owner"]; ok {
-		if owner, ok := ownerInterface.(string); ok && owner != "" {
-			chownCmd := fmt.Sprintf("chown %s %s", owner, path)
-			chownResult, err := executeCommand(conn, chownCmd)
-			if err != nil || chownResult.RC != 0 {
-				return false, fmt.Errorf("failed to chown: %s", chownResult.Stderr)
-			}
-			changed = true
+	// check 模式下只报告内容和权限是否会变化
+	if checkMode(args) {
+		result.Changed = changed || attributesWouldChange(agentFileAttributes(info), args)
+		if result.Changed {
+			result.Msg = fmt.Sprintf("template would be rendered to %s", dest)
+		} else {
+			result.Msg = fmt.Sprintf("template already up to date at %s", dest)
 		}
+		return result
 	}
 
-	// 应用 group
-	if groupInterface, ok := args["group"]; ok {
-		if group, ok := groupInterface.(string); ok && group != "" {
-			chgrpCmd := fmt.Sprintf("chgrp %s %s", group, path)
-			chgrpResult, err := executeCommand(conn, chgrpCmd)
-			if err != nil || chgrpResult.RC != 0 {
-				return false, fmt.Errorf("failed to chgrp: %s", chgrpResult.Stderr)
-			}
-			changed = true
-		}
+	// 内容变化时先备份原文件
+	if backup, ok := args["backup"].(bool); ok && backup && changed && info.Exists {
+		_, _ = executeCommand(conn, fmt.Sprintf("cp -p %s %s.bak", dest, dest))
+	}
+
+	written, err := a.Write(dest, []byte(content), attrs)
+	if err != nil {
+		result.Failed = true
+		result.Msg = fmt.Sprintf("failed to write template to dest: %s", err.Error())
+		return result
+	}
+
+	// 如果指定了 validate，执行验证命令
+	if err := validateDest(conn, dest, args); err != nil {
+		result.Failed = true
+		result.Msg = err.Error()
+		return result
 	}
 
-	return changed, nil
+	result.Changed = written
+	if written {
+		result.Msg = fmt.Sprintf("template rendered to %s", dest)
+	} else {
+		result.Msg = fmt.Sprintf("template already up to date at %s", dest)
+	}
+	return result
+}
+
+// validateDest 执行 validate 参数指定的验证命令，命令中的 %s 会被替换为目标文件路径
+func validateDest(conn connection.Connection, dest string, args map[string]interface{}) error {
+	validate, ok := args["validate"].(string)
+	if !ok || validate == "" {
+		return nil
+	}
+	validateResult, err := executeCommand(conn, fmt.Sprintf(validate, dest))
+	if err != nil {
+		return fmt.Errorf("validation failed: %v", err)
+	}
+	if validateResult.RC != 0 {
+		return fmt.Errorf("validation failed: %s", validateResult.Stderr)
+	}
+	return nil
 }
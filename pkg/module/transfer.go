@@ -1,44 +1,64 @@
 package module
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"os"
+	"path"
 	"path/filepath"
+	"strings"
 
 	"github.com/google/uuid"
 	"github.com/jimyag/ansigo/pkg/connection"
 )
 
+// systemTmpDir become 为非特权用户时使用的远程临时目录
+// 登录用户的 ~/.ansible/tmp 对 become 用户不可访问，和 Ansible 一样改用系统临时目录
+const systemTmpDir = "/var/tmp"
+
 // ModuleTransfer 处理模块传输和执行
 type ModuleTransfer struct {
-	conn *connection.Connection
+	conn       connection.Connection
+	becomeUser string // 执行模块的非特权 become 用户，为空时模块以登录用户或 root 执行
 }
 
 // NewModuleTransfer 创建模块传输器
-func NewModuleTransfer(conn *connection.Connection) *ModuleTransfer {
-	return &ModuleTransfer{
+// become 到 root 以外的用户时，临时目录和文件需要交给 become 用户访问
+func NewModuleTransfer(conn connection.Connection, become bool, becomeUser string) *ModuleTransfer {
+	mt := &ModuleTransfer{
 		conn: conn,
 	}
+	if become && becomeUser != "" && becomeUser != "root" {
+		mt.becomeUser = becomeUser
+	}
+	return mt
 }
 
-// PrepareRemoteDir 在远程创建临时目录
+// PrepareRemoteDir 在远程创建临时目录，返回目录的绝对路径（上传文件时远程不会展开 ~）
+// 通常在 ~/.ansible/tmp 下创建只有登录用户可以访问的目录；become 为非特权用户时
+// 在系统临时目录下创建其他用户可以进入的目录，其中的文件由 GrantAccess 单独授权
 func (mt *ModuleTransfer) PrepareRemoteDir() (string, error) {
 	taskID := uuid.New().String()
-	remoteDir := fmt.Sprintf("~/.ansible/tmp/ansigo-%s", taskID)
+	cmd := fmt.Sprintf("umask 77 && mkdir -p ~/.ansible/tmp/ansigo-%s && cd ~/.ansible/tmp/ansigo-%s && pwd", taskID, taskID)
+	if mt.becomeUser != "" {
+		// 目录名是随机的，mkdir 不带 -p，其他用户无法预先创建同名目录
+		remoteDir := path.Join(systemTmpDir, "ansigo-"+taskID)
+		cmd = fmt.Sprintf("umask 22 && mkdir %s && cd %s && pwd", shellQuote(remoteDir), shellQuote(remoteDir))
+	}
 
-	_, _, exitCode, err := mt.conn.Exec(fmt.Sprintf("mkdir -p %s", remoteDir))
+	stdout, stderr, exitCode, err := mt.conn.Exec(cmd)
 	if err != nil {
 		return "", fmt.Errorf("failed to create remote directory: %w", err)
 	}
 	if exitCode != 0 {
-		return "", fmt.Errorf("failed to create remote directory, exit code: %d", exitCode)
+		return "", fmt.Errorf("failed to create remote directory, exit code: %d: %s", exitCode, strings.TrimSpace(string(stderr)))
 	}
 
-	return remoteDir, nil
+	return strings.TrimSpace(string(stdout)), nil
 }
 
-// TransferArgs 将参数传输到远程
+// TransferArgs 将参数传输到远程，参数文件只有所有者可以读取
 func (mt *ModuleTransfer) TransferArgs(args map[string]interface{}, remoteDir string) (string, error) {
 	// 序列化参数为 JSON
 	argsJSON, err := json.Marshal(args)
@@ -46,51 +66,58 @@ func (mt *ModuleTransfer) TransferArgs(args map[string]interface{}, remoteDir st
 		return "", fmt.Errorf("failed to marshal args: %w", err)
 	}
 
-	// 创建临时文件
-	tmpFile, err := os.CreateTemp("", "ansigo-args-*.json")
-	if err != nil {
-		return "", fmt.Errorf("failed to create temp file: %w", err)
-	}
-	defer os.Remove(tmpFile.Name())
-
-	// 写入参数
-	if _, err := tmpFile.Write(argsJSON); err != nil {
-		tmpFile.Close()
-		return "", fmt.Errorf("failed to write args: %w", err)
-	}
-	tmpFile.Close()
-
-	// 传输到远程
-	remoteArgsPath := filepath.Join(remoteDir, "args.json")
-	if err := mt.conn.PutFile(tmpFile.Name(), remoteArgsPath); err != nil {
+	remoteArgsPath := path.Join(remoteDir, "args.json")
+	if err := mt.conn.Upload(bytes.NewReader(argsJSON), remoteArgsPath, 0o600); err != nil {
 		return "", fmt.Errorf("failed to transfer args: %w", err)
 	}
 
 	return remoteArgsPath, nil
 }
 
-// TransferModule 传输模块文件到远程（如果需要）
+// TransferModule 传输模块文件到远程，模块文件只有所有者可以读取和执行
 func (mt *ModuleTransfer) TransferModule(localModulePath, remoteDir string) (string, error) {
-	remoteModulePath := filepath.Join(remoteDir, filepath.Base(localModulePath))
+	remoteModulePath := path.Join(remoteDir, filepath.Base(localModulePath))
 
-	if err := mt.conn.PutFile(localModulePath, remoteModulePath); err != nil {
+	f, err := os.Open(localModulePath)
+	if err != nil {
+		return "", fmt.Errorf("failed to read module: %w", err)
+	}
+	defer f.Close()
+	if err := mt.conn.Upload(f, remoteModulePath, 0o700); err != nil {
 		return "", fmt.Errorf("failed to transfer module: %w", err)
 	}
 
-	// 设置执行权限
-	_, _, exitCode, err := mt.conn.Exec(fmt.Sprintf("chmod +x %s", remoteModulePath))
-	if err != nil {
-		return "", fmt.Errorf("failed to set execute permission: %w", err)
+	return remoteModulePath, nil
+}
+
+// GrantAccess 让非特权 become 用户可以读取（可执行文件还可以执行）上传的文件，没有 become 时什么也不做
+// 和 Ansible 一样优先使用 setfacl 只给 become 用户授权，不支持 ACL 时把文件的所有者改为 become 用户
+// （需要登录用户是 root）；两者都失败时报错，不会让文件对所有用户可读
+func (mt *ModuleTransfer) GrantAccess(paths ...string) error {
+	if mt.becomeUser == "" || len(paths) == 0 {
+		return nil
 	}
-	if exitCode != 0 {
-		return "", fmt.Errorf("failed to set execute permission, exit code: %d", exitCode)
+
+	quoted := make([]string, len(paths))
+	for i, p := range paths {
+		quoted[i] = shellQuote(p)
 	}
+	files := strings.Join(quoted, " ")
+	cmd := fmt.Sprintf("setfacl -m %s %s 2>/dev/null || chown %s %s",
+		shellQuote("u:"+mt.becomeUser+":rX"), files, shellQuote(mt.becomeUser), files)
 
-	return remoteModulePath, nil
+	_, stderr, exitCode, err := mt.conn.Exec(cmd)
+	if err == nil && exitCode != 0 {
+		err = fmt.Errorf("setfacl and chown failed: %s", strings.TrimSpace(string(stderr)))
+	}
+	if err != nil {
+		return fmt.Errorf("failed to give become user %s access to the module files: %w", mt.becomeUser, err)
+	}
+	return nil
 }
 
 // Cleanup 清理远程临时目录
 func (mt *ModuleTransfer) Cleanup(remoteDir string) error {
-	_, _, _, err := mt.conn.Exec(fmt.Sprintf("rm -rf %s", remoteDir))
+	_, _, _, err := mt.conn.Exec("rm -rf " + shellQuote(remoteDir))
 	return err
 }
@@ -1,5 +1,7 @@
 package module
 
+import "encoding/json"
+
 // Result 模块执行结果
 type Result struct {
 	Changed      bool                   `json:"changed"`
@@ -14,5 +16,84 @@ type Result struct {
 	Dest         string                 `json:"dest,omitempty"`          // copy 模块目标路径
 	Checksum     string                 `json:"checksum,omitempty"`      // copy 模块校验和
 	AnsibleFacts map[string]interface{} `json:"ansible_facts,omitempty"` // set_fact 模块设置的 facts
+	Diff         *Diff                  `json:"diff,omitempty"`          // --diff 时文件修改前后的内容
 	Data         map[string]interface{} `json:"-"`                       // 其他动态字段
 }
+
+// MarshalJSON 输出结果字段和 Data 中的其他字段，同名时结果字段优先
+func (r Result) MarshalJSON() ([]byte, error) {
+	type plain Result
+	data, err := json.Marshal(plain(r))
+	if err != nil || len(r.Data) == 0 {
+		return data, err
+	}
+
+	fields := make(map[string]interface{}, len(r.Data))
+	for k, v := range r.Data {
+		fields[k] = v
+	}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	return json.Marshal(fields)
+}
+
+// Diff 文件修改前后的内容，runner 用它输出统一格式的 diff
+type Diff struct {
+	Before       string `json:"before"`
+	After        string `json:"after"`
+	BeforeHeader string `json:"before_header,omitempty"`
+	AfterHeader  string `json:"after_header,omitempty"`
+}
+
+// CheckModeArg runner 传给模块的内部参数，为 true 时模块以 check 模式（--check）执行：
+// 只报告将要做的修改，不修改目标主机
+const CheckModeArg = "_ansible_check_mode"
+
+// checkMode 判断模块是否以 check 模式执行
+func checkMode(args map[string]interface{}) bool {
+	check, _ := args[CheckModeArg].(bool)
+	return check
+}
+
+// skippedInCheckMode 返回不支持 check 模式的模块在 check 模式下的结果
+func skippedInCheckMode(msg string) *Result {
+	return &Result{
+		Skipped: true,
+		Msg:     msg,
+	}
+}
+
+// DiffArg runner 传给模块的内部参数，为 true 时修改文件的模块在结果中返回 Diff（--diff）
+const DiffArg = "_ansible_diff"
+
+// diffMode 判断模块是否需要返回 Diff
+func diffMode(args map[string]interface{}) bool {
+	diff, _ := args[DiffArg].(bool)
+	return diff
+}
+
+// PythonInterpreterArg runner 传给模块的内部参数，值是目标主机的 ansible_python_interpreter，
+// library 中的 Python 模块用它执行
+const PythonInterpreterArg = "_ansible_python_interpreter"
+
+// AgentArg runner 传给模块的内部参数，主机变量 ansigo_agent 为 true 时设置，
+// 值是目标主机的 ansible_architecture（没有收集 facts 时为空，启动 agent 前检测），
+// template、lineinfile 等模块通过远程 agent 读写文件
+const AgentArg = "_ansible_agent"
+
+// HostArgs 根据主机变量返回 runner 传给模块的内部参数（PythonInterpreterArg、AgentArg、PkgMgrArg）
+func HostArgs(vars map[string]interface{}) map[string]interface{} {
+	hostArgs := make(map[string]interface{})
+	if interpreter, ok := vars["ansible_python_interpreter"].(string); ok && interpreter != "" {
+		hostArgs[PythonInterpreterArg] = interpreter
+	}
+	if enabled, ok := convertBool(vars["ansigo_agent"]); ok && enabled {
+		arch, _ := vars["ansible_architecture"].(string)
+		hostArgs[AgentArg] = arch
+	}
+	if pkgMgr := pkgMgrFromFacts(vars); pkgMgr != "" {
+		hostArgs[PkgMgrArg] = pkgMgr
+	}
+	return hostArgs
+}
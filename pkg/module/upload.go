@@ -0,0 +1,81 @@
+package module
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/jimyag/ansigo/pkg/connection"
+)
+
+// octalModePattern 匹配八进制权限字符串，如 644、0644、01777
+var octalModePattern = regexp.MustCompile(`^0?[0-7]{3,4}$`)
+
+// putFile 把 r 的内容原子地写入远程 dest
+//
+// 不需要 become 时直接通过连接上传（SFTP，或回退到 shell）。
+// 需要 become 时先以登录用户上传到 /tmp，再以 become 用户复制到目标目录下的临时文件并重命名，
+// 这样目标文件属于 become 用户。mode 为 0 时已存在的文件保持原有权限和所有者，新文件使用 0644
+func putFile(conn connection.Connection, r io.Reader, dest string, mode os.FileMode, become bool, becomeUser, becomeMethod string) error {
+	if !become {
+		return conn.Upload(r, dest, mode)
+	}
+
+	// become 用户不是 root 时无法读取登录用户的私有文件，只能放宽临时文件权限
+	tmpMode := os.FileMode(0o600)
+	if becomeUser != "" && becomeUser != "root" {
+		tmpMode = 0o644
+	}
+
+	tmp := "/tmp/.ansigo-upload-" + uuid.New().String()
+	if err := conn.Upload(r, tmp, tmpMode); err != nil {
+		return err
+	}
+	defer conn.Exec("rm -f " + shellQuote(tmp))
+
+	staged := shellQuote(path.Join(path.Dir(dest), "."+path.Base(dest)+".ansigo-"+uuid.New().String()[:8]))
+	quotedDest := shellQuote(dest)
+
+	// mode 保留 setuid、setgid 和 sticky 位
+	chmod := fmt.Sprintf("chmod %04o %s", mode&0o7777, staged)
+	if mode == 0 {
+		chmod = fmt.Sprintf("if [ -e %s ]; then chmod \"$(stat -c %%a %s)\" %s && { chown \"$(stat -c %%u:%%g %s)\" %s 2>/dev/null || true; }; else chmod 0644 %s; fi",
+			quotedDest, quotedDest, staged, quotedDest, staged, staged)
+	}
+
+	cmd := fmt.Sprintf("cp %s %s && %s && mv -f %s %s || { rm -f %s; exit 1; }",
+		shellQuote(tmp), staged, chmod, staged, quotedDest, staged)
+
+	_, stderr, exitCode, err := conn.ExecWithBecome(cmd, becomeUser, becomeMethod)
+	if err != nil {
+		return err
+	}
+	if exitCode != 0 {
+		return fmt.Errorf("failed to install %s: %s", dest, strings.TrimSpace(string(stderr)))
+	}
+	return nil
+}
+
+// numericMode 解析 mode 参数中的八进制权限
+// 返回 ok=false 表示未指定 mode 或者是 u+rw 这类符号权限（需要上传后再 chmod）
+func numericMode(value interface{}) (os.FileMode, bool) {
+	switch v := value.(type) {
+	case string:
+		if octalModePattern.MatchString(v) {
+			mode, err := parseMode(v)
+			return mode, err == nil
+		}
+	case int:
+		// YAML 中的 0644 已经被解析为八进制整数
+		return os.FileMode(v), true
+	case int64:
+		return os.FileMode(v), true
+	case float64:
+		return os.FileMode(int(v)), true
+	}
+	return 0, false
+}
@@ -0,0 +1,73 @@
+package playbook
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/jimyag/ansigo/pkg/connection"
+	"github.com/jimyag/ansigo/pkg/inventory"
+)
+
+// delegateHost 返回任务实际连接的主机
+// 未设置 delegate_to 时返回原主机；delegate_to 使用原主机的变量上下文渲染，
+// 优先使用 inventory 中的同名主机，localhost 不在 inventory 中时使用隐式本地主机（ansible_connection=local），
+// 其他未知主机按名称直接连接
+func (r *Runner) delegateHost(task *Task, host *inventory.Host, context map[string]interface{}) (*inventory.Host, error) {
+	if task.DelegateTo == "" {
+		return host, nil
+	}
+
+	name, err := r.template.RenderString(task.DelegateTo, context)
+	if err != nil {
+		return nil, fmt.Errorf("failed to render delegate_to: %w", err)
+	}
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, fmt.Errorf("delegate_to rendered to an empty host name")
+	}
+
+	if delegated, err := r.inventory.GetHost(name); err == nil {
+		return delegated, nil
+	}
+	return implicitHost(name), nil
+}
+
+// implicitHost 为不在 inventory 中的主机创建连接用的 Host
+func implicitHost(name string) *inventory.Host {
+	vars := map[string]interface{}{
+		"ansible_host": name,
+	}
+	if connection.IsLocalhost(name) {
+		vars["ansible_connection"] = "local"
+	}
+	return &inventory.Host{
+		Name:   name,
+		Vars:   vars,
+		Groups: []string{"all", "ungrouped"},
+	}
+}
+
+// runOnceResult 记录 run_once 任务在当前批次中的唯一一次执行
+type runOnceResult struct {
+	once   sync.Once
+	result *TaskResult
+}
+
+// runOnce 保证 task 在当前批次中只执行一次
+// 第一个调用者执行 run 并返回 first=true，其他调用者等待执行完成后得到同一个结果
+func (r *Runner) runOnce(task *Task, run func() *TaskResult) (result *TaskResult, first bool) {
+	r.runOnceMu.Lock()
+	state, ok := r.runOnceResults[task]
+	if !ok {
+		state = &runOnceResult{}
+		r.runOnceResults[task] = state
+	}
+	r.runOnceMu.Unlock()
+
+	state.once.Do(func() {
+		first = true
+		state.result = run()
+	})
+	return state.result, first
+}
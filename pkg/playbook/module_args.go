@@ -0,0 +1,58 @@
+package playbook
+
+import (
+	"errors"
+
+	ansierrors "github.com/jimyag/ansigo/pkg/errors"
+	"github.com/jimyag/ansigo/pkg/module"
+)
+
+// validateModuleArgs 按模块的参数定义检查并转换任务的参数，参数错误时返回 ErrInvalidArgs 类型的错误
+// 模块没有注册（如 import_tasks）时原样返回参数
+func validateModuleArgs(taskName, moduleName string, args map[string]interface{}) (map[string]interface{}, error) {
+	spec, ok := module.Lookup(moduleName)
+	if !ok {
+		return args, nil
+	}
+
+	validated, err := spec.ValidateArgs(args)
+	if err != nil {
+		var argErr *module.ArgError
+		param := ""
+		if errors.As(err, &argErr) {
+			param = argErr.Param
+		}
+		if taskName == "" {
+			taskName = moduleName
+		}
+		return nil, ansierrors.NewInvalidArgsError(taskName, moduleName, param, err)
+	}
+	return validated, nil
+}
+
+// checkTasksArgs 加载 play 时检查所有任务（包括 block 中的任务）和 handler 的模块参数，
+// 参数错误（未知参数、缺少必需参数、类型错误等）在连接任何主机之前报告
+// 还没有渲染的模板不检查类型和取值，执行任务时渲染后再检查一次
+func checkTasksArgs(tasks []Task, handlers []Handler) error {
+	for i := range tasks {
+		task := &tasks[i]
+		if task.TaskBlock != nil {
+			for _, tasks := range [][]Task{task.TaskBlock.Block, task.TaskBlock.Rescue, task.TaskBlock.Always} {
+				if err := checkTasksArgs(tasks, nil); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		if _, err := validateModuleArgs(task.Name, task.Module, task.ModuleArgs); err != nil {
+			return err
+		}
+	}
+
+	for i := range handlers {
+		if _, err := validateModuleArgs(handlers[i].Name, handlers[i].Module, handlers[i].ModuleArgs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package playbook
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	ansierrors "github.com/jimyag/ansigo/pkg/errors"
+	"github.com/jimyag/ansigo/pkg/inventory"
+)
+
+func TestLoadPlayTasksValidatesModuleArgs(t *testing.T) {
+	tests := []struct {
+		name      string
+		tasks     string
+		wantTask  string
+		wantParam string
+	}{
+		{
+			name: "valid args with templates",
+			tasks: `
+    - name: Copy config
+      copy: {content: "{{ body }}", dest: /tmp/app.conf, mode: 0644}
+    - block:
+        - file: {path: /tmp/app, state: "{{ wanted_state }}"}
+`,
+		},
+		{
+			name: "unknown parameter",
+			tasks: `
+    - name: Copy config
+      copy: {content: hi, dest: /tmp/app.conf, mdoe: "0644"}
+`,
+			wantTask:  "Copy config",
+			wantParam: "mdoe",
+		},
+		{
+			name: "missing required parameter in block",
+			tasks: `
+    - block:
+        - name: Touch
+          file: {state: touch}
+`,
+			wantTask:  "Touch",
+			wantParam: "path",
+		},
+		{
+			name: "bad type in handler",
+			tasks: `
+  handlers:
+    - name: Restart app
+      systemd: {name: app, daemon_reload: sometimes}
+`,
+			wantTask:  "Restart app",
+			wantParam: "daemon_reload",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			playbookPath := filepath.Join(t.TempDir(), "site.yml")
+			content := "- hosts: all\n"
+			if !strings.HasPrefix(strings.TrimLeft(tt.tasks, "\n"), "  handlers:") {
+				content += "  tasks:\n"
+			}
+			writeTestFile(t, playbookPath, content+strings.TrimLeft(tt.tasks, "\n"))
+
+			data, err := os.ReadFile(playbookPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			pb, err := ParsePlaybook(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			r := NewRunner(inventory.NewManager())
+			defer r.Close()
+			r.SetPlaybookPath(playbookPath)
+
+			_, _, err = r.loadPlayTasks(&pb[0], nil)
+			if tt.wantParam == "" {
+				if err != nil {
+					t.Fatalf("loadPlayTasks() error = %v", err)
+				}
+				return
+			}
+
+			execErr, ok := ansierrors.AsExecutionError(err)
+			if !ok || execErr.Type != ansierrors.ErrInvalidArgs {
+				t.Fatalf("loadPlayTasks() error = %v, want ErrInvalidArgs", err)
+			}
+			if execErr.Task != tt.wantTask {
+				t.Errorf("error task = %q, want %q", execErr.Task, tt.wantTask)
+			}
+			if param := execErr.Details["param"]; param != tt.wantParam {
+				t.Errorf("error param = %v, want %q", param, tt.wantParam)
+			}
+		})
+	}
+}
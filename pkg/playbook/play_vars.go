@@ -0,0 +1,289 @@
+package playbook
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/jimyag/ansigo/pkg/inventory"
+	"golang.org/x/term"
+	"gopkg.in/yaml.v3"
+)
+
+// VarPrompt vars_prompt 中的一项：运行 play 前向用户询问变量的值
+type VarPrompt struct {
+	Name    string      `yaml:"name"`
+	Prompt  string      `yaml:"prompt"`  // 提示文字（默认为变量名）
+	Default interface{} `yaml:"default"` // 直接回车或无法交互时使用的值
+	Private *bool       `yaml:"private"` // 输入时不回显（默认 true）
+	Confirm bool        `yaml:"confirm"` // 要求再输入一次确认
+}
+
+// VarPrompter 读取用户输入，private 为 true 时不回显
+type VarPrompter func(prompt string, private bool) (string, error)
+
+// TerminalVarPrompt 从终端读取 vars_prompt 的输入
+func TerminalVarPrompt(prompt string, private bool) (string, error) {
+	fmt.Fprint(os.Stderr, prompt)
+	if private {
+		value, err := term.ReadPassword(int(os.Stdin.Fd()))
+		fmt.Fprintln(os.Stderr)
+		return string(value), err
+	}
+
+	// 逐字节读取，不缓冲 stdin 中下一次输入的内容
+	var line []byte
+	buf := make([]byte, 1)
+	for {
+		n, err := os.Stdin.Read(buf)
+		if n > 0 {
+			if buf[0] == '\n' {
+				break
+			}
+			line = append(line, buf[0])
+		}
+		if err != nil {
+			if len(line) > 0 {
+				break
+			}
+			return "", err
+		}
+	}
+	return strings.TrimSuffix(string(line), "\r"), nil
+}
+
+// promptVars 按 vars_prompt 询问变量的值
+// 已经通过 -e 指定的变量不再询问；没有设置 VarPrompter（非交互运行）时使用默认值，没有默认值时报错
+func (r *Runner) promptVars(prompts []VarPrompt) (map[string]interface{}, error) {
+	vars := make(map[string]interface{})
+	for _, p := range prompts {
+		if p.Name == "" {
+			return nil, fmt.Errorf("vars_prompt item is missing 'name'")
+		}
+		if _, ok := r.extraVars[p.Name]; ok {
+			continue
+		}
+
+		if r.varPrompt == nil {
+			if p.Default == nil {
+				return nil, fmt.Errorf("vars_prompt '%s' has no default and cannot prompt: stdin is not a terminal", p.Name)
+			}
+			vars[p.Name] = p.Default
+			continue
+		}
+
+		value, err := r.askVar(p)
+		if err != nil {
+			return nil, fmt.Errorf("vars_prompt '%s': %w", p.Name, err)
+		}
+		vars[p.Name] = value
+	}
+	return vars, nil
+}
+
+// askVar 询问单个变量，直接回车时使用默认值
+func (r *Runner) askVar(p VarPrompt) (interface{}, error) {
+	text := p.Prompt
+	if text == "" {
+		text = p.Name
+	}
+	private := p.Private == nil || *p.Private
+
+	prompt := text + ": "
+	if p.Default != nil {
+		prompt = fmt.Sprintf("%s [%v]: ", text, p.Default)
+	}
+
+	for {
+		value, err := r.varPrompt(prompt, private)
+		if err != nil {
+			return nil, err
+		}
+		if value == "" && p.Default != nil {
+			return p.Default, nil
+		}
+		if !p.Confirm {
+			return value, nil
+		}
+
+		confirm, err := r.varPrompt("confirm "+prompt, private)
+		if err != nil {
+			return nil, err
+		}
+		if confirm == value {
+			return value, nil
+		}
+		fmt.Fprintln(os.Stderr, "***** VALUES ENTERED DO NOT MATCH ****")
+	}
+}
+
+// loadVarsFiles 按顺序加载 vars_files，后面的文件覆盖前面的变量
+// 每一项可以是文件名或文件名列表（使用列表中第一个存在的文件）；文件名可以使用模板，相对路径相对于 playbook 所在目录
+//
+// vars_files 对每个主机单独加载（见 loadHostVarsFiles），文件名可以引用 inventory 变量和 facts。
+// play 级别加载时（deferred 为 true）还没有主机变量，无法渲染的文件名被跳过，只在主机上加载
+func (r *Runner) loadVarsFiles(entries []interface{}, vars map[string]interface{}, deferred bool) (map[string]interface{}, error) {
+	context := r.withExtraVars(vars)
+	loaded := make(map[string]interface{})
+
+	for _, entry := range entries {
+		var candidates []string
+		switch v := entry.(type) {
+		case string:
+			candidates = []string{v}
+		case []interface{}:
+			for _, item := range v {
+				name, ok := item.(string)
+				if !ok {
+					return nil, fmt.Errorf("vars_files entries must be file names, got %T", item)
+				}
+				candidates = append(candidates, name)
+			}
+		default:
+			return nil, fmt.Errorf("vars_files entries must be a file name or a list of file names, got %T", entry)
+		}
+
+		path, err := r.findVarsFile(candidates, context, deferred)
+		if err != nil {
+			return nil, err
+		}
+		if path == "" {
+			continue
+		}
+		fileVars, err := inventory.ReadVarsFile(path)
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range fileVars {
+			loaded[k] = v
+		}
+	}
+	return loaded, nil
+}
+
+// findVarsFile 渲染候选文件名，返回第一个存在的文件
+// 只有一个候选文件时直接返回它，由读取时报告文件不存在；有多个候选文件时跳过引用了未定义变量的文件名。
+// deferred 为 true 时跳过所有渲染失败的文件名，因此没有找到文件时返回空，留给主机级别加载
+func (r *Runner) findVarsFile(candidates []string, context map[string]interface{}, deferred bool) (string, error) {
+	var paths, tried []string
+	skipped := false
+	for _, name := range candidates {
+		rendered, err := r.template.RenderString(name, context)
+		if err != nil {
+			if deferred || len(candidates) > 1 {
+				skipped = true
+				tried = append(tried, name)
+				continue
+			}
+			return "", fmt.Errorf("failed to render vars_files name '%s': %w", name, err)
+		}
+		if !filepath.IsAbs(rendered) {
+			rendered = filepath.Join(filepath.Dir(r.playbookPath), rendered)
+		}
+		paths = append(paths, rendered)
+		tried = append(tried, rendered)
+	}
+
+	if len(candidates) == 1 && len(paths) == 1 {
+		return paths[0], nil
+	}
+	for _, path := range paths {
+		if _, err := os.Stat(path); err == nil {
+			return path, nil
+		}
+	}
+	if deferred && skipped {
+		return "", nil
+	}
+	return "", fmt.Errorf("vars_files: none of the files was found: %s", strings.Join(tried, ", "))
+}
+
+// loadHostVarsFiles 在每个主机的变量上下文（inventory 变量、facts、play 变量）中重新加载 vars_files
+// 结果替换该主机的 play vars_files 层，如 vars/{{ ansible_os_family }}.yml 在每个主机上选择不同的文件
+func (r *Runner) loadHostVarsFiles(play *Play, hosts []*inventory.Host) error {
+	if len(play.VarsFiles) == 0 {
+		return nil
+	}
+	for _, host := range hosts {
+		vars, err := r.loadVarsFiles(play.VarsFiles, r.varMgr.GetContext(host.Name), false)
+		if err != nil {
+			return fmt.Errorf("%s: %w", host.Name, err)
+		}
+		r.varMgr.SetHostVarsFiles(host.Name, vars)
+	}
+	return nil
+}
+
+// ParseExtraVars 解析 -e 参数，支持三种形式：
+//   - key=value 形式，多个变量用空白分隔，值可以用引号包含空白（值都是字符串）
+//   - @file.yml 从 YAML 或 JSON 文件读取
+//   - {"key": "value"} JSON（或 YAML 流式）字典
+func ParseExtraVars(value string) (map[string]interface{}, error) {
+	value = strings.TrimSpace(value)
+	switch {
+	case value == "":
+		return nil, fmt.Errorf("extra vars must not be empty")
+	case strings.HasPrefix(value, "@"):
+		return inventory.ReadVarsFile(value[1:])
+	case strings.HasPrefix(value, "{"):
+		vars := make(map[string]interface{})
+		if err := yaml.Unmarshal([]byte(value), &vars); err != nil {
+			return nil, fmt.Errorf("invalid extra vars %q: %w", value, err)
+		}
+		return vars, nil
+	}
+
+	words, err := splitQuoted(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid extra vars %q: %w", value, err)
+	}
+	vars := make(map[string]interface{})
+	for _, word := range words {
+		key, val, ok := strings.Cut(word, "=")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid extra vars %q: expected key=value, got %q", value, word)
+		}
+		vars[key] = val
+	}
+	return vars, nil
+}
+
+// splitQuoted 按空白拆分，单引号或双引号中的空白不拆分，引号本身被去掉
+func splitQuoted(s string) ([]string, error) {
+	var words []string
+	var word strings.Builder
+	inWord := false
+	var quote rune
+
+	for _, c := range s {
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			} else {
+				word.WriteRune(c)
+			}
+		case c == '\'' || c == '"':
+			quote = c
+			inWord = true
+		case c == ' ' || c == '\t' || c == '\n':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(c)
+			inWord = true
+		}
+	}
+
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated quote")
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
@@ -0,0 +1,260 @@
+package playbook
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseExtraVars(t *testing.T) {
+	dir := t.TempDir()
+	varsFile := filepath.Join(dir, "extra.yml")
+	writeTestFile(t, varsFile, "version: 2\nfeatures: [a, b]\n")
+
+	tests := []struct {
+		name    string
+		value   string
+		want    map[string]interface{}
+		wantErr bool
+	}{
+		{name: "key value", value: "env=prod port=8080", want: map[string]interface{}{"env": "prod", "port": "8080"}},
+		{name: "quoted value", value: `msg="hello world" name='a b'`, want: map[string]interface{}{"msg": "hello world", "name": "a b"}},
+		{name: "value with equals", value: "opts=a=b", want: map[string]interface{}{"opts": "a=b"}},
+		{name: "json", value: `{"port": 8080, "debug": true}`, want: map[string]interface{}{"port": 8080, "debug": true}},
+		{name: "file", value: "@" + varsFile, want: map[string]interface{}{"version": 2, "features": []interface{}{"a", "b"}}},
+		{name: "missing file", value: "@" + filepath.Join(dir, "missing.yml"), wantErr: true},
+		{name: "not key value", value: "prod", wantErr: true},
+		{name: "unterminated quote", value: `msg="hello`, wantErr: true},
+		{name: "empty", value: " ", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseExtraVars(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseExtraVars() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseExtraVars() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadPlayVars(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "vars", "common.yml"), "app_port: 8080\nregion: default\n")
+	writeTestFile(t, filepath.Join(dir, "vars", "prod.yml"), "region: eu\n")
+	writeTestFile(t, filepath.Join(dir, "vars", "defaults.yml"), "tier: default\n")
+
+	invMgr, _ := loadTestInventory(t, "[local]\nlocalhost\n")
+	newRunner := func() *Runner {
+		r := NewRunner(invMgr)
+		t.Cleanup(func() { r.Close() })
+		r.SetPlaybookPath(filepath.Join(dir, "site.yml"))
+		return r
+	}
+
+	play := &Play{
+		Vars: map[string]interface{}{"env": "prod", "region": "inline"},
+		VarsFiles: []interface{}{
+			"vars/common.yml",
+			"vars/{{ env }}.yml",
+			[]interface{}{"vars/{{ tier | default('web') }}.yml", "vars/defaults.yml"},
+		},
+		VarsPrompt: []VarPrompt{
+			{Name: "release", Default: "1.0"},
+			{Name: "password", Prompt: "Password"},
+		},
+	}
+
+	t.Run("vars files and prompt", func(t *testing.T) {
+		r := newRunner()
+		var prompts []string
+		r.SetVarPrompt(func(prompt string, private bool) (string, error) {
+			prompts = append(prompts, prompt)
+			if prompt == "Password: " {
+				if !private {
+					t.Error("password prompt is not private")
+				}
+				return "s3cret", nil
+			}
+			return "", nil
+		})
+
+		got, err := r.loadPlayVars(play, true)
+		if err != nil {
+			t.Fatalf("loadPlayVars() error = %v", err)
+		}
+		want := &playVarLayers{
+			vars:   map[string]interface{}{"env": "prod", "region": "inline"},
+			prompt: map[string]interface{}{"release": "1.0", "password": "s3cret"},
+			files:  map[string]interface{}{"region": "eu", "app_port": 8080, "tier": "default"},
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("loadPlayVars() = %+v, want %+v", got, want)
+		}
+		if region := got.merged()["region"]; region != "eu" {
+			t.Errorf("merged region = %v, want eu (vars_files override vars)", region)
+		}
+		if wantPrompts := []string{"release [1.0]: ", "Password: "}; !reflect.DeepEqual(prompts, wantPrompts) {
+			t.Errorf("prompts = %q, want %q", prompts, wantPrompts)
+		}
+	})
+
+	t.Run("non interactive requires default", func(t *testing.T) {
+		if _, err := newRunner().loadPlayVars(play, true); err == nil {
+			t.Fatal("loadPlayVars() without a prompter should fail for a prompt without default")
+		}
+	})
+
+	t.Run("extra vars skip prompts and template file names", func(t *testing.T) {
+		r := newRunner()
+		r.SetExtraVars(map[string]interface{}{"password": "from-cli", "env": "common"})
+
+		layers, err := r.loadPlayVars(play, true)
+		if err != nil {
+			t.Fatalf("loadPlayVars() error = %v", err)
+		}
+		got := layers.merged()
+		if _, prompted := got["password"]; prompted {
+			t.Error("password was prompted although it is set with -e")
+		}
+		// vars/{{ env }}.yml 使用 extra vars 中的 env
+		if got["region"] != "default" {
+			t.Errorf("region = %v, want default (from vars/common.yml)", got["region"])
+		}
+
+		r.varMgr.SetPlayVars(got)
+		if ctx := r.varMgr.GetContext("localhost"); ctx["env"] != "common" || ctx["password"] != "from-cli" {
+			t.Errorf("extra vars do not override play vars: env=%v password=%v", ctx["env"], ctx["password"])
+		}
+	})
+
+	t.Run("missing file", func(t *testing.T) {
+		_, err := newRunner().loadPlayVars(&Play{VarsFiles: []interface{}{"vars/missing.yml"}}, false)
+		if err == nil {
+			t.Fatal("loadPlayVars() with a missing vars file should fail")
+		}
+		_, err = newRunner().loadPlayVars(&Play{VarsFiles: []interface{}{[]interface{}{"a.yml", "b.yml"}}}, false)
+		if err == nil {
+			t.Fatal("loadPlayVars() without any existing alternative should fail")
+		}
+	})
+}
+
+func TestLoadHostVarsFiles(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "vars", "Debian.yml"), "pkg: apache2\n")
+	writeTestFile(t, filepath.Join(dir, "vars", "RedHat.yml"), "pkg: httpd\n")
+	writeTestFile(t, filepath.Join(dir, "vars", "default.yml"), "pkg: nginx\n")
+	writeTestFile(t, filepath.Join(dir, "vars", "prod.yml"), "tier: prod\n")
+	writeTestFile(t, filepath.Join(dir, "vars", "common.yml"), "tier: common\n")
+
+	invMgr, hosts := loadTestInventory(t, `[web]
+web1 env=prod
+web2
+web3
+`)
+	r := NewRunner(invMgr)
+	t.Cleanup(func() { r.Close() })
+	r.SetPlaybookPath(filepath.Join(dir, "site.yml"))
+
+	play := &Play{VarsFiles: []interface{}{
+		[]interface{}{"vars/{{ ansible_os_family }}.yml", "vars/default.yml"},
+		[]interface{}{"vars/{{ env }}.yml", "vars/common.yml"},
+	}}
+
+	// play 级别还没有主机变量和 facts，使用能找到的候选文件
+	layers, err := r.loadPlayVars(play, false)
+	if err != nil {
+		t.Fatalf("loadPlayVars() error = %v", err)
+	}
+	if want := map[string]interface{}{"pkg": "nginx", "tier": "common"}; !reflect.DeepEqual(layers.files, want) {
+		t.Errorf("play vars_files = %v, want %v", layers.files, want)
+	}
+	r.varMgr.SetPlayVarsFiles(layers.files)
+
+	// 主机级别使用 facts 和 inventory 变量
+	r.varMgr.SetHostVars("web1", map[string]interface{}{"ansible_os_family": "Debian"})
+	r.varMgr.SetHostVars("web2", map[string]interface{}{"ansible_os_family": "RedHat"})
+	if err := r.loadHostVarsFiles(play, hosts); err != nil {
+		t.Fatalf("loadHostVarsFiles() error = %v", err)
+	}
+
+	tests := []struct {
+		host string
+		pkg  string
+		tier string
+	}{
+		{"web1", "apache2", "prod"},
+		{"web2", "httpd", "common"},
+		{"web3", "nginx", "common"},
+	}
+	for _, tt := range tests {
+		ctx := r.varMgr.GetContext(tt.host)
+		if ctx["pkg"] != tt.pkg || ctx["tier"] != tt.tier {
+			t.Errorf("%s: pkg=%v tier=%v, want %v %v", tt.host, ctx["pkg"], ctx["tier"], tt.pkg, tt.tier)
+		}
+	}
+
+	// 主机上仍然找不到文件时报错
+	missing := &Play{VarsFiles: []interface{}{"vars/{{ ansible_os_family }}.yml"}}
+	if err := r.loadHostVarsFiles(missing, hosts); err == nil {
+		t.Error("loadHostVarsFiles() with a missing file should fail")
+	}
+}
+
+func TestVariableManagerExtraVarsPrecedence(t *testing.T) {
+	invMgr, _ := loadTestInventory(t, "[web]\nweb1\n")
+	vm := NewVariableManager(invMgr)
+	vm.SetPlayVars(map[string]interface{}{"env": "play"})
+	vm.SetHostVar("web1", "env", "registered")
+	vm.SetExtraVars(map[string]interface{}{"env": "extra"})
+
+	if got := vm.GetContext("web1")["env"]; got != "extra" {
+		t.Errorf("GetContext()[env] = %v, want extra", got)
+	}
+	if got, _ := vm.GetHostVar("web1", "env"); got != "extra" {
+		t.Errorf("GetHostVar(env) = %v, want extra", got)
+	}
+}
+
+func TestResolveIncludeVarsFile(t *testing.T) {
+	dir := t.TempDir()
+	rolePath := filepath.Join(dir, "roles", "web")
+	writeTestFile(t, filepath.Join(rolePath, "vars", "app.yml"), "port: 80\n")
+	writeTestFile(t, filepath.Join(dir, "vars", "common.yml"), "tier: common\n")
+	writeTestFile(t, filepath.Join(dir, "extra.yml"), "extra: true\n")
+
+	inv, _ := loadTestInventory(t, "[local]\nlocalhost\n")
+	r := NewRunner(inv)
+	t.Cleanup(func() { r.Close() })
+	r.SetPlaybookPath(filepath.Join(dir, "site.yml"))
+
+	roleTask := &Task{Module: "include_vars", Scope: &VarScope{RoleName: "web", RolePath: rolePath}}
+	playTask := &Task{Module: "include_vars"}
+	tests := []struct {
+		name string
+		task *Task
+		args map[string]interface{}
+		key  string
+		want string
+	}{
+		{"role vars dir", roleTask, map[string]interface{}{"file": "app.yml"}, "file", filepath.Join(rolePath, "vars", "app.yml")},
+		{"role falls back to playbook", roleTask, map[string]interface{}{"_raw_params": "common.yml"}, "_raw_params", filepath.Join(dir, "vars", "common.yml")},
+		{"playbook vars dir", playTask, map[string]interface{}{"file": "common.yml"}, "file", filepath.Join(dir, "vars", "common.yml")},
+		{"playbook dir", playTask, map[string]interface{}{"file": "vars/common.yml"}, "file", filepath.Join(dir, "vars", "common.yml")},
+		{"playbook relative", playTask, map[string]interface{}{"file": "extra.yml"}, "file", filepath.Join(dir, "extra.yml")},
+		{"missing", playTask, map[string]interface{}{"file": "missing.yml"}, "file", filepath.Join(dir, "missing.yml")},
+		{"absolute", roleTask, map[string]interface{}{"file": "/etc/app.yml"}, "file", "/etc/app.yml"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r.resolveIncludeVarsFile(tt.task, tt.args)
+			if got := tt.args[tt.key]; got != tt.want {
+				t.Errorf("%s = %v, want %v", tt.key, got, tt.want)
+			}
+		})
+	}
+}
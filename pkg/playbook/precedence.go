@@ -0,0 +1,294 @@
+package playbook
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/jimyag/ansigo/pkg/inventory"
+)
+
+// VarLayer 变量来源，按 Ansible 文档中的变量优先级从低到高排列
+// 第 1 级是命令行选项（如 -u），它们不是变量，这里从第 2 级开始
+type VarLayer int
+
+const (
+	LayerRoleDefaults            VarLayer = iota + 2 // role defaults/main.yml
+	LayerInventoryGroupVars                          // inventory 文件中的组变量（按组深度合并）
+	LayerInventoryGroupVarsAll                       // inventory 目录下的 group_vars/all
+	LayerPlaybookGroupVarsAll                        // playbook 目录下的 group_vars/all
+	LayerInventoryGroupVarsFiles                     // inventory 目录下的 group_vars/*
+	LayerPlaybookGroupVarsFiles                      // playbook 目录下的 group_vars/*
+	LayerInventoryHostVars                           // inventory 文件中的主机变量
+	LayerInventoryHostVarsFiles                      // inventory 目录下的 host_vars/*
+	LayerPlaybookHostVarsFiles                       // playbook 目录下的 host_vars/*
+	LayerFacts                                       // gather_facts 收集的 facts
+	LayerPlayVars                                    // play vars
+	LayerPlayVarsPrompt                              // play vars_prompt
+	LayerPlayVarsFiles                               // play vars_files
+	LayerRoleVars                                    // role vars/main.yml
+	LayerBlockVars                                   // block vars（只作用于 block 中的任务）
+	LayerTaskVars                                    // task vars（只作用于该任务）
+	LayerIncludeVars                                 // include_vars 加载的变量
+	LayerSetFacts                                    // set_fact 和 register
+	LayerRoleParams                                  // role 参数（roles: [{role: x, port: 80}]）
+	LayerIncludeParams                               // import_tasks/include_role 的 vars
+	LayerExtraVars                                   // -e 指定的变量（总是优先）
+)
+
+var layerNames = map[VarLayer]string{
+	LayerRoleDefaults:            "role defaults",
+	LayerInventoryGroupVars:      "inventory group vars",
+	LayerInventoryGroupVarsAll:   "inventory group_vars/all",
+	LayerPlaybookGroupVarsAll:    "playbook group_vars/all",
+	LayerInventoryGroupVarsFiles: "inventory group_vars/*",
+	LayerPlaybookGroupVarsFiles:  "playbook group_vars/*",
+	LayerInventoryHostVars:       "inventory host vars",
+	LayerInventoryHostVarsFiles:  "inventory host_vars/*",
+	LayerPlaybookHostVarsFiles:   "playbook host_vars/*",
+	LayerFacts:                   "host facts",
+	LayerPlayVars:                "play vars",
+	LayerPlayVarsPrompt:          "play vars_prompt",
+	LayerPlayVarsFiles:           "play vars_files",
+	LayerRoleVars:                "role vars",
+	LayerBlockVars:               "block vars",
+	LayerTaskVars:                "task vars",
+	LayerIncludeVars:             "include_vars",
+	LayerSetFacts:                "set_facts / registered vars",
+	LayerRoleParams:              "role params",
+	LayerIncludeParams:           "include params",
+	LayerExtraVars:               "extra vars",
+}
+
+// String 返回变量来源的名称（与 Ansible 文档一致）
+func (l VarLayer) String() string {
+	if name, ok := layerNames[l]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+// VarScope 任务的变量作用域，加载 play 时根据任务所在的 role、block 和 include 设置
+// role 的变量只对该 role 的任务可见，不会泄漏到其他 role 或 play 的任务
+type VarScope struct {
+	RoleName      string                 // 任务所属的 role（play 中的任务为空）
+	RolePath      string                 // role 所在目录，用于查找 role 中的相对路径文件
+	RoleDefaults  map[string]interface{} // role defaults
+	RoleVars      map[string]interface{} // role vars
+	RoleParams    map[string]interface{} // role 参数
+	BlockVars     map[string]interface{} // 外层 block 的 vars（从外到内合并）
+	IncludeParams map[string]interface{} // 外层 import_tasks/include_role 的 vars（从外到内合并）
+}
+
+// withBlockVars 返回加入一层 block vars 后的作用域（不修改 s）
+func (s *VarScope) withBlockVars(vars map[string]interface{}) *VarScope {
+	scope := s.clone()
+	scope.BlockVars = mergeVars(scope.BlockVars, vars)
+	return scope
+}
+
+// withIncludeParams 返回加入一层 include 参数后的作用域（不修改 s）
+func (s *VarScope) withIncludeParams(vars map[string]interface{}) *VarScope {
+	scope := s.clone()
+	scope.IncludeParams = mergeVars(scope.IncludeParams, vars)
+	return scope
+}
+
+// clone 返回作用域的浅拷贝，s 为 nil 时返回空作用域
+func (s *VarScope) clone() *VarScope {
+	if s == nil {
+		return &VarScope{}
+	}
+	scope := *s
+	return &scope
+}
+
+// taskScope 返回执行任务时使用的作用域和 task vars
+// block 任务的 vars 是 block vars，对 block 自身（如 when）和其中的任务都可见
+func taskScope(task *Task) (*VarScope, map[string]interface{}) {
+	if task.TaskBlock != nil {
+		return task.Scope.withBlockVars(task.Vars), nil
+	}
+	return task.Scope, task.Vars
+}
+
+// scopeTasks 返回设置了作用域的任务副本，block 的 vars 传递给 block/rescue/always 中的任务
+// 原任务不会被修改
+func scopeTasks(tasks []Task, scope *VarScope) []Task {
+	result := make([]Task, len(tasks))
+	for i, task := range tasks {
+		task.Scope = scope
+		if task.TaskBlock != nil {
+			inner := scope.withBlockVars(task.Vars)
+			task.TaskBlock = &Block{
+				Block:  scopeTasks(task.TaskBlock.Block, inner),
+				Rescue: scopeTasks(task.TaskBlock.Rescue, inner),
+				Always: scopeTasks(task.TaskBlock.Always, inner),
+			}
+		}
+		result[i] = task
+	}
+	return result
+}
+
+// scopeHandlers 返回设置了作用域的 handler 副本
+func scopeHandlers(handlers []Handler, scope *VarScope) []Handler {
+	result := make([]Handler, len(handlers))
+	for i, handler := range handlers {
+		handler.Scope = scope
+		result[i] = handler
+	}
+	return result
+}
+
+// mergeVars 返回 base 加上 override 的新字典，override 中的变量优先
+func mergeVars(base, override map[string]interface{}) map[string]interface{} {
+	merged := make(map[string]interface{}, len(base)+len(override))
+	for k, v := range base {
+		merged[k] = v
+	}
+	for k, v := range override {
+		merged[k] = v
+	}
+	return merged
+}
+
+// varLayer 一层变量：来源、来源的细节（如组名、role 名）和变量
+type varLayer struct {
+	layer  VarLayer
+	source string
+	vars   map[string]interface{}
+}
+
+// VarSource 定义了某个变量的一层来源，用于 --explain-var
+type VarSource struct {
+	Layer  VarLayer
+	Source string // 来源的细节，如组名、role 名，可以为空
+	Value  interface{}
+}
+
+// String 返回来源的名称，如 "role defaults [web]"
+func (s VarSource) String() string {
+	if s.Source == "" {
+		return s.Layer.String()
+	}
+	return s.Layer.String() + " [" + s.Source + "]"
+}
+
+// varLayers 返回主机在 scope 中的所有变量层，按优先级从低到高排列
+// scope 为 nil 时只包含与任务无关的变量（inventory、facts、play、set_fact 和 extra vars）
+// 调用者需要持有读锁
+func (vm *VariableManager) varLayers(hostname string, scope *VarScope, taskVars map[string]interface{}) []varLayer {
+	if scope == nil {
+		scope = &VarScope{}
+	}
+
+	layers := []varLayer{{LayerRoleDefaults, scope.RoleName, scope.RoleDefaults}}
+
+	// inventory 组变量：all 组最先，子组覆盖父组
+	groups := vm.inventory.HostGroups(hostname)
+	for _, group := range groups {
+		layers = append(layers, varLayer{LayerInventoryGroupVars, group.Name, group.Vars})
+	}
+
+	// group_vars/：all 优先级最低，其他组按深度合并；playbook 目录下的文件优先于 inventory 目录下的
+	inventoryDirs, playbookDirs := vm.inventory.InventoryVarsDirs(), vm.inventory.PlaybookVarsDirs()
+	layers = append(layers,
+		varLayer{LayerInventoryGroupVarsAll, "all", inventoryDirs.GroupVars("all")},
+		varLayer{LayerPlaybookGroupVarsAll, "all", playbookDirs.GroupVars("all")},
+	)
+	for _, dirs := range []struct {
+		layer VarLayer
+		vars  *inventory.VarsDirs
+	}{{LayerInventoryGroupVarsFiles, inventoryDirs}, {LayerPlaybookGroupVarsFiles, playbookDirs}} {
+		for _, group := range groups {
+			if group.Name != "all" {
+				layers = append(layers, varLayer{dirs.layer, group.Name, dirs.vars.GroupVars(group.Name)})
+			}
+		}
+	}
+
+	if host, err := vm.inventory.GetHost(hostname); err == nil {
+		hostVars := host.OwnVars
+		if hostVars == nil {
+			hostVars = host.Vars
+		}
+		layers = append(layers, varLayer{LayerInventoryHostVars, "", hostVars})
+	}
+	layers = append(layers,
+		varLayer{LayerInventoryHostVarsFiles, "", inventoryDirs.HostVars(hostname)},
+		varLayer{LayerPlaybookHostVarsFiles, "", playbookDirs.HostVars(hostname)},
+	)
+
+	varsFiles, ok := vm.hostVarsFiles[hostname]
+	if !ok {
+		varsFiles = vm.playVarsFiles
+	}
+
+	layers = append(layers,
+		varLayer{LayerFacts, "", vm.facts[hostname]},
+		varLayer{LayerPlayVars, "", vm.playVars},
+		varLayer{LayerPlayVarsPrompt, "", vm.playVarsPrompt},
+		varLayer{LayerPlayVarsFiles, "", varsFiles},
+		varLayer{LayerRoleVars, scope.RoleName, scope.RoleVars},
+		varLayer{LayerBlockVars, "", scope.BlockVars},
+		varLayer{LayerTaskVars, "", taskVars},
+		varLayer{LayerIncludeVars, "", vm.includeVars[hostname]},
+		varLayer{LayerSetFacts, "", vm.registeredVars[hostname]},
+		varLayer{LayerRoleParams, scope.RoleName, scope.RoleParams},
+		varLayer{LayerIncludeParams, "", scope.IncludeParams},
+		varLayer{LayerExtraVars, "", vm.extraVars},
+	)
+	return layers
+}
+
+// mergeLayers 按优先级合并所有变量层
+func mergeLayers(layers []varLayer) map[string]interface{} {
+	merged := make(map[string]interface{})
+	for _, l := range layers {
+		for k, v := range l.vars {
+			merged[k] = v
+		}
+	}
+	return merged
+}
+
+// ExplainVar 返回主机执行 scope 中的任务时定义了变量 name 的所有来源，按优先级从低到高排列
+// 最后一项是实际使用的值；没有任何来源时返回空
+func (vm *VariableManager) ExplainVar(hostname string, scope *VarScope, taskVars map[string]interface{}, name string) []VarSource {
+	vm.mu.RLock()
+	defer vm.mu.RUnlock()
+
+	var sources []VarSource
+	for _, l := range vm.varLayers(hostname, scope, taskVars) {
+		if value, ok := l.vars[name]; ok {
+			sources = append(sources, VarSource{Layer: l.layer, Source: l.source, Value: value})
+		}
+	}
+	return sources
+}
+
+// explainVarText 格式化 --explain-var 的输出，按优先级从高到低列出定义了变量的各层
+//
+//	EXPLAIN VAR [http_port] on [web1]: 8080 (role params [web])
+//	  role params [web]: 8080
+//	  role defaults [web]: 80 (overridden)
+func explainVarText(name, hostName string, value interface{}, defined bool, sources []VarSource) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "EXPLAIN VAR [%s] on [%s]: ", name, hostName)
+	switch {
+	case !defined:
+		b.WriteString("not defined")
+	case len(sources) == 0:
+		fmt.Fprintf(&b, "%v (magic variable)", value)
+	default:
+		fmt.Fprintf(&b, "%v (%s)", value, sources[len(sources)-1])
+	}
+
+	for i := len(sources) - 1; i >= 0; i-- {
+		fmt.Fprintf(&b, "\n  %s: %v", sources[i], sources[i].Value)
+		if i < len(sources)-1 {
+			b.WriteString(" (overridden)")
+		}
+	}
+	return b.String()
+}
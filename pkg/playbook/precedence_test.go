@@ -0,0 +1,295 @@
+package playbook
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/jimyag/ansigo/pkg/inventory"
+)
+
+func TestVariablePrecedence(t *testing.T) {
+	inv, _ := loadTestInventory(t, `[nginx]
+web1 x=inventory_host
+
+[web:children]
+nginx
+
+[all:vars]
+x=group_all
+
+[web:vars]
+x=group_web
+
+[nginx:vars]
+x=group_nginx
+`)
+
+	// 每一层都定义 x，按优先级从低到高逐层加入，每次都应该使用最新加入的一层
+	// role defaults 优先级最低，inventory 中已经定义了 x，因此从 inventory 主机变量开始检查
+	set := func(value string) map[string]interface{} { return map[string]interface{}{"x": value} }
+	steps := []struct {
+		layer VarLayer
+		apply func(vm *VariableManager, scope *VarScope, taskVars *map[string]interface{})
+	}{
+		{LayerInventoryHostVars, func(*VariableManager, *VarScope, *map[string]interface{}) {}},
+		{LayerFacts, func(vm *VariableManager, _ *VarScope, _ *map[string]interface{}) {
+			vm.SetHostVars("web1", set("facts"))
+		}},
+		{LayerPlayVars, func(vm *VariableManager, _ *VarScope, _ *map[string]interface{}) { vm.SetPlayVars(set("play_vars")) }},
+		{LayerPlayVarsPrompt, func(vm *VariableManager, _ *VarScope, _ *map[string]interface{}) {
+			vm.SetPlayVarsPrompt(set("vars_prompt"))
+		}},
+		{LayerPlayVarsFiles, func(vm *VariableManager, _ *VarScope, _ *map[string]interface{}) {
+			vm.SetPlayVarsFiles(set("vars_files"))
+		}},
+		{LayerRoleVars, func(vm *VariableManager, s *VarScope, _ *map[string]interface{}) { s.RoleVars = set("role_vars") }},
+		{LayerBlockVars, func(vm *VariableManager, s *VarScope, _ *map[string]interface{}) { s.BlockVars = set("block_vars") }},
+		{LayerTaskVars, func(vm *VariableManager, _ *VarScope, tv *map[string]interface{}) { *tv = set("task_vars") }},
+		{LayerIncludeVars, func(vm *VariableManager, _ *VarScope, _ *map[string]interface{}) {
+			vm.SetIncludeVars("web1", set("include_vars"))
+		}},
+		{LayerSetFacts, func(vm *VariableManager, _ *VarScope, _ *map[string]interface{}) {
+			vm.SetHostVar("web1", "x", "set_fact")
+		}},
+		{LayerRoleParams, func(vm *VariableManager, s *VarScope, _ *map[string]interface{}) { s.RoleParams = set("role_params") }},
+		{LayerIncludeParams, func(vm *VariableManager, s *VarScope, _ *map[string]interface{}) {
+			s.IncludeParams = set("include_params")
+		}},
+		{LayerExtraVars, func(vm *VariableManager, _ *VarScope, _ *map[string]interface{}) { vm.SetExtraVars(set("extra_vars")) }},
+	}
+
+	vm := NewVariableManager(inv)
+	scope := &VarScope{RoleName: "web", RoleDefaults: set("role_defaults")}
+	var taskVars map[string]interface{}
+	for _, step := range steps {
+		step.apply(vm, scope, &taskVars)
+
+		sources := vm.ExplainVar("web1", scope, taskVars, "x")
+		if len(sources) == 0 {
+			t.Fatalf("after %s: ExplainVar() returned no sources", step.layer)
+		}
+		winner := sources[len(sources)-1]
+		if winner.Layer != step.layer {
+			t.Errorf("after %s: x comes from %s", step.layer, winner)
+		}
+		if got := vm.GetTaskContext("web1", scope, taskVars)["x"]; got != winner.Value {
+			t.Errorf("after %s: GetTaskContext()[x] = %v, want %v", step.layer, got, winner.Value)
+		}
+	}
+
+	// inventory 组变量按深度合并：all < web < nginx
+	sources := vm.ExplainVar("web1", scope, taskVars, "x")
+	if sources[0].Layer != LayerRoleDefaults {
+		t.Errorf("lowest source = %s, want role defaults", sources[0])
+	}
+	var groups []string
+	for _, s := range sources {
+		if s.Layer == LayerInventoryGroupVars {
+			groups = append(groups, s.Source)
+		}
+	}
+	if want := []string{"all", "web", "nginx"}; !reflect.DeepEqual(groups, want) {
+		t.Errorf("inventory group layers = %v, want %v", groups, want)
+	}
+	if len(sources) != 1+len(steps)+len(groups) {
+		t.Errorf("ExplainVar() returned %d sources, want %d", len(sources), 1+len(steps)+len(groups))
+	}
+
+	// 没有任务作用域时不包含 role、block、task 和 include 的变量
+	vm.SetExtraVars(nil)
+	if got := vm.GetContext("web1")["x"]; got != "set_fact" {
+		t.Errorf("GetContext()[x] = %v, want set_fact", got)
+	}
+}
+
+func TestRoleVarsScope(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "roles", "web", "defaults", "main.yml"), "port: 80\nweb_only: true\n")
+	writeTestFile(t, filepath.Join(dir, "roles", "web", "vars", "main.yml"), "user: www\n")
+	writeTestFile(t, filepath.Join(dir, "roles", "web", "tasks", "main.yml"), `
+- name: web task
+  debug: {msg: web}
+`)
+	writeTestFile(t, filepath.Join(dir, "roles", "db", "defaults", "main.yml"), "port: 5432\n")
+	writeTestFile(t, filepath.Join(dir, "roles", "db", "tasks", "main.yml"), `
+- name: db task
+  debug: {msg: db}
+`)
+	writeTestFile(t, filepath.Join(dir, "tasks", "extra.yml"), `
+- name: imported task
+  debug: {msg: imported}
+  vars:
+    level: task
+`)
+	playbookPath := filepath.Join(dir, "site.yml")
+	writeTestFile(t, playbookPath, `
+- name: Site
+  hosts: all
+  vars:
+    port: 8080
+  roles:
+    - role: web
+      port: 8000
+    - db
+  tasks:
+    - name: play block
+      vars:
+        level: block
+        block_only: true
+      block:
+        - name: block task
+          debug: {msg: block}
+          vars:
+            level: task
+    - import_tasks: tasks/extra.yml
+      vars:
+        level: include
+        included: true
+    - include_role:
+        name: db
+      vars:
+        port: 6432
+`)
+
+	data, err := os.ReadFile(playbookPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pb, err := ParsePlaybook(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	inv, _ := loadTestInventory(t, "[local]\nlocalhost\n")
+	r := NewRunner(inv)
+	defer r.Close()
+	r.SetPlaybookPath(playbookPath)
+
+	playVars, err := r.loadPlayVars(&pb[0], false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tasks, _, err := r.loadPlayTasks(&pb[0], playVars.merged())
+	if err != nil {
+		t.Fatalf("loadPlayTasks() error = %v", err)
+	}
+	r.varMgr.SetPlayVars(playVars.vars)
+
+	contexts := make(map[string]map[string]interface{})
+	var walk func(tasks []Task)
+	walk = func(tasks []Task) {
+		for i := range tasks {
+			// include_role 再次加载的 db task 在最后单独检查
+			if _, seen := contexts[tasks[i].Name]; !seen {
+				contexts[tasks[i].Name] = r.taskContext(&tasks[i], "localhost")
+			}
+			if tasks[i].TaskBlock != nil {
+				walk(tasks[i].TaskBlock.Block)
+			}
+		}
+	}
+	walk(tasks)
+
+	tests := []struct {
+		task    string
+		want    map[string]interface{}
+		missing []string
+	}{
+		// role 参数优先于 play vars，role vars 只在 role 中可见
+		{task: "web task", want: map[string]interface{}{"port": 8000, "user": "www", "web_only": true}},
+		// role defaults 的优先级低于 play vars；web 的变量不会泄漏到 db
+		{task: "db task", want: map[string]interface{}{"port": 8080}, missing: []string{"user", "web_only"}},
+		{task: "play block", want: map[string]interface{}{"level": "block", "port": 8080}, missing: []string{"user"}},
+		// task vars 优先于 block vars
+		{task: "block task", want: map[string]interface{}{"level": "task", "block_only": true}},
+		// import_tasks 的 vars 是 include 参数，优先于 task vars
+		{task: "imported task", want: map[string]interface{}{"level": "include", "included": true}},
+	}
+	for _, tt := range tests {
+		ctx, ok := contexts[tt.task]
+		if !ok {
+			t.Errorf("task %q not loaded", tt.task)
+			continue
+		}
+		for k, want := range tt.want {
+			if ctx[k] != want {
+				t.Errorf("task %q: %s = %v, want %v", tt.task, k, ctx[k], want)
+			}
+		}
+		for _, k := range tt.missing {
+			if _, leaked := ctx[k]; leaked {
+				t.Errorf("task %q: %s = %v leaked from another scope", tt.task, k, ctx[k])
+			}
+		}
+	}
+
+	// include_role 的 vars 优先于 play vars 和 role defaults
+	last := tasks[len(tasks)-1]
+	if last.Name != "db task" || r.taskContext(&last, "localhost")["port"] != 6432 {
+		t.Errorf("include_role task %q port = %v, want 6432", last.Name, r.taskContext(&last, "localhost")["port"])
+	}
+}
+
+func TestExplainVarText(t *testing.T) {
+	sources := []VarSource{
+		{Layer: LayerRoleDefaults, Source: "web", Value: 80},
+		{Layer: LayerPlayVars, Value: 8080},
+		{Layer: LayerRoleParams, Source: "web", Value: 8000},
+	}
+	want := `EXPLAIN VAR [port] on [web1]: 8000 (role params [web])
+  role params [web]: 8000
+  play vars: 8080 (overridden)
+  role defaults [web]: 80 (overridden)`
+	if got := explainVarText("port", "web1", 8000, true, sources); got != want {
+		t.Errorf("explainVarText() = %q, want %q", got, want)
+	}
+
+	if got := explainVarText("port", "web1", nil, false, nil); got != "EXPLAIN VAR [port] on [web1]: not defined" {
+		t.Errorf("explainVarText() undefined = %q", got)
+	}
+	if got := explainVarText("inventory_hostname", "web1", "web1", true, nil); got != "EXPLAIN VAR [inventory_hostname] on [web1]: web1 (magic variable)" {
+		t.Errorf("explainVarText() magic = %q", got)
+	}
+}
+
+func TestVarsDirsPrecedence(t *testing.T) {
+	invDir, playbookDir := t.TempDir(), t.TempDir()
+	writeTestFile(t, filepath.Join(invDir, "hosts.ini"), "[web]\nweb1 x=inventory_host\n")
+	writeTestFile(t, filepath.Join(invDir, "group_vars", "all.yml"), "x: inventory_all\n")
+	writeTestFile(t, filepath.Join(invDir, "group_vars", "web", "main.yml"), "x: inventory_web\n")
+	writeTestFile(t, filepath.Join(invDir, "host_vars", "web1.yml"), "x: inventory_host_file\n")
+	writeTestFile(t, filepath.Join(playbookDir, "group_vars", "all.yml"), "x: playbook_all\n")
+	writeTestFile(t, filepath.Join(playbookDir, "group_vars", "web.yml"), "x: playbook_web\n")
+	writeTestFile(t, filepath.Join(playbookDir, "host_vars", "web1.yml"), "x: playbook_host_file\n")
+
+	inv := inventory.NewManager()
+	if err := inv.Load(filepath.Join(invDir, "hosts.ini")); err != nil {
+		t.Fatal(err)
+	}
+	if err := inv.LoadPlaybookVars(playbookDir); err != nil {
+		t.Fatal(err)
+	}
+
+	vm := NewVariableManager(inv)
+	var got []string
+	for _, s := range vm.ExplainVar("web1", nil, nil, "x") {
+		got = append(got, s.String()+"="+s.Value.(string))
+	}
+	want := []string{
+		"inventory group_vars/all [all]=inventory_all",
+		"playbook group_vars/all [all]=playbook_all",
+		"inventory group_vars/* [web]=inventory_web",
+		"playbook group_vars/* [web]=playbook_web",
+		"inventory host vars=inventory_host",
+		"inventory host_vars/*=inventory_host_file",
+		"playbook host_vars/*=playbook_host_file",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ExplainVar() = %v, want %v", got, want)
+	}
+	if x := vm.GetContext("web1")["x"]; x != "playbook_host_file" {
+		t.Errorf("GetContext()[x] = %v, want playbook_host_file", x)
+	}
+}
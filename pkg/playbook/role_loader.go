@@ -28,6 +28,7 @@ func NewRoleLoader(playbookPath string) *RoleLoader {
 }
 
 // LoadRole 加载指定的 Role
+// spec 中的变量是 role 参数，不合并到 role.Vars 中（它们的优先级不同）
 func (rl *RoleLoader) LoadRole(spec RoleSpec) (*Role, error) {
 	// 查找 role 目录
 	rolePath, err := rl.findRolePath(spec.Name)
@@ -58,11 +59,6 @@ func (rl *RoleLoader) LoadRole(spec RoleSpec) (*Role, error) {
 		}
 	}
 
-	// 合并 RoleSpec 中的变量（最高优先级）
-	for k, v := range spec.Vars {
-		role.Vars[k] = v
-	}
-
 	// 加载 tasks/main.yaml
 	if err := rl.loadRoleTasks(role); err != nil {
 		return nil, fmt.Errorf("failed to load role tasks: %w", err)
@@ -197,7 +193,7 @@ func ParseRoleSpec(roleData interface{}) (RoleSpec, error) {
 		// 简单格式: roles: [common, nginx]
 		spec.Name = v
 	case map[string]interface{}:
-		// 字典格式: roles: [{role: common, vars: {...}}]
+		// 字典格式: roles: [{role: common, tags: [...], vars: {...}}]
 		if name, ok := v["role"].(string); ok {
 			spec.Name = name
 		} else if name, ok := v["name"].(string); ok {
@@ -206,9 +202,26 @@ func ParseRoleSpec(roleData interface{}) (RoleSpec, error) {
 			return spec, fmt.Errorf("role spec must have 'role' or 'name' field")
 		}
 
-		// 提取其他字段作为变量
+		// tags 是 role 中所有任务继承的标签
+		tags, err := parseTags(v["tags"])
+		if err != nil {
+			return spec, fmt.Errorf("role %s: %w", spec.Name, err)
+		}
+		spec.Tags = tags
+
+		// 提取其他字段作为 role 参数，vars 字段中的变量同样作为 role 参数
 		for k, val := range v {
-			if k != "role" && k != "name" {
+			switch k {
+			case "role", "name", "tags":
+			case "vars":
+				vars, ok := val.(map[string]interface{})
+				if !ok {
+					return spec, fmt.Errorf("role %s: vars must be a dictionary", spec.Name)
+				}
+				for name, value := range vars {
+					spec.Vars[name] = value
+				}
+			default:
 				spec.Vars[k] = val
 			}
 		}
@@ -2,15 +2,20 @@ package playbook
 
 import (
 	"fmt"
+	"io"
 	"os"
+	"path/filepath"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/jimyag/ansigo/pkg/connection"
+	ansierrors "github.com/jimyag/ansigo/pkg/errors"
 	"github.com/jimyag/ansigo/pkg/facts"
 	"github.com/jimyag/ansigo/pkg/inventory"
 	"github.com/jimyag/ansigo/pkg/logger"
 	"github.com/jimyag/ansigo/pkg/module"
+	"github.com/jimyag/ansigo/pkg/worker"
 )
 
 // Runner Playbook 执行器
@@ -21,9 +26,19 @@ type Runner struct {
 	varMgr           *VariableManager
 	template         TemplateEngineInterface
 	logger           *logger.AnsibleLogger
-	notifiedHandlers map[string]bool // 记录被通知的 handlers
-	playbookPath     string          // Playbook 文件路径（用于 role 查找）
-	currentPlay      *Play           // 当前正在执行的 Play（用于访问 play 级别设置）
+	notifiedHandlers map[string]bool        // 记录被通知的 handlers
+	playbookPath     string                 // Playbook 文件路径（用于 role 查找）
+	currentPlay      *Play                  // 当前正在执行的 Play（用于访问 play 级别设置）
+	pool             *worker.Pool           // 限制同时操作的主机数（forks）
+	tagFilter        TagFilter              // --tags/--skip-tags 选择的任务
+	checkMode        bool                   // --check：只报告将要做的修改，不修改目标主机
+	diffMode         bool                   // --diff：输出修改文件的模块修改前后的 diff
+	extraVars        map[string]interface{} // -e 指定的变量（最高优先级）
+	varPrompt        VarPrompter            // vars_prompt 读取输入（nil 时使用默认值）
+	explainVar       string                 // --explain-var：执行任务时输出该变量的来源
+
+	runOnceMu      sync.Mutex
+	runOnceResults map[*Task]*runOnceResult // 当前批次中 run_once 任务的执行结果
 }
 
 // NewRunner 创建 Playbook Runner
@@ -35,24 +50,109 @@ func NewRunner(inv *inventory.Manager) *Runner {
 		varMgr:    NewVariableManager(inv),
 		template:  NewDefaultTemplateEngine(), // 使用 Jinja2 引擎
 		logger:    logger.NewAnsibleLogger(false),
+		pool:      worker.NewPool(worker.DefaultForks),
 	}
 }
 
+// SetForks 设置同时操作的最大主机数（小于 1 时使用默认值 5）
+func (r *Runner) SetForks(forks int) {
+	r.pool = worker.NewPool(forks)
+}
+
+// SetTagFilter 设置 --tags/--skip-tags，只执行被选中的任务
+func (r *Runner) SetTagFilter(filter TagFilter) {
+	r.tagFilter = filter
+}
+
+// SetCheckMode 设置 check 模式（--check），任务的 check_mode 优先于它
+func (r *Runner) SetCheckMode(check bool) {
+	r.checkMode = check
+	r.varMgr.SetCheckMode(check)
+}
+
+// taskCheckMode 判断任务是否以 check 模式执行
+func (r *Runner) taskCheckMode(task *Task) bool {
+	if task.CheckMode != nil {
+		return *task.CheckMode
+	}
+	return r.checkMode
+}
+
+// SetDiffMode 设置 diff 模式（--diff），任务的 diff 关键字优先于它
+func (r *Runner) SetDiffMode(diff bool) {
+	r.diffMode = diff
+	r.varMgr.SetDiffMode(diff)
+}
+
+// taskDiffMode 判断任务是否输出 diff
+func (r *Runner) taskDiffMode(task *Task) bool {
+	if task.Diff != nil {
+		return *task.Diff
+	}
+	return r.diffMode
+}
+
+// SetExtraVars 设置 -e 指定的变量，它们覆盖其他所有变量
+func (r *Runner) SetExtraVars(vars map[string]interface{}) {
+	r.extraVars = vars
+	r.varMgr.SetExtraVars(vars)
+}
+
+// SetVarPrompt 设置 vars_prompt 读取输入的方式，未设置时（非交互运行）使用默认值
+func (r *Runner) SetVarPrompt(prompt VarPrompter) {
+	r.varPrompt = prompt
+}
+
+// withExtraVars 返回 vars 加上 extra vars 的副本，用于渲染加载阶段的模板
+func (r *Runner) withExtraVars(vars map[string]interface{}) map[string]interface{} {
+	merged := make(map[string]interface{}, len(vars)+len(r.extraVars))
+	for k, v := range vars {
+		merged[k] = v
+	}
+	for k, v := range r.extraVars {
+		merged[k] = v
+	}
+	return merged
+}
+
+// SetExplainVar 设置 --explain-var，执行每个任务时输出变量 name 的值来自哪一层
+func (r *Runner) SetExplainVar(name string) {
+	r.explainVar = name
+}
+
 // SetPlaybookPath 设置 playbook 文件路径
 func (r *Runner) SetPlaybookPath(path string) {
 	r.playbookPath = path
 }
 
-// Close 关闭 Runner 并释放资源
+// ConnectionManager 返回 Runner 使用的连接管理器（用于设置连接选项）
+func (r *Runner) ConnectionManager() *connection.Manager {
+	return r.connMgr
+}
+
+// Close 关闭 Runner 并释放资源（模板引擎和连接池）
 func (r *Runner) Close() error {
+	var firstErr error
 	if r.template != nil {
-		return r.template.Close()
+		firstErr = r.template.Close()
 	}
-	return nil
+	if r.connMgr != nil {
+		if err := r.connMgr.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
 }
 
 // Run 执行整个 Playbook
 func (r *Runner) Run(playbook Playbook) error {
+	// 加载 playbook 所在目录的 group_vars/ 和 host_vars/
+	if r.playbookPath != "" {
+		if err := r.inventory.LoadPlaybookVars(filepath.Dir(r.playbookPath)); err != nil {
+			return err
+		}
+	}
+
 	for _, play := range playbook {
 		if err := r.ExecutePlay(&play); err != nil {
 			return fmt.Errorf("play '%s' failed: %w", play.Name, err)
@@ -61,6 +161,25 @@ func (r *Runner) Run(playbook Playbook) error {
 	return nil
 }
 
+// ListTags 输出每个 play 的标签和被 --tags/--skip-tags 选中的任务使用的标签（--list-tags），不执行任务
+func (r *Runner) ListTags(playbook Playbook, w io.Writer) error {
+	for i := range playbook {
+		play := &playbook[i]
+		playVars, err := r.loadPlayVars(play, false)
+		if err != nil {
+			return fmt.Errorf("play '%s' failed: %w", play.Name, err)
+		}
+		tasks, _, err := r.loadPlayTasks(play, playVars.merged())
+		if err != nil {
+			return fmt.Errorf("play '%s' failed: %w", play.Name, err)
+		}
+
+		fmt.Fprintf(w, "\n  play #%d (%s): %s\tTAGS: [%s]\n", i+1, play.Hosts, play.Name, strings.Join(play.Tags, ", "))
+		fmt.Fprintf(w, "      TASK TAGS: [%s]\n", strings.Join(collectTags(filterTasks(tasks, r.tagFilter)), ", "))
+	}
+	return nil
+}
+
 // ExecutePlay 执行单个 Play
 func (r *Runner) ExecutePlay(play *Play) error {
 	r.logger.PlayHeader(play.Name)
@@ -68,12 +187,114 @@ func (r *Runner) ExecutePlay(play *Play) error {
 	// 设置当前 Play（用于任务执行时访问 play 级别设置）
 	r.currentPlay = play
 
-	// 初始化 notified handlers 跟踪
-	r.notifiedHandlers = make(map[string]bool)
+	playVars, err := r.loadPlayVars(play, true)
+	if err != nil {
+		return err
+	}
 
-	// 加载并展开 roles
-	var allTasks []Task
-	var allHandlers []Handler
+	allTasks, allHandlers, err := r.loadPlayTasks(play, playVars.merged())
+	if err != nil {
+		return err
+	}
+
+	// 按 --tags/--skip-tags 选择任务
+	allTasks = filterTasks(allTasks, r.tagFilter)
+
+	// 设置 Play 变量
+	r.varMgr.SetPlayVars(playVars.vars)
+	r.varMgr.SetPlayVarsPrompt(playVars.prompt)
+	r.varMgr.SetPlayVarsFiles(playVars.files)
+
+	// 获取目标主机
+	hosts, err := r.inventory.GetHosts(play.Hosts)
+	if err != nil {
+		return fmt.Errorf("failed to get hosts: %w", err)
+	}
+
+	if len(hosts) == 0 {
+		return fmt.Errorf("no hosts matched pattern: %s", play.Hosts)
+	}
+
+	// 设置 play 主机列表（用于魔法变量）
+	hostNames := make([]string, len(hosts))
+	for i, host := range hosts {
+		hostNames[i] = host.Name
+	}
+	r.varMgr.SetPlayHosts(hostNames)
+
+	// 主机统计（跨批次累计）
+	stats := make(map[string]*HostStats)
+	for _, host := range hosts {
+		stats[host.Name] = &HostStats{}
+	}
+
+	strategy, err := GetStrategy(play.Strategy)
+	if err != nil {
+		return err
+	}
+
+	// 按 serial 划分批次，每个批次依次执行完整的任务列表和 handlers
+	batches, err := splitSerialBatches(play.Serial, hosts)
+	if err != nil {
+		return fmt.Errorf("failed to split hosts into batches: %w", err)
+	}
+
+	var abortErr error
+	for i, batch := range batches {
+		if i > 0 {
+			r.logger.PlayHeader(play.Name)
+		}
+
+		activeHosts, err := r.executeBatch(play, strategy, batch, allTasks, allHandlers, stats)
+		if err != nil {
+			return err
+		}
+
+		// 检查批次失败比例，超过阈值或整个批次失败时不再执行后续批次
+		failed := len(batch) - len(activeHosts)
+		if exceedsMaxFailPercentage(play.MaxFailPercentage, failed, len(batch)) {
+			abortErr = fmt.Errorf("max_fail_percentage %v%% exceeded: %d of %d hosts failed", *play.MaxFailPercentage, failed, len(batch))
+		} else if failed == len(batch) && i < len(batches)-1 {
+			abortErr = fmt.Errorf("all hosts in batch %d failed", i+1)
+		}
+		if abortErr != nil {
+			r.logger.Warning(fmt.Sprintf("Aborting play: %v", abortErr))
+			break
+		}
+	}
+
+	// 打印 Play Recap
+	r.printPlayRecap(play.Name, stats)
+
+	if abortErr != nil {
+		return abortErr
+	}
+
+	// 检查是否有失败
+	for _, stat := range stats {
+		if !stat.IsSuccess() {
+			return fmt.Errorf("play had failures")
+		}
+	}
+
+	return nil
+}
+
+// playVarLayers play 的 vars、vars_prompt 和 vars_files，它们在变量优先级中是不同的三层
+type playVarLayers struct {
+	vars   map[string]interface{}
+	prompt map[string]interface{}
+	files  map[string]interface{}
+}
+
+// merged 按优先级合并三层变量，用于加载阶段的模板渲染
+func (p *playVarLayers) merged() map[string]interface{} {
+	return mergeVars(mergeVars(p.vars, p.prompt), p.files)
+}
+
+// loadPlayVars 加载 play 变量：vars、vars_prompt 和 vars_files
+// prompt 为 false 时（如 --list-tags）不处理 vars_prompt
+func (r *Runner) loadPlayVars(play *Play, prompt bool) (*playVarLayers, error) {
 	playVars := make(map[string]interface{})
 
 	// 复制 play vars
@@ -85,9 +306,33 @@ func (r *Runner) ExecutePlay(play *Play) error {
 	lookupHandler := NewLookupHandler(r.playbookPath, r.template)
 	processedVars, err := lookupHandler.ProcessLookupsInVars(playVars, playVars)
 	if err != nil {
-		return fmt.Errorf("failed to process lookups in play vars: %w", err)
+		return nil, fmt.Errorf("failed to process lookups in play vars: %w", err)
 	}
-	playVars = processedVars
+	layers := &playVarLayers{vars: processedVars}
+
+	if prompt {
+		layers.prompt, err = r.promptVars(play.VarsPrompt)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// vars_files 的文件名可以引用 vars 和 vars_prompt 中的变量；引用主机变量的文件在执行时按主机加载
+	layers.files, err = r.loadVarsFiles(play.VarsFiles, layers.merged(), true)
+	if err != nil {
+		return nil, err
+	}
+
+	return layers, nil
+}
+
+// loadPlayTasks 加载 play 的 roles、任务和 handlers，展开 import_tasks/include_role 并传递标签
+// role 的 defaults、vars 和参数设置到 role 中任务和 handlers 的作用域上，只对它们可见
+// playVars 用于渲染加载阶段的模板
+func (r *Runner) loadPlayTasks(play *Play, playVars map[string]interface{}) ([]Task, []Handler, error) {
+	// 加载并展开 roles
+	var allTasks []Task
+	var allHandlers []Handler
 
 	// 处理 roles
 	if len(play.Roles) > 0 {
@@ -97,202 +342,206 @@ func (r *Runner) ExecutePlay(play *Play) error {
 			// 解析 role spec
 			spec, err := ParseRoleSpec(roleData)
 			if err != nil {
-				return fmt.Errorf("failed to parse role spec: %w", err)
+				return nil, nil, fmt.Errorf("failed to parse role spec: %w", err)
 			}
 
 			// 加载 role
 			role, err := loader.LoadRole(spec)
 			if err != nil {
-				return fmt.Errorf("failed to load role '%s': %w", spec.Name, err)
+				return nil, nil, fmt.Errorf("failed to load role '%s': %w", spec.Name, err)
 			}
 
-			// 合并 role defaults（最低优先级）
-			for k, v := range role.Defaults {
-				if _, exists := playVars[k]; !exists {
-					playVars[k] = v
-				}
-			}
-
-			// 合并 role vars（高优先级）
-			for k, v := range role.Vars {
-				playVars[k] = v
+			scope := &VarScope{
+				RoleName:     role.Name,
+				RolePath:     role.Path,
+				RoleDefaults: role.Defaults,
+				RoleVars:     role.Vars,
+				RoleParams:   spec.Vars,
 			}
 
-			// 添加 role 任务到任务列表
-			allTasks = append(allTasks, role.Tasks...)
+			// 添加 role 任务到任务列表（继承 role 的标签）
+			allTasks = append(allTasks, scopeTasks(inheritTags(role.Tasks, spec.Tags), scope)...)
 
 			// 添加 role handlers
-			allHandlers = append(allHandlers, role.Handlers...)
+			allHandlers = append(allHandlers, scopeHandlers(role.Handlers, scope)...)
 		}
 	}
 
 	// 添加 play 的任务（在 role 任务之后）
-	allTasks = append(allTasks, play.Tasks...)
+	allTasks = append(allTasks, scopeTasks(play.Tasks, nil)...)
 
 	// 添加 play 的 handlers
 	allHandlers = append(allHandlers, play.Handlers...)
 
 	// 展开任务（处理 import_tasks 和 include_role）
 	taskIncluder := NewTaskIncluder(r.playbookPath)
-	expandedTasks, err := r.expandAllTasks(allTasks, taskIncluder, playVars)
+	expandedTasks, err := r.expandAllTasks(allTasks, taskIncluder, r.withExtraVars(playVars))
 	if err != nil {
-		return fmt.Errorf("failed to expand tasks: %w", err)
+		return nil, nil, fmt.Errorf("failed to expand tasks: %w", err)
 	}
-	allTasks = expandedTasks
 
-	// 设置合并后的 Play 变量
-	r.varMgr.SetPlayVars(playVars)
+	// play 的标签传递给所有任务，block 的标签传递给其中的任务
+	allTasks = inheritTags(expandedTasks, play.Tags)
 
-	// 获取目标主机
-	hosts, err := r.inventory.GetHosts(play.Hosts)
-	if err != nil {
-		return fmt.Errorf("failed to get hosts: %w", err)
+	// 检查模块参数，参数错误时在连接主机之前失败
+	if err := checkTasksArgs(allTasks, allHandlers); err != nil {
+		return nil, nil, err
 	}
 
-	if len(hosts) == 0 {
-		return fmt.Errorf("no hosts matched pattern: %s", play.Hosts)
-	}
+	return allTasks, allHandlers, nil
+}
 
-	// 设置 play 主机列表（用于魔法变量）
-	hostNames := make([]string, len(hosts))
+// executeBatch 在一个批次的主机上执行 gather facts、所有任务和被通知的 handlers
+// 返回批次中仍然活跃（未失败）的主机
+func (r *Runner) executeBatch(play *Play, strategy Strategy, hosts []*inventory.Host, allTasks []Task, allHandlers []Handler, stats map[string]*HostStats) ([]*inventory.Host, error) {
+	// 每个批次独立跟踪被通知的 handlers 和 run_once 任务
+	r.notifiedHandlers = make(map[string]bool)
+	r.runOnceResults = make(map[*Task]*runOnceResult)
+
+	// 设置当前批次的主机列表（ansible_play_batch）
+	batchNames := make([]string, len(hosts))
 	for i, host := range hosts {
-		hostNames[i] = host.Name
+		batchNames[i] = host.Name
 	}
-	r.varMgr.SetPlayHosts(hostNames)
+	r.varMgr.SetPlayBatch(batchNames)
 
 	// 跟踪活跃主机（未失败的主机）
 	activeHosts := make([]*inventory.Host, len(hosts))
 	copy(activeHosts, hosts)
 
-	// 主机统计
-	stats := make(map[string]*HostStats)
-	for _, host := range hosts {
-		stats[host.Name] = &HostStats{}
-	}
-
 	// Gather facts if enabled (default is true unless explicitly set to false)
 	if play.GatherFacts {
 		if err := r.gatherFactsForHosts(hosts); err != nil {
-			return fmt.Errorf("failed to gather facts: %w", err)
+			return nil, fmt.Errorf("failed to gather facts: %w", err)
 		}
 	}
 
-	// 执行所有任务（包括 role 任务和 play 任务）
-	for taskIdx, task := range allTasks {
-		if len(activeHosts) == 0 {
-			r.logger.Warning("No more hosts available, stopping play")
-			break
-		}
-
-		// 显示任务名称
-		taskName := task.Name
-		if taskName == "" {
-			taskName = task.Module
-		}
-		r.logger.TaskHeader(taskName)
+	// 按主机加载 vars_files（可以引用刚收集的 facts）
+	if err := r.loadHostVarsFiles(play, hosts); err != nil {
+		return nil, fmt.Errorf("failed to load vars_files: %w", err)
+	}
 
-		// 并发执行任务
-		results := make(chan *TaskResult, len(activeHosts))
-		var wg sync.WaitGroup
+	// 按 play 的执行策略执行所有任务（包括 role 任务和 play 任务）
+	activeHosts = strategy.Run(r, activeHosts, allTasks, stats)
+	if len(allTasks) > 0 {
+		fmt.Println()
+	}
 
-		for _, host := range activeHosts {
-			wg.Add(1)
-			go func(h *inventory.Host) {
-				defer wg.Done()
-				result := r.executeTask(&task, h)
-				results <- result
-			}(host)
+	// 执行所有被通知的 handlers（包括 role handlers 和 play handlers）
+	if len(allHandlers) > 0 && len(r.notifiedHandlers) > 0 {
+		if err := r.executeHandlers(allHandlers, activeHosts, stats); err != nil {
+			return nil, fmt.Errorf("handler execution failed: %w", err)
 		}
+	}
 
-		// 等待所有任务完成
-		go func() {
-			wg.Wait()
-			close(results)
-		}()
+	return activeHosts, nil
+}
 
-		// 收集结果
-		failedHosts := []string{}
-		newActiveHosts := []*inventory.Host{}
+// recordTaskResult 处理任务结果：更新统计、register、facts 和 notify
+// 返回主机是否可以继续执行后续任务（失败且未忽略错误时返回 false）
+func (r *Runner) recordTaskResult(task *Task, result *TaskResult, stats map[string]*HostStats) bool {
+	// 更新统计
+	hostStat := stats[result.Host]
+	if result.Failed {
+		hostStat.Failed++
+	} else if result.Skipped {
+		hostStat.Skipped++
+	} else {
+		hostStat.Ok++
+		if result.Changed {
+			hostStat.Changed++
+		}
+	}
 
-		for result := range results {
-			// 显示结果
-			r.printTaskResult(result)
+	r.registerTaskResult(task, result.Host, result)
 
-			// 更新统计
-			hostStat := stats[result.Host]
-			if result.Failed {
-				hostStat.Failed++
-			} else if result.Skipped {
-				hostStat.Skipped++
-			} else {
-				hostStat.Ok++
-				if result.Changed {
-					hostStat.Changed++
-				}
-			}
-
-			// 处理 register
-			if task.Register != "" && !result.Failed {
-				r.varMgr.SetHostVar(result.Host, task.Register, result.Data)
-			}
+	// 处理 notify（只在任务 changed 时通知 handler）
+	if result.Changed && len(task.Notify) > 0 {
+		for _, handlerName := range task.Notify {
+			r.notifiedHandlers[handlerName] = true
+		}
+	}
 
-			// 处理 ansible_facts (set_fact 模块)
-			if ansibleFacts, ok := result.Data["ansible_facts"].(map[string]interface{}); ok {
-				for key, value := range ansibleFacts {
-					r.varMgr.SetHostVar(result.Host, key, value)
-				}
-			}
+	return !result.Failed || task.IgnoreErrors
+}
 
-			// 处理 notify（只在任务 changed 时通知 handler）
-			if result.Changed && len(task.Notify) > 0 {
-				for _, handlerName := range task.Notify {
-					r.notifiedHandlers[handlerName] = true
-				}
-			}
+// registerTaskResult 把任务结果保存为 hostName 的变量（register 和 ansible_facts）
+// run_once 任务的结果会以此传播给其他主机；delegate_facts 时 facts 设置到被委托的主机
+func (r *Runner) registerTaskResult(task *Task, hostName string, result *TaskResult) {
+	// 处理 register
+	if task.Register != "" && !result.Failed {
+		r.varMgr.SetHostVar(hostName, task.Register, result.Data)
+	}
 
-			// 处理失败
-			if result.Failed && !task.IgnoreErrors {
-				failedHosts = append(failedHosts, result.Host)
-			} else if !result.Failed {
-				// 保留成功的主机
-				for _, h := range activeHosts {
-					if h.Name == result.Host {
-						newActiveHosts = append(newActiveHosts, h)
-						break
-					}
-				}
-			}
+	// 处理 ansible_facts (set_fact 模块)
+	if ansibleFacts, ok := result.Data["ansible_facts"].(map[string]interface{}); ok {
+		factsHost := hostName
+		if task.DelegateFacts && result.DelegatedHost != "" {
+			factsHost = result.DelegatedHost
 		}
+		r.setTaskFacts(task, factsHost, ansibleFacts)
+	}
+}
 
-		// 更新活跃主机列表
-		if len(failedHosts) > 0 {
-			activeHosts = newActiveHosts
-		}
+// resolveIncludeVarsFile 把 include_vars 的相对文件路径解析为控制节点上的绝对路径
+// 依次查找 role 的 vars/ 目录、role 目录、playbook 的 vars/ 目录和 playbook 目录，
+// 都不存在时按 playbook 目录解析，让模块报告完整路径
+func (r *Runner) resolveIncludeVarsFile(task *Task, args map[string]interface{}) {
+	key := "file"
+	file, _ := args[key].(string)
+	if file == "" {
+		key = "_raw_params"
+		file, _ = args[key].(string)
+	}
+	if file == "" || filepath.IsAbs(file) {
+		return
+	}
 
-		// 如果是最后一个任务，打印空行
-		if taskIdx == len(allTasks)-1 {
-			fmt.Println()
-		}
+	var dirs []string
+	if task.Scope != nil && task.Scope.RolePath != "" {
+		dirs = append(dirs, filepath.Join(task.Scope.RolePath, "vars"), task.Scope.RolePath)
 	}
+	playbookDir := filepath.Dir(r.playbookPath)
+	dirs = append(dirs, filepath.Join(playbookDir, "vars"), playbookDir)
 
-	// 执行所有被通知的 handlers（包括 role handlers 和 play handlers）
-	if len(allHandlers) > 0 && len(r.notifiedHandlers) > 0 {
-		if err := r.executeHandlers(allHandlers, activeHosts, stats); err != nil {
-			return fmt.Errorf("handler execution failed: %w", err)
+	args[key] = filepath.Join(playbookDir, file)
+	for _, dir := range dirs {
+		path := filepath.Join(dir, file)
+		if _, err := os.Stat(path); err == nil {
+			args[key] = path
+			return
 		}
 	}
+}
 
-	// 打印 Play Recap
-	r.printPlayRecap(play.Name, stats)
-
-	// 检查是否有失败
-	for _, stat := range stats {
-		if !stat.IsSuccess() {
-			return fmt.Errorf("play had failures")
-		}
+// setTaskFacts 保存任务返回的 ansible_facts
+// include_vars 加载的变量属于 include_vars 层，其他（set_fact）属于 set_fact 层
+func (r *Runner) setTaskFacts(task *Task, hostName string, facts map[string]interface{}) {
+	if task.Module == "include_vars" {
+		r.varMgr.SetIncludeVars(hostName, facts)
+		return
+	}
+	for key, value := range facts {
+		r.varMgr.SetHostVar(hostName, key, value)
 	}
+}
 
-	return nil
+// taskContext 返回主机执行任务时的变量上下文（包括任务作用域中的变量）
+func (r *Runner) taskContext(task *Task, hostName string) map[string]interface{} {
+	scope, taskVars := taskScope(task)
+	context := r.varMgr.GetTaskContext(hostName, scope, taskVars)
+	r.explainTaskVar(hostName, scope, taskVars, context)
+	return context
+}
+
+// explainTaskVar 设置了 --explain-var 时输出变量在主机上的值和各层来源
+func (r *Runner) explainTaskVar(hostName string, scope *VarScope, taskVars, context map[string]interface{}) {
+	if r.explainVar == "" {
+		return
+	}
+	value, defined := context[r.explainVar]
+	sources := r.varMgr.ExplainVar(hostName, scope, taskVars, r.explainVar)
+	r.logger.Info(explainVarText(r.explainVar, hostName, value, defined, sources))
 }
 
 // executeTask 在单个主机上执行任务
@@ -314,7 +563,7 @@ func (r *Runner) executeTask(task *Task, host *inventory.Host) *TaskResult {
 	}
 
 	// 获取主机变量上下文
-	context := r.varMgr.GetContext(host.Name)
+	context := r.taskContext(task, host.Name)
 
 	// 评估 when 条件
 	if task.When != "" {
@@ -331,6 +580,22 @@ func (r *Runner) executeTask(task *Task, host *inventory.Host) *TaskResult {
 		}
 	}
 
+	// 配置了 until 时重复执行，直到条件满足或重试次数用完
+	if task.Until != "" {
+		return r.executeTaskWithRetries(task, host, context)
+	}
+
+	return r.executeModule(task, host, context)
+}
+
+// executeModule 渲染参数并在主机上执行一次任务模块（包括 failed_when/changed_when 评估）
+func (r *Runner) executeModule(task *Task, host *inventory.Host, context map[string]interface{}) *TaskResult {
+	result := &TaskResult{
+		Host: host.Name,
+		Task: task.Name,
+		Data: make(map[string]interface{}),
+	}
+
 	// 渲染模块参数
 	renderedArgs, err := r.template.RenderArgs(task.ModuleArgs, context)
 	if err != nil {
@@ -339,6 +604,14 @@ func (r *Runner) executeTask(task *Task, host *inventory.Host) *TaskResult {
 		return result
 	}
 
+	// 按模块的参数定义检查和转换渲染后的参数
+	renderedArgs, err = validateModuleArgs(task.Name, task.Module, renderedArgs)
+	if err != nil {
+		result.Failed = true
+		result.Msg = err.Error()
+		return result
+	}
+
 	// 特殊处理 debug 模块的 var 参数
 	if task.Module == "debug" {
 		if varName, ok := renderedArgs["var"].(string); ok {
@@ -391,16 +664,36 @@ func (r *Runner) executeTask(task *Task, host *inventory.Host) *TaskResult {
 		// 删除 src 参数（模块不需要它）
 		delete(renderedArgs, "src")
 	}
+	if task.Module == "include_vars" {
+		r.resolveIncludeVarsFile(task, renderedArgs)
+	}
 
 	// 规范化参数
 	normalizedArgs := NormalizeModuleArgs(task.Module, renderedArgs)
+	if r.taskCheckMode(task) {
+		normalizedArgs[module.CheckModeArg] = true
+	}
+	if r.taskDiffMode(task) {
+		normalizedArgs[module.DiffArg] = true
+	}
 
-	// 建立连接
-	conn, err := r.connMgr.Connect(host)
+	// 建立连接（delegate_to 时连接被委托的主机，变量上下文仍然是原主机）
+	target, err := r.delegateHost(task, host, context)
+	if err != nil {
+		result.Failed = true
+		result.Msg = err.Error()
+		return result
+	}
+	if target.Name != host.Name {
+		result.DelegatedHost = target.Name
+	}
+	setHostArgs(normalizedArgs, host, target, context)
+
+	conn, err := r.connMgr.Connect(target)
 	if err != nil {
 		result.Failed = true
 		result.Msg = fmt.Sprintf("connection failed: %v", err)
-		result.Data["unreachable"] = true
+		result.Data["unreachable"] = ansierrors.IsUnreachable(err)
 		return result
 	}
 	defer conn.Close()
@@ -432,6 +725,7 @@ func (r *Runner) executeTask(task *Task, host *inventory.Host) *TaskResult {
 	// 转换结果
 	result.Changed = modResult.Changed
 	result.Failed = modResult.Failed || modResult.Unreachable
+	result.Skipped = modResult.Skipped
 	result.Msg = modResult.Msg
 
 	// 将模块结果转换为 map
@@ -439,16 +733,22 @@ func (r *Runner) executeTask(task *Task, host *inventory.Host) *TaskResult {
 		"changed":     modResult.Changed,
 		"failed":      modResult.Failed,
 		"unreachable": modResult.Unreachable,
+		"skipped":     modResult.Skipped,
 		"msg":         modResult.Msg,
 		"rc":          modResult.RC,
 		"stdout":      modResult.Stdout,
 		"stderr":      modResult.Stderr,
 	}
 
+	addModuleData(result.Data, modResult)
+
 	// 如果有 ansible_facts，添加到 Data 中
 	if len(modResult.AnsibleFacts) > 0 {
 		result.Data["ansible_facts"] = modResult.AnsibleFacts
 	}
+	if modResult.Diff != nil {
+		result.Data["diff"] = diffData(modResult.Diff)
+	}
 
 	// 评估 failed_when 条件
 	if task.FailedWhen != "" {
@@ -512,8 +812,72 @@ func (r *Runner) executeTask(task *Task, host *inventory.Host) *TaskResult {
 	return result
 }
 
+// executeTaskWithRetries 执行带 until/retries/delay 的任务
+// 每次执行后用 until 条件评估结果，不满足时等待 delay 秒后重试，最多执行 retries+1 次。
+// 重试次数用完仍不满足时任务失败；主机不可达时不重试
+func (r *Runner) executeTaskWithRetries(task *Task, host *inventory.Host, context map[string]interface{}) *TaskResult {
+	maxAttempts := task.Retries + 1
+	if maxAttempts < 1 {
+		maxAttempts = 1
+	}
+
+	var result *TaskResult
+	for attempt := 1; attempt <= maxAttempts; attempt++ {
+		result = r.executeModule(task, host, context)
+		result.Data["attempts"] = attempt
+
+		if unreachable, _ := result.Data["unreachable"].(bool); unreachable {
+			return result
+		}
+
+		// 创建包含任务结果的上下文（与 failed_when 相同，支持直接访问 rc/stdout 或通过 register 变量访问）
+		evalContext := make(map[string]interface{})
+		for k, v := range context {
+			evalContext[k] = v
+		}
+		for _, key := range []string{"rc", "stdout", "stderr", "changed", "failed"} {
+			if v, ok := result.Data[key]; ok {
+				evalContext[key] = v
+			}
+		}
+		evalContext["attempts"] = attempt
+		if task.Register != "" {
+			evalContext[task.Register] = result.Data
+		}
+
+		done, err := r.template.EvaluateCondition(task.Until, evalContext)
+		if err != nil {
+			result.Failed = true
+			result.Msg = fmt.Sprintf("failed to evaluate until condition: %v", err)
+			result.Data["failed"] = true
+			return result
+		}
+		if done {
+			return result
+		}
+
+		if attempt < maxAttempts {
+			r.logger.TaskRetry(host.Name, taskDisplayName(task), maxAttempts-attempt)
+			time.Sleep(time.Duration(task.Delay) * time.Second)
+		}
+	}
+
+	result.Failed = true
+	result.Data["failed"] = true
+	if result.Msg == "" {
+		result.Msg = fmt.Sprintf("until condition not met after %d attempts: %s", maxAttempts, task.Until)
+	}
+	return result
+}
+
 // printTaskResult 打印任务结果
 func (r *Runner) printTaskResult(result *TaskResult) {
+	// 委托执行时显示为 "host -> delegated"
+	hostLabel := result.Host
+	if result.DelegatedHost != "" {
+		hostLabel = result.Host + " -> " + result.DelegatedHost
+	}
+
 	// 检查是否是循环结果
 	if results, ok := result.Data["results"].([]map[string]interface{}); ok && len(results) > 0 {
 		// 这是循环任务，显示每个迭代的结果
@@ -549,18 +913,66 @@ func (r *Runner) printTaskResult(result *TaskResult) {
 				skipped = s
 			}
 
+			r.printDiff(iterResult)
+
 			// 格式化输出 "item=value => msg"
 			displayMsg := fmt.Sprintf("item=%v", itemValue)
 			if msg != "" {
 				displayMsg = fmt.Sprintf("%s => %s", displayMsg, msg)
 			}
 
-			r.logger.TaskResult("ok", result.Host, displayMsg, changed, failed, skipped)
+			r.logger.TaskResult("ok", hostLabel, displayMsg, changed, failed, skipped)
 		}
 	} else {
 		// 普通任务结果
+		r.printDiff(result.Data)
 		status := "ok"
-		r.logger.TaskResult(status, result.Host, result.Msg, result.Changed, result.Failed, result.Skipped)
+		r.logger.TaskResult(status, hostLabel, result.Msg, result.Changed, result.Failed, result.Skipped)
+	}
+}
+
+// printDiff 打印模块返回的 diff（--diff）
+func (r *Runner) printDiff(data map[string]interface{}) {
+	diff, ok := data["diff"].(map[string]interface{})
+	if !ok {
+		return
+	}
+	before, _ := diff["before"].(string)
+	after, _ := diff["after"].(string)
+	beforeHeader, _ := diff["before_header"].(string)
+	afterHeader, _ := diff["after_header"].(string)
+	r.logger.Diff(before, after, beforeHeader, afterHeader)
+}
+
+// diffData 把模块返回的 Diff 转换为 register 变量中的 diff 字段
+func diffData(diff *module.Diff) map[string]interface{} {
+	return map[string]interface{}{
+		"before":        diff.Before,
+		"after":         diff.After,
+		"before_header": diff.BeforeHeader,
+		"after_header":  diff.AfterHeader,
+	}
+}
+
+// addModuleData 把模块结果中的其他字段（如 library 模块返回的自定义字段）加入任务结果，不覆盖已有的字段
+func addModuleData(data map[string]interface{}, modResult *module.Result) {
+	for k, v := range modResult.Data {
+		if _, exists := data[k]; !exists {
+			data[k] = v
+		}
+	}
+}
+
+// setHostArgs 把目标主机相关的内部参数传给模块（module.HostArgs）：
+// library 中的 Python 模块使用的 ansible_python_interpreter、是否启用远程 agent（ansigo_agent）、包管理器
+// delegate_to 时使用被委托主机的变量
+func setHostArgs(args map[string]interface{}, host, target *inventory.Host, context map[string]interface{}) {
+	vars := context
+	if target.Name != host.Name {
+		vars = target.Vars
+	}
+	for k, v := range module.HostArgs(vars) {
+		args[k] = v
 	}
 }
 
@@ -608,27 +1020,13 @@ func (r *Runner) executeHandlers(handlers []Handler, hosts []*inventory.Host, st
 		}
 		r.logger.TaskHeader(handlerName)
 
-		// 并发执行 handler 任务
-		results := make(chan *TaskResult, len(hosts))
-		var wg sync.WaitGroup
-
-		for _, host := range hosts {
-			wg.Add(1)
-			go func(h *inventory.Host) {
-				defer wg.Done()
-				result := r.executeHandlerTask(&handler, h)
-				results <- result
-			}(host)
-		}
-
-		// 等待所有 handler 完成
-		go func() {
-			wg.Wait()
-			close(results)
-		}()
+		// 并发执行 handler 任务（最多 forks 个主机）
+		results := worker.Map(r.pool, hosts, func(h *inventory.Host) *TaskResult {
+			return r.executeHandlerTask(&handler, h)
+		})
 
 		// 收集结果并更新统计
-		for result := range results {
+		for _, result := range results {
 			// 显示结果
 			r.printTaskResult(result)
 
@@ -659,8 +1057,9 @@ func (r *Runner) executeHandlerTask(handler *Handler, host *inventory.Host) *Tas
 		Data: make(map[string]interface{}),
 	}
 
-	// 获取主机变量上下文
-	context := r.varMgr.GetContext(host.Name)
+	// 获取主机变量上下文（role handler 可以使用 role 的变量）
+	context := r.varMgr.GetTaskContext(host.Name, handler.Scope, nil)
+	r.explainTaskVar(host.Name, handler.Scope, nil, context)
 
 	// 评估 when 条件
 	if handler.When != "" {
@@ -685,6 +1084,14 @@ func (r *Runner) executeHandlerTask(handler *Handler, host *inventory.Host) *Tas
 		return result
 	}
 
+	// 按模块的参数定义检查和转换渲染后的参数
+	renderedArgs, err = validateModuleArgs(handler.Name, handler.Module, renderedArgs)
+	if err != nil {
+		result.Failed = true
+		result.Msg = err.Error()
+		return result
+	}
+
 	// 特殊处理 debug 模块的 var 参数
 	if handler.Module == "debug" {
 		if varName, ok := renderedArgs["var"].(string); ok {
@@ -702,13 +1109,21 @@ func (r *Runner) executeHandlerTask(handler *Handler, host *inventory.Host) *Tas
 
 	// 规范化参数
 	normalizedArgs := NormalizeModuleArgs(handler.Module, renderedArgs)
+	if r.checkMode {
+		normalizedArgs[module.CheckModeArg] = true
+	}
+	if r.diffMode {
+		normalizedArgs[module.DiffArg] = true
+	}
+
+	setHostArgs(normalizedArgs, host, host, context)
 
 	// 建立连接
 	conn, err := r.connMgr.Connect(host)
 	if err != nil {
 		result.Failed = true
 		result.Msg = fmt.Sprintf("connection failed: %v", err)
-		result.Data["unreachable"] = true
+		result.Data["unreachable"] = ansierrors.IsUnreachable(err)
 		return result
 	}
 	defer conn.Close()
@@ -730,6 +1145,7 @@ func (r *Runner) executeHandlerTask(handler *Handler, host *inventory.Host) *Tas
 	// 转换结果
 	result.Changed = modResult.Changed
 	result.Failed = modResult.Failed || modResult.Unreachable
+	result.Skipped = modResult.Skipped
 	result.Msg = modResult.Msg
 
 	// 将模块结果转换为 map
@@ -737,16 +1153,22 @@ func (r *Runner) executeHandlerTask(handler *Handler, host *inventory.Host) *Tas
 		"changed":     modResult.Changed,
 		"failed":      modResult.Failed,
 		"unreachable": modResult.Unreachable,
+		"skipped":     modResult.Skipped,
 		"msg":         modResult.Msg,
 		"rc":          modResult.RC,
 		"stdout":      modResult.Stdout,
 		"stderr":      modResult.Stderr,
 	}
 
+	addModuleData(result.Data, modResult)
+
 	// 如果有 ansible_facts，添加到 Data 中
 	if len(modResult.AnsibleFacts) > 0 {
 		result.Data["ansible_facts"] = modResult.AnsibleFacts
 	}
+	if modResult.Diff != nil {
+		result.Data["diff"] = diffData(modResult.Diff)
+	}
 
 	return result
 }
@@ -770,7 +1192,7 @@ func (r *Runner) executeTaskWithLoop(task *Task, host *inventory.Host) *TaskResu
 	}
 
 	// 获取主机变量上下文
-	baseContext := r.varMgr.GetContext(host.Name)
+	baseContext := r.taskContext(task, host.Name)
 
 	// 评估循环列表（可能包含模板变量）
 	var loopItems []interface{}
@@ -811,10 +1233,11 @@ func (r *Runner) executeTaskWithLoop(task *Task, host *inventory.Host) *TaskResu
 
 	// 存储所有迭代结果
 	results := make([]map[string]interface{}, 0, len(loopItems))
+	delegatedHost := ""
 	hasChanged := false
 	hasFailed := false
 	hasSkipped := false
-	allSkipped := true
+	skippedItems := 0
 
 	// 遍历循环项
 	for idx, item := range loopItems {
@@ -844,7 +1267,6 @@ func (r *Runner) executeTaskWithLoop(task *Task, host *inventory.Host) *TaskResu
 				}
 				results = append(results, iterResult)
 				hasFailed = true
-				allSkipped = false
 				continue
 			}
 			if !shouldRun {
@@ -860,18 +1282,23 @@ func (r *Runner) executeTaskWithLoop(task *Task, host *inventory.Host) *TaskResu
 				}
 				results = append(results, iterResult)
 				hasSkipped = true
+				skippedItems++
 				continue
 			}
 		}
 
-		allSkipped = false
-
 		// 渲染模块参数
 		renderedArgs, err := r.template.RenderArgs(task.ModuleArgs, loopContext)
+		if err != nil {
+			err = fmt.Errorf("failed to render args: %w", err)
+		} else {
+			// 按模块的参数定义检查和转换渲染后的参数
+			renderedArgs, err = validateModuleArgs(task.Name, task.Module, renderedArgs)
+		}
 		if err != nil {
 			iterResult := map[string]interface{}{
 				"failed":           true,
-				"msg":              fmt.Sprintf("failed to render args: %v", err),
+				"msg":              err.Error(),
 				loopVar:            item,
 				"ansible_loop_var": loopVar,
 			}
@@ -894,16 +1321,29 @@ func (r *Runner) executeTaskWithLoop(task *Task, host *inventory.Host) *TaskResu
 				delete(renderedArgs, "var")
 			}
 		}
+		if task.Module == "include_vars" {
+			r.resolveIncludeVarsFile(task, renderedArgs)
+		}
 
 		// 规范化参数
 		normalizedArgs := NormalizeModuleArgs(task.Module, renderedArgs)
+		if r.taskCheckMode(task) {
+			normalizedArgs[module.CheckModeArg] = true
+		}
+		if r.taskDiffMode(task) {
+			normalizedArgs[module.DiffArg] = true
+		}
 
-		// 建立连接
-		conn, err := r.connMgr.Connect(host)
+		// 建立连接（delegate_to 可以引用循环变量）
+		target, err := r.delegateHost(task, host, loopContext)
+		var conn connection.Connection
+		if err == nil {
+			conn, err = r.connMgr.Connect(target)
+		}
 		if err != nil {
 			iterResult := map[string]interface{}{
 				"failed":           true,
-				"unreachable":      true,
+				"unreachable":      target != nil && ansierrors.IsUnreachable(err),
 				"msg":              fmt.Sprintf("connection failed: %v", err),
 				loopVar:            item,
 				"ansible_loop_var": loopVar,
@@ -916,6 +1356,8 @@ func (r *Runner) executeTaskWithLoop(task *Task, host *inventory.Host) *TaskResu
 			continue
 		}
 
+		setHostArgs(normalizedArgs, host, target, loopContext)
+
 		// 确定是否使用 become（任务级别优先，然后是 play 级别）
 		shouldBecome := r.currentPlay.Become
 		becomeUser := r.currentPlay.BecomeUser
@@ -930,6 +1372,10 @@ func (r *Runner) executeTaskWithLoop(task *Task, host *inventory.Host) *TaskResu
 			}
 		}
 
+		if target.Name != host.Name {
+			delegatedHost = target.Name
+		}
+
 		// 执行模块
 		modResult, err := r.modExec.Execute(conn, task.Module, normalizedArgs, shouldBecome, becomeUser, becomeMethod)
 		conn.Close()
@@ -954,6 +1400,7 @@ func (r *Runner) executeTaskWithLoop(task *Task, host *inventory.Host) *TaskResu
 			"changed":          modResult.Changed,
 			"failed":           modResult.Failed,
 			"unreachable":      modResult.Unreachable,
+			"skipped":          modResult.Skipped,
 			"msg":              modResult.Msg,
 			"rc":               modResult.RC,
 			"stdout":           modResult.Stdout,
@@ -964,14 +1411,21 @@ func (r *Runner) executeTaskWithLoop(task *Task, host *inventory.Host) *TaskResu
 		if indexVar != "" {
 			iterResult[indexVar] = idx
 		}
+		addModuleData(iterResult, modResult)
+
+		if modResult.Diff != nil {
+			iterResult["diff"] = diffData(modResult.Diff)
+		}
 
 		// 如果有 ansible_facts，添加到结果中
 		if len(modResult.AnsibleFacts) > 0 {
 			iterResult["ansible_facts"] = modResult.AnsibleFacts
-			// 将 facts 设置到主机变量中
-			for key, value := range modResult.AnsibleFacts {
-				r.varMgr.SetHostVar(host.Name, key, value)
+			// 将 facts 设置到主机变量中（delegate_facts 时设置到被委托的主机）
+			factsHost := host.Name
+			if task.DelegateFacts {
+				factsHost = target.Name
 			}
+			r.setTaskFacts(task, factsHost, modResult.AnsibleFacts)
 		}
 
 		// 评估 failed_when 条件
@@ -1020,6 +1474,10 @@ func (r *Runner) executeTaskWithLoop(task *Task, host *inventory.Host) *TaskResu
 		results = append(results, iterResult)
 
 		// 更新总体状态
+		if modResult.Skipped {
+			hasSkipped = true
+			skippedItems++
+		}
 		if iterResult["changed"].(bool) {
 			hasChanged = true
 		}
@@ -1034,13 +1492,15 @@ func (r *Runner) executeTaskWithLoop(task *Task, host *inventory.Host) *TaskResu
 		}
 	}
 
-	// 构建循环任务的总体结果
+	// 构建循环任务的总体结果（所有循环项都被跳过时任务被跳过）
+	allSkipped := skippedItems == len(loopItems)
 	result := &TaskResult{
-		Host:    host.Name,
-		Task:    task.Name,
-		Changed: hasChanged,
-		Failed:  hasFailed && !task.IgnoreErrors,
-		Skipped: allSkipped,
+		Host:          host.Name,
+		DelegatedHost: delegatedHost,
+		Task:          task.Name,
+		Changed:       hasChanged,
+		Failed:        hasFailed && !task.IgnoreErrors,
+		Skipped:       allSkipped,
 		Data: map[string]interface{}{
 			"results": results,
 			"changed": hasChanged,
@@ -1074,8 +1534,8 @@ func (r *Runner) executeBlock(task *Task, host *inventory.Host) *TaskResult {
 		Data: make(map[string]interface{}),
 	}
 
-	// 获取主机变量上下文
-	context := r.varMgr.GetContext(host.Name)
+	// 获取主机变量上下文（包括 block 自身的 vars）
+	context := r.taskContext(task, host.Name)
 
 	// 评估 when 条件（block 级别）
 	if task.When != "" {
@@ -1195,11 +1655,13 @@ func (r *Runner) expandAllTasks(tasks []Task, includer *TaskIncluder, vars map[s
 		// 检查是否是包含任务
 		if task.Module == "import_tasks" || task.Module == "ansible.builtin.import_tasks" ||
 			task.Module == "include_role" || task.Module == "ansible.builtin.include_role" {
-			// 展开包含任务
+			// 展开包含任务，包含任务上的标签传递给展开的任务
+			// （include_role 在这里静态展开，因此和 import_tasks 一样传递标签）
 			expandedTasks, err := includer.ExpandTask(&task, vars)
 			if err != nil {
 				return nil, fmt.Errorf("failed to expand task '%s': %w", task.Name, err)
 			}
+			expandedTasks = inheritTags(expandedTasks, task.Tags)
 
 			// 递归展开（因为展开的任务可能也包含 import_tasks）
 			expandedTasks, err = r.expandAllTasks(expandedTasks, includer, vars)
@@ -1217,45 +1679,36 @@ func (r *Runner) expandAllTasks(tasks []Task, includer *TaskIncluder, vars map[s
 	return result, nil
 }
 
-// gatherFactsForHosts gathers facts for all hosts in parallel
+// gatherFactsForHosts gathers facts for all hosts in parallel (at most forks at a time)
 func (r *Runner) gatherFactsForHosts(hosts []*inventory.Host) error {
-	var wg sync.WaitGroup
-	errors := make(chan error, len(hosts))
-
-	for _, host := range hosts {
-		wg.Add(1)
-		go func(h *inventory.Host) {
-			defer wg.Done()
-
-			// Connect to host
-			conn, err := r.connMgr.Connect(h)
-			if err != nil {
-				errors <- fmt.Errorf("failed to connect to %s: %w", h.Name, err)
-				return
-			}
-			defer conn.Close()
-
-			// Gather facts
-			hostFacts, err := facts.GatherFacts(conn)
-			if err != nil {
-				errors <- fmt.Errorf("failed to gather facts for %s: %w", h.Name, err)
-				return
-			}
-
-			// Set facts as host variables
-			r.varMgr.SetHostVars(h.Name, hostFacts)
-		}(host)
+	type gathered struct {
+		facts map[string]interface{}
+		err   error
 	}
 
-	// Wait for all fact gathering to complete
-	wg.Wait()
-	close(errors)
+	results := worker.Map(r.pool, hosts, func(h *inventory.Host) gathered {
+		// Connect to host
+		conn, err := r.connMgr.Connect(h)
+		if err != nil {
+			return gathered{err: fmt.Errorf("failed to connect to %s: %w", h.Name, err)}
+		}
+		defer conn.Close()
 
-	// Check for errors
-	for err := range errors {
+		// Gather facts
+		hostFacts, err := facts.GatherFacts(conn)
 		if err != nil {
-			return err
+			return gathered{err: fmt.Errorf("failed to gather facts for %s: %w", h.Name, err)}
+		}
+		return gathered{facts: hostFacts}
+	})
+
+	// Set facts as host variables after all workers finish (VariableManager is not goroutine-safe),
+	// reporting the first error in host order
+	for i, res := range results {
+		if res.err != nil {
+			return res.err
 		}
+		r.varMgr.SetHostVars(hosts[i].Name, res.facts)
 	}
 
 	return nil
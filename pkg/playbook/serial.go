@@ -0,0 +1,98 @@
+package playbook
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/jimyag/ansigo/pkg/inventory"
+)
+
+// splitSerialBatches 按 play 的 serial 设置把主机划分为批次
+//
+// serial 可以是整数、百分比字符串（如 "30%"），或者二者组成的列表（逐步扩大的批次大小）。
+// 列表用完后最后一个值重复使用，直到所有主机都被分配。未设置或为 0 时所有主机作为一个批次
+func splitSerialBatches(serial interface{}, hosts []*inventory.Host) ([][]*inventory.Host, error) {
+	if serial == nil || len(hosts) == 0 {
+		return [][]*inventory.Host{hosts}, nil
+	}
+
+	var specs []interface{}
+	if list, ok := serial.([]interface{}); ok {
+		specs = list
+	} else {
+		specs = []interface{}{serial}
+	}
+	if len(specs) == 0 {
+		return [][]*inventory.Host{hosts}, nil
+	}
+
+	sizes := make([]int, len(specs))
+	for i, spec := range specs {
+		size, err := serialBatchSize(spec, len(hosts))
+		if err != nil {
+			return nil, err
+		}
+		sizes[i] = size
+	}
+
+	var batches [][]*inventory.Host
+	for i, start := 0, 0; start < len(hosts); i++ {
+		size := sizes[len(sizes)-1]
+		if i < len(sizes) {
+			size = sizes[i]
+		}
+		if size <= 0 || start+size > len(hosts) {
+			size = len(hosts) - start
+		}
+		batches = append(batches, hosts[start:start+size])
+		start += size
+	}
+	return batches, nil
+}
+
+// serialBatchSize 计算单个 serial 值对应的批次大小
+// 百分比按总主机数向下取整，但至少为 1；返回 0 表示剩余主机全部放入一个批次
+func serialBatchSize(spec interface{}, total int) (int, error) {
+	switch v := spec.(type) {
+	case int:
+		if v < 0 {
+			return 0, fmt.Errorf("invalid serial value: %d", v)
+		}
+		return v, nil
+	case float64:
+		if v < 0 {
+			return 0, fmt.Errorf("invalid serial value: %v", v)
+		}
+		return int(v), nil
+	case string:
+		s := strings.TrimSpace(v)
+		if pct, ok := strings.CutSuffix(s, "%"); ok {
+			p, err := strconv.ParseFloat(strings.TrimSpace(pct), 64)
+			if err != nil || p < 0 {
+				return 0, fmt.Errorf("invalid serial percentage: %q", v)
+			}
+			size := int(math.Floor(float64(total) * p / 100))
+			if size < 1 {
+				size = 1
+			}
+			return size, nil
+		}
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid serial value: %q", v)
+		}
+		return n, nil
+	default:
+		return 0, fmt.Errorf("invalid serial value: %v", spec)
+	}
+}
+
+// exceedsMaxFailPercentage 判断批次的失败比例是否超过 max_fail_percentage（严格大于才算超过）
+func exceedsMaxFailPercentage(maxFail *float64, failed, total int) bool {
+	if maxFail == nil || total == 0 {
+		return false
+	}
+	return float64(failed)*100/float64(total) > *maxFail
+}
@@ -0,0 +1,83 @@
+package playbook
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/jimyag/ansigo/pkg/inventory"
+)
+
+func TestSplitSerialBatches(t *testing.T) {
+	hosts := make([]*inventory.Host, 10)
+	for i := range hosts {
+		hosts[i] = &inventory.Host{Name: fmt.Sprintf("web%02d", i+1)}
+	}
+
+	tests := []struct {
+		name    string
+		serial  interface{}
+		want    []int // 每个批次的主机数
+		wantErr bool
+	}{
+		{name: "unset", serial: nil, want: []int{10}},
+		{name: "zero", serial: 0, want: []int{10}},
+		{name: "int", serial: 3, want: []int{3, 3, 3, 1}},
+		{name: "larger than hosts", serial: 20, want: []int{10}},
+		{name: "numeric string", serial: "4", want: []int{4, 4, 2}},
+		{name: "percentage", serial: "30%", want: []int{3, 3, 3, 1}},
+		{name: "small percentage rounds up to one", serial: "5%", want: []int{1, 1, 1, 1, 1, 1, 1, 1, 1, 1}},
+		{name: "escalating list", serial: []interface{}{1, 2, "50%"}, want: []int{1, 2, 5, 2}},
+		{name: "list ending in zero", serial: []interface{}{1, 0}, want: []int{1, 9}},
+		{name: "invalid string", serial: "abc", wantErr: true},
+		{name: "negative", serial: -1, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			batches, err := splitSerialBatches(tt.serial, hosts)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("splitSerialBatches(%v) error = %v, wantErr %v", tt.serial, err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			var sizes []int
+			var order []*inventory.Host
+			for _, b := range batches {
+				sizes = append(sizes, len(b))
+				order = append(order, b...)
+			}
+			if !reflect.DeepEqual(sizes, tt.want) {
+				t.Errorf("batch sizes = %v, want %v", sizes, tt.want)
+			}
+			if !reflect.DeepEqual(order, hosts) {
+				t.Error("batches do not cover hosts in inventory order")
+			}
+		})
+	}
+}
+
+func TestExceedsMaxFailPercentage(t *testing.T) {
+	thirty := 30.0
+	zero := 0.0
+
+	tests := []struct {
+		maxFail       *float64
+		failed, total int
+		want          bool
+	}{
+		{nil, 5, 5, false},
+		{&thirty, 3, 10, false},
+		{&thirty, 4, 10, true},
+		{&zero, 0, 10, false},
+		{&zero, 1, 10, true},
+	}
+
+	for _, tt := range tests {
+		if got := exceedsMaxFailPercentage(tt.maxFail, tt.failed, tt.total); got != tt.want {
+			t.Errorf("exceedsMaxFailPercentage(%v, %d, %d) = %v, want %v", tt.maxFail, tt.failed, tt.total, got, tt.want)
+		}
+	}
+}
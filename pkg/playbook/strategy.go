@@ -0,0 +1,230 @@
+package playbook
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/jimyag/ansigo/pkg/inventory"
+	"github.com/jimyag/ansigo/pkg/worker"
+)
+
+// Strategy 执行策略，决定一个批次内的任务如何在主机之间调度
+type Strategy interface {
+	// Run 在 hosts 上执行 tasks，返回执行结束后仍然活跃（未失败）的主机
+	Run(r *Runner, hosts []*inventory.Host, tasks []Task, stats map[string]*HostStats) []*inventory.Host
+}
+
+// DefaultStrategy 未指定 strategy 时使用的策略
+const DefaultStrategy = "linear"
+
+var (
+	strategiesMu sync.RWMutex
+	strategies   = map[string]Strategy{
+		"linear":      linearStrategy{},
+		"free":        freeStrategy{},
+		"host_pinned": hostPinnedStrategy{},
+	}
+)
+
+// RegisterStrategy 注册执行策略，同名策略会被覆盖
+func RegisterStrategy(name string, s Strategy) {
+	strategiesMu.Lock()
+	defer strategiesMu.Unlock()
+	strategies[name] = s
+}
+
+// GetStrategy 按名称获取执行策略，名称为空时返回 linear
+func GetStrategy(name string) (Strategy, error) {
+	if name == "" {
+		name = DefaultStrategy
+	}
+
+	strategiesMu.RLock()
+	defer strategiesMu.RUnlock()
+
+	s, ok := strategies[name]
+	if !ok {
+		names := make([]string, 0, len(strategies))
+		for n := range strategies {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("unknown strategy '%s' (available: %v)", name, names)
+	}
+	return s, nil
+}
+
+// linearStrategy 逐个任务执行：所有主机完成当前任务后才开始下一个任务
+type linearStrategy struct{}
+
+func (linearStrategy) Run(r *Runner, hosts []*inventory.Host, tasks []Task, stats map[string]*HostStats) []*inventory.Host {
+	activeHosts := hosts
+
+	for i := range tasks {
+		task := &tasks[i]
+		if len(activeHosts) == 0 {
+			r.logger.Warning("No more hosts available, stopping play")
+			break
+		}
+
+		r.logger.TaskHeader(taskDisplayName(task))
+
+		// run_once 只在第一个活跃主机上执行，结果传播给其他主机
+		if task.RunOnce {
+			result := r.executeTask(task, activeHosts[0])
+			r.printTaskResult(result)
+
+			var survivors []*inventory.Host
+			if r.recordTaskResult(task, result, stats) {
+				survivors = append(survivors, activeHosts[0])
+			}
+			for _, h := range activeHosts[1:] {
+				if r.propagateRunOnce(task, h.Name, result, stats) {
+					survivors = append(survivors, h)
+				}
+			}
+			activeHosts = survivors
+			continue
+		}
+
+		// 并发执行任务（最多 forks 个主机），结果按主机顺序收集
+		results := worker.Map(r.pool, activeHosts, func(h *inventory.Host) *TaskResult {
+			return r.executeTask(task, h)
+		})
+
+		var survivors []*inventory.Host
+		for j, result := range results {
+			r.printTaskResult(result)
+			if r.recordTaskResult(task, result, stats) {
+				survivors = append(survivors, activeHosts[j])
+			}
+		}
+		activeHosts = survivors
+	}
+
+	return activeHosts
+}
+
+// freeStrategy 每个主机独立执行自己的任务列表，不等待其他主机
+// 同时执行的任务数不超过 forks，所有主机交替推进
+type freeStrategy struct{}
+
+func (freeStrategy) Run(r *Runner, hosts []*inventory.Host, tasks []Task, stats map[string]*HostStats) []*inventory.Host {
+	slots := make(chan struct{}, r.pool.Size())
+	out := &hostTaskOutput{}
+
+	ok := make([]bool, len(hosts))
+	var wg sync.WaitGroup
+	for i, host := range hosts {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ok[i] = r.runHostTasks(host, tasks, stats, out, func(run func()) {
+				slots <- struct{}{}
+				defer func() { <-slots }()
+				run()
+			})
+		}()
+	}
+	wg.Wait()
+
+	return survivingHosts(hosts, ok)
+}
+
+// hostPinnedStrategy 与 free 类似，但每个 fork 固定服务一个主机直到其任务全部完成，
+// 之后才开始下一个主机（同时只有 forks 个主机在执行）
+type hostPinnedStrategy struct{}
+
+func (hostPinnedStrategy) Run(r *Runner, hosts []*inventory.Host, tasks []Task, stats map[string]*HostStats) []*inventory.Host {
+	out := &hostTaskOutput{}
+
+	ok := make([]bool, len(hosts))
+	r.pool.Run(len(hosts), func(i int) {
+		ok[i] = r.runHostTasks(hosts[i], tasks, stats, out, func(run func()) { run() })
+	})
+
+	return survivingHosts(hosts, ok)
+}
+
+// hostTaskOutput 串行化 free/host_pinned 策略下多个主机的输出和结果处理
+type hostTaskOutput struct {
+	mu   sync.Mutex
+	last *Task // 上一次打印标题的任务，连续执行同一任务时不重复打印
+}
+
+// runHostTasks 在单个主机上依次执行所有任务，withSlot 控制每个任务占用 fork 的方式
+// 主机失败时停止执行后续任务并返回 false
+func (r *Runner) runHostTasks(host *inventory.Host, tasks []Task, stats map[string]*HostStats, out *hostTaskOutput, withSlot func(run func())) bool {
+	for i := range tasks {
+		task := &tasks[i]
+
+		var result *TaskResult
+		if task.RunOnce {
+			// 第一个到达的主机执行，其他主机等待并使用同一个结果（不重复输出和统计）
+			var first bool
+			withSlot(func() {
+				result, first = r.runOnce(task, func() *TaskResult {
+					return r.executeTask(task, host)
+				})
+			})
+			if !first {
+				out.mu.Lock()
+				ok := r.propagateRunOnce(task, host.Name, result, stats)
+				out.mu.Unlock()
+				if !ok {
+					return false
+				}
+				continue
+			}
+		} else {
+			withSlot(func() {
+				result = r.executeTask(task, host)
+			})
+		}
+
+		out.mu.Lock()
+		if out.last != task {
+			r.logger.TaskHeader(taskDisplayName(task))
+			out.last = task
+		}
+		r.printTaskResult(result)
+		ok := r.recordTaskResult(task, result, stats)
+		out.mu.Unlock()
+
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// propagateRunOnce 把 run_once 任务的结果传播给没有执行该任务的主机，返回主机是否仍然活跃
+// 结果不重复输出；失败（且未 ignore_errors）时主机同样被移出，并计入该主机的 failed 统计
+func (r *Runner) propagateRunOnce(task *Task, hostName string, result *TaskResult, stats map[string]*HostStats) bool {
+	r.registerTaskResult(task, hostName, result)
+	if result.Failed && !task.IgnoreErrors {
+		stats[hostName].Failed++
+		return false
+	}
+	return true
+}
+
+// survivingHosts 返回 ok 为 true 的主机（保持原有顺序）
+func survivingHosts(hosts []*inventory.Host, ok []bool) []*inventory.Host {
+	var survivors []*inventory.Host
+	for i, host := range hosts {
+		if ok[i] {
+			survivors = append(survivors, host)
+		}
+	}
+	return survivors
+}
+
+// taskDisplayName 返回任务标题中显示的名称（未命名任务使用模块名）
+func taskDisplayName(task *Task) string {
+	if task.Name != "" {
+		return task.Name
+	}
+	return task.Module
+}
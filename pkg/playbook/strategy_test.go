@@ -0,0 +1,221 @@
+package playbook
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jimyag/ansigo/pkg/inventory"
+)
+
+func TestGetStrategy(t *testing.T) {
+	for _, name := range []string{"", "linear", "free", "host_pinned"} {
+		if _, err := GetStrategy(name); err != nil {
+			t.Errorf("GetStrategy(%q) error = %v", name, err)
+		}
+	}
+
+	if _, err := GetStrategy("debug"); err == nil {
+		t.Error("GetStrategy(\"debug\") succeeded, want error for unknown strategy")
+	}
+}
+
+func TestStrategiesStopFailedHosts(t *testing.T) {
+	// 不读取本机的 ssh 配置和 agent
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("SSH_AUTH_SOCK", "")
+
+	inv, hosts := loadTestInventory(t, `[web]
+web1
+bad ansible_host=127.0.0.1 ansible_port=1
+web2
+`)
+
+	// 只有 bad 执行第一个任务（连接失败），其余任务都被跳过，不需要真实的 SSH 服务器
+	tasks := []Task{
+		{Name: "only bad", Module: "debug", ModuleArgs: map[string]interface{}{"msg": "hi"}, When: "inventory_hostname == 'bad'"},
+		{Name: "never", Module: "debug", ModuleArgs: map[string]interface{}{"msg": "hi"}, When: "false"},
+	}
+
+	for _, name := range []string{"linear", "free", "host_pinned"} {
+		t.Run(name, func(t *testing.T) {
+			strategy, err := GetStrategy(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			r, stats := newTestRunner(t, inv, hosts)
+
+			active := strategy.Run(r, hosts, tasks, stats)
+
+			var names []string
+			for _, h := range active {
+				names = append(names, h.Name)
+			}
+			if len(names) != 2 || names[0] != "web1" || names[1] != "web2" {
+				t.Errorf("active hosts = %v, want [web1 web2]", names)
+			}
+
+			if s := stats["bad"]; s.Failed != 1 || s.Skipped != 0 {
+				t.Errorf("bad stats = %+v, want 1 failed and no further tasks", *s)
+			}
+			for _, h := range []string{"web1", "web2"} {
+				if s := stats[h]; s.Skipped != 2 || s.Failed != 0 {
+					t.Errorf("%s stats = %+v, want 2 skipped", h, *s)
+				}
+			}
+		})
+	}
+}
+
+func TestStrategiesRunOnce(t *testing.T) {
+	inv, hosts := loadTestInventory(t, `[web]
+web1
+web2
+web3
+`)
+
+	// when 为 false 的任务不需要连接，结果仍然会被注册并传播
+	tasks := []Task{
+		{Name: "once", Module: "debug", ModuleArgs: map[string]interface{}{"msg": "hi"}, When: "false", Register: "once_result", RunOnce: true},
+	}
+
+	for _, name := range []string{"linear", "free", "host_pinned"} {
+		t.Run(name, func(t *testing.T) {
+			strategy, err := GetStrategy(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r, stats := newTestRunner(t, inv, hosts)
+
+			if active := strategy.Run(r, hosts, tasks, stats); len(active) != 3 {
+				t.Errorf("active hosts = %d, want 3", len(active))
+			}
+
+			executed := 0
+			for _, h := range hosts {
+				executed += stats[h.Name].Skipped
+				if _, ok := r.varMgr.GetHostVar(h.Name, "once_result"); !ok {
+					t.Errorf("%s has no registered once_result", h.Name)
+				}
+			}
+			if executed != 1 {
+				t.Errorf("run_once task executed %d times, want 1", executed)
+			}
+		})
+	}
+}
+
+func TestStrategiesRunOnceFailure(t *testing.T) {
+	// 不读取本机的 ssh 配置和 agent
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("SSH_AUTH_SOCK", "")
+
+	inv, hosts := loadTestInventory(t, `[web]
+web1
+web2
+web3
+
+[web:vars]
+ansible_host=127.0.0.1
+ansible_port=1
+`)
+
+	// run_once 任务连接失败，所有主机都被移出并计入 failed
+	tasks := []Task{
+		{Name: "once", Module: "debug", ModuleArgs: map[string]interface{}{"msg": "hi"}, RunOnce: true},
+		{Name: "never", Module: "debug", ModuleArgs: map[string]interface{}{"msg": "hi"}, When: "false"},
+	}
+
+	for _, name := range []string{"linear", "free", "host_pinned"} {
+		t.Run(name, func(t *testing.T) {
+			strategy, err := GetStrategy(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r, stats := newTestRunner(t, inv, hosts)
+
+			if active := strategy.Run(r, hosts, tasks, stats); len(active) != 0 {
+				t.Errorf("active hosts = %d, want 0", len(active))
+			}
+			for _, h := range hosts {
+				if s := stats[h.Name]; s.Failed != 1 || s.Skipped != 0 {
+					t.Errorf("%s stats = %+v, want 1 failed", h.Name, *s)
+				}
+			}
+		})
+	}
+}
+
+func TestDelegateHost(t *testing.T) {
+	inv, hosts := loadTestInventory(t, `[web]
+web1
+
+[lb]
+lb1 ansible_host=10.0.0.5
+`)
+	r, _ := newTestRunner(t, inv, hosts)
+	context := r.varMgr.GetContext("web1")
+
+	tests := []struct {
+		delegateTo string
+		wantName   string
+		wantLocal  bool
+	}{
+		{"", "web1", false},
+		{"{{ groups['lb'][0] }}", "lb1", false},
+		{"localhost", "localhost", true},
+		{"db.example.com", "db.example.com", false},
+	}
+
+	for _, tt := range tests {
+		task := &Task{Module: "command", DelegateTo: tt.delegateTo}
+		got, err := r.delegateHost(task, hosts[0], context)
+		if err != nil {
+			t.Fatalf("delegateHost(%q) error = %v", tt.delegateTo, err)
+		}
+		if got.Name != tt.wantName {
+			t.Errorf("delegateHost(%q) = %s, want %s", tt.delegateTo, got.Name, tt.wantName)
+		}
+		if local := got.Vars["ansible_connection"] == "local"; local != tt.wantLocal {
+			t.Errorf("delegateHost(%q) local = %v, want %v", tt.delegateTo, local, tt.wantLocal)
+		}
+	}
+}
+
+// loadTestInventory 从 INI 内容加载 inventory，返回 all 组的主机
+func loadTestInventory(t *testing.T, content string) (*inventory.Manager, []*inventory.Host) {
+	t.Helper()
+
+	invPath := filepath.Join(t.TempDir(), "hosts.ini")
+	if err := os.WriteFile(invPath, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	inv := inventory.NewManager()
+	if err := inv.Load(invPath); err != nil {
+		t.Fatal(err)
+	}
+	hosts, err := inv.GetHosts("all")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return inv, hosts
+}
+
+// newTestRunner 创建可以直接调用策略的 Runner（相当于 executeBatch 的初始化）
+func newTestRunner(t *testing.T, inv *inventory.Manager, hosts []*inventory.Host) (*Runner, map[string]*HostStats) {
+	t.Helper()
+
+	r := NewRunner(inv)
+	t.Cleanup(func() { r.Close() })
+	r.SetForks(2)
+	r.currentPlay = &Play{}
+	r.notifiedHandlers = make(map[string]bool)
+	r.runOnceResults = make(map[*Task]*runOnceResult)
+
+	stats := make(map[string]*HostStats)
+	for _, h := range hosts {
+		stats[h.Name] = &HostStats{}
+	}
+	return r, stats
+}
@@ -0,0 +1,214 @@
+package playbook
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// 特殊标签
+const (
+	TagAlways   = "always"   // 除非被 --skip-tags 明确跳过，否则总是执行
+	TagNever    = "never"    // 除非被 --tags 明确选中，否则不执行
+	TagAll      = "all"      // 所有任务（不含 never），--tags 的默认值
+	TagTagged   = "tagged"   // 至少有一个标签的任务
+	TagUntagged = "untagged" // 没有标签的任务
+)
+
+// Tags 标签列表，YAML 中可以写成列表或逗号分隔的字符串
+type Tags []string
+
+// UnmarshalYAML 解析 tags: a,b 和 tags: [a, b] 两种写法
+func (t *Tags) UnmarshalYAML(value *yaml.Node) error {
+	var raw interface{}
+	if err := value.Decode(&raw); err != nil {
+		return err
+	}
+	tags, err := parseTags(raw)
+	if err != nil {
+		return fmt.Errorf("line %d: %w", value.Line, err)
+	}
+	*t = tags
+	return nil
+}
+
+// parseTags 把字符串（逗号分隔）、数字或它们的列表转换为标签列表
+func parseTags(value interface{}) (Tags, error) {
+	switch v := value.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		return SplitTags(v), nil
+	case int, float64, bool:
+		return Tags{fmt.Sprint(v)}, nil
+	case []interface{}:
+		var tags Tags
+		for _, item := range v {
+			if _, nested := item.([]interface{}); nested {
+				return nil, fmt.Errorf("tags must be a string or a list of strings")
+			}
+			itemTags, err := parseTags(item)
+			if err != nil {
+				return nil, err
+			}
+			tags = append(tags, itemTags...)
+		}
+		return tags, nil
+	default:
+		return nil, fmt.Errorf("tags must be a string or a list of strings, got %T", value)
+	}
+}
+
+// SplitTags 拆分逗号分隔的标签，忽略空白和空项
+func SplitTags(s string) Tags {
+	var tags Tags
+	for _, tag := range strings.Split(s, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// contains 判断是否包含标签
+func (t Tags) contains(tag string) bool {
+	for _, v := range t {
+		if v == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// intersects 判断两个标签列表是否有交集
+func (t Tags) intersects(other Tags) bool {
+	for _, v := range t {
+		if other.contains(v) {
+			return true
+		}
+	}
+	return false
+}
+
+// merge 返回 t 加上 other 中尚未出现的标签（不修改 t）
+func (t Tags) merge(other Tags) Tags {
+	merged := append(Tags{}, t...)
+	for _, tag := range other {
+		if !merged.contains(tag) {
+			merged = append(merged, tag)
+		}
+	}
+	return merged
+}
+
+// TagFilter 根据 --tags 和 --skip-tags 选择任务
+type TagFilter struct {
+	Only Tags // 只执行带这些标签的任务，为空时等同于 all
+	Skip Tags // 跳过带这些标签的任务
+}
+
+// ShouldRun 判断带有 tags 的任务是否执行，规则与 Ansible 相同：
+//   - always 标签的任务总是被选中，never 标签的任务只有被 --tags 明确选中时才执行
+//   - tagged/untagged 匹配有/没有标签的任务
+//   - --skip-tags 优先于 --tags；--skip-tags always 可以跳过 always 任务
+func (f TagFilter) ShouldRun(tags Tags) bool {
+	if len(tags) == 0 {
+		tags = Tags{TagUntagged}
+	}
+	untagged := len(tags) == 1 && tags[0] == TagUntagged
+
+	only := f.Only
+	if len(only) == 0 {
+		only = Tags{TagAll}
+	}
+
+	switch {
+	case tags.contains(TagAlways):
+	case only.contains(TagAll) && !tags.contains(TagNever):
+	case tags.intersects(only):
+	case only.contains(TagTagged) && !untagged && !tags.contains(TagNever):
+	default:
+		return false
+	}
+
+	switch {
+	case f.Skip.contains(TagAll):
+		return tags.contains(TagAlways) && !f.Skip.contains(TagAlways)
+	case tags.intersects(f.Skip):
+		return false
+	case f.Skip.contains(TagTagged) && !untagged:
+		return false
+	}
+	return true
+}
+
+// inheritTags 返回继承了 tags 的任务副本，block 的标签同时传递给 block/rescue/always 中的任务
+// play、role、block、import_tasks 上的标签通过它向下传递，原任务不会被修改
+func inheritTags(tasks []Task, tags Tags) []Task {
+	result := make([]Task, len(tasks))
+	for i, task := range tasks {
+		task.Tags = task.Tags.merge(tags)
+		if task.TaskBlock != nil {
+			task.TaskBlock = &Block{
+				Block:  inheritTags(task.TaskBlock.Block, task.Tags),
+				Rescue: inheritTags(task.TaskBlock.Rescue, task.Tags),
+				Always: inheritTags(task.TaskBlock.Always, task.Tags),
+			}
+		}
+		result[i] = task
+	}
+	return result
+}
+
+// filterTasks 返回 filter 选中的任务
+// block 中的任务逐个判断，block/rescue/always 中没有任务被选中时整个 block 被移除
+func filterTasks(tasks []Task, filter TagFilter) []Task {
+	var result []Task
+	for _, task := range tasks {
+		if task.TaskBlock == nil {
+			if filter.ShouldRun(task.Tags) {
+				result = append(result, task)
+			}
+			continue
+		}
+
+		block := &Block{
+			Block:  filterTasks(task.TaskBlock.Block, filter),
+			Rescue: filterTasks(task.TaskBlock.Rescue, filter),
+			Always: filterTasks(task.TaskBlock.Always, filter),
+		}
+		if len(block.Block)+len(block.Rescue)+len(block.Always) > 0 {
+			task.TaskBlock = block
+			result = append(result, task)
+		}
+	}
+	return result
+}
+
+// collectTags 返回任务（包括 block 中的任务）使用的所有标签，按字母排序
+func collectTags(tasks []Task) Tags {
+	seen := make(map[string]bool)
+	var walk func(tasks []Task)
+	walk = func(tasks []Task) {
+		for _, task := range tasks {
+			for _, tag := range task.Tags {
+				seen[tag] = true
+			}
+			if task.TaskBlock != nil {
+				walk(task.TaskBlock.Block)
+				walk(task.TaskBlock.Rescue)
+				walk(task.TaskBlock.Always)
+			}
+		}
+	}
+	walk(tasks)
+
+	tags := make(Tags, 0, len(seen))
+	for tag := range seen {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+	return tags
+}
@@ -0,0 +1,207 @@
+package playbook
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/jimyag/ansigo/pkg/inventory"
+	"gopkg.in/yaml.v3"
+)
+
+func TestTagsUnmarshal(t *testing.T) {
+	tests := []struct {
+		name    string
+		yaml    string
+		want    Tags
+		wantErr bool
+	}{
+		{name: "string", yaml: `tags: config`, want: Tags{"config"}},
+		{name: "comma separated", yaml: `tags: "config, packages"`, want: Tags{"config", "packages"}},
+		{name: "list", yaml: `tags: [config, 2024]`, want: Tags{"config", "2024"}},
+		{name: "nested list", yaml: `tags: [[config]]`, wantErr: true},
+		{name: "mapping", yaml: `tags: {config: true}`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var task Task
+			err := yaml.Unmarshal([]byte("debug: {msg: hi}\n"+tt.yaml), &task)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Unmarshal() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(task.Tags, tt.want) {
+				t.Errorf("Tags = %#v, want %#v", task.Tags, tt.want)
+			}
+		})
+	}
+}
+
+func TestTagFilterShouldRun(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter TagFilter
+		tags   Tags
+		want   bool
+	}{
+		{name: "default runs untagged", tags: nil, want: true},
+		{name: "default runs tagged", tags: Tags{"config"}, want: true},
+		{name: "default skips never", tags: Tags{"never", "debug"}, want: false},
+		{name: "only match", filter: TagFilter{Only: Tags{"config"}}, tags: Tags{"config", "web"}, want: true},
+		{name: "only no match", filter: TagFilter{Only: Tags{"config"}}, tags: Tags{"packages"}, want: false},
+		{name: "only untagged", filter: TagFilter{Only: Tags{"config"}}, tags: nil, want: false},
+		{name: "only always", filter: TagFilter{Only: Tags{"config"}}, tags: Tags{"always"}, want: true},
+		{name: "only selects never", filter: TagFilter{Only: Tags{"debug"}}, tags: Tags{"never", "debug"}, want: true},
+		{name: "tagged", filter: TagFilter{Only: Tags{"tagged"}}, tags: Tags{"config"}, want: true},
+		{name: "tagged skips untagged", filter: TagFilter{Only: Tags{"tagged"}}, tags: nil, want: false},
+		{name: "tagged skips never", filter: TagFilter{Only: Tags{"tagged"}}, tags: Tags{"never"}, want: false},
+		{name: "untagged", filter: TagFilter{Only: Tags{"untagged"}}, tags: nil, want: true},
+		{name: "untagged skips tagged", filter: TagFilter{Only: Tags{"untagged"}}, tags: Tags{"config"}, want: false},
+		{name: "skip match", filter: TagFilter{Skip: Tags{"packages"}}, tags: Tags{"packages"}, want: false},
+		{name: "skip wins over only", filter: TagFilter{Only: Tags{"config"}, Skip: Tags{"slow"}}, tags: Tags{"config", "slow"}, want: false},
+		{name: "skip does not match", filter: TagFilter{Skip: Tags{"packages"}}, tags: Tags{"config"}, want: true},
+		{name: "skip always explicitly", filter: TagFilter{Skip: Tags{"always"}}, tags: Tags{"always"}, want: false},
+		{name: "skip all keeps always", filter: TagFilter{Skip: Tags{"all"}}, tags: Tags{"always"}, want: true},
+		{name: "skip all", filter: TagFilter{Skip: Tags{"all"}}, tags: Tags{"config"}, want: false},
+		{name: "skip tagged", filter: TagFilter{Skip: Tags{"tagged"}}, tags: Tags{"config"}, want: false},
+		{name: "skip tagged keeps untagged", filter: TagFilter{Skip: Tags{"tagged"}}, tags: nil, want: true},
+		{name: "skip untagged", filter: TagFilter{Skip: Tags{"untagged"}}, tags: nil, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.filter.ShouldRun(tt.tags); got != tt.want {
+				t.Errorf("ShouldRun(%v) = %v, want %v", tt.tags, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInheritAndFilterTasks(t *testing.T) {
+	tasks := []Task{
+		{Name: "packages", Tags: Tags{"packages"}},
+		{Name: "config", Tags: Tags{"config"}, TaskBlock: &Block{
+			Block:  []Task{{Name: "render"}, {Name: "validate", Tags: Tags{"validate"}}},
+			Always: []Task{{Name: "cleanup"}},
+		}},
+	}
+
+	inherited := inheritTags(tasks, Tags{"site"})
+
+	// 原任务不被修改
+	if !reflect.DeepEqual(tasks[0].Tags, Tags{"packages"}) || len(tasks[1].TaskBlock.Block[0].Tags) != 0 {
+		t.Fatalf("inheritTags modified the original tasks: %+v", tasks)
+	}
+
+	block := inherited[1].TaskBlock
+	if got := block.Block[1].Tags; !reflect.DeepEqual(got, Tags{"validate", "config", "site"}) {
+		t.Errorf("block task tags = %v, want [validate config site]", got)
+	}
+	if got := block.Always[0].Tags; !reflect.DeepEqual(got, Tags{"config", "site"}) {
+		t.Errorf("always task tags = %v, want [config site]", got)
+	}
+
+	selected := filterTasks(inherited, TagFilter{Only: Tags{"validate"}})
+	if len(selected) != 1 || selected[0].Name != "config" {
+		t.Fatalf("filterTasks() = %+v, want only the config block", selected)
+	}
+	if got := selected[0].TaskBlock; len(got.Block) != 1 || got.Block[0].Name != "validate" || len(got.Always) != 0 {
+		t.Errorf("filtered block = %+v, want only the validate task", got)
+	}
+
+	if got := collectTags(inherited); !reflect.DeepEqual(got, Tags{"config", "packages", "site", "validate"}) {
+		t.Errorf("collectTags() = %v", got)
+	}
+}
+
+func TestListTagsInheritsFromRolesAndImports(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "roles", "web", "tasks", "main.yml"), `
+- name: Install nginx
+  debug: {msg: install}
+`)
+	writeTestFile(t, filepath.Join(dir, "tasks", "config.yml"), `
+- name: Render config
+  debug: {msg: render}
+- name: Debug config
+  debug: {msg: debug}
+  tags: never
+`)
+	playbookPath := filepath.Join(dir, "site.yml")
+	writeTestFile(t, playbookPath, `
+- name: Site
+  hosts: all
+  tags: site
+  roles:
+    - role: web
+      tags: [web]
+  tasks:
+    - import_tasks: tasks/config.yml
+      tags: config
+`)
+
+	data, err := os.ReadFile(playbookPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pb, err := ParsePlaybook(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := NewRunner(inventory.NewManager())
+	defer r.Close()
+	r.SetPlaybookPath(playbookPath)
+
+	playVars, err := r.loadPlayVars(&pb[0], false)
+	if err != nil {
+		t.Fatalf("loadPlayVars() error = %v", err)
+	}
+	tasks, _, err := r.loadPlayTasks(&pb[0], playVars.merged())
+	if err != nil {
+		t.Fatalf("loadPlayTasks() error = %v", err)
+	}
+	wantTags := []Tags{{"web", "site"}, {"config", "site"}, {"never", "config", "site"}}
+	if len(tasks) != len(wantTags) {
+		t.Fatalf("loadPlayTasks() returned %d tasks, want %d", len(tasks), len(wantTags))
+	}
+	for i, want := range wantTags {
+		if !reflect.DeepEqual(tasks[i].Tags, want) {
+			t.Errorf("task %q tags = %v, want %v", tasks[i].Name, tasks[i].Tags, want)
+		}
+	}
+
+	// 默认不列出 never 任务；--tags 明确选中 never 任务的其他标签时列出
+	for _, tt := range []struct {
+		filter   TagFilter
+		taskTags string
+	}{
+		{filter: TagFilter{}, taskTags: "config, site, web"},
+		{filter: TagFilter{Only: Tags{"config"}}, taskTags: "config, never, site"},
+	} {
+		var out bytes.Buffer
+		r.SetTagFilter(tt.filter)
+		if err := r.ListTags(pb, &out); err != nil {
+			t.Fatalf("ListTags() error = %v", err)
+		}
+		want := "\n  play #1 (all): Site\tTAGS: [site]\n      TASK TAGS: [" + tt.taskTags + "]\n"
+		if out.String() != want {
+			t.Errorf("ListTags() with %+v = %q, want %q", tt.filter, out.String(), want)
+		}
+	}
+}
+
+// writeTestFile 写入测试文件，自动创建父目录
+func writeTestFile(t *testing.T, path, content string) {
+	t.Helper()
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(strings.TrimPrefix(content, "\n")), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -23,7 +23,7 @@ func NewTaskIncluder(playbookPath string) *TaskIncluder {
 }
 
 // ExpandTask 展开任务（处理 import_tasks 和 include_role）
-// 返回展开后的任务列表
+// 返回展开后的任务列表，展开的任务继承包含任务的作用域，包含任务的 vars 作为 include 参数
 func (ti *TaskIncluder) ExpandTask(task *Task, vars map[string]interface{}) ([]Task, error) {
 	// 规范化模块名（移除 ansible.builtin. 前缀）
 	moduleName := task.Module
@@ -85,7 +85,7 @@ func (ti *TaskIncluder) expandImportTasks(task *Task, vars map[string]interface{
 		return nil, fmt.Errorf("failed to parse tasks file %s: %w", tasksFile, err)
 	}
 
-	return tasks, nil
+	return scopeTasks(tasks, task.Scope.withIncludeParams(task.Vars)), nil
 }
 
 // expandIncludeRole 展开 include_role
@@ -99,29 +99,35 @@ func (ti *TaskIncluder) expandIncludeRole(task *Task, vars map[string]interface{
 	// 获取可选的 tasks_from 参数
 	tasksFrom, _ := task.ModuleArgs["tasks_from"].(string)
 
-	// 构建 RoleSpec
-	spec := RoleSpec{
-		Name: roleName,
-		Vars: make(map[string]interface{}),
-	}
-
-	// 提取传递给 role 的变量（从 vars 参数）
+	// 传递给 role 的变量（任务的 vars 或 include_role 的 vars 参数）作为 include 参数
+	params := task.Vars
 	if roleVars, ok := task.ModuleArgs["vars"].(map[string]interface{}); ok {
-		spec.Vars = roleVars
+		params = mergeVars(roleVars, task.Vars)
 	}
+	scope := task.Scope.withIncludeParams(params)
+	scope.RoleName, scope.RolePath = roleName, ""
+	scope.RoleDefaults, scope.RoleVars, scope.RoleParams = nil, nil, nil
 
 	// 如果有 tasks_from，只加载特定的任务文件
 	if tasksFrom != "" {
-		return ti.loadRoleTasksFrom(roleName, tasksFrom, spec.Vars)
+		tasks, err := ti.loadRoleTasksFrom(roleName, tasksFrom, params)
+		if err != nil {
+			return nil, err
+		}
+		scope.RolePath, _ = ti.roleLoader.findRolePath(roleName)
+		return scopeTasks(tasks, scope), nil
 	}
 
-	// 否则加载整个 role
-	role, err := ti.roleLoader.LoadRole(spec)
+	// 否则加载整个 role，role 的 defaults 和 vars 只对 role 中的任务可见
+	role, err := ti.roleLoader.LoadRole(RoleSpec{Name: roleName})
 	if err != nil {
 		return nil, fmt.Errorf("failed to load role '%s': %w", roleName, err)
 	}
+	scope.RolePath = role.Path
+	scope.RoleDefaults = role.Defaults
+	scope.RoleVars = role.Vars
 
-	return role.Tasks, nil
+	return scopeTasks(role.Tasks, scope), nil
 }
 
 // loadRoleTasksFrom 加载 role 的特定任务文件
@@ -2,8 +2,10 @@ package playbook
 
 import (
 	"fmt"
+	"strconv"
 	"strings"
 
+	"github.com/jimyag/ansigo/pkg/module"
 	"gopkg.in/yaml.v3"
 )
 
@@ -16,12 +18,19 @@ type Play struct {
 	Hosts        string                 `yaml:"hosts"`
 	GatherFacts  bool                   `yaml:"gather_facts"`
 	Vars         map[string]interface{} `yaml:"vars"`
-	Roles        []interface{}          `yaml:"roles"` // 可以是字符串或字典
+	VarsFiles    []interface{}          `yaml:"vars_files"`  // 变量文件：文件名或候选文件名列表
+	VarsPrompt   []VarPrompt            `yaml:"vars_prompt"` // 运行前询问的变量
+	Roles        []interface{}          `yaml:"roles"`       // 可以是字符串或字典
 	Tasks        []Task                 `yaml:"tasks"`
 	Handlers     []Handler              `yaml:"handlers"`
 	Become       bool                   `yaml:"become"`        // Play 级别权限提升
 	BecomeUser   string                 `yaml:"become_user"`   // 切换到的用户（默认 root）
 	BecomeMethod string                 `yaml:"become_method"` // 提权方法（默认 sudo）
+
+	Serial            interface{} `yaml:"serial"`              // 滚动更新批次大小：整数、百分比或列表
+	MaxFailPercentage *float64    `yaml:"max_fail_percentage"` // 单个批次失败主机比例超过该值时中止 play
+	Strategy          string      `yaml:"strategy"`            // 执行策略：linear（默认）、free、host_pinned
+	Tags              Tags        `yaml:"tags"`                // play 中所有任务继承的标签
 }
 
 // Role 代表一个 Ansible Role
@@ -37,7 +46,8 @@ type Role struct {
 // RoleSpec 代表 Role 引用（可以是字符串或带参数的字典）
 type RoleSpec struct {
 	Name string                 // Role 名称
-	Vars map[string]interface{} // 传递给 role 的变量
+	Vars map[string]interface{} // 传递给 role 的参数（role params）
+	Tags Tags                   // role 中所有任务继承的标签
 }
 
 // LoopControl 循环控制选项
@@ -57,21 +67,32 @@ type Block struct {
 
 // Task 代表一个任务
 type Task struct {
-	Name         string
-	Module       string
-	ModuleArgs   map[string]interface{}
-	Register     string
-	When         string
-	FailedWhen   string
-	ChangedWhen  string
-	IgnoreErrors bool
-	Notify       []string      // 通知的 handler 名称列表
-	Loop         []interface{} // 循环列表
-	LoopControl  *LoopControl  // 循环控制选项
-	TaskBlock    *Block        // Block 结构（如果是 block 任务）
-	Become       *bool         // Task 级别权限提升（指针以区分未设置和 false）
-	BecomeUser   string        // 切换到的用户
-	BecomeMethod string        // 提权方法
+	Name          string
+	Module        string
+	ModuleArgs    map[string]interface{}
+	Register      string
+	When          string
+	FailedWhen    string
+	ChangedWhen   string
+	Until         string // 重试直到条件满足
+	Retries       int    // until 的最大重试次数（默认 3）
+	Delay         int    // 两次重试之间等待的秒数（默认 5）
+	IgnoreErrors  bool
+	Notify        []string               // 通知的 handler 名称列表
+	Loop          []interface{}          // 循环列表
+	LoopControl   *LoopControl           // 循环控制选项
+	TaskBlock     *Block                 // Block 结构（如果是 block 任务）
+	Become        *bool                  // Task 级别权限提升（指针以区分未设置和 false）
+	BecomeUser    string                 // 切换到的用户
+	BecomeMethod  string                 // 提权方法
+	DelegateTo    string                 // 委托执行的主机（支持模板），变量上下文仍然是原主机
+	DelegateFacts bool                   // 为 true 时 facts 设置到被委托的主机上
+	RunOnce       bool                   // 只在第一个活跃主机上执行，结果传播给所有主机
+	Tags          Tags                   // 任务标签（包括从 play、role、block、import_tasks 继承的标签）
+	CheckMode     *bool                  // check_mode：true 时总是以 check 模式执行，false 时忽略 --check（nil 表示跟随 --check）
+	Diff          *bool                  // diff：是否输出文件修改前后的 diff（nil 表示跟随 --diff）
+	Vars          map[string]interface{} // task vars（block 任务上的是 block vars）
+	Scope         *VarScope              // 任务所在的 role、block、include 的变量，加载 play 时设置
 }
 
 // Handler 代表一个 handler（本质是特殊的任务）
@@ -82,27 +103,56 @@ type Handler struct {
 	ModuleArgs   map[string]interface{}
 	When         string
 	IgnoreErrors bool
+	Scope        *VarScope // handler 所在 role 的变量，加载 play 时设置
+}
+
+// inheritBlockModes 把 block 的 check_mode 和 diff 设置到没有设置它们的任务上（包括嵌套 block 中的任务）
+func inheritBlockModes(tasks []Task, checkMode, diff *bool) {
+	for i := range tasks {
+		task := &tasks[i]
+		if task.CheckMode == nil {
+			task.CheckMode = checkMode
+		}
+		if task.Diff == nil {
+			task.Diff = diff
+		}
+		if task.TaskBlock != nil {
+			inheritBlockModes(task.TaskBlock.Block, task.CheckMode, task.Diff)
+			inheritBlockModes(task.TaskBlock.Rescue, task.CheckMode, task.Diff)
+			inheritBlockModes(task.TaskBlock.Always, task.CheckMode, task.Diff)
+		}
+	}
 }
 
 // UnmarshalYAML 自定义 Task 的 YAML 解析
 func (t *Task) UnmarshalYAML(value *yaml.Node) error {
 	// 使用辅助结构解析已知字段
 	type TaskFields struct {
-		Name         string       `yaml:"name"`
-		Register     string       `yaml:"register"`
-		When         interface{}  `yaml:"when"`          // 可以是字符串或列表
-		FailedWhen   string       `yaml:"failed_when"`
-		ChangedWhen  string       `yaml:"changed_when"`
-		IgnoreErrors bool         `yaml:"ignore_errors"`
-		Notify       interface{}  `yaml:"notify"`        // 可以是字符串或列表
-		Loop         interface{}  `yaml:"loop"`          // 循环列表（可以是列表或模板字符串）
-		LoopControl  *LoopControl `yaml:"loop_control"`  // 循环控制
-		Block        []Task       `yaml:"block"`         // Block 任务列表
-		Rescue       []Task       `yaml:"rescue"`        // Rescue 任务列表
-		Always       []Task       `yaml:"always"`        // Always 任务列表
-		Become       *bool        `yaml:"become"`        // 权限提升
-		BecomeUser   string       `yaml:"become_user"`   // 切换用户
-		BecomeMethod string       `yaml:"become_method"` // 提权方法
+		Name          string                 `yaml:"name"`
+		Register      string                 `yaml:"register"`
+		When          interface{}            `yaml:"when"` // 可以是字符串或列表
+		FailedWhen    string                 `yaml:"failed_when"`
+		ChangedWhen   string                 `yaml:"changed_when"`
+		Until         interface{}            `yaml:"until"`   // 可以是字符串或列表
+		Retries       interface{}            `yaml:"retries"` // 整数或数字字符串
+		Delay         interface{}            `yaml:"delay"`   // 整数或数字字符串
+		IgnoreErrors  bool                   `yaml:"ignore_errors"`
+		Notify        interface{}            `yaml:"notify"`         // 可以是字符串或列表
+		Loop          interface{}            `yaml:"loop"`           // 循环列表（可以是列表或模板字符串）
+		LoopControl   *LoopControl           `yaml:"loop_control"`   // 循环控制
+		Block         []Task                 `yaml:"block"`          // Block 任务列表
+		Rescue        []Task                 `yaml:"rescue"`         // Rescue 任务列表
+		Always        []Task                 `yaml:"always"`         // Always 任务列表
+		Become        *bool                  `yaml:"become"`         // 权限提升
+		BecomeUser    string                 `yaml:"become_user"`    // 切换用户
+		BecomeMethod  string                 `yaml:"become_method"`  // 提权方法
+		DelegateTo    string                 `yaml:"delegate_to"`    // 委托主机
+		DelegateFacts bool                   `yaml:"delegate_facts"` // facts 设置到委托主机
+		RunOnce       bool                   `yaml:"run_once"`       // 只执行一次
+		Tags          Tags                   `yaml:"tags"`           // 标签
+		CheckMode     *bool                  `yaml:"check_mode"`     // check 模式
+		Diff          *bool                  `yaml:"diff"`           // 输出 diff
+		Vars          map[string]interface{} `yaml:"vars"`           // task vars
 	}
 
 	var fields TaskFields
@@ -135,10 +185,37 @@ func (t *Task) UnmarshalYAML(value *yaml.Node) error {
 	t.FailedWhen = fields.FailedWhen
 	t.ChangedWhen = fields.ChangedWhen
 	t.IgnoreErrors = fields.IgnoreErrors
+
+	// 解析 until/retries/delay（until 列表同样表示 AND 关系）
+	t.Until = joinConditions(fields.Until)
+	if t.Until != "" {
+		t.Retries, t.Delay = 3, 5
+	}
+	if fields.Retries != nil {
+		n, err := parseTaskInt("retries", fields.Retries)
+		if err != nil {
+			return err
+		}
+		t.Retries = n
+	}
+	if fields.Delay != nil {
+		n, err := parseTaskInt("delay", fields.Delay)
+		if err != nil {
+			return err
+		}
+		t.Delay = n
+	}
 	t.LoopControl = fields.LoopControl
 	t.Become = fields.Become
 	t.BecomeUser = fields.BecomeUser
 	t.BecomeMethod = fields.BecomeMethod
+	t.DelegateTo = fields.DelegateTo
+	t.DelegateFacts = fields.DelegateFacts
+	t.RunOnce = fields.RunOnce
+	t.Tags = fields.Tags
+	t.CheckMode = fields.CheckMode
+	t.Diff = fields.Diff
+	t.Vars = fields.Vars
 	t.ModuleArgs = make(map[string]interface{})
 
 	// 检查是否是 block 任务
@@ -148,6 +225,10 @@ func (t *Task) UnmarshalYAML(value *yaml.Node) error {
 			Rescue: fields.Rescue,
 			Always: fields.Always,
 		}
+		// block 上的 check_mode/diff 传递给没有设置它们的子任务
+		inheritBlockModes(t.TaskBlock.Block, t.CheckMode, t.Diff)
+		inheritBlockModes(t.TaskBlock.Rescue, t.CheckMode, t.Diff)
+		inheritBlockModes(t.TaskBlock.Always, t.CheckMode, t.Diff)
 		// Block 任务不需要 Module
 		return nil
 	}
@@ -183,78 +264,50 @@ func (t *Task) UnmarshalYAML(value *yaml.Node) error {
 
 	// 已知的标准字段
 	knownFields := map[string]bool{
-		"name":          true,
-		"register":      true,
-		"when":          true,
-		"failed_when":   true,
-		"changed_when":  true,
-		"ignore_errors": true,
-		"notify":        true,
-		"loop":          true,
-		"loop_control":  true,
-		"block":         true,
-		"rescue":        true,
-		"always":        true,
-		"become":        true,
-		"become_user":   true,
-		"become_method": true,
-	}
-
-	// 已知的模块列表
-	knownModules := map[string]bool{
-		"ping":                         true,
-		"command":                      true,
-		"shell":                        true,
-		"raw":                          true,
-		"copy":                         true,
-		"debug":                        true,
-		"set_fact":                     true,
-		"file":                         true,
-		"template":                     true,
-		"lineinfile":                   true,
-		"service":                      true,
-		"systemd":                      true,
-		"get_url":                      true,
-		"fail":                         true,
-		"ansible.builtin.import_tasks": true,
-		"import_tasks":                 true,
-		"ansible.builtin.include_role": true,
-		"include_role":                 true,
-	}
-
-	// 遍历所有字段，查找模块名
-	if value.Kind == yaml.MappingNode {
-		for i := 0; i < len(value.Content); i += 2 {
-			keyNode := value.Content[i]
-			valueNode := value.Content[i+1]
-
-			key := keyNode.Value
+		"name":           true,
+		"register":       true,
+		"when":           true,
+		"failed_when":    true,
+		"changed_when":   true,
+		"until":          true,
+		"retries":        true,
+		"delay":          true,
+		"ignore_errors":  true,
+		"notify":         true,
+		"loop":           true,
+		"loop_control":   true,
+		"block":          true,
+		"rescue":         true,
+		"always":         true,
+		"become":         true,
+		"become_user":    true,
+		"become_method":  true,
+		"delegate_to":    true,
+		"delegate_facts": true,
+		"run_once":       true,
+		"tags":           true,
+		"check_mode":     true,
+		"diff":           true,
+		"vars":           true,
+		"local_action":   true,
+	}
 
-			// 跳过已知字段
-			if knownFields[key] {
-				continue
-			}
+	// 查找模块
+	moduleName, args, err := findModule(value, knownFields)
+	if err != nil {
+		return err
+	}
+	if moduleName != "" {
+		t.Module = moduleName
+		t.ModuleArgs = args
+	}
 
-			// 检查是否是模块
-			if knownModules[key] {
-				t.Module = key
-
-				// 解析模块参数
-				switch valueNode.Kind {
-				case yaml.ScalarNode:
-					// 短格式: command: uptime
-					if valueNode.Value != "" {
-						t.ModuleArgs["_raw_params"] = valueNode.Value
-					}
-				case yaml.MappingNode:
-					// 长格式: command: {cmd: uptime}
-					var args map[string]interface{}
-					if err := valueNode.Decode(&args); err != nil {
-						return fmt.Errorf("failed to parse module args: %w", err)
-					}
-					t.ModuleArgs = args
-				default:
-					return fmt.Errorf("unsupported module args format for module %s", key)
+	// local_action 是 delegate_to: localhost 的简写，模块写在 local_action 的值中
+	if t.Module == "" && value.Kind == yaml.MappingNode {
+		for i := 0; i < len(value.Content); i += 2 {
+			if value.Content[i].Value == "local_action" {
+				if err := t.parseLocalAction(value.Content[i+1]); err != nil {
+					return err
 				}
 				break
 			}
@@ -268,6 +321,137 @@ func (t *Task) UnmarshalYAML(value *yaml.Node) error {
 	return nil
 }
 
+// parseLocalAction 解析 local_action 的模块和参数
+// 支持两种格式: "command uptime" 或 {module: copy, src: a, dest: b}
+func (t *Task) parseLocalAction(node *yaml.Node) error {
+	t.DelegateTo = "localhost"
+
+	switch node.Kind {
+	case yaml.ScalarNode:
+		module, rest, _ := strings.Cut(strings.TrimSpace(node.Value), " ")
+		t.Module = module
+		if rest = strings.TrimSpace(rest); rest != "" {
+			t.ModuleArgs["_raw_params"] = rest
+		}
+	case yaml.MappingNode:
+		var args map[string]interface{}
+		if err := node.Decode(&args); err != nil {
+			return fmt.Errorf("failed to parse local_action args: %w", err)
+		}
+		module, _ := args["module"].(string)
+		delete(args, "module")
+		t.Module = module
+		t.ModuleArgs = args
+	default:
+		return fmt.Errorf("unsupported local_action format in task: %s", t.Name)
+	}
+
+	if !isAction(t.Module) {
+		return fmt.Errorf("unknown module '%s' in local_action of task: %s", t.Module, t.Name)
+	}
+	t.Module = actionName(t.Module)
+	return nil
+}
+
+// includeActions 加载 play 时由 runner 展开的任务，它们不是模块，不在模块注册表中
+var includeActions = map[string]string{
+	"import_tasks":                 "import_tasks",
+	"ansible.builtin.import_tasks": "import_tasks",
+	"include_role":                 "include_role",
+	"ansible.builtin.include_role": "include_role",
+}
+
+// isAction 判断 name 是否是已注册的模块（或别名）或 include 任务
+func isAction(name string) bool {
+	if _, ok := includeActions[name]; ok {
+		return true
+	}
+	_, ok := module.Lookup(name)
+	return ok
+}
+
+// actionName 返回模块名：别名（如 ansible.builtin.copy）转换为注册的模块名
+func actionName(name string) string {
+	if action, ok := includeActions[name]; ok {
+		return action
+	}
+	if spec, ok := module.Lookup(name); ok {
+		return spec.Name
+	}
+	return name
+}
+
+// findModule 在任务（或 handler）的字段中查找模块，返回模块名和参数，没有模块时返回空的模块名
+// knownFields 中的字段是任务关键字，不会被当作模块
+func findModule(value *yaml.Node, knownFields map[string]bool) (string, map[string]interface{}, error) {
+	if value.Kind != yaml.MappingNode {
+		return "", nil, nil
+	}
+
+	for i := 0; i < len(value.Content); i += 2 {
+		key := value.Content[i].Value
+		valueNode := value.Content[i+1]
+
+		// 跳过已知字段
+		if knownFields[key] || !isAction(key) {
+			continue
+		}
+
+		// 解析模块参数
+		args := make(map[string]interface{})
+		switch valueNode.Kind {
+		case yaml.ScalarNode:
+			// 短格式: command: uptime
+			if valueNode.Value != "" {
+				args["_raw_params"] = valueNode.Value
+			}
+		case yaml.MappingNode:
+			// 长格式: command: {cmd: uptime}
+			if err := valueNode.Decode(&args); err != nil {
+				return "", nil, fmt.Errorf("failed to parse module args: %w", err)
+			}
+		default:
+			return "", nil, fmt.Errorf("unsupported module args format for module %s", key)
+		}
+		return actionName(key), args, nil
+	}
+	return "", nil, nil
+}
+
+// joinConditions 把字符串或条件列表转换为单个条件表达式（列表表示 AND 关系）
+func joinConditions(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case bool:
+		return strconv.FormatBool(v)
+	case []interface{}:
+		conditions := make([]string, 0, len(v))
+		for _, cond := range v {
+			if s, ok := cond.(string); ok {
+				conditions = append(conditions, "("+s+")")
+			}
+		}
+		return strings.Join(conditions, " and ")
+	}
+	return ""
+}
+
+// parseTaskInt 解析整数类型的任务关键字（如 retries、delay）
+func parseTaskInt(name string, value interface{}) (int, error) {
+	switch v := value.(type) {
+	case int:
+		if v >= 0 {
+			return v, nil
+		}
+	case string:
+		if n, err := strconv.Atoi(strings.TrimSpace(v)); err == nil && n >= 0 {
+			return n, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid %s value: %v", name, value)
+}
+
 // UnmarshalYAML 自定义 Handler 的 YAML 解析
 func (h *Handler) UnmarshalYAML(value *yaml.Node) error {
 	// 使用辅助结构解析已知字段
@@ -297,65 +481,14 @@ func (h *Handler) UnmarshalYAML(value *yaml.Node) error {
 		"ignore_errors": true,
 	}
 
-	// 已知的模块列表（与 Task 相同）
-	knownModules := map[string]bool{
-		"ping":                         true,
-		"command":                      true,
-		"shell":                        true,
-		"raw":                          true,
-		"copy":                         true,
-		"debug":                        true,
-		"set_fact":                     true,
-		"file":                         true,
-		"template":                     true,
-		"lineinfile":                   true,
-		"service":                      true,
-		"systemd":                      true,
-		"get_url":                      true,
-		"fail":                         true,
-		"ansible.builtin.import_tasks": true,
-		"import_tasks":                 true,
-		"ansible.builtin.include_role": true,
-		"include_role":                 true,
-	}
-
-	// 遍历所有字段，查找模块名
-	if value.Kind == yaml.MappingNode {
-		for i := 0; i < len(value.Content); i += 2 {
-			keyNode := value.Content[i]
-			valueNode := value.Content[i+1]
-
-			key := keyNode.Value
-
-			// 跳过已知字段
-			if knownFields[key] {
-				continue
-			}
-
-			// 检查是否是模块
-			if knownModules[key] {
-				h.Module = key
-
-				// 解析模块参数
-				switch valueNode.Kind {
-				case yaml.ScalarNode:
-					// 短格式: command: uptime
-					if valueNode.Value != "" {
-						h.ModuleArgs["_raw_params"] = valueNode.Value
-					}
-				case yaml.MappingNode:
-					// 长格式: command: {cmd: uptime}
-					var args map[string]interface{}
-					if err := valueNode.Decode(&args); err != nil {
-						return fmt.Errorf("failed to parse module args: %w", err)
-					}
-					h.ModuleArgs = args
-				default:
-					return fmt.Errorf("unsupported module args format for module %s", key)
-				}
-				break
-			}
-		}
+	// 查找模块（与 Task 相同）
+	moduleName, args, err := findModule(value, knownFields)
+	if err != nil {
+		return err
+	}
+	if moduleName != "" {
+		h.Module = moduleName
+		h.ModuleArgs = args
 	}
 
 	if h.Module == "" {
@@ -367,13 +500,14 @@ func (h *Handler) UnmarshalYAML(value *yaml.Node) error {
 
 // TaskResult 任务执行结果
 type TaskResult struct {
-	Host    string
-	Task    string
-	Changed bool
-	Failed  bool
-	Skipped bool
-	Msg     string
-	Data    map[string]interface{}
+	Host          string
+	DelegatedHost string // delegate_to 实际执行的主机（未委托时为空）
+	Task          string
+	Changed       bool
+	Failed        bool
+	Skipped       bool
+	Msg           string
+	Data          map[string]interface{}
 }
 
 // PlayRecap Play 执行总结
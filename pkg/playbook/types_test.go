@@ -0,0 +1,269 @@
+package playbook
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/jimyag/ansigo/pkg/module"
+	"gopkg.in/yaml.v3"
+)
+
+func TestTaskUnmarshalUntil(t *testing.T) {
+	tests := []struct {
+		name        string
+		yaml        string
+		wantUntil   string
+		wantRetries int
+		wantDelay   int
+		wantErr     bool
+	}{
+		{
+			name: "defaults",
+			yaml: `
+name: wait
+command: curl -sf http://localhost
+register: out
+until: out.rc == 0
+`,
+			wantUntil:   "out.rc == 0",
+			wantRetries: 3,
+			wantDelay:   5,
+		},
+		{
+			name: "explicit retries and delay",
+			yaml: `
+shell: systemctl is-active app
+register: st
+until:
+  - st.rc == 0
+  - "'active' in st.stdout"
+retries: 10
+delay: "2"
+`,
+			wantUntil:   "(st.rc == 0) and ('active' in st.stdout)",
+			wantRetries: 10,
+			wantDelay:   2,
+		},
+		{
+			name:        "no until",
+			yaml:        "command: uptime\n",
+			wantRetries: 0,
+			wantDelay:   0,
+		},
+		{
+			name:    "invalid retries",
+			yaml:    "command: uptime\nuntil: true\nretries: many\n",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var task Task
+			err := yaml.Unmarshal([]byte(tt.yaml), &task)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Unmarshal() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if task.Until != tt.wantUntil || task.Retries != tt.wantRetries || task.Delay != tt.wantDelay {
+				t.Errorf("until=%q retries=%d delay=%d, want %q %d %d",
+					task.Until, task.Retries, task.Delay, tt.wantUntil, tt.wantRetries, tt.wantDelay)
+			}
+			if task.Module == "" {
+				t.Error("until/retries/delay parsed as module")
+			}
+		})
+	}
+}
+
+func TestTaskUnmarshalDelegation(t *testing.T) {
+	tests := []struct {
+		name         string
+		yaml         string
+		wantModule   string
+		wantArgs     map[string]interface{}
+		wantDelegate string
+		wantFacts    bool
+		wantRunOnce  bool
+		wantErr      bool
+	}{
+		{
+			name: "delegate_to and run_once",
+			yaml: `
+name: drain
+command: lbctl drain {{ inventory_hostname }}
+delegate_to: "{{ groups['lb'][0] }}"
+delegate_facts: true
+run_once: true
+`,
+			wantModule:   "command",
+			wantArgs:     map[string]interface{}{"_raw_params": "lbctl drain {{ inventory_hostname }}"},
+			wantDelegate: "{{ groups['lb'][0] }}",
+			wantFacts:    true,
+			wantRunOnce:  true,
+		},
+		{
+			name:         "local_action string",
+			yaml:         "local_action: shell echo hi > /tmp/out\n",
+			wantModule:   "shell",
+			wantArgs:     map[string]interface{}{"_raw_params": "echo hi > /tmp/out"},
+			wantDelegate: "localhost",
+		},
+		{
+			name: "local_action mapping",
+			yaml: `
+local_action:
+  module: copy
+  content: hi
+  dest: /tmp/out
+`,
+			wantModule:   "copy",
+			wantArgs:     map[string]interface{}{"content": "hi", "dest": "/tmp/out"},
+			wantDelegate: "localhost",
+		},
+		{
+			name:    "local_action unknown module",
+			yaml:    "local_action: frobnicate now\n",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var task Task
+			err := yaml.Unmarshal([]byte(tt.yaml), &task)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Unmarshal() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if task.Module != tt.wantModule || !reflect.DeepEqual(task.ModuleArgs, tt.wantArgs) {
+				t.Errorf("module = %s %v, want %s %v", task.Module, task.ModuleArgs, tt.wantModule, tt.wantArgs)
+			}
+			if task.DelegateTo != tt.wantDelegate || task.DelegateFacts != tt.wantFacts || task.RunOnce != tt.wantRunOnce {
+				t.Errorf("delegate_to=%q delegate_facts=%v run_once=%v, want %q %v %v",
+					task.DelegateTo, task.DelegateFacts, task.RunOnce, tt.wantDelegate, tt.wantFacts, tt.wantRunOnce)
+			}
+		})
+	}
+}
+
+func TestTaskUnmarshalCheckAndDiffMode(t *testing.T) {
+	var task Task
+	err := yaml.Unmarshal([]byte(`
+block:
+  - name: inherits
+    command: uptime
+  - name: overrides
+    command: uptime
+    check_mode: false
+  - block:
+      - name: nested
+        command: uptime
+    diff: false
+check_mode: true
+diff: true
+`), &task)
+	if err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+
+	r := &Runner{}
+	want := map[string]bool{"inherits": true, "overrides": false, "nested": true}
+	block := task.TaskBlock.Block
+	for _, got := range []Task{block[0], block[1], block[2].TaskBlock.Block[0]} {
+		if got.CheckMode == nil || r.taskCheckMode(&got) != want[got.Name] {
+			t.Errorf("task %q check_mode = %v, want %v", got.Name, got.CheckMode, want[got.Name])
+		}
+	}
+
+	// 嵌套 block 的 diff 覆盖外层 block
+	wantDiff := map[string]bool{"inherits": true, "overrides": true, "nested": false}
+	for _, got := range []Task{block[0], block[1], block[2].TaskBlock.Block[0]} {
+		if r.taskDiffMode(&got) != wantDiff[got.Name] {
+			t.Errorf("task %q diff = %v, want %v", got.Name, r.taskDiffMode(&got), wantDiff[got.Name])
+		}
+	}
+
+	// 没有设置 check_mode 的任务跟随 --check
+	r.checkMode = true
+	if plain := (Task{Name: "plain"}); !r.taskCheckMode(&plain) {
+		t.Error("taskCheckMode() = false with --check, want true")
+	}
+}
+
+func TestTaskUnmarshalRegisteredModules(t *testing.T) {
+	// 嵌入 ansigo 的程序注册的模块可以直接在 playbook 中使用
+	if _, ok := module.Lookup("ansigo_test_echo"); !ok {
+		err := module.Register(module.Spec{
+			Name:    "ansigo_test_echo",
+			Aliases: []string{"example.tools.ansigo_test_echo"},
+			Module:  &module.FailModule{},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name       string
+		yaml       string
+		wantModule string
+		wantErr    bool
+	}{
+		{name: "fqcn", yaml: "ansible.builtin.copy: {content: hi, dest: /tmp/out}\n", wantModule: "copy"},
+		{name: "fqcn include", yaml: "ansible.builtin.import_tasks: tasks/a.yml\n", wantModule: "import_tasks"},
+		{name: "fqcn local_action", yaml: "local_action: ansible.builtin.command uptime\n", wantModule: "command"},
+		{name: "registered module", yaml: "ansigo_test_echo: {msg: hi}\n", wantModule: "ansigo_test_echo"},
+		{name: "registered alias", yaml: "example.tools.ansigo_test_echo: {msg: hi}\n", wantModule: "ansigo_test_echo"},
+		{name: "unknown module", yaml: "frobnicate: now\n", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var task Task
+			err := yaml.Unmarshal([]byte(tt.yaml), &task)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Task Unmarshal() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && task.Module != tt.wantModule {
+				t.Errorf("task module = %s, want %s", task.Module, tt.wantModule)
+			}
+
+			// handler 使用同一个注册表（local_action 只用于任务）
+			if task.DelegateTo != "" {
+				return
+			}
+			var handler Handler
+			err = yaml.Unmarshal([]byte(tt.yaml), &handler)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Handler Unmarshal() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && handler.Module != tt.wantModule {
+				t.Errorf("handler module = %s, want %s", handler.Module, tt.wantModule)
+			}
+		})
+	}
+}
+
+func TestTaskUnmarshalLibraryModule(t *testing.T) {
+	// library 目录中的模块在解析 playbook 之前添加，不检查参数
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "ansigo_test_lib.py"), "#!/usr/bin/python\nprint('{}')\n")
+	module.AddLibrary(dir)
+
+	var task Task
+	if err := yaml.Unmarshal([]byte("name: Custom\nansigo_test_lib: {anything: 1}\n"), &task); err != nil {
+		t.Fatalf("Task Unmarshal() error = %v", err)
+	}
+	if task.Module != "ansigo_test_lib" {
+		t.Errorf("task module = %s, want ansigo_test_lib", task.Module)
+	}
+	if _, err := validateModuleArgs(task.Name, task.Module, task.ModuleArgs); err != nil {
+		t.Errorf("validateModuleArgs() error = %v", err)
+	}
+}
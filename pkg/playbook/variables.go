@@ -1,15 +1,29 @@
 package playbook
 
 import (
+	"sync"
+
 	"github.com/jimyag/ansigo/pkg/inventory"
 )
 
 // VariableManager 管理变量作用域和优先级
+// 变量按 Ansible 的优先级分层保存（见 VarLayer），获取上下文时按顺序合并
+// 可以被多个主机的任务并发读写
 type VariableManager struct {
+	mu             sync.RWMutex
 	inventory      *inventory.Manager
 	playVars       map[string]interface{}
-	registeredVars map[string]map[string]interface{} // hostname -> vars
+	playVarsPrompt map[string]interface{}            // vars_prompt 输入的变量
+	playVarsFiles  map[string]interface{}            // vars_files 加载的变量
+	hostVarsFiles  map[string]map[string]interface{} // hostname -> 在主机上下文中加载的 vars_files 变量
+	facts          map[string]map[string]interface{} // hostname -> gather_facts 收集的 facts
+	includeVars    map[string]map[string]interface{} // hostname -> include_vars 加载的变量
+	registeredVars map[string]map[string]interface{} // hostname -> set_fact 和 register 的变量
+	extraVars      map[string]interface{}            // -e 指定的变量（最高优先级）
 	playHosts      []string                          // 当前 play 的主机列表
+	playBatch      []string                          // 当前批次（serial）的主机列表
+	checkMode      bool                              // 是否以 check 模式运行（ansible_check_mode）
+	diffMode       bool                              // 是否输出 diff（ansible_diff_mode）
 }
 
 // NewVariableManager 创建变量管理器
@@ -17,93 +31,162 @@ func NewVariableManager(inv *inventory.Manager) *VariableManager {
 	return &VariableManager{
 		inventory:      inv,
 		playVars:       make(map[string]interface{}),
+		facts:          make(map[string]map[string]interface{}),
+		includeVars:    make(map[string]map[string]interface{}),
 		registeredVars: make(map[string]map[string]interface{}),
 	}
 }
 
 // SetPlayVars 设置 Play 级别变量
 func (vm *VariableManager) SetPlayVars(vars map[string]interface{}) {
+	vm.mu.Lock()
+	defer vm.mu.Unlock()
+
 	vm.playVars = vars
 }
 
+// SetPlayVarsPrompt 设置 vars_prompt 输入的变量
+func (vm *VariableManager) SetPlayVarsPrompt(vars map[string]interface{}) {
+	vm.mu.Lock()
+	defer vm.mu.Unlock()
+
+	vm.playVarsPrompt = vars
+}
+
+// SetPlayVarsFiles 设置 vars_files 加载的变量
+func (vm *VariableManager) SetPlayVarsFiles(vars map[string]interface{}) {
+	vm.mu.Lock()
+	defer vm.mu.Unlock()
+
+	vm.playVarsFiles = vars
+	vm.hostVarsFiles = nil
+}
+
+// SetHostVarsFiles 设置在主机上下文中加载的 vars_files 变量，替换该主机的 play vars_files 层
+func (vm *VariableManager) SetHostVarsFiles(hostname string, vars map[string]interface{}) {
+	vm.mu.Lock()
+	defer vm.mu.Unlock()
+
+	if vm.hostVarsFiles == nil {
+		vm.hostVarsFiles = make(map[string]map[string]interface{})
+	}
+	vm.hostVarsFiles[hostname] = vars
+}
+
+// SetExtraVars 设置 -e 指定的变量，它们覆盖其他所有变量
+func (vm *VariableManager) SetExtraVars(vars map[string]interface{}) {
+	vm.mu.Lock()
+	defer vm.mu.Unlock()
+
+	vm.extraVars = vars
+}
+
 // SetPlayHosts 设置当前 play 的主机列表
 func (vm *VariableManager) SetPlayHosts(hosts []string) {
+	vm.mu.Lock()
+	defer vm.mu.Unlock()
+
 	vm.playHosts = hosts
 }
 
-// SetHostVar 设置主机变量（用于 register）
+// SetPlayBatch 设置当前批次的主机列表（未使用 serial 时与 play 主机列表相同）
+func (vm *VariableManager) SetPlayBatch(hosts []string) {
+	vm.mu.Lock()
+	defer vm.mu.Unlock()
+
+	vm.playBatch = hosts
+}
+
+// SetCheckMode 设置 ansible_check_mode 变量
+func (vm *VariableManager) SetCheckMode(check bool) {
+	vm.mu.Lock()
+	defer vm.mu.Unlock()
+
+	vm.checkMode = check
+}
+
+// SetDiffMode 设置 ansible_diff_mode 变量
+func (vm *VariableManager) SetDiffMode(diff bool) {
+	vm.mu.Lock()
+	defer vm.mu.Unlock()
+
+	vm.diffMode = diff
+}
+
+// SetHostVar 设置主机变量（用于 register 和 set_fact）
 func (vm *VariableManager) SetHostVar(hostname, key string, value interface{}) {
+	vm.mu.Lock()
+	defer vm.mu.Unlock()
+
 	if vm.registeredVars[hostname] == nil {
 		vm.registeredVars[hostname] = make(map[string]interface{})
 	}
 	vm.registeredVars[hostname][key] = value
 }
 
-// SetHostVars 批量设置主机变量（用于 facts）
+// SetHostVars 批量设置主机的 facts（用于 gather_facts）
+// facts 的优先级低于 play 变量，set_fact 设置的变量使用 SetHostVar
 func (vm *VariableManager) SetHostVars(hostname string, vars map[string]interface{}) {
-	if vm.registeredVars[hostname] == nil {
-		vm.registeredVars[hostname] = make(map[string]interface{})
+	vm.mu.Lock()
+	defer vm.mu.Unlock()
+
+	if vm.facts[hostname] == nil {
+		vm.facts[hostname] = make(map[string]interface{})
 	}
 	for k, v := range vars {
-		vm.registeredVars[hostname][k] = v
+		vm.facts[hostname][k] = v
 	}
 }
 
-// GetHostVar 获取主机的特定变量
-func (vm *VariableManager) GetHostVar(hostname, key string) (interface{}, bool) {
-	// 先查找 registered 变量
-	if hostVars, ok := vm.registeredVars[hostname]; ok {
-		if value, exists := hostVars[key]; exists {
-			return value, true
-		}
-	}
+// SetIncludeVars 设置 include_vars 加载的主机变量
+func (vm *VariableManager) SetIncludeVars(hostname string, vars map[string]interface{}) {
+	vm.mu.Lock()
+	defer vm.mu.Unlock()
 
-	// 查找 play 变量
-	if value, ok := vm.playVars[key]; ok {
-		return value, true
+	if vm.includeVars[hostname] == nil {
+		vm.includeVars[hostname] = make(map[string]interface{})
+	}
+	for k, v := range vars {
+		vm.includeVars[hostname][k] = v
 	}
+}
 
-	// 查找 inventory 变量
-	hosts, err := vm.inventory.GetHosts(hostname)
-	if err == nil && len(hosts) > 0 {
-		if value, ok := hosts[0].Vars[key]; ok {
+// GetHostVar 获取主机的特定变量（不包含 role、block、task 等任务作用域中的变量）
+func (vm *VariableManager) GetHostVar(hostname, key string) (interface{}, bool) {
+	vm.mu.RLock()
+	defer vm.mu.RUnlock()
+
+	// 从优先级最高的一层开始查找
+	layers := vm.varLayers(hostname, nil, nil)
+	for i := len(layers) - 1; i >= 0; i-- {
+		if value, ok := layers[i].vars[key]; ok {
 			return value, true
 		}
 	}
-
 	return nil, false
 }
 
-// GetContext 获取主机的完整变量上下文
+// GetContext 获取主机的完整变量上下文（不包含任务作用域中的变量）
 // 用于模板渲染
 func (vm *VariableManager) GetContext(hostname string) map[string]interface{} {
-	context := make(map[string]interface{})
-
-	// 1. 合并 inventory 变量
-	host, err := vm.inventory.GetHost(hostname)
-	if err == nil {
-		for k, v := range host.Vars {
-			context[k] = v
-		}
-	}
+	return vm.GetTaskContext(hostname, nil, nil)
+}
 
-	// 2. 合并 play 变量
-	for k, v := range vm.playVars {
-		context[k] = v
-	}
+// GetTaskContext 获取主机执行任务时的变量上下文
+// 在 GetContext 的基础上按优先级加入任务所在 role、block、include 的变量和 task vars
+func (vm *VariableManager) GetTaskContext(hostname string, scope *VarScope, taskVars map[string]interface{}) map[string]interface{} {
+	vm.mu.RLock()
+	defer vm.mu.RUnlock()
 
-	// 3. 合并 registered 变量（最高优先级）
-	if hostVars, ok := vm.registeredVars[hostname]; ok {
-		for k, v := range hostVars {
-			context[k] = v
-		}
-	}
+	// 1. 按优先级合并所有变量层
+	context := mergeLayers(vm.varLayers(hostname, scope, taskVars))
 
-	// 4. 添加特殊变量
+	// 2. 添加特殊变量
 	context["inventory_hostname"] = hostname
 
 	// 从 host vars 中获取 ansible_host，如果没有则使用 inventory_hostname
-	if host != nil {
+	host, err := vm.inventory.GetHost(hostname)
+	if err == nil {
 		if ansibleHost, ok := host.Vars["ansible_host"]; ok {
 			context["ansible_host"] = ansibleHost
 		} else {
@@ -113,7 +196,7 @@ func (vm *VariableManager) GetContext(hostname string) map[string]interface{} {
 		context["ansible_host"] = hostname
 	}
 
-	// 5. 添加魔法变量
+	// 3. 添加魔法变量
 
 	// hostvars: 所有主机的变量
 	context["hostvars"] = vm.buildHostvars()
@@ -127,8 +210,17 @@ func (vm *VariableManager) GetContext(hostname string) map[string]interface{} {
 	// ansible_play_hosts: 当前 play 的主机列表
 	if len(vm.playHosts) > 0 {
 		context["ansible_play_hosts"] = vm.playHosts
-		context["ansible_play_batch"] = vm.playHosts // 简化实现，与 ansible_play_hosts 相同
+		context["ansible_play_batch"] = vm.playHosts
 	}
+	if len(vm.playBatch) > 0 {
+		context["ansible_play_batch"] = vm.playBatch
+	}
+
+	// ansible_check_mode: 是否以 --check 运行
+	context["ansible_check_mode"] = vm.checkMode
+
+	// ansible_diff_mode: 是否以 --diff 运行
+	context["ansible_diff_mode"] = vm.diffMode
 
 	return context
 }
@@ -138,7 +230,14 @@ func (vm *VariableManager) GetAllHostVars() map[string]map[string]interface{} {
 	result := make(map[string]map[string]interface{})
 
 	// 这里简化实现，实际应该遍历所有主机
+	vm.mu.RLock()
+	hostnames := make([]string, 0, len(vm.registeredVars))
 	for hostname := range vm.registeredVars {
+		hostnames = append(hostnames, hostname)
+	}
+	vm.mu.RUnlock()
+
+	for _, hostname := range hostnames {
 		result[hostname] = vm.GetContext(hostname)
 	}
 
@@ -148,6 +247,9 @@ func (vm *VariableManager) GetAllHostVars() map[string]map[string]interface{} {
 // ClearRegisteredVars 清除所有 registered 变量
 // 通常在新的 Play 开始时调用
 func (vm *VariableManager) ClearRegisteredVars() {
+	vm.mu.Lock()
+	defer vm.mu.Unlock()
+
 	vm.registeredVars = make(map[string]map[string]interface{})
 }
 
@@ -162,26 +264,9 @@ func (vm *VariableManager) buildHostvars() map[string]interface{} {
 		return hostvars
 	}
 
-	// 为每个主机构建变量上下文
+	// 为每个主机构建变量上下文（不包含任务作用域中的变量）
 	for _, host := range allHosts {
-		hostContext := make(map[string]interface{})
-
-		// 添加 inventory 变量
-		for k, v := range host.Vars {
-			hostContext[k] = v
-		}
-
-		// 添加 play 变量
-		for k, v := range vm.playVars {
-			hostContext[k] = v
-		}
-
-		// 添加 registered 变量
-		if hostVars, ok := vm.registeredVars[host.Name]; ok {
-			for k, v := range hostVars {
-				hostContext[k] = v
-			}
-		}
+		hostContext := mergeLayers(vm.varLayers(host.Name, nil, nil))
 
 		// 添加基本魔法变量
 		hostContext["inventory_hostname"] = host.Name
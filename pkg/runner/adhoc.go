@@ -1,12 +1,11 @@
 package runner
 
 import (
-	"sync"
-
 	"github.com/jimyag/ansigo/pkg/connection"
 	"github.com/jimyag/ansigo/pkg/errors"
 	"github.com/jimyag/ansigo/pkg/inventory"
 	"github.com/jimyag/ansigo/pkg/module"
+	"github.com/jimyag/ansigo/pkg/worker"
 )
 
 // TaskResult 任务执行结果
@@ -21,6 +20,7 @@ type AdhocRunner struct {
 	inventory *inventory.Manager
 	connMgr   *connection.Manager
 	modExec   *module.Executor
+	pool      *worker.Pool
 }
 
 // NewAdhocRunner 创建一个新的 Ad-hoc Runner
@@ -29,43 +29,51 @@ func NewAdhocRunner(inv *inventory.Manager) *AdhocRunner {
 		inventory: inv,
 		connMgr:   connection.NewManager(),
 		modExec:   module.NewExecutor(),
+		pool:      worker.NewPool(worker.DefaultForks),
 	}
 }
 
-// Run 运行 ad-hoc 命令
+// SetForks 设置同时操作的最大主机数（小于 1 时使用默认值 5）
+func (r *AdhocRunner) SetForks(forks int) {
+	r.pool = worker.NewPool(forks)
+}
+
+// ConnectionManager 返回 Runner 使用的连接管理器（用于设置连接选项）
+func (r *AdhocRunner) ConnectionManager() *connection.Manager {
+	return r.connMgr
+}
+
+// Close 关闭 Runner 持有的所有连接
+func (r *AdhocRunner) Close() error {
+	return r.connMgr.Close()
+}
+
+// Run 运行 ad-hoc 命令，模块参数错误时在连接主机之前返回 ErrInvalidArgs 类型的错误
 func (r *AdhocRunner) Run(pattern, moduleName string, moduleArgs map[string]interface{}) ([]TaskResult, error) {
+	if spec, ok := module.Lookup(moduleName); ok {
+		validated, err := spec.ValidateArgs(moduleArgs)
+		if err != nil {
+			param := ""
+			if argErr, ok := err.(*module.ArgError); ok {
+				param = argErr.Param
+			}
+			return nil, errors.NewInvalidArgsError("ad-hoc", moduleName, param, err)
+		}
+		moduleArgs = validated
+	}
+
 	// 获取目标主机
 	hosts, err := r.inventory.GetHosts(pattern)
 	if err != nil {
 		return nil, err
 	}
 
-	// 并发执行
-	results := make(chan TaskResult, len(hosts))
-	var wg sync.WaitGroup
-
-	for _, host := range hosts {
-		wg.Add(1)
-		go func(h *inventory.Host) {
-			defer wg.Done()
-			result := r.executeOnHost(h, moduleName, moduleArgs)
-			results <- result
-		}(host)
-	}
-
-	// 等待所有任务完成
-	go func() {
-		wg.Wait()
-		close(results)
-	}()
-
-	// 收集结果
-	taskResults := []TaskResult{}
-	for result := range results {
-		taskResults = append(taskResults, result)
-	}
+	// 并发执行（最多 forks 个主机），结果按主机顺序返回
+	results := worker.Map(r.pool, hosts, func(h *inventory.Host) TaskResult {
+		return r.executeOnHost(h, moduleName, moduleArgs)
+	})
 
-	return taskResults, nil
+	return results, nil
 }
 
 // executeOnHost 在单个主机上执行模块
@@ -73,16 +81,18 @@ func (r *AdhocRunner) executeOnHost(host *inventory.Host, moduleName string, mod
 	// 建立连接
 	conn, err := r.connMgr.Connect(host)
 	if err != nil {
-		// 连接失败
+		// 连接失败（主机密钥校验失败按 FAILED 处理，而不是 UNREACHABLE）
 		var execErr *errors.ExecutionError
 		if e, ok := err.(*errors.ExecutionError); ok {
 			execErr = e
 		}
 
+		unreachable := errors.IsUnreachable(err)
 		return TaskResult{
 			Host: host.Name,
 			ModuleResult: &module.Result{
-				Unreachable: true,
+				Unreachable: unreachable,
+				Failed:      !unreachable,
 				Msg:         err.Error(),
 			},
 			Error: execErr,
@@ -90,6 +100,18 @@ func (r *AdhocRunner) executeOnHost(host *inventory.Host, moduleName string, mod
 	}
 	defer conn.Close()
 
+	// 主机相关的内部参数：library 中的 Python 模块使用的 ansible_python_interpreter、是否启用远程 agent、包管理器
+	if hostArgs := module.HostArgs(host.Vars); len(hostArgs) > 0 {
+		args := make(map[string]interface{}, len(moduleArgs)+len(hostArgs))
+		for k, v := range moduleArgs {
+			args[k] = v
+		}
+		for k, v := range hostArgs {
+			args[k] = v
+		}
+		moduleArgs = args
+	}
+
 	// 执行模块（ad-hoc 命令默认不使用 become）
 	modResult, err := r.modExec.Execute(conn, moduleName, moduleArgs, false, "", "")
 	if err != nil {
@@ -0,0 +1,64 @@
+// Package worker 提供有界并发的任务执行（对应 Ansible 的 forks）
+package worker
+
+import "sync"
+
+// DefaultForks 默认并发数，与 Ansible 一致
+const DefaultForks = 5
+
+// Pool 有界 worker 池，同一时刻最多运行 size 个任务
+//
+// Pool 本身不持有 goroutine，每次 Run 按需启动最多 size 个 worker，
+// 因此可以被 ad-hoc、任务、handler 和 facts 收集共用，嵌套调用也不会死锁
+type Pool struct {
+	size int
+}
+
+// NewPool 创建 worker 池，size 小于 1 时使用 DefaultForks
+func NewPool(size int) *Pool {
+	if size < 1 {
+		size = DefaultForks
+	}
+	return &Pool{size: size}
+}
+
+// Size 返回最大并发数
+func (p *Pool) Size() int {
+	return p.size
+}
+
+// Run 并发执行 fn(0) ... fn(n-1)，等待全部完成后返回
+// 任务按下标顺序领取，因此先启动的总是靠前的任务
+func (p *Pool) Run(n int, fn func(i int)) {
+	workers := p.size
+	if n < workers {
+		workers = n
+	}
+
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				fn(i)
+			}
+		}()
+	}
+
+	for i := 0; i < n; i++ {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+}
+
+// Map 对 items 中每个元素并发调用 fn，结果按 items 的顺序返回
+func Map[T, R any](p *Pool, items []T, fn func(T) R) []R {
+	results := make([]R, len(items))
+	p.Run(len(items), func(i int) {
+		results[i] = fn(items[i])
+	})
+	return results
+}
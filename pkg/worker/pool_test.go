@@ -0,0 +1,52 @@
+package worker
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPoolLimitsConcurrency(t *testing.T) {
+	pool := NewPool(3)
+
+	var running, peak atomic.Int32
+	pool.Run(20, func(i int) {
+		n := running.Add(1)
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+		running.Add(-1)
+	})
+
+	if got := peak.Load(); got != 3 {
+		t.Errorf("peak concurrency = %d, want 3", got)
+	}
+}
+
+func TestMapKeepsOrder(t *testing.T) {
+	items := []int{5, 1, 4, 2, 3}
+
+	// 靠前的任务更慢，完成顺序与输入顺序相反
+	got := Map(NewPool(len(items)), items, func(v int) int {
+		time.Sleep(time.Duration(v) * time.Millisecond)
+		return v * 10
+	})
+
+	want := []int{50, 10, 40, 20, 30}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("Map() = %v, want %v", got, want)
+		}
+	}
+}
+
+func TestNewPoolDefault(t *testing.T) {
+	if got := NewPool(0).Size(); got != DefaultForks {
+		t.Errorf("NewPool(0).Size() = %d, want %d", got, DefaultForks)
+	}
+	NewPool(2).Run(0, func(int) { t.Error("fn called for n = 0") })
+}